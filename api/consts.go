@@ -44,6 +44,9 @@ const (
 	LuaMinStack = 20
 	LuaMaxStack = 1000000
 
+	LuaMaxCCalls    = 200    // max nested calls from Go to Lua, similar to LUAI_MAXCCALLS
+	LuaMaxCallDepth = 200000 // default max depth of call stack
+
 	//      -max                                       max
 	//        |                                         |
 	//     |__|____________________|____________________|
@@ -54,6 +57,19 @@ const (
 	LuaRidxGlobals int64 = 2 // index in global
 )
 
+// garbage-collection options
+const (
+	LuaGCStop = iota
+	LuaGCRestart
+	LuaGCCollect
+	LuaGCCount
+	LuaGCCountB
+	LuaGCStep
+	LuaGCSetPause
+	LuaGCSetStepMul
+	LuaGCIsRunning = 9
+)
+
 // Error code
 const (
 	LuaOk = iota
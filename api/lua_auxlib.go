@@ -0,0 +1,63 @@
+package api
+
+// FuncReg is the functions of library, name => function
+type FuncReg map[string]GoFunction
+
+// reference
+const (
+	LuaNoRef  = -2
+	LuaRefNil = -1
+)
+
+// IAuxLib is the auxiliary library, helpers built on the basic API
+type IAuxLib interface {
+	// error-report functions
+	Error2(fmt string, a ...interface{}) int
+	ArgError(arg int, extraMsg string) int
+
+	// argument check functions
+	CheckStack2(sz int, msg string)
+	ArgCheck(cond bool, arg int, extraMsg string)
+	CheckAny(arg int)
+	CheckType(arg int, t LuaType)
+	CheckInteger(arg int) int64
+	CheckNumber(arg int) float64
+	CheckString(arg int) string
+	CheckOption(arg int, def string, lst []string) int
+	OptInteger(arg int, d int64) int64
+	OptNumber(arg int, d float64) float64
+	OptString(arg int, d string) string
+
+	// metatables and userdata
+	NewMetatable(tname string) bool
+	SetMetatableByName(tname string)
+	GetMetaField(obj int, e string) LuaType
+	CallMeta(obj int, e string) bool
+	TestUData(arg int, tname string) interface{}
+	CheckUData(arg int, tname string) interface{}
+
+	// references
+	Ref(t int) int
+	Unref(t, ref int)
+
+	// libraries
+	NewLib(l FuncReg)
+	SetFuncs(l FuncReg, nup int)
+	GetSubTable(idx int, fname string) bool
+	RequireF(modname string, openf GoFunction, glb bool)
+
+	// load functions
+	LoadString(s string) int
+	LoadFile(filename string) int
+	LoadFileX(filename, mode string) int
+	DoString(str string) bool // returns true if there are errors
+	DoFile(filename string) bool
+
+	// other functions
+	TypeName2(idx int) string
+	ToStringMeta(idx int) string
+	Len2(idx int) int64
+	Where(level int)
+	Traceback(msg string, level int)
+	GSub(s, p, r string) string
+}
@@ -1,5 +1,7 @@
 package api
 
+import "luago/binchunk"
+
 //
 //  +----------------------------+-----+             +-------------+
 //  |          Core Lua          |     |             |             |
@@ -21,6 +23,8 @@ type CompareOp = int
 
 // ILuaState LuaState interface
 type ILuaState interface {
+	IAuxLib
+
 	// basic stack manipulation
 	GetTop() int
 	AbsIndex(idx int) int
@@ -51,6 +55,9 @@ type ILuaState interface {
 	ToNumberX(idx int) (float64, bool)
 	ToString(idx int) string
 	ToStringX(idx int) (string, bool)
+	IsUserData(idx int) bool
+	ToUserData(idx int) interface{}
+	ToPointer(idx int) interface{}
 
 	// push methods (go -> stack)
 	PushNil()
@@ -58,6 +65,7 @@ type ILuaState interface {
 	PushInteger(n int64)
 	PushNumber(n float64)
 	PushString(str string)
+	NewUserData(data interface{})
 
 	// operator
 	Arithmetic(op ArithmeticOp)
@@ -81,7 +89,12 @@ type ILuaState interface {
 
 	// function call
 	Load(chunk []byte, chunkName, mode string) int // mode: b(binary), t(text file), bt
+	PushProto(proto *binchunk.ProtoType)
+	ToProto(idx int) *binchunk.ProtoType
 	Call(nArgs, nResults int)
+	SetMaxCallDepth(limit int) int
+	Interrupt()
+	Interrupted() bool
 
 	// Go function
 	PushGoFunction(f GoFunction)
@@ -108,9 +121,28 @@ type ILuaState interface {
 	// iterator
 	Next(idx int) bool
 
+	// upvalues
+	GetUpvalue(funcIdx, n int) (string, bool)
+	SetUpvalue(funcIdx, n int) (string, bool)
+	GetStack(level int) bool
+
 	// error
 	Error() int
 	PCall(nArgs, nResults, msgh int) int
+
+	// garbage collection
+	GC(what, data int) int
+	Close()
+
+	// coroutine functions
+	NewThread() ILuaState
+	PushThread() bool
+	ToThread(idx int) ILuaState
+	XMove(to ILuaState, n int)
+	Resume(from ILuaState, nArgs int) (int, int)
+	Yield(nResults int) int
+	Status() int
+	IsYieldable() bool
 }
 
 // GoFunction is called by lua
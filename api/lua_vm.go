@@ -13,5 +13,10 @@ type ILuaVM interface {
 	LoadVararg(n int)
 	LoadProto(idx int)
 
+	// returns true if a Go function has been called,
+	// false if a Lua function frame has been pushed for the dispatch loop
+	PrepareCall(nArgs, nResults int) bool
+	PrepareTailCall(nArgs int) bool
+
 	CloseUpvalues(a int)
 }
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"luago/compiler/format"
+	"os"
+	"strings"
+)
+
+// fmtMain runs `luago fmt [flags] [files]`, it formats the files, or stdin
+// if there is no file, and returns the exit code: 0 for success, 1 if
+// some files are not formatted in check mode, 2 for errors
+func fmtMain(args []string) int {
+	flags := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	check := flags.Bool("check", false, "list the files not formatted and exit with 1, write nothing")
+	write := flags.Bool("w", false, "write the results to the files instead of stdout")
+	indent := flags.Int("indent", 4, "spaces of an indentation level, 0 for a tab")
+	width := flags.Int("width", format.DefaultOptions.LineWidth, "line width to wrap the argument lists and tables at")
+	single := flags.Bool("single", false, "prefer single quotes for short strings")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: luago fmt [flags] [files]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	opts := format.Options{Indent: strings.Repeat(" ", *indent), LineWidth: *width, Quote: '"'}
+	if *indent == 0 {
+		opts.Indent = "\t"
+	}
+	if *single {
+		opts.Quote = '\''
+	}
+
+	if flags.NArg() == 0 {
+		data, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		out, err := formatFile(string(data), "stdin", opts)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		if *check {
+			if out != string(data) {
+				fmt.Println("<stdin>")
+				return 1
+			}
+			return 0
+		}
+		os.Stdout.WriteString(out)
+		return 0
+	}
+
+	status := 0
+	for _, name := range flags.Args() {
+		data, err := ioutil.ReadFile(name)
+		if err == nil {
+			var out string
+			if out, err = formatFile(string(data), name, opts); err == nil {
+				switch {
+				case *check:
+					if out != string(data) {
+						fmt.Println(name)
+						if status == 0 {
+							status = 1
+						}
+					}
+				case *write:
+					if out != string(data) {
+						err = ioutil.WriteFile(name, []byte(out), 0644)
+					}
+				default:
+					os.Stdout.WriteString(out)
+				}
+			}
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 2
+		}
+	}
+	return status
+}
+
+// formats the source of file, the first line beginning with `#` is kept
+// like the shebang skipped by lua
+func formatFile(src, name string, opts format.Options) (string, error) {
+	head := ""
+	if strings.HasPrefix(src, "#") {
+		i := strings.IndexByte(src, '\n')
+		if i < 0 {
+			return src, nil
+		}
+		head, src = src[:i+1], "\n"+src[i+1:] // keeps the line numbers
+	}
+	out, err := format.Source(src, name, opts)
+	return head + out, err
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"luago/compiler/lint"
+	"os"
+	"strings"
+)
+
+// lintMain runs `luago lint [flags] [files]`, it checks the files, or stdin
+// if there is no file, and returns the exit code: 0 for no warning, 1 if
+// some warnings are reported, 2 for errors
+func lintMain(args []string) int {
+	flags := flag.NewFlagSet("lint", flag.ContinueOnError)
+	globals := flags.String("globals", "", "comma separated globals which can be accessed and set")
+	readOnly := flags.String("readonly", "", "comma separated globals which can be accessed but not set, the standard libraries if empty")
+	ignore := flags.String("ignore", "", "comma separated codes of the warnings ignored")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: luago lint [flags] [files]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg := &lint.Config{
+		Globals: splitList(*globals),
+		Ignore:  splitList(*ignore),
+	}
+	if *readOnly != "" {
+		cfg.ReadOnly = splitList(*readOnly)
+	}
+
+	status := 0
+	report := func(src, name string) {
+		if strings.HasPrefix(src, "#") { // skips the shebang like lua
+			if i := strings.IndexByte(src, '\n'); i >= 0 {
+				src = "--" + src[i:]
+			} else {
+				src = ""
+			}
+		}
+		for _, w := range lint.Check(src, name, cfg) {
+			fmt.Println(w)
+			if status == 0 {
+				status = 1
+			}
+		}
+	}
+
+	if flags.NArg() == 0 {
+		data, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		report(string(data), "stdin")
+		return status
+	}
+
+	for _, name := range flags.Args() {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 2
+			continue
+		}
+		report(string(data), name)
+	}
+	return status
+}
+
+// splits the comma separated list, the empty items are dropped
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"fmt"
+	"luago/lsp"
+	"os"
+)
+
+// lspMain runs `luago lsp`, the language server talking over stdin and
+// stdout, and returns the exit code: 0 if it's shut down before exit, 1
+// if not
+func lspMain(args []string) int {
+	if len(args) > 0 {
+		fmt.Fprintln(os.Stderr, "usage: luago lsp")
+		return 2
+	}
+	if err := lsp.NewServer(os.Stdin, os.Stdout).Serve(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
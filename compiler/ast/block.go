@@ -7,7 +7,9 @@ package ast
 // retstat ::= `return` [explist] [`;`]
 // explist ::= exp {`,` exp}
 type Block struct {
+	Span
 	LastLine int
 	Stats    []Stat
 	RetExps  []Exp
+	RetSpan  Span // span of retstat from `return`, if RetExps isn't nil
 }
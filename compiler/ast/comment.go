@@ -0,0 +1,19 @@
+package ast
+
+import "luago/compiler/lexer"
+
+// Comments is the comments attached to a stat
+type Comments struct {
+	Leading  []lexer.Trivia // comments before the stat, with no token between
+	Trailing []lexer.Trivia // comments after the stat on its last line
+}
+
+// CommentMap maps the stats to their comments
+type CommentMap map[Stat]*Comments
+
+// Chunk is a chunk parsed with its trivia
+type Chunk struct {
+	Block    *Block
+	Trivia   []lexer.Trivia // all comments and blank lines in order
+	Comments CommentMap     // comments attached to the stats
+}
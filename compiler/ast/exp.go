@@ -4,43 +4,52 @@ package ast
 //          prefixexp | tableconstructor | exp binop exp | unop exp
 
 // Exp is expression interface
-type Exp interface{}
+type Exp interface {
+	Node
+}
 
 // NilExp is `nil` expression
 type NilExp struct {
+	Span
 	Line int
 }
 
 // TrueExp is `true` expression
 type TrueExp struct {
+	Span
 	Line int
 }
 
 // FalseExp is `false` expression
 type FalseExp struct {
+	Span
 	Line int
 }
 
 // IntegerExp is integer expression
 type IntegerExp struct {
+	Span
 	Line int
 	Val  int64
 }
 
 // FloatExp is floating point expression
 type FloatExp struct {
+	Span
 	Line int
 	Val  float64
 }
 
 // StringExp is string expression
 type StringExp struct {
+	Span
 	Line int
 	Str  string
 }
 
 // VarargExp is `...` expression
 type VarargExp struct {
+	Span
 	Line int
 }
 
@@ -50,6 +59,7 @@ type VarargExp struct {
 // field ::= `[` exp `]` `=` exp | Name `=` exp | exp
 // fieldsep ::= `,` | `;`
 type TableConstructionExp struct {
+	Span
 	FirstLine int // line of `{`
 	LastLine  int // line of `}`
 	KeyExps   []Exp
@@ -62,9 +72,11 @@ type TableConstructionExp struct {
 // parlist ::= namelist [`,` `...`] | `...`
 // namelist ::= Name {`,` Name}
 type FuncDefExp struct {
+	Span
 	FirstLine int
 	LastLine  int // line of `end`
 	ParList   []string
+	ParSpans  []Span // spans of the parameters, empty for the implicit self
 	IsVararg  bool
 	MBlock    *Block
 }
@@ -83,12 +95,14 @@ type FuncDefExp struct {
 
 // NameExp is identifier name expression
 type NameExp struct {
+	Span
 	Line int
 	Name string
 }
 
 // TableAccessExp is table access expression
 type TableAccessExp struct {
+	Span
 	LastLine  int
 	PrefixExp Exp
 	Key       Exp
@@ -96,6 +110,7 @@ type TableAccessExp struct {
 
 // FuncCallExp is functioncall expression
 type FuncCallExp struct {
+	Span
 	FirstLine int
 	LastLine  int
 	PrefixExp Exp
@@ -105,12 +120,14 @@ type FuncCallExp struct {
 
 // ParensExp is parentheses expression
 type ParensExp struct {
+	Span
 	MExp Exp
 }
 
 // UnOpExp is unary expression
 // unop ::= `-` | `not` | `#` | `~`
 type UnOpExp struct {
+	Span
 	Line int
 	Op   int
 	MExp Exp
@@ -118,10 +135,12 @@ type UnOpExp struct {
 
 // BinOpExp is binary expression
 // binop ::=  `+` | `-` | `*` | `/` | `//` | `^` | `%` |
-//            `&` | `~` | `|` | `>>` | `<<` | `..` |
-//            `<` | `<=` | `>` | `>=` | `==` | `~=` |
-//            `and` | `or`
+//
+//	`&` | `~` | `|` | `>>` | `<<` | `..` |
+//	`<` | `<=` | `>` | `>=` | `==` | `~=` |
+//	`and` | `or`
 type BinOpExp struct {
+	Span
 	Line int
 	Op   int
 	Exp1 Exp
@@ -130,6 +149,7 @@ type BinOpExp struct {
 
 // ConcatExp is `..` expression, for optimizing the concatenating operation
 type ConcatExp struct {
+	Span
 	Line int
 	Exps []Exp
 }
@@ -0,0 +1,26 @@
+package ast
+
+import "luago/compiler/lexer"
+
+// Node is the interface of expressions and statements
+type Node interface {
+	Pos() Span
+}
+
+// Span is the range of a node in source code, from the first character
+// of the node to the position after the last one; the nodes made by the
+// parser without source code, such as the step 1 of numerical for, have
+// empty spans where they would be
+type Span struct {
+	Start lexer.Position
+	End   lexer.Position
+}
+
+// Pos returns the span, the nodes embedding Span implement Node by it
+func (s Span) Pos() Span {
+	return s
+}
+
+func (s *Span) setSpan(span Span) {
+	*s = span
+}
@@ -0,0 +1,226 @@
+package ast
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Rewrite traverses the node like Inspect and rewrites it in place. pre is
+// called for each node before its children, the node is kept as is if it
+// returns false; post is called for each node after its children are
+// rewritten, it returns the node itself to keep it, another node to
+// replace it, or nil to delete it. Either of them can be nil. The node
+// rewritten is returned, nil if it's deleted.
+//
+// The node replacing gets the span and lines of the node replaced if it
+// has none, so the code generated from it keeps the line info. The stats
+// deleted are removed from their blocks, the expressions deleted are
+// removed from their lists, like the arguments and explists; a field of
+// table is removed if its key or value is deleted, a clause of if is
+// removed if its condition is deleted, and a block deleted is emptied.
+// Rewrite panics if the other expressions, like the operands of operators,
+// are deleted, or a node is replaced by another type of node where the
+// type is fixed, like the function of LocalFuncDefStat
+func Rewrite(node Node, pre func(Node) bool, post func(Node) Node) Node {
+	r := &rewriter{pre: pre, post: post}
+	return r.rewrite(node)
+}
+
+type rewriter struct {
+	pre  func(Node) bool
+	post func(Node) Node
+}
+
+func (r *rewriter) rewrite(node Node) Node {
+	if r.pre != nil && !r.pre(node) {
+		return node
+	}
+
+	switch x := node.(type) {
+	case *Block:
+		x.Stats = r.stats(x.Stats)
+		x.RetExps = r.exps(x.RetExps)
+
+	case *EmptyStat, *BreakStat, *LabelStat, *GotoStat:
+	case *AssignStat:
+		x.VarList = r.exps(x.VarList)
+		x.ExpList = r.exps(x.ExpList)
+	case *DoStat:
+		x.MBlock = r.block(x.MBlock)
+	case *WhileStat:
+		x.BExp = r.exp(x.BExp, x)
+		x.MBlock = r.block(x.MBlock)
+	case *RepeatStat:
+		x.MBlock = r.block(x.MBlock)
+		x.BExp = r.exp(x.BExp, x)
+	case *IfStat:
+		exps, blocks := x.BExps[:0], x.Blocks[:0]
+		for i, exp := range x.BExps {
+			if exp = r.rewrite(exp); exp != nil {
+				exps = append(exps, exp)
+				blocks = append(blocks, r.block(x.Blocks[i]))
+			}
+		}
+		x.BExps, x.Blocks = exps, blocks
+	case *ForNumStat:
+		x.InitExp = r.exp(x.InitExp, x)
+		x.LimitExp = r.exp(x.LimitExp, x)
+		x.StepExp = r.exp(x.StepExp, x)
+		x.MBlock = r.block(x.MBlock)
+	case *ForInStat:
+		x.ExpList = r.exps(x.ExpList)
+		x.MBlock = r.block(x.MBlock)
+	case *LocalFuncDefStat:
+		x.Func = r.funcDef(x.Func, x)
+	case *LocalVarDeclStat:
+		x.ExpList = r.exps(x.ExpList)
+
+	case *NilExp, *TrueExp, *FalseExp, *IntegerExp, *FloatExp, *StringExp,
+		*VarargExp, *NameExp:
+	case *TableConstructionExp:
+		keys, vals := x.KeyExps[:0], x.ValExps[:0]
+		for i, key := range x.KeyExps {
+			if key != nil {
+				if key = r.rewrite(key); key == nil {
+					continue
+				}
+			}
+			if val := r.rewrite(x.ValExps[i]); val != nil {
+				keys, vals = append(keys, key), append(vals, val)
+			}
+		}
+		x.KeyExps, x.ValExps = keys, vals
+	case *FuncDefExp:
+		x.MBlock = r.block(x.MBlock)
+	case *TableAccessExp:
+		x.PrefixExp = r.exp(x.PrefixExp, x)
+		x.Key = r.exp(x.Key, x)
+	case *FuncCallExp:
+		x.PrefixExp = r.exp(x.PrefixExp, x)
+		if x.FNameExp != nil {
+			name, ok := r.exp(x.FNameExp, x).(*StringExp)
+			if !ok {
+				panic(fmt.Sprintf("ast: method name of %T replaced by non *StringExp", x))
+			}
+			x.FNameExp = name
+		}
+		x.Args = r.exps(x.Args)
+	case *ParensExp:
+		x.MExp = r.exp(x.MExp, x)
+	case *UnOpExp:
+		x.MExp = r.exp(x.MExp, x)
+	case *BinOpExp:
+		x.Exp1 = r.exp(x.Exp1, x)
+		x.Exp2 = r.exp(x.Exp2, x)
+	case *ConcatExp:
+		x.Exps = r.exps(x.Exps)
+	default:
+		panic(fmt.Sprintf("ast: unknown node %T", node))
+	}
+
+	if r.post == nil {
+		return node
+	}
+	result := r.post(node)
+	if isNil(result) {
+		return nil
+	}
+	if result != node {
+		inheritPos(result, node)
+	}
+	return result
+}
+
+// rewrites the stats, the ones deleted are removed
+func (r *rewriter) stats(stats []Stat) []Stat {
+	result := stats[:0]
+	for _, stat := range stats {
+		if stat = r.rewrite(stat); stat != nil {
+			result = append(result, stat)
+		}
+	}
+	return result
+}
+
+// rewrites the expressions, the ones deleted are removed, the list is
+// still nil or non-nil
+func (r *rewriter) exps(exps []Exp) []Exp {
+	result := exps[:0]
+	for _, exp := range exps {
+		if exp = r.rewrite(exp); exp != nil {
+			result = append(result, exp)
+		}
+	}
+	return result
+}
+
+// rewrites the expression required by parent
+func (r *rewriter) exp(exp Exp, parent Node) Exp {
+	result := r.rewrite(exp)
+	if result == nil {
+		panic(fmt.Sprintf("ast: required %T of %T deleted", exp, parent))
+	}
+	return result
+}
+
+// rewrites the block, it's emptied if deleted
+func (r *rewriter) block(block *Block) *Block {
+	switch result := r.rewrite(block).(type) {
+	case nil:
+		return &Block{Span: block.Span, LastLine: block.LastLine}
+	case *Block:
+		return result
+	default:
+		panic(fmt.Sprintf("ast: block replaced by %T", result))
+	}
+}
+
+func (r *rewriter) funcDef(f *FuncDefExp, parent Node) *FuncDefExp {
+	result, ok := r.exp(f, parent).(*FuncDefExp)
+	if !ok {
+		panic(fmt.Sprintf("ast: function of %T replaced by non *FuncDefExp", parent))
+	}
+	return result
+}
+
+// copies the span and lines of src to dst if dst has none, the lines are
+// the fields Line, FirstLine and LastLine
+func inheritPos(dst, src Node) {
+	if s, ok := dst.(interface{ setSpan(Span) }); ok && dst.Pos() == (Span{}) {
+		s.setSpan(src.Pos())
+	}
+	first, last := lines(src)
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return
+	}
+	for _, f := range []struct {
+		name string
+		line int
+	}{{"Line", first}, {"FirstLine", first}, {"LastLine", last}} {
+		if field := v.Elem().FieldByName(f.name); field.IsValid() && field.Int() == 0 {
+			field.SetInt(int64(f.line))
+		}
+	}
+}
+
+// returns the first and last lines of node, by its line fields if any,
+// or by its span
+func lines(node Node) (first, last int) {
+	span := node.Pos()
+	first, last = span.Start.Line, span.End.Line
+	v := reflect.ValueOf(node)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return
+	}
+	if f := v.Elem().FieldByName("Line"); f.IsValid() && f.Int() != 0 {
+		first, last = int(f.Int()), int(f.Int())
+	}
+	if f := v.Elem().FieldByName("FirstLine"); f.IsValid() && f.Int() != 0 {
+		first = int(f.Int())
+	}
+	if f := v.Elem().FieldByName("LastLine"); f.IsValid() && f.Int() != 0 {
+		last = int(f.Int())
+	}
+	return
+}
@@ -17,13 +17,18 @@ package ast
 // 	local namelist [`,` explist]
 
 // Stat is statement interface
-type Stat interface{}
+type Stat interface {
+	Node
+}
 
 // EmptyStat is `;` statement
-type EmptyStat struct{}
+type EmptyStat struct {
+	Span
+}
 
 // AssignStat is `varlist `=` explist` statement
 type AssignStat struct {
+	Span
 	LastLine int
 	VarList  []Exp
 	ExpList  []Exp
@@ -34,49 +39,58 @@ type FuncCallStat = FuncCallExp
 
 // LabelStat is `::` statement
 type LabelStat struct {
+	Span
 	Line int
 	Name string
 }
 
 // BreakStat is `break` statement
 type BreakStat struct {
+	Span
 	Line int
 }
 
 // GotoStat is `goto name` statement
 type GotoStat struct {
+	Span
 	Line int
 	Name string
 }
 
 // DoStat is `do mblock end` statement block
 type DoStat struct {
+	Span
 	MBlock *Block
 }
 
 // WhileStat is `while bexp do mblock end` statement
 type WhileStat struct {
+	Span
 	BExp   Exp
 	MBlock *Block
 }
 
 // RepeatStat is `repeat block until exp` statement
 type RepeatStat struct {
+	Span
 	BExp   Exp
 	MBlock *Block
 }
 
 // IfStat is `if exp then block {elseif exp then block} [else block] end`
 type IfStat struct {
+	Span
 	BExps  []Exp
 	Blocks []*Block
 }
 
 // ForNumStat is `for Name `=` exp `,` exp [`,` exp] do block end` statement
 type ForNumStat struct {
+	Span
 	LineFor  int
 	LineDo   int
 	VarName  string
+	VarSpan  Span
 	InitExp  Exp
 	LimitExp Exp
 	StepExp  Exp
@@ -85,21 +99,27 @@ type ForNumStat struct {
 
 // ForInStat is `for namelist in explist do block end` statement
 type ForInStat struct {
-	LineDo   int
-	NameList []string
-	ExpList  []Exp
-	MBlock   *Block
+	Span
+	LineDo    int
+	NameList  []string
+	NameSpans []Span
+	ExpList   []Exp
+	MBlock    *Block
 }
 
 // LocalFuncDefStat is `local function funcname funcbody` statement
 type LocalFuncDefStat struct {
-	Name string
-	Func *FuncDefExp
+	Span
+	Name     string
+	NameSpan Span
+	Func     *FuncDefExp
 }
 
 // LocalVarDeclStat is `local namelist [`=` explist]` statement
 type LocalVarDeclStat struct {
-	LastLine int
-	NameList []string
-	ExpList  []Exp
+	Span
+	LastLine  int
+	NameList  []string
+	NameSpans []Span
+	ExpList   []Exp
 }
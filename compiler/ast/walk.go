@@ -0,0 +1,130 @@
+package ast
+
+import "fmt"
+
+// Visitor visits the nodes walked by Walk
+type Visitor interface {
+	// Visit is called for each node before its children, the children are
+	// walked with the visitor returned unless it's nil, then Visit of the
+	// visitor returned is called with nil after the children
+	Visit(node Node) (w Visitor)
+}
+
+// Walk traverses the node and its descendants in depth-first order, the
+// children are walked in the order of source code. The nodes are *Block,
+// the stats and the expressions, the nil children like the missing keys
+// of table fields are skipped
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+	for _, child := range Children(node) {
+		Walk(v, child)
+	}
+	v.Visit(nil)
+}
+
+// Inspect traverses the node like Walk, pre is called for each node
+// before its children, the children and post are skipped if it returns
+// false; post is called for each node after its children. Either of them
+// can be nil
+func Inspect(node Node, pre func(Node) bool, post func(Node)) {
+	if pre != nil && !pre(node) {
+		return
+	}
+	for _, child := range Children(node) {
+		Inspect(child, pre, post)
+	}
+	if post != nil {
+		post(node)
+	}
+}
+
+// Children returns the child nodes of node in the order of source code
+func Children(node Node) []Node {
+	var children []Node
+	add := func(nodes ...Node) {
+		for _, n := range nodes {
+			if !isNil(n) {
+				children = append(children, n)
+			}
+		}
+	}
+	addExps := func(exps []Exp) {
+		for _, exp := range exps {
+			add(exp)
+		}
+	}
+
+	switch x := node.(type) {
+	case *Block:
+		for _, stat := range x.Stats {
+			add(stat)
+		}
+		addExps(x.RetExps)
+
+	case *EmptyStat, *BreakStat, *LabelStat, *GotoStat:
+	case *AssignStat:
+		addExps(x.VarList)
+		addExps(x.ExpList)
+	case *DoStat:
+		add(x.MBlock)
+	case *WhileStat:
+		add(x.BExp, x.MBlock)
+	case *RepeatStat:
+		add(x.MBlock, x.BExp)
+	case *IfStat:
+		for i, exp := range x.BExps {
+			add(exp, x.Blocks[i])
+		}
+	case *ForNumStat:
+		add(x.InitExp, x.LimitExp, x.StepExp, x.MBlock)
+	case *ForInStat:
+		addExps(x.ExpList)
+		add(x.MBlock)
+	case *LocalFuncDefStat:
+		add(x.Func)
+	case *LocalVarDeclStat:
+		addExps(x.ExpList)
+
+	case *NilExp, *TrueExp, *FalseExp, *IntegerExp, *FloatExp, *StringExp,
+		*VarargExp, *NameExp:
+	case *TableConstructionExp:
+		for i, key := range x.KeyExps {
+			add(key, x.ValExps[i])
+		}
+	case *FuncDefExp:
+		add(x.MBlock)
+	case *TableAccessExp:
+		add(x.PrefixExp, x.Key)
+	case *FuncCallExp:
+		add(x.PrefixExp, x.FNameExp)
+		addExps(x.Args)
+	case *ParensExp:
+		add(x.MExp)
+	case *UnOpExp:
+		add(x.MExp)
+	case *BinOpExp:
+		add(x.Exp1, x.Exp2)
+	case *ConcatExp:
+		addExps(x.Exps)
+	default:
+		panic(fmt.Sprintf("ast: unknown node %T", node))
+	}
+	return children
+}
+
+// reports whether node is nil or a typed nil pointer
+func isNil(node Node) bool {
+	switch x := node.(type) {
+	case nil:
+		return true
+	case *Block:
+		return x == nil
+	case *FuncDefExp:
+		return x == nil
+	case *StringExp:
+		return x == nil
+	}
+	return false
+}
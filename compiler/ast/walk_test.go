@@ -0,0 +1,367 @@
+package ast_test
+
+import (
+	"fmt"
+	. "luago/compiler/ast"
+	"luago/compiler/lexer"
+	"luago/compiler/parser"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// source with all types of node
+const allNodesSrc = `local a, b = nil, true
+local function f(x, ...) return false, 1, 2.5, "s", ... end
+t = {1, k = 2, [3] = 4}
+t.x, t[1] = f(a):m(b), #t
+::top::
+while a do break end
+repeat goto top until -a
+if a then elseif b then else end
+for i = 1, 2, 3 do end
+for k, v in pairs(t) do end
+do print(a .. b, (f)(a) + 1, not a, function() end) end
+return`
+
+var allNodeTypes = []Node{
+	&Block{},
+	&EmptyStat{}, &AssignStat{}, &LabelStat{}, &BreakStat{}, &GotoStat{},
+	&DoStat{}, &WhileStat{}, &RepeatStat{}, &IfStat{}, &ForNumStat{},
+	&ForInStat{}, &LocalFuncDefStat{}, &LocalVarDeclStat{},
+	&NilExp{}, &TrueExp{}, &FalseExp{}, &IntegerExp{}, &FloatExp{},
+	&StringExp{}, &VarargExp{}, &TableConstructionExp{}, &FuncDefExp{},
+	&NameExp{}, &TableAccessExp{}, &FuncCallExp{}, &ParensExp{},
+	&UnOpExp{}, &BinOpExp{}, &ConcatExp{},
+}
+
+func parse(t *testing.T, src string) *Block {
+	t.Helper()
+	chunk, diags := parser.ParseChunk(src, "src")
+	if len(diags) > 0 {
+		t.Fatalf("%s: %v", src, diags)
+	}
+	return chunk.Block
+}
+
+func TestInspectAllNodes(t *testing.T) {
+	block := parse(t, allNodesSrc)
+	block.Stats = append(block.Stats, &EmptyStat{}) // dropped by parser
+	seen := map[reflect.Type]bool{}
+	visited := map[Node]bool{}
+	Inspect(block, func(node Node) bool {
+		seen[reflect.TypeOf(node)] = true
+		visited[node] = true
+		return true
+	}, nil)
+	for _, node := range allNodeTypes {
+		if !seen[reflect.TypeOf(node)] {
+			t.Errorf("%T not walked", node)
+		}
+	}
+
+	// all nodes reachable by reflection are walked
+	reachable := map[Node]bool{}
+	collectNodes(reflect.ValueOf(block), reachable)
+	if len(reachable) != len(visited) {
+		t.Errorf("want %d nodes, walked %d", len(reachable), len(visited))
+	}
+	for node := range reachable {
+		if !visited[node] {
+			t.Errorf("%T at %v not walked", node, node.Pos().Start)
+		}
+	}
+}
+
+// collects the nodes in v by reflection
+func collectNodes(v reflect.Value, nodes map[Node]bool) {
+	switch v.Kind() {
+	case reflect.Interface:
+		if !v.IsNil() {
+			collectNodes(v.Elem(), nodes)
+		}
+	case reflect.Ptr:
+		if v.IsNil() {
+			return
+		}
+		if node, ok := v.Interface().(Node); ok {
+			if nodes[node] {
+				return
+			}
+			nodes[node] = true
+		}
+		collectNodes(v.Elem(), nodes)
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			collectNodes(v.Index(i), nodes)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			collectNodes(v.Field(i), nodes)
+		}
+	}
+}
+
+func TestInspectOrder(t *testing.T) {
+	block := parse(t, "x = f(a, b.c) + -d")
+	var events []string
+	Inspect(block, func(node Node) bool {
+		events = append(events, "+"+nodeName(node))
+		return true
+	}, func(node Node) {
+		events = append(events, "-"+nodeName(node))
+	})
+	want := "+Block +AssignStat +NameExp(x) -NameExp(x) +BinOpExp +FuncCallExp " +
+		"+NameExp(f) -NameExp(f) +NameExp(a) -NameExp(a) +TableAccessExp +NameExp(b) -NameExp(b) " +
+		"+StringExp(c) -StringExp(c) -TableAccessExp -FuncCallExp +UnOpExp +NameExp(d) -NameExp(d) " +
+		"-UnOpExp -BinOpExp -AssignStat -Block"
+	if got := strings.Join(events, " "); got != want {
+		t.Errorf("want:\n%s\ngot:\n%s", want, got)
+	}
+
+	// skips the functions
+	var names []string
+	Inspect(parse(t, "f(function(a) return a end, b)"), func(node Node) bool {
+		if name, ok := node.(*NameExp); ok {
+			names = append(names, name.Name)
+		}
+		_, ok := node.(*FuncDefExp)
+		return !ok
+	}, nil)
+	if got := strings.Join(names, " "); got != "f b" {
+		t.Errorf("want f b, got %s", got)
+	}
+}
+
+type depthVisitor struct {
+	depth  *int
+	max    *int
+	events *[]string
+}
+
+func (v depthVisitor) Visit(node Node) Visitor {
+	if node == nil {
+		*v.depth--
+		*v.events = append(*v.events, "end")
+		return nil
+	}
+	*v.depth++
+	if *v.depth > *v.max {
+		*v.max = *v.depth
+	}
+	*v.events = append(*v.events, nodeName(node))
+	if _, ok := node.(*TableConstructionExp); ok {
+		*v.depth--
+		return nil // skips the children, no end
+	}
+	return v
+}
+
+func TestWalk(t *testing.T) {
+	depth, max := 0, 0
+	var events []string
+	Walk(depthVisitor{&depth, &max, &events}, parse(t, "local t = {1, 2} do return end"))
+	want := "Block LocalVarDeclStat TableConstructionExp end DoStat Block end end end"
+	if got := strings.Join(events, " "); got != want || depth != 0 || max != 3 {
+		t.Errorf("want %s, got %s, depth=%d max=%d", want, got, depth, max)
+	}
+}
+
+func TestChildren(t *testing.T) {
+	block := parse(t, "if a then x() elseif b then else y() end t = {k = 1, 2}")
+	var got []string
+	for _, child := range Children(block.Stats[0]) {
+		got = append(got, nodeName(child))
+	}
+	want := "NameExp(a) Block NameExp(b) Block TrueExp Block"
+	if strings.Join(got, " ") != want {
+		t.Errorf("want %s, got %s", want, strings.Join(got, " "))
+	}
+	got = nil
+	for _, child := range Children(block.Stats[1].(*AssignStat).ExpList[0]) {
+		got = append(got, nodeName(child))
+	}
+	if want := "StringExp(k) IntegerExp(1) IntegerExp(2)"; strings.Join(got, " ") != want {
+		t.Errorf("want %s, got %s", want, strings.Join(got, " "))
+	}
+}
+
+func TestRewriteReplace(t *testing.T) {
+	block := parse(t, "local x = 1 + 2\nprint(x,\n  1)")
+	result := Rewrite(block, nil, func(node Node) Node {
+		if n, ok := node.(*IntegerExp); ok && n.Val == 1 {
+			return &IntegerExp{Val: 10}
+		}
+		return node
+	})
+	if result != block {
+		t.Fatalf("root replaced")
+	}
+	first := block.Stats[0].(*LocalVarDeclStat).ExpList[0].(*BinOpExp).Exp1.(*IntegerExp)
+	if first.Val != 10 || first.Line != 1 || first.Start.Column != 11 || first.End.Column != 12 {
+		t.Errorf("unexpected node: %+v", first)
+	}
+	arg := block.Stats[1].(*FuncCallExp).Args[1].(*IntegerExp)
+	if arg.Val != 10 || arg.Line != 3 || arg.Start.Line != 3 || arg.Start.Column != 3 {
+		t.Errorf("unexpected node: %+v", arg)
+	}
+
+	// folds the constants after the children
+	block = parse(t, "x = 1 + 2 * 3")
+	Rewrite(block, nil, func(node Node) Node {
+		if n, ok := node.(*BinOpExp); ok {
+			a, ok1 := n.Exp1.(*IntegerExp)
+			b, ok2 := n.Exp2.(*IntegerExp)
+			if ok1 && ok2 {
+				if n.Op == lexer.TokenOpAdd {
+					return &IntegerExp{Val: a.Val + b.Val}
+				}
+				return &IntegerExp{Val: a.Val * b.Val}
+			}
+		}
+		return node
+	})
+	folded := block.Stats[0].(*AssignStat).ExpList[0].(*IntegerExp)
+	if folded.Val != 7 || folded.Start.Column != 5 || folded.End.Column != 14 || folded.Line != 1 {
+		t.Errorf("unexpected node: %+v", folded)
+	}
+}
+
+func TestRewriteDelete(t *testing.T) {
+	block := parse(t, `print(1)
+local t = {1, debug = true, [k] = 2, 3}
+f(a, debug, b)
+if false then x() elseif c then y() else z() end
+while c do print(2) end
+return debug, 1`)
+	Rewrite(block, nil, func(node Node) Node {
+		switch x := node.(type) {
+		case *FuncCallExp:
+			if name, ok := x.PrefixExp.(*NameExp); ok && name.Name == "print" {
+				return nil
+			}
+		case *NameExp:
+			if x.Name == "debug" || x.Name == "k" {
+				return nil
+			}
+		case *StringExp:
+			if x.Str == "debug" {
+				return nil
+			}
+		case *FalseExp:
+			return nil
+		case *Block:
+			if len(x.Stats) == 1 {
+				if call, ok := x.Stats[0].(*FuncCallExp); ok && call.PrefixExp.(*NameExp).Name == "z" {
+					return nil
+				}
+			}
+		}
+		return node
+	})
+
+	if n := len(block.Stats); n != 4 {
+		t.Fatalf("want 4 stats, got %d", n)
+	}
+	tc := block.Stats[0].(*LocalVarDeclStat).ExpList[0].(*TableConstructionExp)
+	if len(tc.KeyExps) != 2 || len(tc.ValExps) != 2 || tc.KeyExps[0] != nil || tc.ValExps[1].(*IntegerExp).Val != 3 {
+		t.Errorf("unexpected table: %+v", tc)
+	}
+	if call := block.Stats[1].(*FuncCallExp); len(call.Args) != 2 {
+		t.Errorf("unexpected args: %+v", call.Args)
+	}
+	ifStat := block.Stats[2].(*IfStat)
+	if len(ifStat.BExps) != 2 || len(ifStat.Blocks) != 2 || ifStat.BExps[0].(*NameExp).Name != "c" ||
+		len(ifStat.Blocks[1].Stats) != 0 || ifStat.Blocks[1].Span.Start.Line != 4 {
+		t.Errorf("unexpected if: %+v", ifStat)
+	}
+	if while := block.Stats[3].(*WhileStat); len(while.MBlock.Stats) != 0 {
+		t.Errorf("unexpected while: %+v", while)
+	}
+	if len(block.RetExps) != 1 {
+		t.Errorf("unexpected return: %+v", block.RetExps)
+	}
+
+	block = parse(t, "return")
+	Rewrite(block, nil, func(node Node) Node { return node })
+	if block.RetExps == nil {
+		t.Errorf("return removed")
+	}
+	if Rewrite(parse(t, "x()"), nil, func(node Node) Node {
+		if _, ok := node.(*Block); ok {
+			return nil
+		}
+		return node
+	}) != nil {
+		t.Errorf("root not deleted")
+	}
+}
+
+func TestRewriteSkip(t *testing.T) {
+	block := parse(t, "x = 1 f = function() return 1 end")
+	count := 0
+	Rewrite(block, func(node Node) bool {
+		_, ok := node.(*FuncDefExp)
+		return !ok
+	}, func(node Node) Node {
+		if _, ok := node.(*IntegerExp); ok {
+			count++
+			return &IntegerExp{Val: 2}
+		}
+		return node
+	})
+	ret := block.Stats[1].(*AssignStat).ExpList[0].(*FuncDefExp).MBlock.RetExps[0].(*IntegerExp)
+	if count != 1 || ret.Val != 1 {
+		t.Errorf("function rewritten: count=%d, ret=%d", count, ret.Val)
+	}
+}
+
+func TestRewritePanic(t *testing.T) {
+	for _, test := range []struct {
+		src  string
+		post func(Node) Node
+		want string
+	}{
+		{"x = a + b", func(node Node) Node {
+			if _, ok := node.(*NameExp); ok {
+				return nil
+			}
+			return node
+		}, "ast: required *ast.NameExp of *ast.BinOpExp deleted"},
+		{"local function f() end", func(node Node) Node {
+			if _, ok := node.(*FuncDefExp); ok {
+				return &NilExp{}
+			}
+			return node
+		}, "ast: function of *ast.LocalFuncDefStat replaced by non *FuncDefExp"},
+		{"do end", func(node Node) Node {
+			if _, ok := node.(*Block); ok {
+				return &EmptyStat{}
+			}
+			return node
+		}, "ast: block replaced by *ast.EmptyStat"},
+	} {
+		func() {
+			defer func() {
+				if r := recover(); fmt.Sprint(r) != test.want {
+					t.Errorf("%s: want panic %q, got %v", test.src, test.want, r)
+				}
+			}()
+			block := parse(t, test.src)
+			Rewrite(block.Stats[0], nil, test.post)
+		}()
+	}
+}
+
+func nodeName(node Node) string {
+	name := strings.TrimPrefix(reflect.TypeOf(node).String(), "*ast.")
+	switch x := node.(type) {
+	case *NameExp:
+		name += "(" + x.Name + ")"
+	case *StringExp:
+		name += "(" + x.Str + ")"
+	case *IntegerExp:
+		name += fmt.Sprintf("(%d)", x.Val)
+	}
+	return name
+}
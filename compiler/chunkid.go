@@ -0,0 +1,38 @@
+package compiler
+
+import "strings"
+
+const idSize = 60 // like LUA_IDSIZE
+
+// ChunkID returns the name of chunk used in messages,
+// "=name" => "name", "@file" => "file", source => [string "source"]
+func ChunkID(source string) string {
+	switch {
+	case strings.HasPrefix(source, "="):
+		src := source[1:]
+		if len(src) > idSize-1 {
+			src = src[:idSize-1]
+		}
+		return src
+	case strings.HasPrefix(source, "@"):
+		src := source[1:]
+		if len(src) > idSize-1 {
+			src = "..." + src[len(src)-(idSize-1-3):]
+		}
+		return src
+	default:
+		const pre, post, dots = `[string "`, `"]`, "..."
+		l := idSize - 1 - len(pre) - len(dots) - len(post)
+		nl := strings.IndexByte(source, '\n')
+		if nl < 0 && len(source) <= l {
+			return pre + source + post
+		}
+		if nl >= 0 {
+			source = source[:nl]
+		}
+		if len(source) > l {
+			source = source[:l]
+		}
+		return pre + source + dots + post
+	}
+}
@@ -0,0 +1,75 @@
+package codegen
+
+import "luago/compiler/ast"
+
+// block ::= {stat} [retstat]
+func cgBlock(fi *funcInfo, node *ast.Block) {
+	usedRegs := fi.usedRegs
+	for i, stat := range node.Stats {
+		if label, ok := stat.(*ast.LabelStat); ok {
+			// a label at the end of block is out of the scope of
+			// the local variables declared in the block
+			if node.RetExps == nil && onlyLabelsAfter(node.Stats, i) {
+				fi.addLabel(label.Name, label.Line, usedRegs)
+				continue
+			}
+		}
+		cgStat(fi, stat)
+	}
+
+	if node.RetExps != nil {
+		cgRetStat(fi, node.RetExps, node.LastLine)
+	}
+}
+
+func onlyLabelsAfter(stats []ast.Stat, i int) bool {
+	for _, stat := range stats[i+1:] {
+		if _, ok := stat.(*ast.LabelStat); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// retstat ::= `return` [explist] [`;`]
+func cgRetStat(fi *funcInfo, exps []ast.Exp, lastLine int) {
+	nExps := len(exps)
+	if nExps == 0 {
+		fi.emitReturn(lastLine, 0, 0)
+		return
+	}
+
+	if nExps == 1 {
+		if nameExp, ok := exps[0].(*ast.NameExp); ok { // return local
+			if r := fi.slotOfLocVar(nameExp.Name); r >= 0 {
+				fi.emitReturn(lastLine, r, 1)
+				return
+			}
+		}
+		if fcExp, ok := exps[0].(*ast.FuncCallExp); ok { // return f(args)
+			r := fi.allocReg()
+			cgTailCallExp(fi, fcExp, r)
+			fi.freeReg()
+			fi.emitReturn(lastLine, r, -1)
+			return
+		}
+	}
+
+	multRet := isVarargOrFuncCall(exps[nExps-1])
+	for i, exp := range exps {
+		r := fi.allocReg()
+		if i == nExps-1 && multRet {
+			cgExp(fi, exp, r, -1)
+		} else {
+			cgExp(fi, exp, r, 1)
+		}
+	}
+	fi.freeRegs(nExps)
+
+	a := fi.usedRegs // first register of return values
+	if multRet {
+		fi.emitReturn(lastLine, a, -1)
+	} else {
+		fi.emitReturn(lastLine, a, nExps)
+	}
+}
@@ -0,0 +1,306 @@
+package codegen
+
+import (
+	"luago/compiler/ast"
+	"luago/compiler/lexer"
+)
+
+// kinds of operand
+const (
+	argConst = 1                 // const index
+	argReg   = 2                 // register index
+	argUpval = 4                 // upvalue index
+	argRK    = argReg | argConst // register or const index
+	argRU    = argReg | argUpval // register or upvalue index
+)
+
+// generates the code of exp, the results are put into r(a), ..., r(a+n-1)
+// n == -1 means all results
+func cgExp(fi *funcInfo, node ast.Exp, a, n int) {
+	switch exp := node.(type) {
+	case *ast.NilExp:
+		fi.emitLoadNil(exp.Line, a, n)
+	case *ast.FalseExp:
+		fi.emitLoadBool(exp.Line, a, 0, 0)
+	case *ast.TrueExp:
+		fi.emitLoadBool(exp.Line, a, 1, 0)
+	case *ast.IntegerExp:
+		fi.emitLoadk(exp.Line, a, exp.Val)
+	case *ast.FloatExp:
+		fi.emitLoadk(exp.Line, a, exp.Val)
+	case *ast.StringExp:
+		fi.emitLoadk(exp.Line, a, exp.Str)
+	case *ast.ParensExp:
+		cgExp(fi, exp.MExp, a, 1)
+	case *ast.VarargExp:
+		cgVarargExp(fi, exp, a, n)
+	case *ast.FuncDefExp:
+		cgFuncDefExp(fi, exp, a)
+	case *ast.TableConstructionExp:
+		cgTableConstructionExp(fi, exp, a)
+	case *ast.UnOpExp:
+		cgUnOpExp(fi, exp, a)
+	case *ast.BinOpExp:
+		cgBinOpExp(fi, exp, a)
+	case *ast.ConcatExp:
+		cgConcatExp(fi, exp, a)
+	case *ast.NameExp:
+		cgNameExp(fi, exp, a)
+	case *ast.TableAccessExp:
+		cgTableAccessExp(fi, exp, a)
+	case *ast.FuncCallExp:
+		cgFuncCallExp(fi, exp, a, n)
+	}
+}
+
+func cgVarargExp(fi *funcInfo, node *ast.VarargExp, a, n int) {
+	if !fi.isVararg {
+		panic("cannot use '...' outside a vararg function")
+	}
+	fi.emitVararg(node.Line, a, n)
+}
+
+// r(a) := function(args) body end
+func cgFuncDefExp(fi *funcInfo, node *ast.FuncDefExp, a int) {
+	subFI := newFuncInfo(fi, node)
+	fi.subFuncs = append(fi.subFuncs, subFI)
+
+	for _, param := range node.ParList {
+		subFI.addLocVar(param, 0)
+	}
+
+	cgBlock(subFI, node.MBlock)
+	subFI.exitScope(subFI.pc() + 2)
+	subFI.emitReturn(node.LastLine, 0, 0)
+
+	bx := len(fi.subFuncs) - 1
+	fi.emitClosure(node.LastLine, a, bx)
+}
+
+func cgTableConstructionExp(fi *funcInfo, node *ast.TableConstructionExp, a int) {
+	nArr := 0
+	for _, keyExp := range node.KeyExps {
+		if keyExp == nil {
+			nArr++
+		}
+	}
+	nExps := len(node.KeyExps)
+	multRet := nExps > 0 && isVarargOrFuncCall(node.ValExps[nExps-1])
+
+	fi.emitNewTable(node.FirstLine, a, nArr, nExps-nArr)
+
+	arrIdx := 0
+	for i, keyExp := range node.KeyExps {
+		valExp := node.ValExps[i]
+
+		if keyExp == nil { // array part
+			arrIdx++
+			tmp := fi.allocReg()
+			if i == nExps-1 && multRet {
+				cgExp(fi, valExp, tmp, -1)
+			} else {
+				cgExp(fi, valExp, tmp, 1)
+			}
+
+			if arrIdx%lFieldsPerFlush == 0 || arrIdx == nArr { // flush
+				n := arrIdx % lFieldsPerFlush
+				if n == 0 {
+					n = lFieldsPerFlush
+				}
+				fi.freeRegs(n)
+				line := lastLineOf(valExp)
+				c := (arrIdx-1)/lFieldsPerFlush + 1
+				if i == nExps-1 && multRet {
+					fi.emitSetList(line, a, 0, c)
+				} else {
+					fi.emitSetList(line, a, n, c)
+				}
+			}
+			continue
+		}
+
+		// hash part
+		b := fi.allocReg()
+		cgExp(fi, keyExp, b, 1)
+		c := fi.allocReg()
+		cgExp(fi, valExp, c, 1)
+		fi.freeRegs(2)
+
+		line := lastLineOf(valExp)
+		fi.emitSetTable(line, a, b, c)
+	}
+}
+
+// r(a) := op exp
+func cgUnOpExp(fi *funcInfo, node *ast.UnOpExp, a int) {
+	oldRegs := fi.usedRegs
+	b, _ := expToOpArg(fi, node.MExp, argReg)
+	fi.emitUnaryOp(node.Line, node.Op, a, b)
+	fi.usedRegs = oldRegs
+}
+
+// r(a) := exp1 .. exp2 .. ... .. expn
+func cgConcatExp(fi *funcInfo, node *ast.ConcatExp, a int) {
+	for _, subExp := range node.Exps {
+		tmp := fi.allocReg()
+		cgExp(fi, subExp, tmp, 1)
+	}
+
+	c := fi.usedRegs - 1
+	b := c - len(node.Exps) + 1
+	fi.freeRegs(c - b + 1)
+	fi.emitConcat(node.Line, a, b, c)
+}
+
+// r(a) := exp1 op exp2
+func cgBinOpExp(fi *funcInfo, node *ast.BinOpExp, a int) {
+	switch node.Op {
+	case lexer.TokenOpAnd, lexer.TokenOpOr:
+		oldRegs := fi.usedRegs
+
+		b, _ := expToOpArg(fi, node.Exp1, argReg)
+		fi.usedRegs = oldRegs
+		if node.Op == lexer.TokenOpAnd {
+			fi.emitTestSet(node.Line, a, b, 0)
+		} else {
+			fi.emitTestSet(node.Line, a, b, 1)
+		}
+		pcOfJmp := fi.emitJmp(node.Line, 0, 0)
+
+		b, _ = expToOpArg(fi, node.Exp2, argReg)
+		fi.usedRegs = oldRegs
+		fi.emitMove(node.Line, a, b)
+		fi.fixSbx(pcOfJmp, fi.pc()-pcOfJmp)
+	default:
+		oldRegs := fi.usedRegs
+		b, _ := expToOpArg(fi, node.Exp1, argRK)
+		c, _ := expToOpArg(fi, node.Exp2, argRK)
+		fi.emitBinaryOp(node.Line, node.Op, a, b, c)
+		fi.usedRegs = oldRegs
+	}
+}
+
+// r(a) := name
+func cgNameExp(fi *funcInfo, node *ast.NameExp, a int) {
+	if r := fi.slotOfLocVar(node.Name); r >= 0 {
+		fi.emitMove(node.Line, a, r)
+	} else if idx := fi.indexOfUpvalue(node.Name); idx >= 0 {
+		fi.emitGetUpval(node.Line, a, idx)
+	} else { // x => _ENV['x']
+		taExp := &ast.TableAccessExp{
+			Span:      node.Span,
+			LastLine:  node.Line,
+			PrefixExp: &ast.NameExp{Span: node.Span, Line: node.Line, Name: "_ENV"},
+			Key:       &ast.StringExp{Span: node.Span, Line: node.Line, Str: node.Name},
+		}
+		cgTableAccessExp(fi, taExp, a)
+	}
+}
+
+// r(a) := prefix[key]
+func cgTableAccessExp(fi *funcInfo, node *ast.TableAccessExp, a int) {
+	oldRegs := fi.usedRegs
+	b, kindB := expToOpArg(fi, node.PrefixExp, argRU)
+	c, _ := expToOpArg(fi, node.Key, argRK)
+	fi.usedRegs = oldRegs
+
+	if kindB == argUpval {
+		fi.emitGetTabUp(node.LastLine, a, b, c)
+	} else {
+		fi.emitGetTable(node.LastLine, a, b, c)
+	}
+}
+
+// r(a) ... r(a+n-1) := f(args)
+func cgFuncCallExp(fi *funcInfo, node *ast.FuncCallExp, a, n int) {
+	nArgs := prepFuncCall(fi, node, a)
+	fi.emitCall(node.FirstLine, a, nArgs, n)
+}
+
+// return f(args)
+func cgTailCallExp(fi *funcInfo, node *ast.FuncCallExp, a int) {
+	nArgs := prepFuncCall(fi, node, a)
+	fi.emitTailCall(node.FirstLine, a, nArgs)
+}
+
+// puts function and arguments into r(a), r(a+1), ...
+// returns the count of arguments, -1 means the last argument has multiple results
+func prepFuncCall(fi *funcInfo, node *ast.FuncCallExp, a int) int {
+	nArgs := len(node.Args)
+	lastArgIsVarargOrFuncCall := false
+
+	cgExp(fi, node.PrefixExp, a, 1)
+	if node.FNameExp != nil { // obj:f(args)
+		fi.allocReg()
+		c, k := expToOpArg(fi, node.FNameExp, argRK)
+		fi.emitSelf(node.FirstLine, a, a, c)
+		if k == argReg {
+			fi.freeRegs(1)
+		}
+	}
+
+	for i, arg := range node.Args {
+		tmp := fi.allocReg()
+		if i == nArgs-1 && isVarargOrFuncCall(arg) {
+			lastArgIsVarargOrFuncCall = true
+			cgExp(fi, arg, tmp, -1)
+		} else {
+			cgExp(fi, arg, tmp, 1)
+		}
+	}
+	fi.freeRegs(nArgs)
+
+	if node.FNameExp != nil {
+		fi.freeReg()
+		nArgs++
+	}
+	if lastArgIsVarargOrFuncCall {
+		nArgs = -1
+	}
+
+	return nArgs
+}
+
+// converts exp to the operand of instruction,
+// allocates a register and evaluates exp if it's necessary
+func expToOpArg(fi *funcInfo, node ast.Exp, argKinds int) (arg, argKind int) {
+	if argKinds&argConst > 0 {
+		idx := -1
+		switch x := node.(type) {
+		case *ast.NilExp:
+			idx = fi.indexOfConstant(nil)
+		case *ast.FalseExp:
+			idx = fi.indexOfConstant(false)
+		case *ast.TrueExp:
+			idx = fi.indexOfConstant(true)
+		case *ast.IntegerExp:
+			idx = fi.indexOfConstant(x.Val)
+		case *ast.FloatExp:
+			idx = fi.indexOfConstant(x.Val)
+		case *ast.StringExp:
+			idx = fi.indexOfConstant(x.Str)
+		}
+		if idx >= 0 && idx <= 0xFF {
+			return 0x100 + idx, argConst
+		}
+	}
+
+	if nameExp, ok := node.(*ast.NameExp); ok {
+		if argKinds&argReg > 0 {
+			if r := fi.slotOfLocVar(nameExp.Name); r >= 0 {
+				return r, argReg
+			}
+		}
+		if argKinds&argUpval > 0 {
+			if idx := fi.indexOfUpvalue(nameExp.Name); idx >= 0 {
+				return idx, argUpval
+			}
+		}
+	}
+
+	a := fi.allocReg()
+	cgExp(fi, node, a, 1)
+	return a, argReg
+}
+
+const lFieldsPerFlush = 50
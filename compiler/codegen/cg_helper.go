@@ -0,0 +1,86 @@
+package codegen
+
+import "luago/compiler/ast"
+
+func isVarargOrFuncCall(exp ast.Exp) bool {
+	switch exp.(type) {
+	case *ast.VarargExp, *ast.FuncCallExp:
+		return true
+	}
+	return false
+}
+
+// removes the nil expressions at the tail of list,
+// `local a, b = 1, nil` => `local a, b = 1`
+func removeTailNils(exps []ast.Exp) []ast.Exp {
+	for n := len(exps) - 1; n >= 0; n-- {
+		if _, ok := exps[n].(*ast.NilExp); !ok {
+			return exps[0 : n+1]
+		}
+	}
+	return nil
+}
+
+// returns the line of the first token of exp
+func lineOf(exp ast.Exp) int {
+	switch x := exp.(type) {
+	case *ast.NilExp:
+		return x.Line
+	case *ast.TrueExp:
+		return x.Line
+	case *ast.FalseExp:
+		return x.Line
+	case *ast.IntegerExp:
+		return x.Line
+	case *ast.FloatExp:
+		return x.Line
+	case *ast.StringExp:
+		return x.Line
+	case *ast.VarargExp:
+		return x.Line
+	case *ast.NameExp:
+		return x.Line
+	case *ast.FuncDefExp:
+		return x.FirstLine
+	case *ast.FuncCallExp:
+		return lineOf(x.PrefixExp)
+	case *ast.TableConstructionExp:
+		return x.FirstLine
+	case *ast.TableAccessExp:
+		return lineOf(x.PrefixExp)
+	case *ast.ConcatExp:
+		return lineOf(x.Exps[0])
+	case *ast.BinOpExp:
+		return lineOf(x.Exp1)
+	case *ast.UnOpExp:
+		return x.Line
+	case *ast.ParensExp:
+		return lineOf(x.MExp)
+	default:
+		panic("unreachable")
+	}
+}
+
+// returns the line of the last token of exp
+func lastLineOf(exp ast.Exp) int {
+	switch x := exp.(type) {
+	case *ast.FuncDefExp:
+		return x.LastLine
+	case *ast.FuncCallExp:
+		return x.LastLine
+	case *ast.TableConstructionExp:
+		return x.LastLine
+	case *ast.TableAccessExp:
+		return x.LastLine
+	case *ast.ConcatExp:
+		return lastLineOf(x.Exps[len(x.Exps)-1])
+	case *ast.BinOpExp:
+		return lastLineOf(x.Exp2)
+	case *ast.UnOpExp:
+		return lastLineOf(x.MExp)
+	case *ast.ParensExp:
+		return lastLineOf(x.MExp)
+	default:
+		return lineOf(exp)
+	}
+}
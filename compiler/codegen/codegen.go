@@ -0,0 +1,21 @@
+package codegen
+
+import (
+	"luago/binchunk"
+	"luago/compiler/ast"
+)
+
+// GenProto generates the function prototype of main chunk
+// main chunk is a vararg function which has a upvalue `_ENV`
+func GenProto(chunk *ast.Block) *binchunk.ProtoType {
+	fd := &ast.FuncDefExp{
+		LastLine: chunk.LastLine,
+		IsVararg: true,
+		MBlock:   chunk,
+	}
+
+	fi := newFuncInfo(nil, fd)
+	fi.addLocVar("_ENV", 0)
+	cgFuncDefExp(fi, fd, 0)
+	return toProto(fi.subFuncs[0])
+}
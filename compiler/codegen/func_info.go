@@ -1,9 +1,10 @@
 package codegen
 
 import (
-	"vczn/luago/compiler/ast"
-	"vczn/luago/compiler/lexer"
-	"vczn/luago/vm"
+	"fmt"
+	"luago/compiler/ast"
+	"luago/compiler/lexer"
+	"luago/vm"
 )
 
 type funcInfo struct {
@@ -41,11 +42,14 @@ type labelInfo struct {
 	line       int
 	pc         int
 	scopeDepth int // start from 0
+	usedRegs   int // active local variables at the label
 }
 
 type gotoInfo struct {
+	line       int
 	jmpPC      int
 	scopeDepth int
+	usedRegs   int // active local variables at the goto
 	label      string
 	pending    bool
 }
@@ -141,7 +145,7 @@ func (fi *funcInfo) enterScope(canBreak bool) {
 	}
 }
 
-func (fi *funcInfo) exitScope() {
+func (fi *funcInfo) exitScope(endPC int) {
 	pendingBreakJmps := fi.breaks[len(fi.breaks)-1]
 	fi.breaks = fi.breaks[:len(fi.breaks)-1]
 
@@ -152,33 +156,143 @@ func (fi *funcInfo) exitScope() {
 		fi.insts[pc] = uint32(inst)
 	}
 
-	fi.fixGotoJmps()
-
 	fi.scopeDepth--
+	fi.fixGotoJmps()
 	for _, locVar := range fi.locNames {
 		if locVar.scopeDepth > fi.scopeDepth {
-			fi.removeLocVar(locVar)
+			fi.removeLocVar(locVar, endPC)
 		}
 	}
 }
 
+// returns the A operand of JMP which closes the upvalues
+// captured from the local variables of current scope
 func (fi *funcInfo) getJmpArgA() int {
-	// TODO
+	hasCapturedLocVars := false
+	minSlotOfLocVars := fi.maxRegs
+	for _, locVar := range fi.locNames {
+		for v := locVar; v != nil && v.scopeDepth == fi.scopeDepth; v = v.prev {
+			if v.captured {
+				hasCapturedLocVars = true
+			}
+			if v.slot < minSlotOfLocVars && v.name[0] != '(' {
+				minSlotOfLocVars = v.slot
+			}
+		}
+	}
+
+	if hasCapturedLocVars {
+		return minSlotOfLocVars + 1
+	}
 	return 0
 }
 
+// emits a JMP which closes the upvalues of current scope if necessary
+func (fi *funcInfo) closeOpenUpvals(line int) {
+	if a := fi.getJmpArgA(); a > 0 {
+		fi.emitJmp(line, a, 0)
+	}
+}
+
+func labelKey(name string, scopeDepth int) string {
+	return fmt.Sprintf("%s@%d", name, scopeDepth)
+}
+
+// add a label at the next pc, usedRegs is the count of active local variables
+// which are visible from the label
+func (fi *funcInfo) addLabel(name string, line, usedRegs int) {
+	key := labelKey(name, fi.scopeDepth)
+	if label, found := fi.labels[key]; found {
+		panic(fmt.Sprintf("label '%s' already defined on line %d", name, label.line))
+	}
+
+	label := labelInfo{
+		line:       line,
+		pc:         fi.pc() + 1,
+		scopeDepth: fi.scopeDepth,
+		usedRegs:   usedRegs,
+	}
+	fi.labels[key] = label
+
+	// forward gotos of current block
+	for i, g := range fi.gotos {
+		if g != nil && g.label == name && g.scopeDepth >= fi.scopeDepth {
+			fi.fixGotoJmp(g, label)
+			fi.gotos[i] = nil
+		}
+	}
+}
+
+// add a goto whose JMP is at jmpPC
+func (fi *funcInfo) addGoto(name string, line, jmpPC int) {
+	g := &gotoInfo{
+		line:       line,
+		jmpPC:      jmpPC,
+		scopeDepth: fi.scopeDepth,
+		usedRegs:   fi.usedRegs,
+		label:      name,
+	}
+
+	// backward goto, search the visible label from inner to outer
+	for depth := fi.scopeDepth; depth >= 0; depth-- {
+		if label, found := fi.labels[labelKey(name, depth)]; found {
+			fi.fixGotoJmp(g, label)
+			return
+		}
+	}
+
+	g.pending = true
+	fi.gotos = append(fi.gotos, g)
+}
+
+func (fi *funcInfo) fixGotoJmp(g *gotoInfo, label labelInfo) {
+	if label.usedRegs > g.usedRegs {
+		name := "?"
+		for _, locVar := range fi.locVars {
+			if locVar.slot == g.usedRegs && locVar.startPC <= label.pc {
+				name = locVar.name
+			}
+		}
+		panic(fmt.Sprintf("<goto %s> at line %d jumps into the scope of local '%s'",
+			g.label, g.line, name))
+	}
+
+	a := 0
+	if g.usedRegs > label.usedRegs { // leaves the scope of some local variables
+		a = label.usedRegs + 1
+	}
+	inst := (label.pc-g.jmpPC-1+vm.MaxArgSBx)<<14 | a<<6 | vm.OpJMP
+	fi.insts[g.jmpPC] = uint32(inst)
+}
+
+// called after the scope depth has been decreased,
+// pending gotos of the exited block now belong to the enclosing block
 func (fi *funcInfo) fixGotoJmps() {
-	// TODO
+	for _, g := range fi.gotos {
+		if g != nil && g.scopeDepth > fi.scopeDepth {
+			g.scopeDepth = fi.scopeDepth
+			if fi.scopeDepth < 0 { // end of function
+				panic(fmt.Sprintf("no visible label '%s' for <goto> at line %d",
+					g.label, g.line))
+			}
+		}
+	}
+
+	for key, label := range fi.labels {
+		if label.scopeDepth > fi.scopeDepth {
+			delete(fi.labels, key)
+		}
+	}
 }
 
-func (fi *funcInfo) addBreakJmp(pc int) {
+func (fi *funcInfo) addBreakJmp(pc, line int) {
 	for i := fi.scopeDepth; i >= 0; i-- {
 		if fi.breaks[i] != nil { // looping block
 			fi.breaks[i] = append(fi.breaks[i], pc)
 			return
 		}
 	}
-	panic("<break> at line ? not inside a loop!")
+	panic(fmt.Sprintf("<break> at line %d not inside a loop", line))
 }
 
 // add a local variable and return the index of the variable
@@ -197,12 +311,13 @@ func (fi *funcInfo) addLocVar(name string, startPC int) int {
 	return locVar.slot
 }
 
-func (fi *funcInfo) removeLocVar(locVar *locVarInfo) {
+func (fi *funcInfo) removeLocVar(locVar *locVarInfo, endPC int) {
 	fi.freeReg()
+	locVar.endPC = endPC
 	if locVar.prev == nil {
 		delete(fi.locNames, locVar.name)
 	} else if locVar.prev.scopeDepth == locVar.scopeDepth {
-		fi.removeLocVar(locVar.prev)
+		fi.removeLocVar(locVar.prev, endPC)
 	} else {
 		fi.locNames[locVar.name] = locVar.prev
 	}
@@ -243,7 +358,7 @@ func (fi *funcInfo) indexOfUpvalue(name string) int {
 }
 
 func (fi *funcInfo) emitABCInst(line, opcode, a, b, c int) {
-	inst := b<<23 | c<<14 | c<<6 | opcode
+	inst := b<<23 | c<<14 | a<<6 | opcode
 	fi.insts = append(fi.insts, uint32(inst))
 	fi.lines = append(fi.lines, uint32(line))
 }
@@ -323,8 +438,8 @@ func (fi *funcInfo) emitSetTable(line, a, b, c int) {
 }
 
 // r(a) = {}, (arr size = b, map size c)
-func (fi *funcInfo) emitNewTable(line, a, b, c int) {
-	fi.emitABCInst(line, vm.OpNEWTABLE, a, b, c)
+func (fi *funcInfo) emitNewTable(line, a, nArr, nRec int) {
+	fi.emitABCInst(line, vm.OpNEWTABLE, a, vm.Int2fb(nArr), vm.Int2fb(nRec))
 }
 
 // r[a+1] := r[b]; r[a] := r[b][rk(c)]
@@ -406,7 +521,10 @@ func (fi *funcInfo) emitTestSet(line, a, b, c int) {
 	fi.emitABCInst(line, vm.OpTESTSET, a, b, c)
 }
 
-// Concat
+// r(a) = r(b) .. ... .. r(c)
+func (fi *funcInfo) emitConcat(line, a, b, c int) {
+	fi.emitABCInst(line, vm.OpCONCAT, a, b, c)
+}
 
 // r[a], ..., r[a+c-2] = r[a](r[a+1], ..., r[a+b-1])
 func (fi *funcInfo) emitCall(line, a, nArgs, nRets int) {
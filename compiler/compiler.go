@@ -0,0 +1,65 @@
+package compiler
+
+import (
+	"luago/binchunk"
+	"luago/compiler/codegen"
+	"luago/compiler/optimizer"
+	"luago/compiler/parser"
+	"luago/compiler/peephole"
+)
+
+// Level is the level of optimizations, a level has the optimizations of
+// the lower levels
+type Level int
+
+// levels of optimizations
+const (
+	// LevelNone keeps the code faithful to the source, for debugging
+	LevelNone Level = iota
+	// LevelFold folds the constant expressions, it's the default level
+	LevelFold
+	// LevelFull removes the dead branches, propagates the constant locals
+	// and optimizes the instructions by peephole as well, the locals
+	// propagated are no longer named by the error messages and debug info
+	LevelFull
+)
+
+// the lowest levels of the optimizations
+const (
+	levelFold         = LevelFold
+	levelDeadBranches = LevelFull
+	levelConstants    = LevelFull
+	levelPeephole     = LevelFull
+)
+
+// Compile compiles lua source code to the function prototype of main chunk
+// at the default level
+func Compile(chunk, chunkName string) *binchunk.ProtoType {
+	return CompileLevel(chunk, chunkName, LevelFold)
+}
+
+// CompileLevel compiles lua source code like Compile with the optimizations
+// of level
+func CompileLevel(chunk, chunkName string, level Level) *binchunk.ProtoType {
+	block := parser.ParseFolding(chunk, ChunkID(chunkName), level >= levelFold)
+	opts := optimizer.Options{
+		DeadBranches: level >= levelDeadBranches,
+		Constants:    level >= levelConstants,
+	}
+	if opts.DeadBranches || opts.Constants {
+		optimizer.Optimize(block, opts)
+	}
+	proto := codegen.GenProto(block)
+	setSource(proto, chunkName)
+	if level >= levelPeephole {
+		peephole.Optimize(proto)
+	}
+	return proto
+}
+
+func setSource(proto *binchunk.ProtoType, chunkName string) {
+	proto.Source = chunkName
+	for _, subProto := range proto.Protos {
+		setSource(subProto, chunkName)
+	}
+}
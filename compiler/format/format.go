@@ -0,0 +1,191 @@
+// Package format prints lua source code in the canonical layout, it keeps
+// the comments and at most one blank line between the stats
+package format
+
+import (
+	"luago/compiler/ast"
+	"luago/compiler/lexer"
+	"luago/compiler/parser"
+	"sort"
+	"strings"
+)
+
+// Options is the layout of formatted source code, the zero fields are
+// taken from DefaultOptions
+type Options struct {
+	Indent    string // indentation of a level
+	LineWidth int    // width of line to wrap the argument lists and tables at
+	Quote     byte   // quote of short strings, `"` or `'`
+}
+
+// DefaultOptions is the default layout
+var DefaultOptions = Options{Indent: "    ", LineWidth: 80, Quote: '"'}
+
+// Source formats lua source code, the result parses to an ast equivalent
+// to the one of src; the first syntax error of src is returned if any
+func Source(src, chunkName string, opts Options) (string, error) {
+	chunk, diags := parser.ParseChunk(src, chunkName)
+	if len(diags) > 0 {
+		return "", diags[0]
+	}
+
+	text := newPrinter(src, chunk.Trivia, opts).block(chunk.Block, len(src))
+	if text == "" {
+		return "", nil
+	}
+	return text + "\n", nil
+}
+
+type printer struct {
+	src      string
+	opts     Options
+	comments []lexer.Trivia // comments in the order of source
+	blanks   []lexer.Trivia // blank lines in the order of source
+	next     int            // index of the first comment not printed
+	indent   int            // indentation level of the line printing
+}
+
+func newPrinter(src string, trivia []lexer.Trivia, opts Options) *printer {
+	if opts.Indent == "" {
+		opts.Indent = DefaultOptions.Indent
+	}
+	if opts.LineWidth <= 0 {
+		opts.LineWidth = DefaultOptions.LineWidth
+	}
+	if opts.Quote != '"' && opts.Quote != '\'' {
+		opts.Quote = DefaultOptions.Quote
+	}
+
+	p := &printer{src: src, opts: opts}
+	for _, t := range trivia {
+		if t.IsComment() {
+			p.comments = append(p.comments, t)
+		} else {
+			p.blanks = append(p.blanks, t)
+		}
+	}
+	return p
+}
+
+func (p *printer) indentation() string {
+	return strings.Repeat(p.opts.Indent, p.indent)
+}
+
+// returns the source text of node, or "" if node has no span
+func (p *printer) text(node ast.Node) string {
+	span := node.Pos()
+	if span.Start.Offset >= span.End.Offset {
+		return ""
+	}
+	return p.src[span.Start.Offset:span.End.Offset]
+}
+
+// reports whether s printed from column col fits in the line width,
+// only the first line of s is measured
+func (p *printer) fits(col int, s string) bool {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = s[:i]
+	}
+	return col+width(s) <= p.opts.LineWidth
+}
+
+// reports whether there is a comment not printed before offset
+func (p *printer) pending(offset int) bool {
+	return p.next < len(p.comments) && p.comments[p.next].Start.Offset < offset
+}
+
+// reports whether there is a comment not printed in [start, end)
+func (p *printer) pendingIn(start, end int) bool {
+	for i := p.next; i < len(p.comments) && p.comments[i].Start.Offset < end; i++ {
+		if p.comments[i].Start.Offset >= start {
+			return true
+		}
+	}
+	return false
+}
+
+// returns the comments not printed on source line before offset, they
+// trail the code printed at the end of line
+func (p *printer) trailing(line, before int) string {
+	s := ""
+	for p.next < len(p.comments) {
+		c := p.comments[p.next]
+		if c.Start.Line != line || c.Start.Offset >= before {
+			break
+		}
+		s += " " + p.comment(c)
+		p.next++
+	}
+	return s
+}
+
+func (p *printer) comment(c lexer.Trivia) string {
+	text := p.src[c.Start.Offset:c.End.Offset]
+	if c.Kind == lexer.TriviaComment {
+		text = strings.TrimRight(text, " \t\v\f\r")
+	}
+	return text
+}
+
+// reports whether there is a blank line in [start, end) of source
+func (p *printer) hasBlankLine(start, end int) bool {
+	i := sort.Search(len(p.blanks), func(i int) bool {
+		return p.blanks[i].Start.Offset >= start
+	})
+	return i < len(p.blanks) && p.blanks[i].Start.Offset < end
+}
+
+// lines are the lines of a block or broken list indented, a blank line
+// is kept between the items separated by blank lines in source
+type lines struct {
+	p     *printer
+	lines []string
+	prev  int // offset after the last item, -1 before the first one
+}
+
+func newLines(p *printer) *lines {
+	return &lines{p: p, prev: -1}
+}
+
+// adds the item from start to end of source printed as text
+func (w *lines) add(start, end int, text string) {
+	if w.prev >= 0 && w.p.hasBlankLine(w.prev, start) {
+		w.lines = append(w.lines, "")
+	}
+	w.lines = append(w.lines, w.p.indentation()+text)
+	w.prev = end
+}
+
+// adds the comments not printed before offset, a comment a line
+func (w *lines) comments(offset int) {
+	for w.p.pending(offset) {
+		c := w.p.comments[w.p.next]
+		w.p.next++
+		w.add(c.Start.Offset, c.End.Offset, w.p.comment(c))
+	}
+}
+
+func (w *lines) String() string {
+	return strings.Join(w.lines, "\n")
+}
+
+// returns the column after s printed from column col
+func endCol(col int, s string) int {
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		return width(s[i+1:])
+	}
+	return col + width(s)
+}
+
+// returns the count of columns of s, a tab takes 4 columns
+func width(s string) int {
+	n := 0
+	for _, r := range s {
+		if r == '\t' {
+			n += 4
+		} else {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,348 @@
+package format
+
+import (
+	"fmt"
+	"luago/compiler/ast"
+	"luago/compiler/lexer"
+	"strconv"
+	"strings"
+)
+
+// precedences of operators, from low to high
+const (
+	precOr = iota + 1
+	precAnd
+	precCompare
+	precBor
+	precBxor
+	precBand
+	precShift
+	precConcat
+	precAdd
+	precMul
+	precUnary
+	precPow
+	precPrimary
+)
+
+var binOps = map[int]struct {
+	text string
+	prec int
+}{
+	lexer.TokenOpOr:   {"or", precOr},
+	lexer.TokenOpAnd:  {"and", precAnd},
+	lexer.TokenOpLt:   {"<", precCompare},
+	lexer.TokenOpGt:   {">", precCompare},
+	lexer.TokenOpLe:   {"<=", precCompare},
+	lexer.TokenOpGe:   {">=", precCompare},
+	lexer.TokenOpEq:   {"==", precCompare},
+	lexer.TokenOpNe:   {"~=", precCompare},
+	lexer.TokenOpBor:  {"|", precBor},
+	lexer.TokenOpBxor: {"~", precBxor},
+	lexer.TokenOpBand: {"&", precBand},
+	lexer.TokenOpShl:  {"<<", precShift},
+	lexer.TokenOpShr:  {">>", precShift},
+	lexer.TokenOpAdd:  {"+", precAdd},
+	lexer.TokenOpSub:  {"-", precAdd},
+	lexer.TokenOpMul:  {"*", precMul},
+	lexer.TokenOpDiv:  {"/", precMul},
+	lexer.TokenOpIDiv: {"//", precMul},
+	lexer.TokenOpMod:  {"%", precMul},
+	lexer.TokenOpPow:  {"^", precPow},
+}
+
+var unOps = map[int]string{
+	lexer.TokenOpUnm:  "-",
+	lexer.TokenOpBnot: "~",
+	lexer.TokenOpNot:  "not ",
+	lexer.TokenOpLen:  "#",
+}
+
+func precedence(exp ast.Exp) int {
+	switch x := exp.(type) {
+	case *ast.BinOpExp:
+		return binOps[x.Op].prec
+	case *ast.ConcatExp:
+		return precConcat
+	case *ast.UnOpExp:
+		return precUnary
+	default:
+		return precPrimary
+	}
+}
+
+// returns exp printed from column col, the lines after the first one
+// are indented
+func (p *printer) exp(exp ast.Exp, col int) string {
+	switch x := exp.(type) {
+	case *ast.NilExp:
+		return "nil"
+	case *ast.TrueExp:
+		return "true"
+	case *ast.FalseExp:
+		return "false"
+	case *ast.VarargExp:
+		return "..."
+	case *ast.IntegerExp:
+		if text := p.text(x); text != "" { // keeps the base of numeral
+			return text
+		}
+		return strconv.FormatInt(x.Val, 10)
+	case *ast.FloatExp:
+		if text := p.text(x); text != "" {
+			return text
+		}
+		return formatFloat(x.Val)
+	case *ast.StringExp:
+		if text := p.text(x); strings.HasPrefix(text, "[") { // long string
+			return text
+		}
+		return quote(x.Str, p.opts.Quote)
+	case *ast.NameExp:
+		return x.Name
+	case *ast.ParensExp:
+		return "(" + p.exp(x.MExp, col+1) + ")"
+	case *ast.TableAccessExp:
+		s := p.prefixExp(x.PrefixExp, col)
+		if key, ok := x.Key.(*ast.StringExp); ok && p.text(key) == key.Str {
+			return s + "." + key.Str // prefixexp `.` Name
+		}
+		return s + p.bracket(x.Key, endCol(col, s))
+	case *ast.FuncCallExp:
+		return p.funcCallExp(x, col)
+	case *ast.FuncDefExp:
+		return "function" + p.funcBody(x, false)
+	case *ast.TableConstructionExp:
+		return p.tableConstructor(x, col)
+	case *ast.UnOpExp:
+		op := unOps[x.Op]
+		s := p.operand(x.MExp, col+len(op), precedence(x.MExp) < precUnary)
+		if op == "-" && strings.HasPrefix(s, "-") {
+			op = "- " // or it's a comment
+		}
+		return op + s
+	case *ast.BinOpExp:
+		return p.binOpExp(x, col)
+	case *ast.ConcatExp:
+		s := ""
+		for i, exp := range x.Exps {
+			if i > 0 {
+				s += " .. "
+			}
+			s += p.operand(exp, endCol(col, s), precedence(exp) <= precConcat)
+		}
+		return s
+	}
+
+	panic("unreachable")
+}
+
+// exp1 op exp2, the parens are added by the precedences
+func (p *printer) binOpExp(exp *ast.BinOpExp, col int) string {
+	op := binOps[exp.Op]
+	prec1, prec2 := precedence(exp.Exp1), precedence(exp.Exp2)
+	var parens1, parens2 bool
+	if op.prec == precPow { // right associative
+		parens1 = prec1 <= op.prec
+		parens2 = prec2 < precUnary // a ^ -b
+	} else {
+		parens1 = prec1 < op.prec
+		parens2 = prec2 <= op.prec
+	}
+
+	s := p.operand(exp.Exp1, col, parens1) + " " + op.text + " "
+	return s + p.operand(exp.Exp2, endCol(col, s), parens2)
+}
+
+func (p *printer) operand(exp ast.Exp, col int, parens bool) string {
+	if parens {
+		return "(" + p.exp(exp, col+1) + ")"
+	}
+	return p.exp(exp, col)
+}
+
+// the exps other than var, function call and parens exp are put in parens
+// to be prefixexp
+func (p *printer) prefixExp(exp ast.Exp, col int) string {
+	switch exp.(type) {
+	case *ast.NameExp, *ast.TableAccessExp, *ast.FuncCallExp, *ast.ParensExp:
+		return p.exp(exp, col)
+	}
+	return "(" + p.exp(exp, col+1) + ")"
+}
+
+// `[` exp `]`, a long string is apart from the brackets
+func (p *printer) bracket(exp ast.Exp, col int) string {
+	s := p.exp(exp, col+1)
+	if strings.HasPrefix(s, "[") {
+		return "[ " + s + " ]"
+	}
+	return "[" + s + "]"
+}
+
+// prefixexp [`:` Name] args
+// args ::=  `(` [explist] `)` | tableconstructor | LiteralString
+func (p *printer) funcCallExp(exp *ast.FuncCallExp, col int) string {
+	s := p.prefixExp(exp.PrefixExp, col)
+	start := exp.PrefixExp.Pos().End.Offset
+	if exp.FNameExp != nil {
+		s += ":" + exp.FNameExp.Str
+		start = exp.FNameExp.End.Offset
+	}
+	if len(exp.Args) == 1 && p.src[exp.End.Offset-1] != ')' { // f"str" or f{fields}
+		return s + " " + p.exp(exp.Args[0], endCol(col, s)+1)
+	}
+
+	items := make([]item, len(exp.Args))
+	for i, arg := range exp.Args {
+		arg := arg
+		items[i] = item{arg.Pos(), func(col int) string { return p.exp(arg, col) }}
+	}
+	return s + p.list(endCol(col, s), "(", ")", items, start, exp.End.Offset-1, false)
+}
+
+// tableconstructor ::= `{` [fieldlist] `}`
+// field ::= `[` exp `]` `=` exp | Name `=` exp | exp
+func (p *printer) tableConstructor(exp *ast.TableConstructionExp, col int) string {
+	items := make([]item, len(exp.ValExps))
+	for i, val := range exp.ValExps {
+		key, val := exp.KeyExps[i], val
+		span := val.Pos()
+		if key != nil {
+			span.Start = key.Pos().Start
+		}
+		items[i] = item{span, func(col int) string {
+			if key == nil {
+				return p.exp(val, col)
+			}
+			var s string
+			if name, ok := key.(*ast.StringExp); ok && p.text(name) == name.Str {
+				s = name.Str + " = "
+			} else {
+				s = p.bracket(key, col) + " = "
+			}
+			return s + p.exp(val, endCol(col, s))
+		}}
+	}
+	return p.list(col, "{", "}", items, exp.Start.Offset+1, exp.End.Offset-1, true)
+}
+
+// item is an item of list, printed from column col by print
+type item struct {
+	span  ast.Span
+	print func(col int) string
+}
+
+// returns the items in brackets, in a line if it fits in the line width
+// and there is no comment between the items, only the last item may
+// have more lines; or else an item a line indented, with a comma after
+// the last one if comma is true. [start, end) is the source in brackets
+func (p *printer) list(col int, open, close string, items []item, start, end int, comma bool) string {
+	next := p.next
+	if s, ok := p.flatList(col, open, close, items); ok && !p.pendingIn(start, end) {
+		return s
+	}
+	p.next = next // prints the comments again
+
+	p.indent++
+	w := newLines(p)
+	for i, it := range items {
+		w.comments(it.span.Start.Offset)
+		s := it.print(width(p.indentation()))
+		next := end
+		if i+1 < len(items) {
+			s += ","
+			next = items[i+1].span.Start.Offset
+		} else if comma {
+			s += ","
+		}
+		w.add(it.span.Start.Offset, it.span.End.Offset, s+p.trailing(it.span.End.Line, next))
+	}
+	w.comments(end)
+	p.indent--
+
+	if len(w.lines) == 0 {
+		return open + close
+	}
+	return open + "\n" + w.String() + "\n" + p.indentation() + close
+}
+
+func (p *printer) flatList(col int, open, close string, items []item) (string, bool) {
+	s := open
+	for i, it := range items {
+		if i > 0 {
+			if strings.IndexByte(s, '\n') >= 0 {
+				return "", false
+			}
+			s += ", "
+		}
+		s += it.print(endCol(col, s))
+	}
+	s += close
+	return s, p.fits(col, s)
+}
+
+// returns exps printed from column col, separated by commas
+func (p *printer) expList(exps []ast.Exp, col int) string {
+	s := ""
+	for i, exp := range exps {
+		if i > 0 {
+			s += ", "
+		}
+		s += p.exp(exp, endCol(col, s))
+	}
+	return s
+}
+
+// returns s in lua short string, in quote q, or the other quote if s has
+// only q
+func quote(s string, q byte) string {
+	other := byte('"')
+	if q == '"' {
+		other = '\''
+	}
+	if strings.IndexByte(s, q) >= 0 && strings.IndexByte(s, other) < 0 {
+		q = other
+	}
+
+	var b strings.Builder
+	b.WriteByte(q)
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case q, '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\a':
+			b.WriteString(`\a`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\v':
+			b.WriteString(`\v`)
+		default:
+			if c < ' ' || c == 0x7F {
+				fmt.Fprintf(&b, `\%03d`, c) // 3 digits apart from the digits after
+			} else {
+				b.WriteByte(c)
+			}
+		}
+	}
+	b.WriteByte(q)
+	return b.String()
+}
+
+// returns the numeral of float f, which is read as float
+func formatFloat(f float64) string {
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eIN") { // not 1e+20, Inf or NaN
+		s += ".0"
+	}
+	return s
+}
@@ -0,0 +1,191 @@
+package format
+
+import (
+	"luago/compiler/ast"
+	"strings"
+)
+
+// returns the stats of block in lines, end is the offset of the token
+// after block, the comments before it are printed in block
+func (p *printer) block(block *ast.Block, end int) string {
+	w := newLines(p)
+	for i, stat := range block.Stats {
+		span := stat.Pos()
+		next := end
+		if i+1 < len(block.Stats) {
+			next = block.Stats[i+1].Pos().Start.Offset
+		} else if block.RetExps != nil {
+			next = block.RetSpan.Start.Offset
+		}
+
+		w.comments(span.Start.Offset)
+		text := p.stat(stat)
+		if i > 0 && strings.HasPrefix(text, "(") {
+			text = ";" + text // or it's parsed as the args of last stat
+		}
+		w.add(span.Start.Offset, span.End.Offset, text+p.trailing(span.End.Line, next))
+	}
+
+	if block.RetExps != nil {
+		span := block.RetSpan
+		w.comments(span.Start.Offset)
+		text := "return"
+		if len(block.RetExps) > 0 {
+			text += " " + p.expList(block.RetExps, endCol(width(p.indentation()), text)+1)
+		}
+		w.add(span.Start.Offset, span.End.Offset, text+p.trailing(span.End.Line, end))
+	}
+
+	w.comments(end)
+	return w.String()
+}
+
+// returns head and block in lines indented, with the indentation of the
+// keyword closing the block; the empty block without comment is inlined
+// as `head end` if inline is true. line is the source line of head, end
+// is the offset of the keyword closing the block
+func (p *printer) compound(head string, line int, block *ast.Block, end int, inline bool) string {
+	comment := p.trailing(line, block.Start.Offset)
+	p.indent++
+	body := p.block(block, end)
+	p.indent--
+
+	if body == "" {
+		if inline && comment == "" {
+			return head + " "
+		}
+		return head + comment + "\n" + p.indentation()
+	}
+	return head + comment + "\n" + body + "\n" + p.indentation()
+}
+
+func (p *printer) stat(stat ast.Stat) string {
+	col := width(p.indentation())
+	switch x := stat.(type) {
+	case *ast.BreakStat:
+		return "break"
+	case *ast.GotoStat:
+		return "goto " + x.Name
+	case *ast.LabelStat:
+		return "::" + x.Name + "::"
+	case *ast.FuncCallStat:
+		return p.exp(x, col)
+	case *ast.DoStat:
+		return p.compound("do", x.Start.Line, x.MBlock, x.End.Offset-3, true) + "end"
+	case *ast.WhileStat:
+		head := "while " + p.exp(x.BExp, col+6) + " do"
+		return p.compound(head, x.BExp.Pos().End.Line, x.MBlock, x.End.Offset-3, true) + "end"
+	case *ast.RepeatStat:
+		s := p.compound("repeat", x.Start.Line, x.MBlock, x.BExp.Pos().Start.Offset, true)
+		return s + "until " + p.exp(x.BExp, endCol(col, s)+6)
+	case *ast.IfStat:
+		return p.ifStat(x, col)
+	case *ast.ForNumStat:
+		return p.forNumStat(x, col)
+	case *ast.ForInStat:
+		head := "for " + strings.Join(x.NameList, ", ") + " in "
+		head += p.expList(x.ExpList, endCol(col, head)) + " do"
+		line := x.ExpList[len(x.ExpList)-1].Pos().End.Line
+		return p.compound(head, line, x.MBlock, x.End.Offset-3, true) + "end"
+	case *ast.LocalFuncDefStat:
+		return "local function " + x.Name + p.funcBody(x.Func, false)
+	case *ast.LocalVarDeclStat:
+		s := "local " + strings.Join(x.NameList, ", ")
+		if len(x.ExpList) > 0 {
+			s += " = "
+			s += p.expList(x.ExpList, endCol(col, s))
+		}
+		return s
+	case *ast.AssignStat:
+		return p.assignStat(x, col)
+	}
+
+	panic("unreachable")
+}
+
+// if exp then block {elseif exp then block} [else block] end
+func (p *printer) ifStat(stat *ast.IfStat, col int) string {
+	s := ""
+	for i, block := range stat.Blocks {
+		exp := stat.BExps[i]
+		end := stat.End.Offset - 3 // `end`
+		if i+1 < len(stat.Blocks) {
+			end = stat.BExps[i+1].Pos().Start.Offset
+		}
+
+		var head string
+		switch {
+		case i == 0:
+			head = "if " + p.exp(exp, col+3) + " then"
+		case p.isElse(exp):
+			head = "else"
+		default:
+			head = "elseif " + p.exp(exp, col+7) + " then"
+		}
+		s += p.compound(head, exp.Pos().End.Line, block, end, len(stat.Blocks) == 1)
+	}
+	return s + "end"
+}
+
+// reports whether exp is the true expression of `else`
+func (p *printer) isElse(exp ast.Exp) bool {
+	_, ok := exp.(*ast.TrueExp)
+	return ok && p.text(exp) == "else"
+}
+
+// for v = e1, e2, e3 do block end
+func (p *printer) forNumStat(stat *ast.ForNumStat, col int) string {
+	head := "for " + stat.VarName + " = "
+	head += p.exp(stat.InitExp, endCol(col, head)) + ", "
+	head += p.exp(stat.LimitExp, endCol(col, head))
+	line := stat.LimitExp.Pos().End.Line
+	if p.text(stat.StepExp) != "" { // or the step is implicit
+		head += ", "
+		head += p.exp(stat.StepExp, endCol(col, head))
+		line = stat.StepExp.Pos().End.Line
+	}
+	head += " do"
+	return p.compound(head, line, stat.MBlock, stat.End.Offset-3, true) + "end"
+}
+
+// varlist `=` explist | function funcname funcbody
+func (p *printer) assignStat(stat *ast.AssignStat, col int) string {
+	if len(stat.VarList) == 1 && len(stat.ExpList) == 1 {
+		// `function funcname` begins at `function`
+		if f, ok := stat.ExpList[0].(*ast.FuncDefExp); ok && f.Start == stat.Start {
+			name, method := p.funcName(stat.VarList[0])
+			return "function " + name + p.funcBody(f, method)
+		}
+	}
+
+	s := p.expList(stat.VarList, col) + " = "
+	return s + p.expList(stat.ExpList, endCol(col, s))
+}
+
+// funcname ::= Name {`.` Name} [`:` Name]
+func (p *printer) funcName(exp ast.Exp) (name string, method bool) {
+	if x, ok := exp.(*ast.TableAccessExp); ok {
+		prefix, _ := p.funcName(x.PrefixExp)
+		key := x.Key.(*ast.StringExp)
+		before := strings.TrimRight(p.src[:key.Start.Offset], " \t\v\f\r\n")
+		if strings.HasSuffix(before, ":") {
+			return prefix + ":" + key.Str, true
+		}
+		return prefix + "." + key.Str, false
+	}
+	return exp.(*ast.NameExp).Name, false
+}
+
+// funcbody ::= `(` [parlist] `)` block end
+// the parameter self is implicit in method
+func (p *printer) funcBody(f *ast.FuncDefExp, method bool) string {
+	params := f.ParList
+	if method {
+		params = params[1:]
+	}
+	if f.IsVararg {
+		params = append(params[:len(params):len(params)], "...")
+	}
+	head := "(" + strings.Join(params, ", ") + ")"
+	return p.compound(head, f.FirstLine, f.MBlock, f.End.Offset-3, true) + "end"
+}
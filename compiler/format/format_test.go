@@ -0,0 +1,216 @@
+package format
+
+import (
+	"luago/compiler/ast"
+	"luago/compiler/parser"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSource(t *testing.T) {
+	testSource(t, "local   a,b=1,'x'", `local a, b = 1, "x"`)
+	testSource(t, `print('say "hi"', "it's", 'a\tb\0c')`, `print('say "hi"', "it's", "a\tb\000c")`)
+	testSource(t, "x = 0xFF + 1e3 - [[long\nstring]]", "x = 0xFF + 1e3 - [[long\nstring]]")
+	testSource(t, "x = (a + b) * c - (d - e) - f ^ (g ^ h) ^ -i", "x = (a + b) * c - (d - e) - f ^ (g ^ h) ^ -i")
+	testSource(t, "x = (a .. b) .. c .. (d + e) .. - - f", "x = (a .. b) .. c .. d + e .. - -f")
+	testSource(t, `y = ("%d"):format(n) f{1} g"s" h[ [[k]] ] = not (a == b)`,
+		`y = ("%d"):format(n)
+f {1}
+g "s"
+h[ [[k]] ] = not (a == b)`)
+	testSource(t, "a = b\n;(f or g)()", "a = b\n;(f or g)()")
+	testSource(t, "t = {x=1,['y']=2;3,}", `t = {x = 1, ["y"] = 2, 3}`)
+	testSource(t, "function t.a.b:m(x,...) return self end",
+		"function t.a.b:m(x, ...)\n    return self\nend")
+	testSource(t, "local function f() end while x do end repeat until y", `local function f() end
+while x do end
+repeat until y`)
+	testSource(t, "if a then b() elseif c then else d() end", `if a then
+    b()
+elseif c then
+else
+    d()
+end`)
+	testSource(t, "for i=1,n do end for i=10,1,-1 do break end for k,v in pairs(t) do goto c ::c:: end", `for i = 1, n do end
+for i = 10, 1, -1 do
+    break
+end
+for k, v in pairs(t) do
+    goto c
+    ::c::
+end`)
+	testSource(t, "", "")
+}
+
+func TestSourceComments(t *testing.T) {
+	testSource(t, `-- header
+
+
+local x = 1   -- one
+--[[ lint: ignore ]]
+local y = {
+  -- first
+  a = 1, -- a
+
+
+  b = 2,
+}
+function f(a) -- f
+  -- body
+  return a -- ret
+  -- tail
+end
+-- end`, `-- header
+
+local x = 1 -- one
+--[[ lint: ignore ]]
+local y = {
+    -- first
+    a = 1, -- a
+
+    b = 2,
+}
+function f(a) -- f
+    -- body
+    return a -- ret
+    -- tail
+end
+-- end`)
+	testSource(t, "f(a, -- a\n  b)\nt = { -- empty\n}\ndo -- do\nend", `f(
+    a, -- a
+    b
+)
+t = {
+    -- empty
+}
+do -- do
+end`)
+}
+
+func TestSourceWrap(t *testing.T) {
+	testSource(t, `call(aaaaaaaaaaaaaaaa, bbbbbbbbbbbbbbbbbbbbbbbbb, cccccccccccccccccccccccc, ddddddddddddd)`,
+		`call(
+    aaaaaaaaaaaaaaaa,
+    bbbbbbbbbbbbbbbbbbbbbbbbb,
+    cccccccccccccccccccccccc,
+    ddddddddddddd
+)`)
+	testSource(t, `local config = {name = "luago", version = "5.3", paths = {"a", "b"}, debug = true}`,
+		`local config = {
+    name = "luago",
+    version = "5.3",
+    paths = {"a", "b"},
+    debug = true,
+}`)
+	testSource(t, `pcall(function() print(1) end)`, "pcall(function()\n    print(1)\nend)")
+	testSource(t, `setmetatable(t, {__index = function(t, k) return rawget(t, k) or defaults[k] end, __newindex = error})`,
+		`setmetatable(t, {
+    __index = function(t, k)
+        return rawget(t, k) or defaults[k]
+    end,
+    __newindex = error,
+})`)
+}
+
+func TestSourceOptions(t *testing.T) {
+	got, err := Source("if x then\n  f('a', \"b\")\nend", "src", Options{Indent: "\t", Quote: '\''})
+	if want := "if x then\n\tf('a', 'b')\nend\n"; err != nil || got != want {
+		t.Errorf("want=%q, got=%q, %v", want, got, err)
+	}
+	if _, err := Source("x = = 1", "src", DefaultOptions); err == nil || err.Error() != "src:1: syntax error near '='" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestSourceEquivalent(t *testing.T) {
+	srcs := []string{
+		`local t = setmetatable({}, {__index = function(t, k) return k * 2 end})
+for i = 1, 10, 2 do if t[i] > 4 and not (i % 3 == 0) then print(i, t[i]) elseif i == 1 then else break end end`,
+		`local s = "a\n\"b\"" .. 'c\'' .. [==[d]]]==] .. #"e" .. -2 ^ -2 .. (1 + 2) * 3 // 4 % 5`,
+		`x = a or b and c < d | e ~ f & g << h .. i + j * k ^ l
+y = ((a or b) and c) < (d | (e ~ (f & (g << (h .. i)))))
+z = 2 ^ (3 ^ 4) + (2 ^ 3) ^ 4 + (-2) ^ 2 + -(2 ^ 2) + ~(~1) - (-1) + (a - b) - (c + d)`,
+		`function a.b.c:m(...) local x, y = nil, ... end`,
+		`local function f(...) return select('#', ...), (...) end
+repeat local x = f() until x
+while true do goto done end ::done::
+return f(f"1", f{2}, f[[3]]):g()`,
+	}
+	for _, src := range srcs {
+		want, diags := parser.ParseChunk(src, "src")
+		if len(diags) > 0 {
+			t.Fatalf("%s: %v", src, diags)
+		}
+		out, err := Source(src, "src", DefaultOptions)
+		if err != nil {
+			t.Fatalf("%s: %v", src, err)
+		}
+		got, diags := parser.ParseChunk(out, "out")
+		if len(diags) > 0 {
+			t.Fatalf("%s\n=>\n%s: %v", src, out, diags)
+		}
+		if !equalNodes(reflect.ValueOf(want.Block), reflect.ValueOf(got.Block)) {
+			t.Errorf("ast changed:\n%s\n=>\n%s", src, out)
+		}
+		if again, _ := Source(out, "out", DefaultOptions); again != out {
+			t.Errorf("not idempotent:\n%s\n=>\n%s", out, again)
+		}
+	}
+}
+
+func testSource(t *testing.T, src, want string) {
+	t.Helper()
+	got, err := Source(src, "src", DefaultOptions)
+	if err != nil {
+		t.Fatalf("%s: %v", src, err)
+	}
+	if want != "" {
+		want += "\n"
+	}
+	if got != want {
+		t.Errorf("want:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+// compares the ast nodes without positions
+func equalNodes(a, b reflect.Value) bool {
+	if a.Kind() != b.Kind() {
+		return false
+	}
+	switch a.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if a.IsNil() || b.IsNil() {
+			return a.IsNil() == b.IsNil()
+		}
+		if a.Elem().Type() != b.Elem().Type() {
+			return false
+		}
+		return equalNodes(a.Elem(), b.Elem())
+	case reflect.Slice:
+		if a.Len() != b.Len() {
+			return false
+		}
+		for i := 0; i < a.Len(); i++ {
+			if !equalNodes(a.Index(i), b.Index(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Struct:
+		span := reflect.TypeOf(ast.Span{})
+		for i := 0; i < a.NumField(); i++ {
+			field := a.Type().Field(i)
+			if field.Type == span || field.Type == reflect.SliceOf(span) ||
+				strings.Contains(field.Name, "Line") {
+				continue
+			}
+			if !equalNodes(a.Field(i), b.Field(i)) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a.Interface() == b.Interface()
+	}
+}
@@ -0,0 +1,54 @@
+package lexer
+
+import "fmt"
+
+// ErrorKind is the kind of syntax error
+type ErrorKind int
+
+// kinds of syntax error
+const (
+	ErrUnexpectedSymbol ErrorKind = iota // a character which begins no token
+	ErrUnfinishedString                  // a string or long comment without end
+	ErrInvalidDelimiter                  // `[=` not followed by `[`
+	ErrInvalidEscape                     // an invalid or too large escape sequence
+	ErrMalformedNumber                   // a numeral which is not a number
+	ErrUnexpectedToken                   // a token not allowed by the grammar
+)
+
+var errorKindNames = [...]string{
+	ErrUnexpectedSymbol: "unexpected symbol",
+	ErrUnfinishedString: "unfinished string",
+	ErrInvalidDelimiter: "invalid delimiter",
+	ErrInvalidEscape:    "invalid escape",
+	ErrMalformedNumber:  "malformed number",
+	ErrUnexpectedToken:  "unexpected token",
+}
+
+func (k ErrorKind) String() string {
+	if k >= 0 && int(k) < len(errorKindNames) {
+		return errorKindNames[k]
+	}
+	return fmt.Sprintf("ErrorKind(%d)", int(k))
+}
+
+// Position is a position in source code, line and column count from 1,
+// column counts bytes, offset is the count of bytes before it
+type Position struct {
+	Line   int
+	Column int
+	Offset int
+}
+
+// Error is a syntax error, the lexer and parser panic with it
+type Error struct {
+	Kind      ErrorKind
+	Msg       string
+	ChunkName string
+	Start     Position // the first character of the offending text
+	End       Position // the position after the offending text
+}
+
+// Error returns the message with the chunk name and line like Lua
+func (e Error) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.ChunkName, e.End.Line, e.Msg)
+}
@@ -12,18 +12,34 @@ import (
 
 // Lexer lua lexer
 type Lexer struct {
-	chunk     string // source code
+	src       string // whole source code
+	chunk     string // source code not scanned
 	chunkName string // source file name
 	line      int    // current line no.
+	lineStart int    // offset of the first byte of the line being scanned
+
+	start, end Position // span of the last token
+	depth      int      // count of the blocks opened by the tokens so far
 
 	nextToken     string
 	nextTokenKind int
 	nextTokenLine int
+	nextStart     Position
+	nextEnd       Position
+
+	recovering bool    // whether the parser recovers from syntax errors
+	errors     []Error // syntax errors recovered
+
+	keepTrivia bool     // whether to record comments and blank lines
+	trivia     []Trivia // comments and blank lines recorded
+
+	noFolding bool // whether the parser keeps constant expressions unfolded
 }
 
 // NewLexer new lua lexer
 func NewLexer(chunk, chunkName string) *Lexer {
 	return &Lexer{
+		src:       chunk,
 		chunk:     chunk,
 		chunkName: chunkName,
 		line:      1,
@@ -37,11 +53,25 @@ func (lex *Lexer) NextToken() (line, kind int, token string) {
 		kind = lex.nextTokenKind
 		token = lex.nextToken
 		lex.line = lex.nextTokenLine
+		lex.start, lex.end = lex.nextStart, lex.nextEnd
 		lex.nextTokenLine = 0
-		return
+	} else {
+		line, kind, token = lex.scanToken()
+		lex.end = lex.pos()
+	}
+
+	switch kind {
+	case TokenKwDo, TokenKwRepeat, TokenKwIf, TokenKwFunction:
+		lex.depth++
+	case TokenKwEnd, TokenKwUntil:
+		lex.depth--
 	}
+	return
+}
 
+func (lex *Lexer) scanToken() (line, kind int, token string) {
 	lex.skipWhiteSpaces()
+	lex.start = lex.pos()
 	if len(lex.chunk) == 0 {
 		return lex.line, TokenEOF, "EOF"
 	}
@@ -177,7 +207,8 @@ func (lex *Lexer) NextToken() (line, kind int, token string) {
 		return lex.line, TokenIdentifier, token
 	}
 
-	lex.error("unexpected symbol near '%c'", c)
+	lex.next(1)
+	lex.error(ErrUnexpectedSymbol, "unexpected symbol near '%c'", c)
 	return
 }
 
@@ -188,14 +219,43 @@ func (lex *Lexer) LookAhead() int {
 	}
 
 	currentLine := lex.line
-	line, kind, token := lex.NextToken()
+	start := lex.start
+	line, kind, token := lex.scanToken()
+	lex.nextStart, lex.nextEnd = lex.start, lex.pos()
 	lex.line = currentLine
+	lex.start = start
 	lex.nextTokenLine = line
 	lex.nextTokenKind = kind
 	lex.nextToken = token
 	return kind
 }
 
+// Span returns the position of the first character of the last token
+// returned by NextToken and the position after its last character
+func (lex *Lexer) Span() (start, end Position) {
+	return lex.start, lex.end
+}
+
+// LookAheadPos returns the position of the first character of next token,
+// see LookAhead
+func (lex *Lexer) LookAheadPos() Position {
+	lex.LookAhead()
+	return lex.nextStart
+}
+
+// Depth returns the count of the blocks opened by the tokens returned by
+// NextToken, that is `do`, `repeat`, `if` and `function` minus `end`
+// and `until`
+func (lex *Lexer) Depth() int {
+	return lex.depth
+}
+
+// LookAheadLine returns the line of next token, see LookAhead
+func (lex *Lexer) LookAheadLine() int {
+	lex.LookAhead()
+	return lex.nextTokenLine
+}
+
 // Line return current line of lexer
 func (lex *Lexer) Line() int {
 	return lex.line
@@ -203,13 +263,68 @@ func (lex *Lexer) Line() int {
 
 // AssertNextTokenKind extracts the token of the specified type
 func (lex *Lexer) AssertNextTokenKind(k int) (line int, token string) {
-	line, kind, token := lex.NextToken()
-	if kind != k {
-		lex.error("syntax error near '%s'", token)
+	if lex.LookAhead() != k { // leaves the token to the recovery of parser
+		lex.errorAt(ErrUnexpectedToken, lex.nextStart, lex.nextEnd,
+			"syntax error near '%s'", lex.nextToken)
 	}
+	line, _, token = lex.NextToken()
 	return line, token
 }
 
+// TokenError panics with the syntax error of the last token
+func (lex *Lexer) TokenError(kind ErrorKind, format string, args ...interface{}) {
+	lex.errorAt(kind, lex.start, lex.end, format, args...)
+}
+
+// SetRecovery sets whether the parser recovers from syntax errors,
+// then it records the errors by Recover and goes on parsing
+func (lex *Lexer) SetRecovery(on bool) {
+	lex.recovering = on
+}
+
+// Recovering reports whether the parser recovers from syntax errors
+func (lex *Lexer) Recovering() bool {
+	return lex.recovering
+}
+
+// Recover records the syntax error r recovered from panic,
+// r is panicked again if it's not an Error
+func (lex *Lexer) Recover(r interface{}) {
+	err, ok := r.(Error)
+	if !ok {
+		panic(r)
+	}
+	lex.errors = append(lex.errors, err)
+}
+
+// Errors returns the syntax errors recorded by Recover
+func (lex *Lexer) Errors() []Error {
+	return lex.errors
+}
+
+// SetFolding sets whether the parser folds the constant expressions, it's
+// on by default, tools turn it off to keep the ast faithful to the source
+func (lex *Lexer) SetFolding(on bool) {
+	lex.noFolding = !on
+}
+
+// Folding reports whether the parser folds the constant expressions
+func (lex *Lexer) Folding() bool {
+	return !lex.noFolding
+}
+
+// SetKeepTrivia sets whether the lexer records the comments and blank
+// lines it skips
+func (lex *Lexer) SetKeepTrivia(on bool) {
+	lex.keepTrivia = on
+}
+
+// Trivia returns the comments and blank lines recorded in the order of
+// source code, see SetKeepTrivia
+func (lex *Lexer) Trivia() []Trivia {
+	return lex.trivia
+}
+
 var reLeftLongBracket = regexp.MustCompile(`^\[=*\[`)
 var reNewLine = regexp.MustCompile("\r\n|\n\r|\n|\r")
 
@@ -228,18 +343,47 @@ var reNewLine = regexp.MustCompile("\r\n|\n\r|\n|\r")
 var reShortString = regexp.MustCompile(`(?s)(^'(\\\\|\\'|\\\n|\\z\s*|[^'\n])*')|(^"(\\\\|\\"|\\\n|\\z\s*|[^"\n])*")`)
 
 func (lex *Lexer) skipComment() {
+	start := lex.pos()
 	lex.next(2) // skip --
 
 	// long string comment, mutil-line comment
 	if reLeftLongBracket.FindString(lex.chunk) != "" {
-		lex.scanLongString()
+		text := lex.scanLongString()
+		lex.addTrivia(TriviaLongComment, text, start)
 		return
 	}
 
 	// short string comment, single-line comment
+	text := lex.chunk
 	for len(lex.chunk) > 0 && !isNewLine(lex.chunk[0]) {
 		lex.next(1)
 	}
+	lex.addTrivia(TriviaComment, text[:len(text)-len(lex.chunk)], start)
+}
+
+// records the trivia from start to the current position
+func (lex *Lexer) addTrivia(kind TriviaKind, text string, start Position) {
+	if lex.keepTrivia {
+		lex.trivia = append(lex.trivia, Trivia{
+			Kind:  kind,
+			Text:  text,
+			Start: start,
+			End:   lex.pos(),
+		})
+	}
+}
+
+// records the current line if it's blank, the lexer is at its newline
+func (lex *Lexer) addBlankLine() {
+	if !lex.keepTrivia {
+		return
+	}
+	end := lex.pos()
+	lineStart := end.Offset - end.Column + 1
+	if strings.TrimLeft(lex.src[lineStart:end.Offset], " \t\v\f") == "" {
+		start := Position{Line: end.Line, Column: 1, Offset: lineStart}
+		lex.addTrivia(TriviaBlankLine, "", start)
+	}
 }
 
 func (lex *Lexer) scanShortString() string {
@@ -253,19 +397,23 @@ func (lex *Lexer) scanShortString() string {
 		return str
 	}
 
-	lex.error("unfinished short string")
+	lex.skipLine()
+	lex.error(ErrUnfinishedString, "unfinished short string")
 	return ""
 }
 
 func (lex *Lexer) scanLongString() string {
 	leftLongBracket := reLeftLongBracket.FindString(lex.chunk)
 	if leftLongBracket == "" {
-		lex.error("invalid long string delimiter near '%s'", lex.chunk[0:2])
+		delimiter := lex.chunk[0:2]
+		lex.next(len(lex.chunk) - len(strings.TrimLeft(lex.chunk[1:], "="))) // skips `[=*`
+		lex.error(ErrInvalidDelimiter, "invalid long string delimiter near '%s'", delimiter)
 	}
 	rightLongBracket := strings.Replace(leftLongBracket, "[", "]", -1)
 	rightLongBracketIdx := strings.Index(lex.chunk, rightLongBracket)
 	if rightLongBracketIdx < 0 {
-		lex.error("unfinished long string or comment")
+		lex.skipText(len(lex.chunk))
+		lex.error(ErrUnfinishedString, "unfinished long string or comment")
 	}
 	str := lex.chunk[len(leftLongBracket):rightLongBracketIdx]
 	lex.next(rightLongBracketIdx + len(rightLongBracket))
@@ -291,7 +439,7 @@ func (lex *Lexer) escape(str string) string {
 		}
 		// str[0] == '\\'
 		if len(str) == 1 {
-			lex.error("unfinished short string")
+			lex.error(ErrUnfinishedString, "unfinished short string")
 		}
 
 		switch str[1] {
@@ -343,7 +491,7 @@ func (lex *Lexer) escape(str string) string {
 					str = str[len(found):]
 					continue
 				}
-				lex.error("decimal escape too large near '%s'", found)
+				lex.error(ErrInvalidEscape, "decimal escape too large near '%s'", found)
 			}
 		case 'x': // \xhh
 			if found := reHexEscapeSeq.FindString(str); found != "" {
@@ -360,7 +508,7 @@ func (lex *Lexer) escape(str string) string {
 					str = str[len(found):]
 					continue
 				}
-				lex.error("UTF-8 value too large near '%s'", found)
+				lex.error(ErrInvalidEscape, "UTF-8 value too large near '%s'", found)
 			}
 		case 'z': // \z skips the following span of white-space characters, including line breaks
 			str = str[2:] // skips '\\' and 'z'
@@ -369,7 +517,7 @@ func (lex *Lexer) escape(str string) string {
 			}
 			continue
 		}
-		lex.error("invalid escape sequence near '\\%c'", str[1])
+		lex.error(ErrInvalidEscape, "invalid escape sequence near '\\%c'", str[1])
 	}
 
 	return buf.String()
@@ -412,23 +560,65 @@ func (lex *Lexer) test(str string) bool {
 }
 
 func (lex *Lexer) next(n int) {
+	if i := strings.LastIndexAny(lex.chunk[:n], "\r\n"); i >= 0 {
+		lex.lineStart = len(lex.src) - len(lex.chunk) + i + 1
+	}
 	lex.chunk = lex.chunk[n:]
 }
 
-func (lex *Lexer) error(format string, args ...interface{}) {
-	err := fmt.Sprintf("%s:%d: %s", lex.chunkName, lex.line,
-		fmt.Sprintf(format, args...))
-	panic(err)
+// skips n bytes which may contain newlines
+func (lex *Lexer) skipText(n int) {
+	text := lex.chunk[:n]
+	lex.line += len(reNewLine.FindAllString(text, -1))
+	lex.next(n)
+}
+
+// skips the rest of current line
+func (lex *Lexer) skipLine() {
+	n := strings.IndexAny(lex.chunk, "\r\n")
+	if n < 0 {
+		n = len(lex.chunk)
+	}
+	lex.next(n)
+}
+
+// returns the current position
+func (lex *Lexer) pos() Position {
+	offset := len(lex.src) - len(lex.chunk)
+	return Position{
+		Line:   lex.line,
+		Column: offset - lex.lineStart + 1,
+		Offset: offset,
+	}
+}
+
+// panics with the error of the text from the beginning of token being
+// scanned to the current position
+func (lex *Lexer) error(kind ErrorKind, format string, args ...interface{}) {
+	lex.errorAt(kind, lex.start, lex.pos(), format, args...)
+}
+
+func (lex *Lexer) errorAt(kind ErrorKind, start, end Position, format string, args ...interface{}) {
+	panic(Error{
+		Kind:      kind,
+		Msg:       fmt.Sprintf(format, args...),
+		ChunkName: lex.chunkName,
+		Start:     start,
+		End:       end,
+	})
 }
 
 func (lex *Lexer) skipWhiteSpaces() {
 	for len(lex.chunk) > 0 {
 		if lex.test("--") {
+			lex.start = lex.pos()
 			lex.skipComment()
 		} else if lex.test("\r\n") || lex.test("\n\r") {
+			lex.addBlankLine()
 			lex.next(2)
 			lex.line++
 		} else if isNewLine(lex.chunk[0]) {
+			lex.addBlankLine()
 			lex.next(1)
 			lex.line++
 		} else if isWhiteSpace(lex.chunk[0]) {
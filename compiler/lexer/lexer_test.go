@@ -1,6 +1,7 @@
 package lexer
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -197,6 +198,65 @@ func TestLookAhead(t *testing.T) {
 	testEqualKind(t, lex.LookAhead(), TokenEOF)
 }
 
+func TestSpan(t *testing.T) {
+	lex := NewLexer("local s =\r\n  [[a\nb]] x", "src")
+	lex.NextToken() // local
+	lex.NextToken() // s
+	if got, want := lex.LookAheadPos(), (Position{1, 9, 8}); got != want {
+		t.Errorf("want=%v, got=%v", want, got)
+	}
+	lex.NextToken() // =
+	lex.NextToken() // [[a\nb]]
+	start, end := lex.Span()
+	if start != (Position{2, 3, 13}) || end != (Position{3, 4, 20}) {
+		t.Errorf("span of long string: %v-%v", start, end)
+	}
+	lex.NextToken() // x
+	if start, end = lex.Span(); start != (Position{3, 5, 21}) || end != (Position{3, 6, 22}) {
+		t.Errorf("span of name: %v-%v", start, end)
+	}
+}
+
+func TestSpanLongLine(t *testing.T) {
+	// columns are counted from the start of line, not searched for it
+	src := strings.Repeat("x=1;", 100000) + "'a\\\nb' y"
+	lex := NewLexer(src, "src")
+	for lex.LookAhead() != TokenString {
+		lex.NextToken()
+	}
+	lex.NextToken()
+	if _, end := lex.Span(); end != (Position{2, 3, len(src) - 2}) {
+		t.Errorf("span of string: %v", end)
+	}
+	lex.NextToken() // y
+	if start, _ := lex.Span(); start != (Position{2, 4, len(src) - 1}) {
+		t.Errorf("span of name: %v", start)
+	}
+}
+
+func TestTrivia(t *testing.T) {
+	lex := NewLexer("x = 1 -- one\n\n  \n--[==[ long\n]==] y", "src")
+	lex.SetKeepTrivia(true)
+	for lex.LookAhead() != TokenEOF {
+		lex.NextToken()
+	}
+	want := []Trivia{
+		{TriviaComment, " one", Position{1, 7, 6}, Position{1, 13, 12}},
+		{TriviaBlankLine, "", Position{2, 1, 13}, Position{2, 1, 13}},
+		{TriviaBlankLine, "", Position{3, 1, 14}, Position{3, 3, 16}},
+		{TriviaLongComment, " long\n", Position{4, 1, 17}, Position{5, 5, 33}},
+	}
+	got := lex.Trivia()
+	if len(got) != len(want) {
+		t.Fatalf("want=%v, got=%v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("trivia %d: want=%v, got=%v", i, want[i], got[i])
+		}
+	}
+}
+
 func TestErrors(t *testing.T) {
 	testError(t, "?", "src:1: unexpected symbol near '?'")
 	testError(t, "[===", "src:1: invalid long string delimiter near '[='")
@@ -208,6 +268,31 @@ func TestErrors(t *testing.T) {
 	testError(t, "'\\'", "src:1: unfinished short string")
 }
 
+func TestErrorSpans(t *testing.T) {
+	testErrorSpan(t, "a ?", ErrUnexpectedSymbol, Position{1, 3, 2}, Position{1, 4, 3})
+	testErrorSpan(t, "x = [==", ErrInvalidDelimiter, Position{1, 5, 4}, Position{1, 8, 7})
+	testErrorSpan(t, "\n [[ab\ncd", ErrUnfinishedString, Position{2, 2, 2}, Position{3, 3, 9})
+	testErrorSpan(t, "--[[ab\n", ErrUnfinishedString, Position{1, 1, 0}, Position{2, 1, 7})
+	testErrorSpan(t, "'ab\n", ErrUnfinishedString, Position{1, 1, 0}, Position{1, 4, 3})
+	testErrorSpan(t, "  '\\q'", ErrInvalidEscape, Position{1, 3, 2}, Position{1, 7, 6})
+}
+
+func testErrorSpan(t *testing.T, chunk string, kind ErrorKind, start, end Position) {
+	lex := NewLexer(chunk, "src")
+	defer func() {
+		err, _ := recover().(Error)
+		if err.Kind != kind || err.Start != start || err.End != end {
+			t.Errorf("%q: want=%v %v-%v, got=%v %v-%v", chunk, kind, start, end,
+				err.Kind, err.Start, err.End)
+		}
+	}()
+	for {
+		if _, kind, _ := lex.NextToken(); kind == TokenEOF {
+			return
+		}
+	}
+}
+
 func testNextTokenKind(t *testing.T, lex *Lexer, want int) {
 	line, got, token := lex.NextToken()
 	if got != want {
@@ -258,7 +343,7 @@ func testError(t *testing.T, chunk, want string) {
 func safeNextToken(lex *Lexer) (err string) {
 	defer func() {
 		if r := recover(); r != nil {
-			err = r.(string)
+			err = r.(Error).Error()
 		}
 	}()
 	_, _, err = lex.NextToken()
@@ -92,6 +92,12 @@ var keywords = map[string]int{
 	"while":    TokenKwWhile,
 }
 
+// IsKeyword reports whether s is a reserved word
+func IsKeyword(s string) bool {
+	_, found := keywords[s]
+	return found
+}
+
 // KindToString coverts kind int to string
 func KindToString(kind int) string {
 	switch {
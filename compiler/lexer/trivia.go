@@ -0,0 +1,25 @@
+package lexer
+
+// TriviaKind is the kind of Trivia
+type TriviaKind int
+
+// kinds of trivia
+const (
+	TriviaComment     TriviaKind = iota // short comment `--text`
+	TriviaLongComment                   // long comment `--[[text]]`
+	TriviaBlankLine                     // line with only white spaces
+)
+
+// Trivia is a comment or blank line skipped by the lexer, recorded if
+// the lexer keeps trivia, see SetKeepTrivia
+type Trivia struct {
+	Kind  TriviaKind
+	Text  string   // text of comment without `--` and the long brackets
+	Start Position // the first character, column 1 for blank line
+	End   Position // the position after the last character, the newline of blank line
+}
+
+// IsComment reports whether the trivia is a comment
+func (t Trivia) IsComment() bool {
+	return t.Kind != TriviaBlankLine
+}
@@ -0,0 +1,279 @@
+package lint
+
+import (
+	"fmt"
+	"luago/compiler/ast"
+	"luago/compiler/sema"
+	"sort"
+	"strings"
+)
+
+var declKindNames = [...]string{
+	sema.DeclLocal:     "local variable",
+	sema.DeclLocalFunc: "local function",
+	sema.DeclParam:     "argument",
+	sema.DeclSelf:      "self",
+	sema.DeclForNum:    "loop variable",
+	sema.DeclForIn:     "loop variable",
+}
+
+type checker struct {
+	chunkName string
+	readOnly  map[string]bool
+	allowed   map[string]bool // globals can be accessed and set
+	warnings  []Warning
+}
+
+func newChecker(chunkName string, cfg *Config) *checker {
+	c := &checker{
+		chunkName: chunkName,
+		readOnly:  map[string]bool{},
+		allowed:   map[string]bool{"_ENV": true},
+	}
+	readOnly := cfg.ReadOnly
+	if readOnly == nil {
+		readOnly = StdGlobals
+	}
+	for _, name := range readOnly {
+		c.readOnly[name] = true
+	}
+	for _, name := range cfg.Globals {
+		c.allowed[name] = true
+	}
+	return c
+}
+
+func (c *checker) warn(code string, span ast.Span, format string, a ...interface{}) {
+	c.warnings = append(c.warnings, Warning{
+		Code:      code,
+		Msg:       fmt.Sprintf(format, a...),
+		ChunkName: c.chunkName,
+		Start:     span.Start,
+		End:       span.End,
+	})
+}
+
+// checks the main chunk, the names are resolved by sema
+func (c *checker) check(block *ast.Block) {
+	info := sema.Analyze(block)
+	assigned := map[*ast.NameExp]bool{}
+	ast.Inspect(block, func(node ast.Node) bool {
+		if x, ok := node.(*ast.AssignStat); ok {
+			for _, v := range x.VarList {
+				if name, ok := v.(*ast.NameExp); ok {
+					assigned[name] = true
+				}
+			}
+		}
+		return true
+	}, nil)
+
+	c.locals(info, assigned)
+	c.globals(info, assigned)
+	c.flow(block)
+}
+
+// checks the shadowed and unused locals
+func (c *checker) locals(info *sema.Info, assigned map[*ast.NameExp]bool) {
+	order := make(map[*sema.Decl]int, len(info.Decls))
+	for i, d := range info.Decls {
+		order[d] = i
+	}
+	for _, d := range info.Decls {
+		if prev := shadowed(d, order); prev != nil && d.Kind != sema.DeclSelf &&
+			prev.Kind != sema.DeclSelf && !strings.HasPrefix(d.Name, "_") {
+			line := prev.Span.Start.Line
+			switch {
+			case prev.Scope == d.Scope:
+				c.warn(CodeRedefineLocal, d.Span, "redefining %s '%s' on line %d", declKindNames[prev.Kind], d.Name, line)
+			case prev.Func == d.Func:
+				c.warn(CodeShadowLocal, d.Span, "shadowing %s '%s' on line %d", declKindNames[prev.Kind], d.Name, line)
+			default:
+				c.warn(CodeShadowUpvalue, d.Span, "shadowing upvalue '%s' on line %d", d.Name, line)
+			}
+		}
+	}
+
+	for _, d := range info.Decls {
+		reads, writes, closureWrites := 0, 0, 0
+		for _, use := range d.Uses {
+			b := info.Bindings[use]
+			switch {
+			case b.Kind == sema.Global: // a global accessed through the local _ENV
+			case !assigned[use]:
+				reads++
+			case b.Depth > 0:
+				closureWrites++
+				writes++
+			default:
+				writes++
+			}
+		}
+		if reads > 0 || d.Kind == sema.DeclSelf || strings.HasPrefix(d.Name, "_") {
+			continue
+		}
+		switch {
+		case closureWrites > 0:
+			c.warn(CodeUnusedUpvalue, d.Span, "upvalue '%s' is set but never accessed", d.Name)
+		case d.Kind == sema.DeclParam:
+			c.warn(CodeUnusedParam, d.Span, "unused argument '%s'", d.Name)
+		case d.Kind == sema.DeclForNum || d.Kind == sema.DeclForIn:
+			c.warn(CodeUnusedLoopVar, d.Span, "unused loop variable '%s'", d.Name)
+		case writes > 0:
+			c.warn(CodeUnusedLocal, d.Span, "local variable '%s' is set but never accessed", d.Name)
+		default:
+			c.warn(CodeUnusedLocal, d.Span, "unused %s '%s'", declKindNames[d.Kind], d.Name)
+		}
+	}
+}
+
+// returns the local named like d visible where d is declared, the order
+// of declarations tells the locals declared before d
+func shadowed(d *sema.Decl, order map[*sema.Decl]int) *sema.Decl {
+	for s := d.Scope; s != nil; s = s.Parent {
+		for i := len(s.Decls) - 1; i >= 0; i-- {
+			if prev := s.Decls[i]; prev.Name == d.Name && order[prev] < order[d] {
+				return prev
+			}
+		}
+	}
+	return nil
+}
+
+// checks the globals set but never accessed, accessed but never set and
+// the read-only ones set
+func (c *checker) globals(info *sema.Info, assigned map[*ast.NameExp]bool) {
+	gets := map[string][]ast.Span{}
+	sets := map[string][]ast.Span{}
+	for name, b := range info.Bindings {
+		if b.Kind != sema.Global {
+			continue
+		}
+		if assigned[name] {
+			sets[name.Name] = append(sets[name.Name], name.Span)
+		} else {
+			gets[name.Name] = append(gets[name.Name], name.Span)
+		}
+	}
+
+	for name, spans := range sets {
+		sortSpans(spans)
+		for i, span := range spans {
+			if c.readOnly[name] {
+				c.warn(CodeReadOnlyGlobal, span, "setting read-only global '%s'", name)
+			} else if i == 0 && !c.allowed[name] && len(gets[name]) == 0 {
+				c.warn(CodeUnusedGlobal, span, "global '%s' is set but never accessed", name)
+			}
+		}
+	}
+	for name, spans := range gets {
+		if c.readOnly[name] || c.allowed[name] || len(sets[name]) > 0 {
+			continue
+		}
+		for _, span := range spans {
+			c.warn(CodeUndefinedGlobal, span, "accessing undefined global '%s'", name)
+		}
+	}
+}
+
+func sortSpans(spans []ast.Span) {
+	sort.Slice(spans, func(i, j int) bool {
+		return spans[i].Start.Offset < spans[j].Start.Offset
+	})
+}
+
+// labels is the labels of a block, the labels of enclosing blocks in the
+// same function are visible as well
+type labels struct {
+	names []string
+	fn    bool // the block is the body of function
+}
+
+// checks the unreachable code and the gotos without visible label
+func (c *checker) flow(block *ast.Block) {
+	var scopes []labels
+	fn := true // the main chunk
+	ast.Inspect(block, func(node ast.Node) bool {
+		switch x := node.(type) {
+		case *ast.FuncDefExp:
+			fn = true
+		case *ast.Block:
+			var names []string
+			for _, stat := range x.Stats {
+				if label, ok := stat.(*ast.LabelStat); ok {
+					names = append(names, label.Name)
+				}
+			}
+			scopes = append(scopes, labels{names, fn})
+			fn = false
+			c.unreachable(x)
+		case *ast.GotoStat:
+			if !labelVisible(scopes, x.Name) {
+				c.warn(CodeInvisibleLabel, x.Span, "no visible label '%s' for goto", x.Name)
+			}
+		}
+		return true
+	}, func(node ast.Node) {
+		if _, ok := node.(*ast.Block); ok {
+			scopes = scopes[:len(scopes)-1]
+		}
+	})
+}
+
+// reports whether the label is in the innermost block or the enclosing
+// blocks of the same function
+func labelVisible(scopes []labels, label string) bool {
+	for i := len(scopes) - 1; i >= 0; i-- {
+		if contains(scopes[i].names, label) {
+			return true
+		}
+		if scopes[i].fn {
+			break
+		}
+	}
+	return false
+}
+
+// reports the first stat after return, break and goto unreachable unless
+// it's a label
+func (c *checker) unreachable(block *ast.Block) {
+	dead, reported := false, false
+	for _, stat := range block.Stats {
+		if _, ok := stat.(*ast.LabelStat); ok {
+			dead, reported = false, false
+		} else if dead && !reported {
+			c.warn(CodeUnreachable, stat.Pos(), "unreachable code")
+			reported = true
+		}
+		dead = dead || terminates(stat)
+	}
+	if block.RetExps != nil && dead && !reported {
+		c.warn(CodeUnreachable, block.RetSpan, "unreachable code")
+	}
+}
+
+// reports whether the stats after stat are never executed
+func terminates(stat ast.Stat) bool {
+	switch x := stat.(type) {
+	case *ast.BreakStat, *ast.GotoStat:
+		return true
+	case *ast.DoStat:
+		return blockTerminates(x.MBlock)
+	case *ast.IfStat:
+		if _, ok := x.BExps[len(x.BExps)-1].(*ast.TrueExp); !ok { // else
+			return false
+		}
+		for _, block := range x.Blocks {
+			if !blockTerminates(block) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func blockTerminates(block *ast.Block) bool {
+	return block.RetExps != nil ||
+		len(block.Stats) > 0 && terminates(block.Stats[len(block.Stats)-1])
+}
@@ -0,0 +1,169 @@
+// Package lint checks lua source code for the suspicious code, like the
+// undefined globals, unused locals, shadowed locals and unreachable code.
+//
+// The warnings can be suppressed by the comments attached to stats:
+//
+//	-- lint: ignore [codes]       ignores the warnings in the stat
+//	-- lint: ignore-file [codes]  ignores the warnings in the file
+//	-- lint: globals names        allows the globals in the file
+//
+// the codes and names are separated by spaces or commas, all codes are
+// ignored if there is no code.
+package lint
+
+import (
+	"fmt"
+	"luago/compiler/ast"
+	"luago/compiler/lexer"
+	"luago/compiler/parser"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// codes of warnings
+const (
+	CodeSyntax          = "E011" // syntax error
+	CodeUndefinedGlobal = "W111" // accessing an undefined global
+	CodeUnusedGlobal    = "W112" // setting a global never accessed
+	CodeReadOnlyGlobal  = "W113" // setting a read-only global
+	CodeUnusedLocal     = "W211" // unused local variable or function
+	CodeUnusedParam     = "W212" // unused argument
+	CodeUnusedLoopVar   = "W213" // unused loop variable
+	CodeUnusedUpvalue   = "W214" // upvalue set by closures but never accessed
+	CodeShadowLocal     = "W311" // shadowing a local of enclosing block
+	CodeRedefineLocal   = "W312" // redefining a local in the same block
+	CodeShadowUpvalue   = "W313" // shadowing a local of enclosing function
+	CodeUnreachable     = "W411" // code after return, break or goto
+	CodeInvisibleLabel  = "W511" // goto a label not visible
+)
+
+// StdGlobals is the globals of the standard libraries
+var StdGlobals = []string{
+	"_G", "_VERSION", "assert", "collectgarbage", "dofile", "error",
+	"getmetatable", "ipairs", "load", "loadfile", "next", "pairs", "pcall",
+	"print", "rawequal", "rawget", "rawlen", "rawset", "require", "select",
+	"setmetatable", "tonumber", "tostring", "type", "xpcall",
+	"coroutine", "debug", "io", "json", "lanes", "math", "os", "package",
+	"string", "table", "utf8",
+}
+
+// Config is the configuration of linter
+type Config struct {
+	ReadOnly []string // globals which can be accessed but not set, StdGlobals if nil
+	Globals  []string // globals which can be accessed and set
+	Ignore   []string // codes of the warnings ignored
+}
+
+// Warning is a suspicious code found by the linter
+type Warning struct {
+	Code      string
+	Msg       string
+	ChunkName string
+	Start     lexer.Position
+	End       lexer.Position
+}
+
+// String returns the warning like `chunk:line:column: code msg`
+func (w Warning) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s %s", w.ChunkName, w.Start.Line, w.Start.Column, w.Code, w.Msg)
+}
+
+// Check lints lua source code with cfg, or the default configuration if
+// cfg is nil, the warnings are returned in the order of position. The
+// syntax errors are reported as warnings, the stats without them are
+// still checked
+func Check(src, chunkName string, cfg *Config) []Warning {
+	if cfg == nil {
+		cfg = &Config{}
+	}
+	chunk, diags := parser.ParseChunk(src, chunkName)
+	c := newChecker(chunkName, cfg)
+	for _, d := range diags {
+		c.warnings = append(c.warnings, Warning{
+			Code:      CodeSyntax,
+			Msg:       d.Msg,
+			ChunkName: chunkName,
+			Start:     d.Start,
+			End:       d.End,
+		})
+	}
+
+	ignores := c.directives(chunk)
+	c.check(chunk.Block)
+
+	warnings := make([]Warning, 0, len(c.warnings))
+	for _, w := range c.warnings {
+		if !ignored(w, ignores, cfg.Ignore) {
+			warnings = append(warnings, w)
+		}
+	}
+	sort.SliceStable(warnings, func(i, j int) bool {
+		return warnings[i].Start.Offset < warnings[j].Start.Offset
+	})
+	return warnings
+}
+
+// ignore is the suppression of warnings in [start, end) of source
+type ignore struct {
+	start, end int
+	codes      []string // all codes if empty
+}
+
+var reDirective = regexp.MustCompile(`(?s)^\s*lint:\s*(ignore-file|ignore|globals)\b(.*)$`)
+
+// reads the directives of comments, the globals allowed are added to
+// checker, the suppressions are returned
+func (c *checker) directives(chunk *ast.Chunk) (ignores []ignore) {
+	for _, t := range chunk.Trivia {
+		if name, args := directive(t); name == "ignore-file" {
+			ignores = append(ignores, ignore{0, int(^uint(0) >> 1), args})
+		} else if name == "globals" {
+			for _, name := range args {
+				c.allowed[name] = true
+			}
+		}
+	}
+	for stat, comments := range chunk.Comments {
+		for _, comment := range append(comments.Leading, comments.Trailing...) {
+			if name, args := directive(comment); name == "ignore" {
+				span := stat.Pos()
+				ignores = append(ignores, ignore{span.Start.Offset, span.End.Offset, args})
+			}
+		}
+	}
+	return
+}
+
+// returns the name and arguments of the directive in comment, if any
+func directive(comment lexer.Trivia) (name string, args []string) {
+	m := reDirective.FindStringSubmatch(comment.Text)
+	if !comment.IsComment() || m == nil {
+		return "", nil
+	}
+	return m[1], strings.FieldsFunc(m[2], func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\r' || r == '\n'
+	})
+}
+
+func ignored(w Warning, ignores []ignore, codes []string) bool {
+	if contains(codes, w.Code) {
+		return true
+	}
+	for _, ig := range ignores {
+		if w.Start.Offset >= ig.start && w.Start.Offset < ig.end &&
+			(len(ig.codes) == 0 || contains(ig.codes, w.Code)) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,139 @@
+package lint
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGlobals(t *testing.T) {
+	testCheck(t, `print(x, string.upper(y))
+z = 1
+w = 2
+print(w)
+print = nil
+string.foo = 1`,
+		"1:7: W111 accessing undefined global 'x'",
+		"1:23: W111 accessing undefined global 'y'",
+		"2:1: W112 global 'z' is set but never accessed",
+		"5:1: W113 setting read-only global 'print'")
+	testCheckConfig(t, "x = y print(x)", &Config{Globals: []string{"y"}})
+	testCheckConfig(t, "print(1) x = 1", &Config{ReadOnly: []string{"x"}},
+		"1:1: W111 accessing undefined global 'print'",
+		"1:10: W113 setting read-only global 'x'")
+	testCheck(t, "function f() end function M.g() end local _ENV = {} print(_ENV)",
+		"1:10: W112 global 'f' is set but never accessed",
+		"1:27: W111 accessing undefined global 'M'")
+}
+
+func TestUnusedLocals(t *testing.T) {
+	testCheck(t, `local a, b, _c = 1
+local function f(x, y, ...) return y end
+local function g() end
+for i, v in pairs({}) do print(v) end
+for k = 1, 2 do end
+local s = 0 s = 1
+local u
+local function h() u = 1 end
+h(f)
+function obj:m() end`,
+		"1:7: W211 unused local variable 'a'",
+		"1:10: W211 unused local variable 'b'",
+		"2:18: W212 unused argument 'x'",
+		"3:16: W211 unused local function 'g'",
+		"4:5: W213 unused loop variable 'i'",
+		"5:5: W213 unused loop variable 'k'",
+		"6:7: W211 local variable 's' is set but never accessed",
+		"7:7: W214 upvalue 'u' is set but never accessed",
+		"10:10: W111 accessing undefined global 'obj'")
+	testCheck(t, "local n = 0 repeat local x = n n = x + 1 until x > 9")
+}
+
+func TestShadowing(t *testing.T) {
+	testCheck(t, `local x = 1
+local x = x + 1
+do local x = x print(x) end
+local function f(x)
+  return function() local x = x return x end
+end
+for _, f in ipairs({}) do print(f) end
+local function m(self) return function(self) return self end end
+print(f, m)`,
+		"2:7: W312 redefining local variable 'x' on line 1",
+		"3:10: W311 shadowing local variable 'x' on line 2",
+		"4:18: W313 shadowing upvalue 'x' on line 2",
+		"5:27: W313 shadowing upvalue 'x' on line 4",
+		"7:8: W311 shadowing local function 'f' on line 4",
+		"8:18: W212 unused argument 'self'",
+		"8:40: W313 shadowing upvalue 'self' on line 8")
+}
+
+func TestUnreachable(t *testing.T) {
+	testCheck(t, `local function f(x)
+  do return end
+  print(x)
+  print(x)
+end
+while true do
+  if f() then break else goto next end
+  f()
+  ::next::
+  f()
+  goto next
+  return
+end
+goto done
+local y = 1 print(y)
+::done::`,
+		"3:3: W411 unreachable code",
+		"8:3: W411 unreachable code",
+		"12:3: W411 unreachable code",
+		"15:1: W411 unreachable code")
+	testCheck(t, `do goto l1 end
+::l1::
+for i = 1, 2 do if i then goto continue end ::continue:: end
+local function f() goto l1 end
+goto l2
+do ::l2:: end
+print(f)`,
+		"4:20: W511 no visible label 'l1' for goto",
+		"5:1: W511 no visible label 'l2' for goto",
+		"6:1: W411 unreachable code")
+}
+
+func TestSuppression(t *testing.T) {
+	testCheck(t, `-- lint: globals app, config
+app.run(config)
+local unused -- lint: ignore
+--[[ lint: ignore W211 ]]
+local function f(a)
+  print(undefined1)
+end
+x = undefined2 -- lint: ignore W111`,
+		"5:18: W212 unused argument 'a'",
+		"6:9: W111 accessing undefined global 'undefined1'",
+		"8:1: W112 global 'x' is set but never accessed")
+	testCheck(t, "--lint: ignore-file W111, W112\nx = y")
+	testCheckConfig(t, "local x x = y", &Config{Ignore: []string{"W111", "W211"}})
+}
+
+func TestSyntaxErrors(t *testing.T) {
+	testCheck(t, "local x = = 1\nlocal y\n",
+		"1:11: E011 syntax error near '='",
+		"2:7: W211 unused local variable 'y'")
+}
+
+func testCheck(t *testing.T, src string, want ...string) {
+	t.Helper()
+	testCheckConfig(t, src, nil, want...)
+}
+
+func testCheckConfig(t *testing.T, src string, cfg *Config, want ...string) {
+	t.Helper()
+	var got []string
+	for _, w := range Check(src, "src", cfg) {
+		got = append(got, strings.TrimPrefix(w.String(), "src:"))
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("%s\nwant:\n%s\ngot:\n%s", src, strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+}
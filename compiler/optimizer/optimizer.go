@@ -0,0 +1,138 @@
+// Package optimizer optimizes the ast of chunk after parsing, it uses
+// the bindings of names to propagate the constants, which the parser
+// can't see, and folds the expressions they make constant like the parser
+package optimizer
+
+import (
+	"luago/compiler/ast"
+	"luago/compiler/parser"
+	"luago/compiler/sema"
+)
+
+// Options selects the optimizations of Optimize
+type Options struct {
+	// DeadBranches removes `if false` and `while false` branches, and
+	// turns `if true` into do block
+	DeadBranches bool
+	// Constants propagates the locals never reassigned, which are
+	// initialized by literals, into their uses
+	Constants bool
+}
+
+// Optimize optimizes the main chunk block parsed in place, the block
+// should be parsed with folding, the expressions are folded again only
+// if the constants are propagated
+func Optimize(block *ast.Block, opts Options) {
+	var info *sema.Info
+	if opts.Constants {
+		info = sema.Analyze(block)
+	}
+	consts := map[*sema.Decl]ast.Exp{}
+
+	ast.Rewrite(block, nil, func(node ast.Node) ast.Node {
+		switch x := node.(type) {
+		case *ast.LocalVarDeclStat: // the initializers are optimized already
+			if opts.Constants {
+				addConsts(consts, info, x)
+			}
+		case *ast.NameExp:
+			if opts.Constants {
+				if b := info.Bindings[x]; b != nil && b.Kind != sema.Global {
+					if c, ok := consts[b.Decl]; ok {
+						return copyConst(c, x)
+					}
+				}
+			}
+		case *ast.BinOpExp, *ast.UnOpExp, *ast.ConcatExp, *ast.ParensExp:
+			if opts.Constants {
+				return parser.FoldExp(x.(ast.Exp))
+			}
+		case *ast.IfStat:
+			if opts.DeadBranches {
+				return optimizeIf(x)
+			}
+		case *ast.WhileStat:
+			if opts.DeadBranches && isFalse(x.BExp) {
+				return nil
+			}
+		}
+		return node
+	})
+}
+
+// records the locals declared by stat which are never reassigned and
+// initialized by literals
+func addConsts(consts map[*sema.Decl]ast.Exp, info *sema.Info, stat *ast.LocalVarDeclStat) {
+	for i, d := range info.Declared[stat] {
+		if !d.Reassigned && i < len(stat.ExpList) && isConstant(stat.ExpList[i]) {
+			consts[d] = stat.ExpList[i]
+		}
+	}
+}
+
+// removes the clauses of false conditions and the ones after true
+// condition, the stat is deleted if no clause is left, or turned into do
+// block if the first clause is always taken
+func optimizeIf(stat *ast.IfStat) ast.Node {
+	exps, blocks := stat.BExps[:0], stat.Blocks[:0]
+	for i, exp := range stat.BExps {
+		if isFalse(exp) {
+			continue
+		}
+		exps = append(exps, exp)
+		blocks = append(blocks, stat.Blocks[i])
+		if isTrue(exp) {
+			break
+		}
+	}
+	stat.BExps, stat.Blocks = exps, blocks
+
+	switch {
+	case len(exps) == 0:
+		return nil
+	case isTrue(exps[0]):
+		return &ast.DoStat{Span: stat.Span, MBlock: blocks[0]}
+	}
+	return stat
+}
+
+// returns a copy of the literal c at the place of name
+func copyConst(c ast.Exp, name *ast.NameExp) ast.Exp {
+	switch x := c.(type) {
+	case *ast.NilExp:
+		return &ast.NilExp{Span: name.Span, Line: name.Line}
+	case *ast.TrueExp:
+		return &ast.TrueExp{Span: name.Span, Line: name.Line}
+	case *ast.FalseExp:
+		return &ast.FalseExp{Span: name.Span, Line: name.Line}
+	case *ast.IntegerExp:
+		return &ast.IntegerExp{Span: name.Span, Line: name.Line, Val: x.Val}
+	case *ast.FloatExp:
+		return &ast.FloatExp{Span: name.Span, Line: name.Line, Val: x.Val}
+	case *ast.StringExp:
+		return &ast.StringExp{Span: name.Span, Line: name.Line, Str: x.Str}
+	}
+	panic("unreachable")
+}
+
+func isConstant(exp ast.Exp) bool {
+	return isTrue(exp) || isFalse(exp)
+}
+
+// reports whether exp is a literal other than nil and false
+func isTrue(exp ast.Exp) bool {
+	switch exp.(type) {
+	case *ast.TrueExp, *ast.IntegerExp, *ast.FloatExp, *ast.StringExp:
+		return true
+	}
+	return false
+}
+
+// reports whether exp is nil or false literal
+func isFalse(exp ast.Exp) bool {
+	switch exp.(type) {
+	case *ast.NilExp, *ast.FalseExp:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,195 @@
+package optimizer_test
+
+import (
+	"fmt"
+	"luago/compiler"
+	"luago/compiler/ast"
+	"luago/compiler/lexer"
+	"luago/compiler/optimizer"
+	"luago/compiler/parser"
+	"luago/state"
+	"luago/stdlib"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDeadBranches(t *testing.T) {
+	block := parser.Parse(`if false then f() end
+if nil then f() elseif x then g() elseif 1 then h() else k() end
+while false do f() end
+if true then local y = 1 end
+while x do end
+if x then elseif false then f() end`, "src")
+	optimizer.Optimize(block, optimizer.Options{DeadBranches: true})
+
+	if got, want := statTypes(block), "IfStat DoStat WhileStat IfStat"; got != want {
+		t.Fatalf("want stats %s, got %s", want, got)
+	}
+	if stat := block.Stats[0].(*ast.IfStat); len(stat.BExps) != 2 || expString(stat.BExps[1]) != "1" {
+		t.Errorf("unexpected clauses: %d", len(stat.BExps))
+	}
+	if stat := block.Stats[1].(*ast.DoStat); statTypes(stat.MBlock) != "LocalVarDeclStat" ||
+		stat.Start.Line != 4 {
+		t.Errorf("unexpected do block: %s at %d", statTypes(stat.MBlock), stat.Start.Line)
+	}
+	if stat := block.Stats[3].(*ast.IfStat); len(stat.BExps) != 1 {
+		t.Errorf("unexpected clauses: %d", len(stat.BExps))
+	}
+}
+
+func TestConstants(t *testing.T) {
+	src := `local a, b, c = 1, "s", x
+local d = a + 1
+local e = 2
+e = 3
+print(a, b, c, d, e, -a, #b, b .. a, a < d, not a, (a))
+local function f() return a end
+local a = "t"
+if a == "t" then print(a .. a) end`
+	block := parser.Parse(src, "src")
+	optimizer.Optimize(block, optimizer.Options{DeadBranches: true, Constants: true})
+
+	call := block.Stats[4].(*ast.FuncCallExp)
+	want := `1 "s" c 2 e -1 1 "s1" true false 1`
+	if got := expStrings(call.Args); got != want {
+		t.Errorf("want args %s, got %s", want, got)
+	}
+	if line := call.Args[0].(*ast.IntegerExp).Line; line != 5 {
+		t.Errorf("want line 5, got %d", line)
+	}
+	f := block.Stats[5].(*ast.LocalFuncDefStat).Func
+	if got := expStrings(f.MBlock.RetExps); got != "1" {
+		t.Errorf("want return 1, got %s", got)
+	}
+	do, ok := block.Stats[7].(*ast.DoStat)
+	if !ok {
+		t.Fatalf("want do block, got %T", block.Stats[7])
+	}
+	if got := expStrings(do.MBlock.Stats[0].(*ast.FuncCallExp).Args); got != `"tt"` {
+		t.Errorf(`want "tt", got %s`, got)
+	}
+
+	// the constants are kept without the option
+	block = parser.Parse(src, "src")
+	optimizer.Optimize(block, optimizer.Options{DeadBranches: true})
+	call = block.Stats[4].(*ast.FuncCallExp)
+	want = `a b c d e -(a) #(b) b..a a<d not(a) (a)`
+	if got := expStrings(call.Args); got != want {
+		t.Errorf("want args %s, got %s", want, got)
+	}
+	if _, ok := block.Stats[7].(*ast.IfStat); !ok {
+		t.Errorf("want if, got %T", block.Stats[7])
+	}
+}
+
+// runs the scripts at all levels, compares the results
+func TestLevels(t *testing.T) {
+	for _, src := range []string{
+		`local n, s = 10, "x"
+local t = {}
+for i = 1, n do
+  if i % 2 == 0 then t[#t+1] = s .. i elseif false then t[#t+1] = 0 end
+end
+while false do t = nil end
+if true then t.n = #t end
+return table.concat(t, ","), t.n, n > 5, not n, -n, #s, s .. 1 .. 2`,
+		`local debug = false
+local limit = 3
+local function f(x)
+  if debug then print("x", x) end
+  local r = {}
+  repeat x = x + 1; r[#r+1] = x until x >= limit
+  return r
+end
+local r = f(0)
+local ok, err = pcall(function() local v = {}; return v.a.b end)
+return #r, r[limit], ok, err`,
+		`local a = 1
+local function inc() a = a + 1 return a end
+inc()
+local b = "b"
+local function get() return b, a end
+return get()`,
+	} {
+		want := run(t, src, compiler.LevelNone)
+		for _, level := range []compiler.Level{compiler.LevelFold, compiler.LevelFull} {
+			if got := run(t, src, level); got != want {
+				t.Errorf("%s\nlevel %d\nwant: %s\ngot:  %s", src, level, want, got)
+			}
+		}
+	}
+}
+
+// runs src and returns its results, or the error
+func run(t *testing.T, src string, level compiler.Level) string {
+	t.Helper()
+	proto, err := state.CompileLevel([]byte(src), "src", "t", level)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ls := state.NewLuaState()
+	stdlib.OpenLibs(ls)
+	ls.PushProto(proto)
+	if ls.PCall(0, -1, 0) != 0 {
+		return "error: " + ls.ToString(-1)
+	}
+	var results []string
+	for i := 1; i <= ls.GetTop(); i++ {
+		results = append(results, fmt.Sprintf("%s:%s", ls.TypeName(ls.Type(i)), ls.ToString(i)))
+	}
+	return strings.Join(results, " ")
+}
+
+func statTypes(block *ast.Block) string {
+	var types []string
+	for _, stat := range block.Stats {
+		types = append(types, reflect.TypeOf(stat).Elem().Name())
+	}
+	return strings.Join(types, " ")
+}
+
+func expStrings(exps []ast.Exp) string {
+	var s []string
+	for _, exp := range exps {
+		s = append(s, expString(exp))
+	}
+	return strings.Join(s, " ")
+}
+
+var unOps = map[int]string{lexer.TokenOpUnm: "-", lexer.TokenOpLen: "#", lexer.TokenOpNot: "not"}
+
+var binOps = map[int]string{lexer.TokenOpLt: "<", lexer.TokenOpEq: "==", lexer.TokenOpAdd: "+"}
+
+// formats the literals, names and simple operations
+func expString(exp ast.Exp) string {
+	switch x := exp.(type) {
+	case *ast.NilExp:
+		return "nil"
+	case *ast.TrueExp:
+		return "true"
+	case *ast.FalseExp:
+		return "false"
+	case *ast.IntegerExp:
+		return fmt.Sprint(x.Val)
+	case *ast.FloatExp:
+		return fmt.Sprint(x.Val)
+	case *ast.StringExp:
+		return fmt.Sprintf("%q", x.Str)
+	case *ast.NameExp:
+		return x.Name
+	case *ast.ParensExp:
+		return "(" + expString(x.MExp) + ")"
+	case *ast.UnOpExp:
+		return unOps[x.Op] + "(" + expString(x.MExp) + ")"
+	case *ast.BinOpExp:
+		return expString(x.Exp1) + binOps[x.Op] + expString(x.Exp2)
+	case *ast.ConcatExp:
+		var s []string
+		for _, e := range x.Exps {
+			s = append(s, expString(e))
+		}
+		return strings.Join(s, "..")
+	}
+	return fmt.Sprintf("%T", exp)
+}
@@ -0,0 +1,93 @@
+package parser
+
+import (
+	"luago/compiler/ast"
+	"luago/compiler/lexer"
+	"sort"
+	"strings"
+)
+
+// attaches the comments to the stats of chunk: a comment trails the
+// outermost stat ending before it on the same line, or leads the first
+// stat after it, if there are only white spaces and comments between
+func attachComments(src string, chunk *ast.Block, trivia []lexer.Trivia) ast.CommentMap {
+	cmap := ast.CommentMap{}
+	stats := collectStats(chunk)
+	if len(stats) == 0 {
+		return cmap
+	}
+	byStart := stats // collected in the order of start
+	byEnd := append([]ast.Stat(nil), stats...)
+	sort.SliceStable(byEnd, func(i, j int) bool {
+		return byEnd[i].Pos().End.Offset < byEnd[j].Pos().End.Offset
+	})
+
+	// source code with the comments blanked out
+	blank := []byte(src)
+	for _, t := range trivia {
+		if t.IsComment() {
+			for i := t.Start.Offset; i < t.End.Offset; i++ {
+				blank[i] = ' '
+			}
+		}
+	}
+	isBlank := func(from, to int) bool {
+		return strings.TrimSpace(string(blank[from:to])) == ""
+	}
+	comments := func(stat ast.Stat) *ast.Comments {
+		c := cmap[stat]
+		if c == nil {
+			c = &ast.Comments{}
+			cmap[stat] = c
+		}
+		return c
+	}
+
+	for _, t := range trivia {
+		if !t.IsComment() {
+			continue
+		}
+		// the last stat ending before comment, the outermost one of ties
+		i := sort.Search(len(byEnd), func(i int) bool {
+			return byEnd[i].Pos().End.Offset > t.Start.Offset
+		}) - 1
+		for i > 0 && byEnd[i-1].Pos().End == byEnd[i].Pos().End {
+			i--
+		}
+		if i >= 0 {
+			end := byEnd[i].Pos().End
+			if end.Line == t.Start.Line && isBlank(end.Offset, t.Start.Offset) {
+				c := comments(byEnd[i])
+				c.Trailing = append(c.Trailing, t)
+				continue
+			}
+		}
+		// the first stat beginning after comment
+		i = sort.Search(len(byStart), func(i int) bool {
+			return byStart[i].Pos().Start.Offset >= t.End.Offset
+		})
+		if i < len(byStart) && isBlank(t.End.Offset, byStart[i].Pos().Start.Offset) {
+			c := comments(byStart[i])
+			c.Leading = append(c.Leading, t)
+		}
+	}
+	return cmap
+}
+
+// returns the stats of block and the stats nested in them in the order
+// of source code
+func collectStats(block *ast.Block) (stats []ast.Stat) {
+	inBlock := map[ast.Node]bool{}
+	ast.Inspect(block, func(node ast.Node) bool {
+		if inBlock[node] {
+			stats = append(stats, node)
+		}
+		if b, ok := node.(*ast.Block); ok {
+			for _, stat := range b.Stats {
+				inBlock[stat] = true
+			}
+		}
+		return true
+	}, nil)
+	return
+}
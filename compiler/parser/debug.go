@@ -45,8 +45,6 @@ func expToString(exp ast.Exp) string {
 		return expToString(x.PrefixExp) + "[" + expToString(x.Key) + "]"
 	case *ast.FuncCallExp:
 		return fcExpToString(x)
-	case int: // index?
-		return fmt.Sprintf("%d", x)
 	default:
 		panic("unreachable")
 	}
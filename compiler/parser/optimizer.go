@@ -5,8 +5,50 @@ import (
 	"luago/compiler/lexer"
 	"luago/number"
 	"math"
+	"strconv"
 )
 
+// folds exp if the lexer folds the constant expressions
+func optimize(lex *lexer.Lexer, exp ast.Exp) ast.Exp {
+	if !lex.Folding() {
+		return exp
+	}
+	return FoldExp(exp)
+}
+
+// FoldExp folds the constant expression exp whose operands are folded
+// already, like the parser does by default, exp is returned as is if it
+// can't be folded. The optimizers after parsing use it to fold the
+// expressions they make constant
+func FoldExp(exp ast.Exp) ast.Exp {
+	switch x := exp.(type) {
+	case *ast.BinOpExp:
+		switch x.Op {
+		case lexer.TokenOpOr:
+			return optimizeLogicalOr(x)
+		case lexer.TokenOpAnd:
+			return optimizeLogicalAnd(x)
+		case lexer.TokenOpBand, lexer.TokenOpBor, lexer.TokenOpBxor,
+			lexer.TokenOpShl, lexer.TokenOpShr:
+			return optimizeBitwiseBinOp(x)
+		case lexer.TokenOpLt, lexer.TokenOpGt, lexer.TokenOpLe,
+			lexer.TokenOpGe, lexer.TokenOpEq, lexer.TokenOpNe:
+			return optimizeComparison(x)
+		default:
+			return optimizeArithBinOp(x)
+		}
+	case *ast.UnOpExp:
+		return optimizeUnaryOp(x)
+	case *ast.ConcatExp:
+		return optimizeConcat(x)
+	case *ast.ParensExp:
+		if isConstant(x.MExp) {
+			return x.MExp
+		}
+	}
+	return exp
+}
+
 func optimizeLogicalOr(exp *ast.BinOpExp) ast.Exp {
 	if isTrue(exp.Exp1) {
 		return exp.Exp1 // true or x => true
@@ -34,15 +76,15 @@ func optimizeBitwiseBinOp(exp *ast.BinOpExp) ast.Exp {
 		if y, ok := castToInt(exp.Exp2); ok {
 			switch exp.Op {
 			case lexer.TokenOpBand:
-				return &ast.IntegerExp{Line: exp.Line, Val: x & y}
+				return &ast.IntegerExp{Span: exp.Span, Line: exp.Line, Val: x & y}
 			case lexer.TokenOpBor:
-				return &ast.IntegerExp{Line: exp.Line, Val: x | y}
+				return &ast.IntegerExp{Span: exp.Span, Line: exp.Line, Val: x | y}
 			case lexer.TokenOpBxor:
-				return &ast.IntegerExp{Line: exp.Line, Val: x ^ y}
+				return &ast.IntegerExp{Span: exp.Span, Line: exp.Line, Val: x ^ y}
 			case lexer.TokenOpShl:
-				return &ast.IntegerExp{Line: exp.Line, Val: number.ShiftLeft(x, y)}
+				return &ast.IntegerExp{Span: exp.Span, Line: exp.Line, Val: number.ShiftLeft(x, y)}
 			case lexer.TokenOpShr:
-				return &ast.IntegerExp{Line: exp.Line, Val: number.ShiftRight(x, y)}
+				return &ast.IntegerExp{Span: exp.Span, Line: exp.Line, Val: number.ShiftRight(x, y)}
 			}
 		}
 	}
@@ -55,14 +97,15 @@ func optimizeArithBinOp(exp *ast.BinOpExp) ast.Exp {
 		if y, ok := exp.Exp2.(*ast.IntegerExp); ok {
 			switch exp.Op {
 			case lexer.TokenOpAdd:
-				return &ast.IntegerExp{Line: exp.Line, Val: x.Val + y.Val}
+				return &ast.IntegerExp{Span: exp.Span, Line: exp.Line, Val: x.Val + y.Val}
 			case lexer.TokenOpSub:
-				return &ast.IntegerExp{Line: exp.Line, Val: x.Val - y.Val}
+				return &ast.IntegerExp{Span: exp.Span, Line: exp.Line, Val: x.Val - y.Val}
 			case lexer.TokenOpMul:
-				return &ast.IntegerExp{Line: exp.Line, Val: x.Val * y.Val}
+				return &ast.IntegerExp{Span: exp.Span, Line: exp.Line, Val: x.Val * y.Val}
 			case lexer.TokenOpIDiv:
 				if y.Val != 0 {
 					return &ast.IntegerExp{
+						Span: exp.Span,
 						Line: exp.Line,
 						Val:  number.IFloorDiv(x.Val, y.Val),
 					}
@@ -70,6 +113,7 @@ func optimizeArithBinOp(exp *ast.BinOpExp) ast.Exp {
 			case lexer.TokenOpMod:
 				if y.Val != 0 {
 					return &ast.IntegerExp{
+						Span: exp.Span,
 						Line: exp.Line,
 						Val:  number.IMod(x.Val, y.Val),
 					}
@@ -82,23 +126,23 @@ func optimizeArithBinOp(exp *ast.BinOpExp) ast.Exp {
 		if y, ok := castToFloat(exp.Exp2); ok {
 			switch exp.Op {
 			case lexer.TokenOpAdd:
-				return &ast.FloatExp{Line: exp.Line, Val: x + y}
+				return &ast.FloatExp{Span: exp.Span, Line: exp.Line, Val: x + y}
 			case lexer.TokenOpSub:
-				return &ast.FloatExp{Line: exp.Line, Val: x - y}
+				return &ast.FloatExp{Span: exp.Span, Line: exp.Line, Val: x - y}
 			case lexer.TokenOpMul:
-				return &ast.FloatExp{Line: exp.Line, Val: x * y}
+				return &ast.FloatExp{Span: exp.Span, Line: exp.Line, Val: x * y}
 			case lexer.TokenOpDiv:
 				if y != 0 {
-					return &ast.FloatExp{Line: exp.Line, Val: x / y}
+					return &ast.FloatExp{Span: exp.Span, Line: exp.Line, Val: x / y}
 				}
 			case lexer.TokenOpIDiv:
 				if y != 0 {
-					return &ast.FloatExp{Line: exp.Line, Val: number.FFloorDiv(x, y)}
+					return &ast.FloatExp{Span: exp.Span, Line: exp.Line, Val: number.FFloorDiv(x, y)}
 				}
 			case lexer.TokenOpMod:
-				return &ast.FloatExp{Line: exp.Line, Val: number.FMod(x, y)}
+				return &ast.FloatExp{Span: exp.Span, Line: exp.Line, Val: number.FMod(x, y)}
 			case lexer.TokenOpPow:
-				return &ast.FloatExp{Line: exp.Line, Val: math.Pow(x, y)}
+				return &ast.FloatExp{Span: exp.Span, Line: exp.Line, Val: math.Pow(x, y)}
 			}
 		}
 	}
@@ -114,7 +158,8 @@ func optimizeUnaryOp(exp *ast.UnOpExp) ast.Exp {
 		return optimizeUnm(exp)
 	case lexer.TokenOpBnot:
 		return optimizeBnot(exp)
-		// NOTE: len?
+	case lexer.TokenOpLen:
+		return optimizeLen(exp)
 	default:
 		return exp
 	}
@@ -123,9 +168,9 @@ func optimizeUnaryOp(exp *ast.UnOpExp) ast.Exp {
 func optimizeNot(exp *ast.UnOpExp) ast.Exp {
 	switch exp.MExp.(type) {
 	case *ast.NilExp, *ast.FalseExp:
-		return &ast.TrueExp{Line: exp.Line}
+		return &ast.TrueExp{Span: exp.Span, Line: exp.Line}
 	case *ast.TrueExp, *ast.IntegerExp, *ast.FloatExp, *ast.StringExp:
-		return &ast.FalseExp{Line: exp.Line}
+		return &ast.FalseExp{Span: exp.Span, Line: exp.Line}
 	default:
 		return exp
 	}
@@ -135,9 +180,11 @@ func optimizeUnm(exp *ast.UnOpExp) ast.Exp {
 	switch x := exp.MExp.(type) {
 	case *ast.IntegerExp:
 		x.Val = -x.Val
+		x.Span = exp.Span
 		return x
 	case *ast.FloatExp:
 		x.Val = -x.Val
+		x.Span = exp.Span
 		return x
 	default:
 		return exp
@@ -148,27 +195,152 @@ func optimizeBnot(exp *ast.UnOpExp) ast.Exp {
 	switch x := exp.MExp.(type) {
 	case *ast.IntegerExp:
 		x.Val = ^x.Val // ^ is bitwise not in golang
+		x.Span = exp.Span
 		return x
 	case *ast.FloatExp:
 		if i, ok := number.FloatToInteger(x.Val); ok {
-			return &ast.IntegerExp{Line: x.Line, Val: ^i}
+			return &ast.IntegerExp{Span: exp.Span, Line: x.Line, Val: ^i}
 		}
 	}
 
 	return exp
 }
 
-// exp = exp0 ^ exp2 | exp0
-func optimizePow(exp ast.Exp) ast.Exp {
-	if binOp, ok := exp.(*ast.BinOpExp); ok { // exp0 ^ exp2
-		if binOp.Op == lexer.TokenOpPow {
-			binOp.Exp2 = optimizePow(binOp.Exp2)
+func optimizeLen(exp *ast.UnOpExp) ast.Exp {
+	if x, ok := exp.MExp.(*ast.StringExp); ok {
+		return &ast.IntegerExp{Span: exp.Span, Line: exp.Line, Val: int64(len(x.Str))}
+	}
+	return exp
+}
+
+// compares the constants like the vm, the numbers are compared as floats
+// if either is float, the strings are compared by bytes, the other
+// operands of order are left to the runtime errors and metamethods
+func optimizeComparison(exp *ast.BinOpExp) ast.Exp {
+	x, y := exp.Exp1, exp.Exp2
+	if !isConstant(x) || !isConstant(y) {
+		return exp
+	}
+	var result, ok bool
+	switch exp.Op {
+	case lexer.TokenOpEq:
+		result, ok = constEqual(x, y), true
+	case lexer.TokenOpNe:
+		result, ok = !constEqual(x, y), true
+	case lexer.TokenOpLt:
+		result, ok = constLess(x, y, false)
+	case lexer.TokenOpLe:
+		result, ok = constLess(x, y, true)
+	case lexer.TokenOpGt:
+		result, ok = constLess(y, x, false)
+	case lexer.TokenOpGe:
+		result, ok = constLess(y, x, true)
+	}
+	if !ok {
+		return exp
+	}
+	if result {
+		return &ast.TrueExp{Span: exp.Span, Line: exp.Line}
+	}
+	return &ast.FalseExp{Span: exp.Span, Line: exp.Line}
+}
+
+func constEqual(x, y ast.Exp) bool {
+	switch a := x.(type) {
+	case *ast.IntegerExp:
+		switch b := y.(type) {
+		case *ast.IntegerExp:
+			return a.Val == b.Val
+		case *ast.FloatExp:
+			return float64(a.Val) == b.Val
+		}
+	case *ast.FloatExp:
+		if b, ok := castToFloat(y); ok {
+			return a.Val == b
 		}
+	case *ast.StringExp:
+		b, ok := y.(*ast.StringExp)
+		return ok && a.Str == b.Str
+	case *ast.NilExp:
+		_, ok := y.(*ast.NilExp)
+		return ok
+	case *ast.TrueExp:
+		_, ok := y.(*ast.TrueExp)
+		return ok
+	case *ast.FalseExp:
+		_, ok := y.(*ast.FalseExp)
+		return ok
+	}
+	return false
+}
+
+// returns x < y, or x <= y if orEqual, and whether they are comparable
+func constLess(x, y ast.Exp, orEqual bool) (bool, bool) {
+	if i, ok := x.(*ast.IntegerExp); ok {
+		if j, ok := y.(*ast.IntegerExp); ok {
+			return i.Val < j.Val || orEqual && i.Val == j.Val, true
+		}
+	}
+	if a, ok := castToFloat(x); ok {
+		if b, ok := castToFloat(y); ok {
+			return a < b || orEqual && a == b, true
+		}
+	}
+	if a, ok := x.(*ast.StringExp); ok {
+		if b, ok := y.(*ast.StringExp); ok {
+			return a.Str < b.Str || orEqual && a.Str == b.Str, true
+		}
+	}
+	return false, false
+}
+
+// joins the strings and integers at the end of exps, the vm concatenates
+// them first, the ones before other operands are kept for the __concat
+// metamethods
+func optimizeConcat(exp *ast.ConcatExp) ast.Exp {
+	n := len(exp.Exps)
+	for n > 0 {
+		if _, ok := concatString(exp.Exps[n-1]); !ok {
+			break
+		}
+		n--
+	}
+	if len(exp.Exps)-n < 2 {
+		return exp
+	}
 
-		return optimizeArithBinOp(binOp)
+	str := ""
+	for _, e := range exp.Exps[n:] {
+		s, _ := concatString(e)
+		str += s
 	}
+	if n == 0 {
+		return &ast.StringExp{Span: exp.Span, Line: exp.Line, Str: str}
+	}
+	span := ast.Span{Start: exp.Exps[n].Pos().Start, End: exp.Span.End}
+	exp.Exps = append(exp.Exps[:n], &ast.StringExp{Span: span, Line: exp.Line, Str: str})
+	return exp
+}
 
-	return exp // exp0
+// returns the string of a string or integer literal in concatenation
+func concatString(exp ast.Exp) (string, bool) {
+	switch x := exp.(type) {
+	case *ast.StringExp:
+		return x.Str, true
+	case *ast.IntegerExp:
+		return strconv.FormatInt(x.Val, 10), true
+	}
+	return "", false
+}
+
+// reports whether exp is a literal of nil, boolean, number or string
+func isConstant(exp ast.Exp) bool {
+	switch exp.(type) {
+	case *ast.NilExp, *ast.TrueExp, *ast.FalseExp, *ast.IntegerExp, *ast.FloatExp,
+		*ast.StringExp:
+		return true
+	}
+	return false
 }
 
 // nil and false => false
@@ -5,10 +5,78 @@ import (
 	"luago/compiler/lexer"
 )
 
+// Diagnostic is a syntax error found by ParseDiagnostics
+type Diagnostic = lexer.Error
+
+// returns the span from start to the end of last token
+func spanFrom(lex *lexer.Lexer, start lexer.Position) ast.Span {
+	_, end := lex.Span()
+	return ast.Span{Start: start, End: end}
+}
+
+// returns the span of last token
+func tokenSpan(lex *lexer.Lexer) ast.Span {
+	start, end := lex.Span()
+	return ast.Span{Start: start, End: end}
+}
+
 // Parse lua string to lua chunk
 func Parse(chunk, chunkName string) *ast.Block {
+	return ParseFolding(chunk, chunkName, true)
+}
+
+// ParseFolding parses lua string to lua chunk like Parse, the constant
+// expressions are folded only if folding is true
+func ParseFolding(chunk, chunkName string, folding bool) *ast.Block {
 	lex := lexer.NewLexer(chunk, chunkName)
+	lex.SetFolding(folding)
 	block := parseBlock(lex)
 	lex.AssertNextTokenKind(lexer.TokenEOF)
 	return block
 }
+
+// ParseDiagnostics parses lua string to lua chunk like Parse, but returns
+// the syntax errors instead of panicking. The parser recovers from the
+// errors at the boundaries of stats, the stats with errors are left out
+// of the chunk, so it has all the errors and the stats without errors
+func ParseDiagnostics(chunk, chunkName string) (*ast.Block, []Diagnostic) {
+	return parseRecovering(lexer.NewLexer(chunk, chunkName))
+}
+
+// ParseChunk parses lua string to lua chunk like ParseDiagnostics for the
+// source tools, it keeps the comments and blank lines and attaches the
+// comments to the stats they lead or trail, the constant expressions are
+// not folded, so the ast follows the source
+func ParseChunk(chunk, chunkName string) (*ast.Chunk, []Diagnostic) {
+	lex := lexer.NewLexer(chunk, chunkName)
+	lex.SetKeepTrivia(true)
+	lex.SetFolding(false)
+	block, diags := parseRecovering(lex)
+	trivia := lex.Trivia()
+	return &ast.Chunk{
+		Block:    block,
+		Trivia:   trivia,
+		Comments: attachComments(chunk, block, trivia),
+	}, diags
+}
+
+func parseRecovering(lex *lexer.Lexer) (*ast.Block, []Diagnostic) {
+	lex.SetRecovery(true)
+	block := parseBlock(lex)
+	for lookAhead(lex) != lexer.TokenEOF { // `end` without block or stats after return
+		func() {
+			defer func() { lex.Recover(recover()) }()
+			lex.AssertNextTokenKind(lexer.TokenEOF)
+		}()
+		lex.NextToken()
+		rest := parseBlock(lex)
+		block.Stats = append(block.Stats, rest.Stats...)
+		if rest.RetExps != nil {
+			block.RetExps = rest.RetExps
+			block.RetSpan = rest.RetSpan
+		}
+		block.LastLine = rest.LastLine
+		block.End = rest.End
+	}
+	return block, lex.Errors()
+}
@@ -6,18 +6,32 @@ import (
 )
 
 func parseBlock(lex *lexer.Lexer) *ast.Block {
-	return &ast.Block{
-		Stats:    parseStats(lex),
-		RetExps:  parseRetExps(lex),
-		LastLine: lex.Line(),
+	if lex.Recovering() {
+		lookAhead(lex) // records the lexical errors before the block
 	}
+	start := lex.LookAheadPos()
+	block := &ast.Block{Stats: parseStats(lex)}
+	retStart := lex.LookAheadPos()
+	block.RetExps = parseRetExps(lex)
+	block.LastLine = lex.Line()
+	if block.RetExps != nil {
+		block.RetSpan = spanFrom(lex, retStart)
+	}
+	block.Span = spanFrom(lex, start)
+	if block.End.Offset < start.Offset { // empty block
+		block.End = start
+	}
+	return block
 }
 
 func parseStats(lex *lexer.Lexer) []ast.Stat {
 	stats := make([]ast.Stat, 0, 8)
-	for !isReturnOrBlockEnd(lex.LookAhead()) {
-		stat := parseStat(lex)
-		if _, ok := stat.(*ast.EmptyStat); !ok { // ignore the empty stat
+	for {
+		stat, ok := nextStat(lex)
+		if !ok {
+			break
+		}
+		if _, ok := stat.(*ast.EmptyStat); !ok && stat != nil { // ignore the empty stat
 			stats = append(stats, stat)
 		}
 	}
@@ -25,6 +39,26 @@ func parseStats(lex *lexer.Lexer) []ast.Stat {
 	return stats
 }
 
+// parses the next stat of block, returns false at the end of block;
+// if the lexer recovers from syntax errors, the stat with error is skipped
+// and nil is returned
+func nextStat(lex *lexer.Lexer) (stat ast.Stat, ok bool) {
+	if lex.Recovering() {
+		depth := lex.Depth()
+		defer func() {
+			if r := recover(); r != nil {
+				lex.Recover(r)
+				skipToStat(lex, depth)
+				stat, ok = nil, true
+			}
+		}()
+	}
+	if isReturnOrBlockEnd(lex.LookAhead()) {
+		return nil, false
+	}
+	return parseStat(lex), true
+}
+
 // see https://cloudwu.github.io/lua53doc/manual.html#9
 // after the block there are `return`, eof, `end`, `until`, `elseif`, `else`
 func isReturnOrBlockEnd(kind int) bool {
@@ -36,10 +70,20 @@ func isReturnOrBlockEnd(kind int) bool {
 	return false
 }
 
-func parseRetExps(lex *lexer.Lexer) []ast.Exp {
+func parseRetExps(lex *lexer.Lexer) (exps []ast.Exp) {
 	if lex.LookAhead() != lexer.TokenKwReturn {
 		return nil
 	}
+	if lex.Recovering() {
+		depth := lex.Depth()
+		defer func() {
+			if r := recover(); r != nil {
+				lex.Recover(r)
+				skipToStat(lex, depth)
+				exps = []ast.Exp{}
+			}
+		}()
+	}
 
 	lex.NextToken() // skips the `return`
 	switch lex.LookAhead() {
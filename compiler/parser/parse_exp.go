@@ -45,8 +45,8 @@ func parseExp12(lex *lexer.Lexer) ast.Exp {
 	exp := parseExp11(lex)
 	for lex.LookAhead() == lexer.TokenOpOr {
 		line, op, _ := lex.NextToken() // `or`
-		lOr := &ast.BinOpExp{Line: line, Op: op, Exp1: exp, Exp2: parseExp11(lex)}
-		exp = optimizeLogicalOr(lOr)
+		lOr := newBinOpExp(lex, line, op, exp, parseExp11(lex))
+		exp = optimize(lex, lOr)
 	}
 
 	return exp
@@ -57,8 +57,8 @@ func parseExp11(lex *lexer.Lexer) ast.Exp {
 	exp := parseExp10(lex)
 	for lex.LookAhead() == lexer.TokenOpAnd {
 		line, op, _ := lex.NextToken() // `and`
-		lAnd := &ast.BinOpExp{Line: line, Op: op, Exp1: exp, Exp2: parseExp10(lex)}
-		exp = optimizeLogicalAnd(lAnd)
+		lAnd := newBinOpExp(lex, line, op, exp, parseExp10(lex))
+		exp = optimize(lex, lAnd)
 	}
 
 	return exp
@@ -72,7 +72,8 @@ func parseExp10(lex *lexer.Lexer) ast.Exp {
 		case lexer.TokenOpLt, lexer.TokenOpGt, lexer.TokenOpLe,
 			lexer.TokenOpGe, lexer.TokenOpEq, lexer.TokenOpNe:
 			line, op, _ := lex.NextToken() // comp op
-			exp = &ast.BinOpExp{Line: line, Op: op, Exp1: exp, Exp2: parseExp9(lex)}
+			cmp := newBinOpExp(lex, line, op, exp, parseExp9(lex))
+			exp = optimize(lex, cmp)
 		default:
 			return exp
 		}
@@ -84,8 +85,8 @@ func parseExp9(lex *lexer.Lexer) ast.Exp {
 	exp := parseExp8(lex)
 	for lex.LookAhead() == lexer.TokenOpBor {
 		line, op, _ := lex.NextToken() // `|`
-		bOr := &ast.BinOpExp{Line: line, Op: op, Exp1: exp, Exp2: parseExp8(lex)}
-		exp = optimizeBitwiseBinOp(bOr)
+		bOr := newBinOpExp(lex, line, op, exp, parseExp8(lex))
+		exp = optimize(lex, bOr)
 	}
 
 	return exp
@@ -96,8 +97,8 @@ func parseExp8(lex *lexer.Lexer) ast.Exp {
 	exp := parseExp7(lex)
 	for lex.LookAhead() == lexer.TokenOpBxor {
 		line, op, _ := lex.NextToken() // `~`
-		bXor := &ast.BinOpExp{Line: line, Op: op, Exp1: exp, Exp2: parseExp7(lex)}
-		exp = optimizeBitwiseBinOp(bXor)
+		bXor := newBinOpExp(lex, line, op, exp, parseExp7(lex))
+		exp = optimize(lex, bXor)
 	}
 
 	return exp
@@ -108,8 +109,8 @@ func parseExp7(lex *lexer.Lexer) ast.Exp {
 	exp := parseExp6(lex)
 	for lex.LookAhead() == lexer.TokenOpBand {
 		line, op, _ := lex.NextToken() // `&`
-		bAnd := &ast.BinOpExp{Line: line, Op: op, Exp1: exp, Exp2: parseExp6(lex)}
-		exp = optimizeBitwiseBinOp(bAnd)
+		bAnd := newBinOpExp(lex, line, op, exp, parseExp6(lex))
+		exp = optimize(lex, bAnd)
 	}
 
 	return exp
@@ -122,8 +123,8 @@ func parseExp6(lex *lexer.Lexer) ast.Exp {
 		switch lex.LookAhead() {
 		case lexer.TokenOpShl, lexer.TokenOpShr:
 			line, op, _ := lex.NextToken() // `<<`|`>>`
-			shift := &ast.BinOpExp{Line: line, Op: op, Exp1: exp, Exp2: parseExp5(lex)}
-			exp = optimizeBitwiseBinOp(shift)
+			shift := newBinOpExp(lex, line, op, exp, parseExp5(lex))
+			exp = optimize(lex, shift)
 		default:
 			return exp
 		}
@@ -146,7 +147,8 @@ func parseExp5(lex *lexer.Lexer) ast.Exp {
 		exps = append(exps, parseExp4(lex))
 	}
 
-	return &ast.ConcatExp{Line: line, Exps: exps}
+	concat := &ast.ConcatExp{Span: spanFrom(lex, exp.Pos().Start), Line: line, Exps: exps}
+	return optimize(lex, concat)
 }
 
 // exp3 {`+`|`-` exp3}
@@ -156,8 +158,8 @@ func parseExp4(lex *lexer.Lexer) ast.Exp {
 		switch lex.LookAhead() {
 		case lexer.TokenOpAdd, lexer.TokenOpSub:
 			line, op, _ := lex.NextToken() // +|-
-			arith := &ast.BinOpExp{Line: line, Op: op, Exp1: exp, Exp2: parseExp3(lex)}
-			exp = optimizeArithBinOp(arith)
+			arith := newBinOpExp(lex, line, op, exp, parseExp3(lex))
+			exp = optimize(lex, arith)
 		default:
 			return exp
 		}
@@ -171,8 +173,8 @@ func parseExp3(lex *lexer.Lexer) ast.Exp {
 		switch lex.LookAhead() {
 		case lexer.TokenOpMul, lexer.TokenOpDiv, lexer.TokenOpIDiv, lexer.TokenOpMod:
 			line, op, _ := lex.NextToken() // *|/|//|%
-			arith := &ast.BinOpExp{Line: line, Op: op, Exp1: exp, Exp2: parseExp2(lex)}
-			exp = optimizeArithBinOp(arith)
+			arith := newBinOpExp(lex, line, op, exp, parseExp2(lex))
+			exp = optimize(lex, arith)
 		default:
 			return exp
 		}
@@ -188,8 +190,10 @@ func parseExp2(lex *lexer.Lexer) ast.Exp {
 	switch lex.LookAhead() {
 	case lexer.TokenOpNot, lexer.TokenOpLen, lexer.TokenOpMinus, lexer.TokenOpBnot:
 		line, op, _ := lex.NextToken() // unary op
-		exp := &ast.UnOpExp{Line: line, Op: op, MExp: parseExp2(lex)}
-		return optimizeUnaryOp(exp)
+		start, _ := lex.Span()
+		mExp := parseExp2(lex)
+		exp := &ast.UnOpExp{Span: spanFrom(lex, start), Line: line, Op: op, MExp: mExp}
+		return optimize(lex, exp)
 	}
 
 	return parseExp1(lex)
@@ -202,11 +206,11 @@ func parseExp1(lex *lexer.Lexer) ast.Exp {
 	exp := parseExp0(lex)
 	for lex.LookAhead() == lexer.TokenOpPow {
 		line, op, _ := lex.NextToken() // `^`
-		exp = &ast.BinOpExp{Line: line, Op: op, Exp1: exp, Exp2: parseExp2(lex)}
+		exp = newBinOpExp(lex, line, op, exp, parseExp2(lex))
 		// exp0 ^ exp2
 	}
 
-	return optimizePow(exp)
+	return optimize(lex, exp)
 }
 
 // `nil` | `false` | `true` | Numeral | LiteralString |
@@ -215,24 +219,25 @@ func parseExp0(lex *lexer.Lexer) ast.Exp {
 	switch lex.LookAhead() {
 	case lexer.TokenKwNil:
 		line, _, _ := lex.NextToken() // `nil`
-		return &ast.NilExp{Line: line}
+		return &ast.NilExp{Span: tokenSpan(lex), Line: line}
 	case lexer.TokenKwFalse:
 		line, _, _ := lex.NextToken() // `false`
-		return &ast.FalseExp{Line: line}
+		return &ast.FalseExp{Span: tokenSpan(lex), Line: line}
 	case lexer.TokenKwTrue:
 		line, _, _ := lex.NextToken() // `true`
-		return &ast.TrueExp{Line: line}
+		return &ast.TrueExp{Span: tokenSpan(lex), Line: line}
 	case lexer.TokenNumber: // Numeral
 		return parseNumberExp(lex)
 	case lexer.TokenString:
 		line, _, token := lex.NextToken() // LiteralString
-		return &ast.StringExp{Line: line, Str: token}
+		return &ast.StringExp{Span: tokenSpan(lex), Line: line, Str: token}
 	case lexer.TokenVararg: // `...`
 		line, _, _ := lex.NextToken()
-		return &ast.VarargExp{Line: line}
+		return &ast.VarargExp{Span: tokenSpan(lex), Line: line}
 	case lexer.TokenKwFunction: // functiondef
 		lex.NextToken() // skips the `function`
-		return parseFuncDefExp(lex)
+		start, _ := lex.Span()
+		return parseFuncDefExp(lex, start)
 	case lexer.TokenSepLcurly: // `{` tablecontructor
 		return parseTableConstructor(lex)
 	default:
@@ -243,24 +248,27 @@ func parseExp0(lex *lexer.Lexer) ast.Exp {
 func parseNumberExp(lex *lexer.Lexer) ast.Exp {
 	line, _, token := lex.NextToken()
 	if i, ok := number.ParseInteger(token); ok {
-		return &ast.IntegerExp{Line: line, Val: i}
+		return &ast.IntegerExp{Span: tokenSpan(lex), Line: line, Val: i}
 	} else if f, ok := number.ParseFloat(token); ok {
-		return &ast.FloatExp{Line: line, Val: f}
+		return &ast.FloatExp{Span: tokenSpan(lex), Line: line, Val: f}
 	}
 
-	panic("not a number: " + token)
+	lex.TokenError(lexer.ErrMalformedNumber, "malformed number near '%s'", token)
+	panic("unreachable")
 }
 
 // tableconstructor ::= `{` [fieldlist] `}`
 // fieldlist ::= field {fieldsep field} [fieldsep]
 func parseTableConstructor(lex *lexer.Lexer) *ast.TableConstructionExp {
 	line := lex.Line()
+	start := lex.LookAheadPos()
 	lex.AssertNextTokenKind(lexer.TokenSepLcurly) // `{`
 	keyExps, valExps := parseFieldList(lex)       // `[fieldlist]`
 	lex.AssertNextTokenKind(lexer.TokenSepRcurly) // `}`
 	lastLine := lex.Line()
 
 	return &ast.TableConstructionExp{
+		Span:      spanFrom(lex, start),
 		FirstLine: line,
 		LastLine:  lastLine,
 		KeyExps:   keyExps,
@@ -308,7 +316,7 @@ func parseField(lex *lexer.Lexer) (k, v ast.Exp) {
 	if name, ok := exp.(*ast.NameExp); ok { // Name `=` exp
 		if lex.LookAhead() == lexer.TokenOpAssign { // Name => LiteralString
 			lex.NextToken() // `=`
-			k = &ast.StringExp{Line: name.Line, Str: name.Name}
+			k = &ast.StringExp{Span: name.Span, Line: name.Line, Str: name.Name}
 			v = parseExp(lex)
 			return
 		}
@@ -318,17 +326,20 @@ func parseField(lex *lexer.Lexer) (k, v ast.Exp) {
 }
 
 // funcbody ::= `(` [parlist] `)` block end
-func parseFuncDefExp(lex *lexer.Lexer) *ast.FuncDefExp {
+// start is the position of `function`
+func parseFuncDefExp(lex *lexer.Lexer, start lexer.Position) *ast.FuncDefExp {
 	line := lex.Line()
 	lex.AssertNextTokenKind(lexer.TokenSepLparen)            // `(`
-	parList, isVararg := parseParList(lex)                   // [parlist]
+	parList, parSpans, isVararg := parseParList(lex)         // [parlist]
 	lex.AssertNextTokenKind(lexer.TokenSepRparen)            // `)`
 	block := parseBlock(lex)                                 // block
 	lastLine, _ := lex.AssertNextTokenKind(lexer.TokenKwEnd) // `end`
 	return &ast.FuncDefExp{
+		Span:      spanFrom(lex, start),
 		FirstLine: line,
 		LastLine:  lastLine,
 		ParList:   parList,
+		ParSpans:  parSpans,
 		IsVararg:  isVararg,
 		MBlock:    block,
 	}
@@ -336,22 +347,24 @@ func parseFuncDefExp(lex *lexer.Lexer) *ast.FuncDefExp {
 
 // parlist ::= namelist [`,` `...`] | `...`
 // namelist ::= Name {`,` Name}
-func parseParList(lex *lexer.Lexer) (names []string, isVararg bool) {
+func parseParList(lex *lexer.Lexer) (names []string, spans []ast.Span, isVararg bool) {
 	switch lex.LookAhead() {
 	case lexer.TokenSepRparen:
-		return nil, false
+		return nil, nil, false
 	case lexer.TokenVararg:
 		lex.NextToken() // `...`
-		return nil, true
+		return nil, nil, true
 	}
 
 	_, name := lex.AssertNextTokenKind(lexer.TokenIdentifier) // Name
 	names = append(names, name)
+	spans = append(spans, tokenSpan(lex))
 	for lex.LookAhead() == lexer.TokenSepComma { // `,`
 		lex.NextToken() // skips the `,`
 		if lex.LookAhead() == lexer.TokenIdentifier {
 			_, name := lex.AssertNextTokenKind(lexer.TokenIdentifier) // Name
 			names = append(names, name)
+			spans = append(spans, tokenSpan(lex))
 		} else {
 			lex.AssertNextTokenKind(lexer.TokenVararg)
 			isVararg = true
@@ -376,7 +389,7 @@ func parseParList(lex *lexer.Lexer) (names []string, isVararg bool) {
 func parsePrefixExp(lex *lexer.Lexer) (exp ast.Exp) {
 	if lex.LookAhead() == lexer.TokenIdentifier { // Name
 		line, name := lex.AssertNextTokenKind(lexer.TokenIdentifier)
-		exp = &ast.NameExp{Line: line, Name: name}
+		exp = &ast.NameExp{Span: tokenSpan(lex), Line: line, Name: name}
 	} else { // `(` exp `)`
 		exp = parseParensExp(lex)
 	}
@@ -384,13 +397,14 @@ func parsePrefixExp(lex *lexer.Lexer) (exp ast.Exp) {
 }
 
 func parseParensExp(lex *lexer.Lexer) ast.Exp {
+	start := lex.LookAheadPos()
 	lex.AssertNextTokenKind(lexer.TokenSepLparen) // `(`
 	exp := parseExp(lex)                          // exp
 	lex.AssertNextTokenKind(lexer.TokenSepRparen) // `)`
 
 	switch exp.(type) {
 	case *ast.VarargExp, *ast.FuncCallExp, *ast.NameExp, *ast.TableAccessExp:
-		return &ast.ParensExp{MExp: exp}
+		return &ast.ParensExp{Span: spanFrom(lex, start), MExp: exp}
 	}
 
 	// no need to keep the parens?
@@ -404,13 +418,23 @@ func finishPrefixExp(lex *lexer.Lexer, exp ast.Exp) ast.Exp {
 			lex.NextToken()                               // `[`
 			keyExp := parseExp(lex)                       // exp
 			lex.AssertNextTokenKind(lexer.TokenSepRBrack) // `]`
-			exp = &ast.TableAccessExp{LastLine: lex.Line(), PrefixExp: exp, Key: keyExp}
+			exp = &ast.TableAccessExp{
+				Span:      spanFrom(lex, exp.Pos().Start),
+				LastLine:  lex.Line(),
+				PrefixExp: exp,
+				Key:       keyExp,
+			}
 
 		case lexer.TokenSepDot: // prefixexp `.` Name
 			lex.NextToken()                                              // `.`
 			line, name := lex.AssertNextTokenKind(lexer.TokenIdentifier) // Name
-			keyExp := &ast.StringExp{Line: line, Str: name}
-			exp = &ast.TableAccessExp{LastLine: line, PrefixExp: exp, Key: keyExp}
+			keyExp := &ast.StringExp{Span: tokenSpan(lex), Line: line, Str: name}
+			exp = &ast.TableAccessExp{
+				Span:      spanFrom(lex, exp.Pos().Start),
+				LastLine:  line,
+				PrefixExp: exp,
+				Key:       keyExp,
+			}
 
 		case lexer.TokenSepColon, lexer.TokenSepLparen,
 			lexer.TokenSepLcurly, lexer.TokenString:
@@ -433,6 +457,7 @@ func parseFuncCallExp(lex *lexer.Lexer, exp ast.Exp) ast.Exp {
 	args := parseArgs(lex)
 	lastLine := lex.Line()
 	return &ast.FuncCallExp{
+		Span:      spanFrom(lex, exp.Pos().Start),
 		FirstLine: line,
 		LastLine:  lastLine,
 		PrefixExp: exp,
@@ -445,7 +470,7 @@ func parseNameExp(lex *lexer.Lexer) *ast.StringExp {
 	if lex.LookAhead() == lexer.TokenSepColon {
 		lex.NextToken() // `:`
 		line, name := lex.AssertNextTokenKind(lexer.TokenIdentifier)
-		return &ast.StringExp{Line: line, Str: name}
+		return &ast.StringExp{Span: tokenSpan(lex), Line: line, Str: name}
 	}
 	return nil
 }
@@ -463,7 +488,17 @@ func parseArgs(lex *lexer.Lexer) (args []ast.Exp) {
 		args = []ast.Exp{parseTableConstructor(lex)}
 	case lexer.TokenString:
 		line, _, str := lex.NextToken()
-		args = []ast.Exp{&ast.StringExp{Line: line, Str: str}}
+		args = []ast.Exp{&ast.StringExp{Span: tokenSpan(lex), Line: line, Str: str}}
 	}
 	return
 }
+
+func newBinOpExp(lex *lexer.Lexer, line, op int, exp1, exp2 ast.Exp) *ast.BinOpExp {
+	return &ast.BinOpExp{
+		Span: spanFrom(lex, exp1.Pos().Start),
+		Line: line,
+		Op:   op,
+		Exp1: exp1,
+		Exp2: exp2,
+	}
+}
@@ -37,46 +37,51 @@ func parseStat(lex *lexer.Lexer) ast.Stat {
 // `;`
 func parseEmptyStat(lex *lexer.Lexer) *ast.EmptyStat {
 	lex.AssertNextTokenKind(lexer.TokenSepSemi) // `;`
-	return &ast.EmptyStat{}
+	return &ast.EmptyStat{Span: tokenSpan(lex)}
 }
 
 // :: Name ::
 func parseLabelStat(lex *lexer.Lexer) *ast.LabelStat {
+	start := lex.LookAheadPos()
 	lex.AssertNextTokenKind(lexer.TokenSepLabel)                 // `::`
 	line, name := lex.AssertNextTokenKind(lexer.TokenIdentifier) // Name
 	lex.AssertNextTokenKind(lexer.TokenSepLabel)                 // `::`
-	return &ast.LabelStat{Line: line, Name: name}
+	return &ast.LabelStat{Span: spanFrom(lex, start), Line: line, Name: name}
 }
 
 // break
 func parseBreakStat(lex *lexer.Lexer) *ast.BreakStat {
 	lex.AssertNextTokenKind(lexer.TokenKwBreak) // `break`
-	return &ast.BreakStat{Line: lex.Line()}
+	return &ast.BreakStat{Span: tokenSpan(lex), Line: lex.Line()}
 }
 
 // goto Name
 func parseGotoStat(lex *lexer.Lexer) *ast.GotoStat {
+	start := lex.LookAheadPos()
 	lex.AssertNextTokenKind(lexer.TokenKwGoto)                   // `goto`
 	line, name := lex.AssertNextTokenKind(lexer.TokenIdentifier) // Name
-	return &ast.GotoStat{Line: line, Name: name}
+	return &ast.GotoStat{Span: spanFrom(lex, start), Line: line, Name: name}
 }
 
 // do block end
 func parseDoStat(lex *lexer.Lexer) *ast.DoStat {
+	start := lex.LookAheadPos()
 	lex.AssertNextTokenKind(lexer.TokenKwDo)  // `do`
 	block := parseBlock(lex)                  // block
 	lex.AssertNextTokenKind(lexer.TokenKwEnd) // `end`
-	return &ast.DoStat{MBlock: block}
+	return &ast.DoStat{Span: spanFrom(lex, start), MBlock: block}
 }
 
 // while exp do block end
 func parseWhileStat(lex *lexer.Lexer) *ast.WhileStat {
+	start := lex.LookAheadPos()
 	lex.AssertNextTokenKind(lexer.TokenKwWhile) // `while`
 	exp := parseExp(lex)                        // exp
 	lex.AssertNextTokenKind(lexer.TokenKwDo)    // `do`
 	block := parseBlock(lex)                    // block
 	lex.AssertNextTokenKind(lexer.TokenKwEnd)   // `end`
 	return &ast.WhileStat{
+		Span:   spanFrom(lex, start),
 		BExp:   exp,
 		MBlock: block,
 	}
@@ -84,11 +89,13 @@ func parseWhileStat(lex *lexer.Lexer) *ast.WhileStat {
 
 // repeat block until exp
 func parseRepeatStat(lex *lexer.Lexer) *ast.RepeatStat {
+	start := lex.LookAheadPos()
 	lex.AssertNextTokenKind(lexer.TokenKwRepeat) // `repeat`
 	block := parseBlock(lex)                     // block
 	lex.AssertNextTokenKind(lexer.TokenKwUntil)  // `until`
 	exp := parseExp(lex)                         // exp
 	return &ast.RepeatStat{
+		Span:   spanFrom(lex, start),
 		BExp:   exp,
 		MBlock: block,
 	}
@@ -98,6 +105,7 @@ func parseRepeatStat(lex *lexer.Lexer) *ast.RepeatStat {
 func parseIfStat(lex *lexer.Lexer) *ast.IfStat {
 	exps := make([]ast.Exp, 0, 4)
 	blocks := make([]*ast.Block, 0, 4)
+	start := lex.LookAheadPos()
 	lex.AssertNextTokenKind(lexer.TokenKwIf)   // `if`
 	exps = append(exps, parseExp(lex))         // exp
 	lex.AssertNextTokenKind(lexer.TokenKwThen) // `then`
@@ -113,13 +121,15 @@ func parseIfStat(lex *lexer.Lexer) *ast.IfStat {
 
 	// [else block]
 	if lex.LookAhead() == lexer.TokenKwElse {
-		lex.NextToken()                                     // `else`
-		exps = append(exps, &ast.TrueExp{Line: lex.Line()}) // -> true exp
-		blocks = append(blocks, parseBlock(lex))            // block
+		lex.NextToken() // `else`
+		trueExp := &ast.TrueExp{Span: tokenSpan(lex), Line: lex.Line()}
+		exps = append(exps, trueExp)             // -> true exp
+		blocks = append(blocks, parseBlock(lex)) // block
 	}
 
 	lex.AssertNextTokenKind(lexer.TokenKwEnd)
 	return &ast.IfStat{
+		Span:   spanFrom(lex, start),
 		BExps:  exps,
 		Blocks: blocks,
 	}
@@ -127,16 +137,19 @@ func parseIfStat(lex *lexer.Lexer) *ast.IfStat {
 
 func parseForStat(lex *lexer.Lexer) ast.Stat {
 	lineFor := lex.Line()
+	start := lex.LookAheadPos()
 	lex.AssertNextTokenKind(lexer.TokenKwFor)                 // `for`
 	_, name := lex.AssertNextTokenKind(lexer.TokenIdentifier) // name1
-	if lex.LookAhead() == lexer.TokenOpAssign {               // for number
-		return parseForNumStat(lex, lineFor, name)
+	nameSpan := tokenSpan(lex)
+	if lex.LookAhead() == lexer.TokenOpAssign { // for number
+		return parseForNumStat(lex, start, lineFor, name, nameSpan)
 	}
-	return parseForInStat(lex, name)
+	return parseForInStat(lex, start, name, nameSpan)
 }
 
 // for Name = exp , exp [ , exp] do block end
-func parseForNumStat(lex *lexer.Lexer, lineFor int, name string) *ast.ForNumStat {
+func parseForNumStat(lex *lexer.Lexer, start lexer.Position, lineFor int,
+	name string, nameSpan ast.Span) *ast.ForNumStat {
 	lex.AssertNextTokenKind(lexer.TokenOpAssign) // `=`
 	initExp := parseExp(lex)                     // init exp
 	lex.AssertNextTokenKind(lexer.TokenSepComma) // `,`
@@ -147,7 +160,8 @@ func parseForNumStat(lex *lexer.Lexer, lineFor int, name string) *ast.ForNumStat
 		lex.NextToken()         // `,`
 		stepExp = parseExp(lex) // step exp
 	} else {
-		stepExp = &ast.IntegerExp{Line: lex.Line(), Val: 1}
+		end := limitExp.Pos().End
+		stepExp = &ast.IntegerExp{Span: ast.Span{Start: end, End: end}, Line: lex.Line(), Val: 1}
 	}
 
 	lineDo, _ := lex.AssertNextTokenKind(lexer.TokenKwDo)
@@ -155,9 +169,11 @@ func parseForNumStat(lex *lexer.Lexer, lineFor int, name string) *ast.ForNumStat
 	lex.AssertNextTokenKind(lexer.TokenKwEnd)
 
 	return &ast.ForNumStat{
+		Span:     spanFrom(lex, start),
 		LineFor:  lineFor,
 		LineDo:   lineDo,
 		VarName:  name,
+		VarSpan:  nameSpan,
 		InitExp:  initExp,
 		LimitExp: limitExp,
 		StepExp:  stepExp,
@@ -166,8 +182,8 @@ func parseForNumStat(lex *lexer.Lexer, lineFor int, name string) *ast.ForNumStat
 }
 
 // for namelist in explist do block end
-func parseForInStat(lex *lexer.Lexer, name1 string) *ast.ForInStat {
-	nameList := parseRestNameList(lex, name1)
+func parseForInStat(lex *lexer.Lexer, start lexer.Position, name1 string, span1 ast.Span) *ast.ForInStat {
+	nameList, nameSpans := parseRestNameList(lex, name1, span1)
 
 	lex.AssertNextTokenKind(lexer.TokenKwIn)              // `in`
 	expList := parseExpList(lex)                          // explist
@@ -176,10 +192,12 @@ func parseForInStat(lex *lexer.Lexer, name1 string) *ast.ForInStat {
 	lex.AssertNextTokenKind(lexer.TokenKwEnd)             // `end`
 
 	return &ast.ForInStat{
-		LineDo:   lineDo,
-		NameList: nameList,
-		ExpList:  expList,
-		MBlock:   block,
+		Span:      spanFrom(lex, start),
+		LineDo:    lineDo,
+		NameList:  nameList,
+		NameSpans: nameSpans,
+		ExpList:   expList,
+		MBlock:    block,
 	}
 }
 
@@ -189,16 +207,19 @@ func parseForInStat(lex *lexer.Lexer, name1 string) *ast.ForInStat {
 // `function t.a.b.c.f () body end` => `t.a.b.c.f = function () body end`
 // `function t.a.b.c:f (params) body end` => `t.a.b.c.f = function (self, params) body end`
 func parseFuncDefStat(lex *lexer.Lexer) *ast.AssignStat {
+	start := lex.LookAheadPos()
 	lex.AssertNextTokenKind(lexer.TokenKwFunction) // `function`
 	funcExp, hasColon := parseFuncName(lex)        // funcname
-	funcDef := parseFuncDefExp(lex)                // funcbody
+	funcDef := parseFuncDefExp(lex, start)         // funcbody
 
 	if hasColon { // v:fn(args) => v.fn(self, args)
 		selfParam := []string{"self"}
 		funcDef.ParList = append(selfParam, funcDef.ParList...)
+		funcDef.ParSpans = append([]ast.Span{{}}, funcDef.ParSpans...)
 	}
 
 	return &ast.AssignStat{
+		Span:     funcDef.Span,
 		LastLine: funcDef.FirstLine, // ? LastLine
 		VarList:  []ast.Exp{funcExp},
 		ExpList:  []ast.Exp{funcDef},
@@ -208,21 +229,31 @@ func parseFuncDefStat(lex *lexer.Lexer) *ast.AssignStat {
 // funcname ::= Name {`.` Name} [`:` Name]
 func parseFuncName(lex *lexer.Lexer) (exp ast.Exp, hasColon bool) {
 	line, name := lex.AssertNextTokenKind(lexer.TokenIdentifier) // name
-	exp = &ast.NameExp{Line: line, Name: name}
+	exp = &ast.NameExp{Span: tokenSpan(lex), Line: line, Name: name}
 	hasColon = false
 
 	for lex.LookAhead() == lexer.TokenSepDot { // { `.` Name }
 		lex.NextToken()                                             // `.`
 		line, name = lex.AssertNextTokenKind(lexer.TokenIdentifier) // name
-		key := &ast.StringExp{Line: line, Str: name}
-		exp = &ast.TableAccessExp{LastLine: line, PrefixExp: exp, Key: key}
+		key := &ast.StringExp{Span: tokenSpan(lex), Line: line, Str: name}
+		exp = &ast.TableAccessExp{
+			Span:      spanFrom(lex, exp.Pos().Start),
+			LastLine:  line,
+			PrefixExp: exp,
+			Key:       key,
+		}
 	}
 
 	if lex.LookAhead() == lexer.TokenSepColon { // [ `:` Name ]
 		lex.NextToken()                                             // `:`
 		line, name = lex.AssertNextTokenKind(lexer.TokenIdentifier) // name
-		key := &ast.StringExp{Line: line, Str: name}
-		exp = &ast.TableAccessExp{LastLine: line, PrefixExp: exp, Key: key}
+		key := &ast.StringExp{Span: tokenSpan(lex), Line: line, Str: name}
+		exp = &ast.TableAccessExp{
+			Span:      spanFrom(lex, exp.Pos().Start),
+			LastLine:  line,
+			PrefixExp: exp,
+			Key:       key,
+		}
 		hasColon = true
 	}
 
@@ -230,29 +261,34 @@ func parseFuncName(lex *lexer.Lexer) (exp ast.Exp, hasColon bool) {
 }
 
 func parseLocalAssignOrFuncDefStat(lex *lexer.Lexer) ast.Stat {
+	start := lex.LookAheadPos()
 	lex.AssertNextTokenKind(lexer.TokenKwLocal) // `local`
 	if lex.LookAhead() == lexer.TokenKwFunction {
-		return parseLocalFuncDefStat(lex)
+		return parseLocalFuncDefStat(lex, start)
 	}
-	return parseLocalAssignStat(lex)
+	return parseLocalAssignStat(lex, start)
 }
 
 // `local` | `function` Name funcbody
 // `local function f() end`  =>  `local f; f = function() end`
-func parseLocalFuncDefStat(lex *lexer.Lexer) *ast.LocalFuncDefStat {
+func parseLocalFuncDefStat(lex *lexer.Lexer, start lexer.Position) *ast.LocalFuncDefStat {
+	funcStart := lex.LookAheadPos()
 	lex.AssertNextTokenKind(lexer.TokenKwFunction)            // `function`
 	_, name := lex.AssertNextTokenKind(lexer.TokenIdentifier) // Name
-	funcDefExp := parseFuncDefExp(lex)                        // funcbody
+	nameSpan := tokenSpan(lex)
+	funcDefExp := parseFuncDefExp(lex, funcStart) // funcbody
 	return &ast.LocalFuncDefStat{
-		Name: name,
-		Func: funcDefExp,
+		Span:     spanFrom(lex, start),
+		Name:     name,
+		NameSpan: nameSpan,
+		Func:     funcDefExp,
 	}
 }
 
 // `local` | namelist [ `=` explist]
-func parseLocalAssignStat(lex *lexer.Lexer) *ast.LocalVarDeclStat {
-	_, name1 := lex.AssertNextTokenKind(lexer.TokenIdentifier) // Name
-	nameList := parseRestNameList(lex, name1)                  // `{ , Name }`
+func parseLocalAssignStat(lex *lexer.Lexer, start lexer.Position) *ast.LocalVarDeclStat {
+	_, name1 := lex.AssertNextTokenKind(lexer.TokenIdentifier)           // Name
+	nameList, nameSpans := parseRestNameList(lex, name1, tokenSpan(lex)) // `{ , Name }`
 
 	var expList []ast.Exp
 	if lex.LookAhead() == lexer.TokenOpAssign { // [ `=` explist]
@@ -262,9 +298,11 @@ func parseLocalAssignStat(lex *lexer.Lexer) *ast.LocalVarDeclStat {
 
 	lastLine := lex.Line()
 	return &ast.LocalVarDeclStat{
-		LastLine: lastLine,
-		NameList: nameList,
-		ExpList:  expList,
+		Span:      spanFrom(lex, start),
+		LastLine:  lastLine,
+		NameList:  nameList,
+		NameSpans: nameSpans,
+		ExpList:   expList,
 	}
 }
 
@@ -285,6 +323,7 @@ func parseAssignStat(lex *lexer.Lexer, prefixExp ast.Exp) *ast.AssignStat {
 
 	lastLine := lex.Line()
 	return &ast.AssignStat{
+		Span:     spanFrom(lex, prefixExp.Pos().Start),
 		LastLine: lastLine,
 		VarList:  varList,
 		ExpList:  expList,
@@ -314,16 +353,18 @@ func checkVar(lex *lexer.Lexer, exp ast.Exp) ast.Exp {
 	panic("unreachable")
 }
 
-// parse name list apart from the name1
+// parse name list apart from the name1, with the spans of names
 // namelist ::= Name {`,` Name}
-func parseRestNameList(lex *lexer.Lexer, name1 string) []string {
+func parseRestNameList(lex *lexer.Lexer, name1 string, span1 ast.Span) ([]string, []ast.Span) {
 	nameList := make([]string, 0, 4)
 	nameList = append(nameList, name1)
+	spans := []ast.Span{span1}
 	for lex.LookAhead() == lexer.TokenSepComma { // { , Name}
 		lex.NextToken()                                           // `,`
 		_, name := lex.AssertNextTokenKind(lexer.TokenIdentifier) // `name`
 		nameList = append(nameList, name)
+		spans = append(spans, tokenSpan(lex))
 	}
 
-	return nameList
+	return nameList, spans
 }
@@ -1,8 +1,10 @@
 package parser
 
 import (
+	"fmt"
+	"luago/compiler/ast"
+	"luago/compiler/lexer"
 	"testing"
-	"vczn/luago/compiler/lexer"
 )
 
 func TestExpLiteral(t *testing.T) {
@@ -21,7 +23,8 @@ func TestUnopExp(t *testing.T) {
 	testExp2(t, `-a`, `-(a)`)
 	testExp2(t, `-0xFF`, `-255`)
 	testExp2(t, `~a`, `~(a)`)
-	testExp2(t, `#'foo'`, `#('foo')`)
+	testExp2(t, `#'foo'`, `3`)
+	testExp2(t, `#a`, `#(a)`)
 	testExp2(t, `not a`, `not(a)`)
 	testExp2(t, `~0xFF`, `-256`)
 	testExp2(t, `not true`, `false`)
@@ -74,14 +77,20 @@ func TestBinopExp(t *testing.T) {
 	testExp2(t, `1 << 2`, `4`)
 	testExp2(t, `a << b`, `(a << b)`)
 	testExp2(t, `a < b`, `(a < b)`)
-	testExp2(t, `2 > 1`, `(2 > 1)`)
+	testExp2(t, `2 > 1`, `true`)
+	testExp2(t, `not (1 >= 2.5)`, `true`)
+	testExp2(t, `'a' < 'b' and 1 == 1.0`, `true`)
+	testExp2(t, `nil ~= false`, `true`)
+	testExp2(t, `1 < 'x'`, `(1 < 'x')`)
 	testExp2(t, `a == b`, `(a == b)`)
 	testExp2(t, `x ~= y`, `(x ~= y)`)
 	testExp2(t, `a <= b`, `(a <= b)`)
 	testExp2(t, `a >= b`, `(a >= b)`)
 	testExp2(t, `a+i < b/2+1`, `((a + i) < ((b / 2) + 1))`)
 	testExp2(t, `a<y and y<=z`, `((a < y) and (y <= z))`)
-	testExp2(t, `'hello' .. 42`, `'hello' .. 42`)
+	testExp2(t, `'hello' .. 42`, `'hello42'`)
+	testExp2(t, `a .. 'b' .. 1 .. 'c'`, `a .. 'b1c'`)
+	testExp2(t, `'a' .. b .. 'c' .. 1.5`, `'a' .. b .. 'c' .. 1.500000`)
 }
 
 func TestTcExp(t *testing.T) {
@@ -161,8 +170,8 @@ func TestFuncCallStat(t *testing.T) {
 	testStat2(t, `print("hello!")`, `print('hello!')`)
 	testStat2(t, `fact(n-1)`, `fact((n - 1))`)
 	testStat(t, `t:f()`)
-	testStat2(t, `assert((4 and 5) == 5)`, `assert((5 == 5))`) // 4 is false => 5
-	testStat2(t, `assert((4 & 5) == 4)`, `assert((4 == 4))`)
+	testStat2(t, `assert((4 and 5) == 5)`, `assert(true)`) // 4 is false => 5
+	testStat2(t, `assert((4 & 5) == 4)`, `assert(true)`)
 }
 
 func TestStatAssignStat(t *testing.T) {
@@ -244,10 +253,166 @@ func testError(t *testing.T, src, want string) {
 func getError(src string) (err string) {
 	defer func() {
 		if r := recover(); r != nil {
-			err = r.(string)
+			err = r.(lexer.Error).Error()
 		}
 	}()
 
 	parseBlock(lexer.NewLexer(src, "string"))
 	return
 }
+
+func TestParseDiagnostics(t *testing.T) {
+	src := `local a = 1
+x = = 2
+if a then
+  y(
+end
+local s = "abc
+while a = 1 do z() end
+print(a) ? b()
+return 1`
+	block, diags := ParseDiagnostics(src, "src")
+	want := []struct {
+		kind       lexer.ErrorKind
+		msg        string
+		start, end lexer.Position
+	}{
+		{lexer.ErrUnexpectedToken, "src:2: syntax error near '='", pos(2, 5, 16), pos(2, 6, 17)},
+		{lexer.ErrUnexpectedToken, "src:5: syntax error near 'end'", pos(5, 1, 35), pos(5, 4, 38)},
+		{lexer.ErrUnfinishedString, "src:6: unfinished short string", pos(6, 11, 49), pos(6, 15, 53)},
+		{lexer.ErrUnexpectedToken, "src:7: syntax error near '='", pos(7, 9, 62), pos(7, 10, 63)},
+		{lexer.ErrUnexpectedSymbol, "src:8: unexpected symbol near '?'", pos(8, 10, 86), pos(8, 11, 87)},
+	}
+	if len(diags) != len(want) {
+		t.Fatalf("want %d diagnostics, got %v", len(want), diags)
+	}
+	for i, w := range want {
+		d := diags[i]
+		if d.Kind != w.kind || d.Error() != w.msg || d.Start != w.start || d.End != w.end {
+			t.Errorf("diagnostic #%d: want=%v %q %v-%v, got=%v %q %v-%v", i, w.kind, w.msg,
+				w.start, w.end, d.Kind, d.Error(), d.Start, d.End)
+		}
+	}
+	// the body of while is left as a do stat
+	if got := blockToString(block); got != "local a = 1 if a then  end do z() end return 1" {
+		t.Errorf("unexpected chunk: %s", got)
+	}
+
+	_, diags = ParseDiagnostics("do end end x = 1 return", "src")
+	if len(diags) != 1 || diags[0].Error() != "src:1: syntax error near 'end'" {
+		t.Errorf("unexpected diagnostics: %v", diags)
+	}
+	if _, diags = ParseDiagnostics("local a = {1, 2}\nreturn a", "src"); len(diags) != 0 {
+		t.Errorf("unexpected diagnostics: %v", diags)
+	}
+}
+
+func pos(line, column, offset int) lexer.Position {
+	return lexer.Position{Line: line, Column: column, Offset: offset}
+}
+
+func TestSpans(t *testing.T) {
+	src := `local function f(a, ...) return a end
+local x, y = -1, {1, [2] = "b"}
+x.y[1] = f(x) .. "s" .. t:m "a"
+for i = 1, 10 do
+  if i > 2 then break end
+end
+;function t.m:n() end`
+	block := parseBlock(lexer.NewLexer(src, "string"))
+	text := func(node ast.Node) string {
+		span := node.Pos()
+		return src[span.Start.Offset:span.End.Offset]
+	}
+	stats := block.Stats
+	assign := stats[2].(*ast.AssignStat)
+	concat := assign.ExpList[0].(*ast.ConcatExp)
+	forStat := stats[3].(*ast.ForNumStat)
+	ifStat := forStat.MBlock.Stats[0].(*ast.IfStat)
+	local := stats[1].(*ast.LocalVarDeclStat)
+	tests := []struct {
+		node ast.Node
+		want string
+	}{
+		{stats[0], "local function f(a, ...) return a end"},
+		{stats[0].(*ast.LocalFuncDefStat).Func, "function f(a, ...) return a end"},
+		{local, `local x, y = -1, {1, [2] = "b"}`},
+		{local.ExpList[0], "-1"},
+		{local.ExpList[1].(*ast.TableConstructionExp).KeyExps[1], "2"},
+		{assign, `x.y[1] = f(x) .. "s" .. t:m "a"`},
+		{assign.VarList[0], "x.y[1]"},
+		{assign.VarList[0].(*ast.TableAccessExp).PrefixExp, "x.y"},
+		{concat.Exps[2], `t:m "a"`},
+		{concat.Exps[2].(*ast.FuncCallExp).FNameExp, "m"},
+		{forStat, "for i = 1, 10 do\n  if i > 2 then break end\nend"},
+		{ifStat, "if i > 2 then break end"},
+		{ifStat.BExps[0], "i > 2"},
+		{ifStat.Blocks[0].Stats[0], "break"},
+		{stats[4], "function t.m:n() end"},
+		{stats[4].(*ast.AssignStat).VarList[0], "t.m:n"},
+	}
+	for _, tt := range tests {
+		if got := text(tt.node); got != tt.want {
+			t.Errorf("want=%q, got=%q", tt.want, got)
+		}
+	}
+	if got, want := forStat.StepExp.Pos(), forStat.LimitExp.Pos().End; got.Start != want || got.End != want {
+		t.Errorf("span of implicit step: %v", got)
+	}
+	if got, want := ifStat.Pos().Start, pos(5, 3, 121); got != want {
+		t.Errorf("want=%v, got=%v", want, got)
+	}
+}
+
+func TestParseChunk(t *testing.T) {
+	src := `-- f does nothing
+--[[ lint: ignore ]]
+local function f()
+  -- body
+  local x = 1 -- one
+  return x
+end -- f
+
+local t = {
+  -- in table
+  a = 1,
+} -- t
+-- dangling`
+	chunk, diags := ParseChunk(src, "string")
+	if len(diags) != 0 {
+		t.Fatalf("unexpected diagnostics: %v", diags)
+	}
+	texts := func(trivia []lexer.Trivia) (texts []string) {
+		for _, c := range trivia {
+			texts = append(texts, c.Text)
+		}
+		return
+	}
+	stats := chunk.Block.Stats
+	fn := stats[0].(*ast.LocalFuncDefStat)
+	tests := []struct {
+		stat              ast.Stat
+		leading, trailing string
+	}{
+		{stats[0], `[" f does nothing" " lint: ignore "]`, `[" f"]`},
+		{stats[1], `[]`, `[" t"]`},
+		{fn.Func.MBlock.Stats[0], `[" body"]`, `[" one"]`},
+	}
+	for i, tt := range tests {
+		c := chunk.Comments[tt.stat]
+		if c == nil {
+			c = &ast.Comments{}
+		}
+		leading := fmt.Sprintf("%q", texts(c.Leading))
+		trailing := fmt.Sprintf("%q", texts(c.Trailing))
+		if leading != tt.leading || trailing != tt.trailing {
+			t.Errorf("stat %d: want=%s %s, got=%s %s", i, tt.leading, tt.trailing, leading, trailing)
+		}
+	}
+	if n := len(chunk.Comments); n != 3 {
+		t.Errorf("want comments of 3 stats, got %d", n)
+	}
+	if n := len(chunk.Trivia); n != 9 {
+		t.Errorf("want 9 trivia, got %d", n)
+	}
+}
@@ -0,0 +1,51 @@
+package parser
+
+import "luago/compiler/lexer"
+
+// returns the kind of next token like LookAhead, the lexical errors
+// before it are recorded by the lexer, which skips the text of them
+func lookAhead(lex *lexer.Lexer) int {
+	for {
+		if kind, ok := tryLookAhead(lex); ok {
+			return kind
+		}
+	}
+}
+
+func tryLookAhead(lex *lexer.Lexer) (kind int, ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			lex.Recover(r)
+		}
+	}()
+	return lex.LookAhead(), true
+}
+
+// skips the tokens after a syntax error to the beginning of next stat,
+// which is a keyword beginning a stat or a name beginning a line, or to
+// the end of block; the blocks opened since the lexer was at depth, the
+// nesting of blocks when the stat with error began, are skipped as a whole
+func skipToStat(lex *lexer.Lexer, depth int) {
+	for {
+		kind := lookAhead(lex)
+		if kind == lexer.TokenEOF {
+			return
+		}
+		if lex.Depth() <= depth {
+			switch kind {
+			case lexer.TokenKwDo, lexer.TokenKwRepeat, lexer.TokenKwIf,
+				lexer.TokenKwFunction, lexer.TokenKwWhile, lexer.TokenKwFor,
+				lexer.TokenKwLocal, lexer.TokenKwReturn, lexer.TokenKwGoto,
+				lexer.TokenKwBreak, lexer.TokenSepLabel, lexer.TokenSepSemi,
+				lexer.TokenKwEnd, lexer.TokenKwUntil, lexer.TokenKwElse,
+				lexer.TokenKwElseif:
+				return
+			case lexer.TokenIdentifier:
+				if lex.LookAheadLine() > lex.Line() {
+					return
+				}
+			}
+		}
+		lex.NextToken()
+	}
+}
@@ -0,0 +1,277 @@
+package peephole
+
+import (
+	"luago/binchunk"
+	"luago/vm"
+)
+
+// the number of registers of a function
+const maxRegs = 256
+
+// regSet is a set of registers
+type regSet [maxRegs / 64]uint64
+
+func (s *regSet) add(r int) {
+	if r >= 0 && r < maxRegs {
+		s[r/64] |= 1 << uint(r%64)
+	}
+}
+
+// adds the registers from..to
+func (s *regSet) addRange(from, to int) {
+	for r := from; r <= to && r < maxRegs; r++ {
+		s.add(r)
+	}
+}
+
+// adds the register or constant index of operand RK
+func (s *regSet) addRK(rk int) {
+	if rk <= 0xFF {
+		s.add(rk)
+	}
+}
+
+func (s *regSet) has(r int) bool {
+	return r >= 0 && r < maxRegs && s[r/64]&(1<<uint(r%64)) != 0
+}
+
+func (s *regSet) union(t *regSet) {
+	for i := range s {
+		s[i] |= t[i]
+	}
+}
+
+func (s *regSet) subtract(t *regSet) {
+	for i := range s {
+		s[i] &^= t[i]
+	}
+}
+
+// returns the target of jump instruction i at pc, -1 if i doesn't jump
+func jumpTarget(i vm.Instruction, pc int) int {
+	switch i.Opcode() {
+	case vm.OpJMP, vm.OpFORLOOP, vm.OpFORPREP, vm.OpTFORLOOP:
+		_, sBx := i.AsBx()
+		return pc + 1 + sBx
+	}
+	return -1
+}
+
+// returns i with the jump offset sBx
+func setSBx(i vm.Instruction, sBx int) vm.Instruction {
+	return i&0x3FFF | vm.Instruction(sBx+vm.MaxArgSBx)<<14
+}
+
+// returns i with the operand A
+func setA(i vm.Instruction, a int) vm.Instruction {
+	return i&^(0xFF<<6) | vm.Instruction(a)<<6
+}
+
+func isTest(i vm.Instruction) bool {
+	switch i.Opcode() {
+	case vm.OpEQ, vm.OpLT, vm.OpLE, vm.OpTEST, vm.OpTESTSET:
+		return true
+	}
+	return false
+}
+
+// returns the pcs which may be executed after pc
+func successors(code []vm.Instruction, pc int) []int {
+	i := code[pc]
+	next := []int{pc + 1}
+	switch i.Opcode() {
+	case vm.OpRETURN:
+		next = nil
+	case vm.OpJMP, vm.OpFORPREP:
+		next = []int{jumpTarget(i, pc)}
+	case vm.OpFORLOOP, vm.OpTFORLOOP:
+		next = append(next, jumpTarget(i, pc))
+	case vm.OpEQ, vm.OpLT, vm.OpLE, vm.OpTEST, vm.OpTESTSET:
+		next = append(next, pc+2)
+	case vm.OpLOADBOOL:
+		if _, _, c := i.ABC(); c != 0 {
+			next = []int{pc + 2}
+		}
+	}
+	result := next[:0]
+	for _, s := range next {
+		if s >= 0 && s < len(code) {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// flow is the control flow of code
+type flow struct {
+	succs  [][]int
+	labels []bool // whether the instruction is reached other than falling through
+	live   []bool // whether the instruction is reachable
+}
+
+func newFlow(code []vm.Instruction) *flow {
+	f := &flow{
+		succs:  make([][]int, len(code)),
+		labels: make([]bool, len(code)),
+		live:   make([]bool, len(code)),
+	}
+	for pc := range code {
+		f.succs[pc] = successors(code, pc)
+		for _, s := range f.succs[pc] {
+			if s != pc+1 {
+				f.labels[s] = true
+			}
+		}
+	}
+	if len(code) > 0 {
+		work := []int{0}
+		f.live[0] = true
+		for len(work) > 0 {
+			pc := work[len(work)-1]
+			work = work[:len(work)-1]
+			for _, s := range f.succs[pc] {
+				if !f.live[s] {
+					f.live[s] = true
+					work = append(work, s)
+				}
+			}
+		}
+	}
+	return f
+}
+
+// returns the registers read and the ones always written by i, the
+// operands up to the top of stack are taken as all the registers above
+func useDef(i vm.Instruction) (use, def regSet) {
+	a, b, c := i.ABC()
+	switch i.Opcode() {
+	case vm.OpMOVE, vm.OpUNM, vm.OpBNOT, vm.OpNOT, vm.OpLEN:
+		use.add(b)
+		def.add(a)
+	case vm.OpLOADK, vm.OpLOADKX, vm.OpLOADBOOL, vm.OpGETUPVAL, vm.OpNEWTABLE,
+		vm.OpCLOSURE:
+		def.add(a)
+	case vm.OpLOADNIL:
+		def.addRange(a, a+b)
+	case vm.OpGETTABUP:
+		use.addRK(c)
+		def.add(a)
+	case vm.OpGETTABLE:
+		use.add(b)
+		use.addRK(c)
+		def.add(a)
+	case vm.OpSETTABUP, vm.OpEQ, vm.OpLT, vm.OpLE:
+		use.addRK(b)
+		use.addRK(c)
+	case vm.OpSETUPVAL, vm.OpTEST:
+		use.add(a)
+	case vm.OpSETTABLE:
+		use.add(a)
+		use.addRK(b)
+		use.addRK(c)
+	case vm.OpSELF:
+		use.add(b)
+		use.addRK(c)
+		def.addRange(a, a+1)
+	case vm.OpADD, vm.OpSUB, vm.OpMUL, vm.OpMOD, vm.OpPOW, vm.OpDIV, vm.OpIDIV,
+		vm.OpBAND, vm.OpBOR, vm.OpBXOR, vm.OpSHL, vm.OpSHR:
+		use.addRK(b)
+		use.addRK(c)
+		def.add(a)
+	case vm.OpCONCAT:
+		use.addRange(b, c)
+		def.add(a)
+	case vm.OpTESTSET: // R(A) is set only if the test passes
+		use.add(b)
+	case vm.OpCALL:
+		use.addRange(a, top(a, b, 1))
+		if c > 0 {
+			def.addRange(a, a+c-2)
+		}
+	case vm.OpTAILCALL:
+		use.addRange(a, top(a, b, 1))
+	case vm.OpRETURN:
+		use.addRange(a, top(a, b, 2))
+	case vm.OpFORLOOP, vm.OpFORPREP:
+		use.addRange(a, a+2)
+		def.add(a)
+	case vm.OpTFORCALL:
+		use.addRange(a, a+2)
+		def.addRange(a+3, a+2+c)
+	case vm.OpTFORLOOP:
+		use.add(a + 1)
+	case vm.OpSETLIST:
+		use.addRange(a, top(a, b, 0))
+	case vm.OpVARARG:
+		if b > 0 {
+			def.addRange(a, a+b-2)
+		}
+	}
+	return
+}
+
+// returns the last register a+b-k of the operands counted by b, or the
+// last register of function if b is 0, which means up to the top
+func top(a, b, k int) int {
+	if b == 0 {
+		return maxRegs - 1
+	}
+	return a + b - k
+}
+
+// returns the registers of proto captured by the closures created in
+// code, they are read whenever the closures are called
+func capturedRegs(proto *binchunk.ProtoType, code []vm.Instruction) (regs regSet) {
+	for _, i := range code {
+		if i.Opcode() == vm.OpCLOSURE {
+			_, bx := i.ABx()
+			for _, uv := range proto.Protos[bx].Upvalues {
+				if uv.Instack == 1 {
+					regs.add(int(uv.Idx))
+				}
+			}
+		}
+	}
+	return
+}
+
+// returns the registers live after each instruction, the captured
+// registers are always live
+func liveOut(code []vm.Instruction, f *flow, captured regSet) []regSet {
+	uses := make([]regSet, len(code))
+	defs := make([]regSet, len(code))
+	for pc, i := range code {
+		uses[pc], defs[pc] = useDef(i)
+	}
+	out := make([]regSet, len(code))
+	in := make([]regSet, len(code))
+	for changed := true; changed; {
+		changed = false
+		for pc := len(code) - 1; pc >= 0; pc-- {
+			s := captured
+			for _, succ := range f.succs[pc] {
+				s.union(&in[succ])
+			}
+			out[pc] = s
+			s.subtract(&defs[pc])
+			s.union(&uses[pc])
+			if s != in[pc] {
+				in[pc] = s
+				changed = true
+			}
+		}
+	}
+	return out
+}
+
+// returns the number of local variables active at each pc, they are in
+// the registers below it
+func activeLocals(proto *binchunk.ProtoType, n int) []int {
+	active := make([]int, n+1)
+	for _, v := range proto.LocVars {
+		for pc := int(v.StartPC); pc < int(v.EndPC) && pc <= n; pc++ {
+			active[pc]++
+		}
+	}
+	return active
+}
@@ -0,0 +1,295 @@
+// Package peephole optimizes the instructions of function prototypes, it
+// works on the code generated by codegen and keeps the debug info of
+// lines and local variables consistent
+package peephole
+
+import (
+	"luago/binchunk"
+	"luago/vm"
+)
+
+// Optimize optimizes the code of proto and its nested prototypes in place.
+// It threads the jumps to jumps, drops the unreachable instructions and
+// the jumps to next instruction, merges the consecutive LOADNILs, removes
+// the MOVEs of temporary registers into the instructions setting them,
+// and converts the CALLs returning all results into TAILCALLs. The line
+// info and the pcs of local variables are mapped to the code optimized
+func Optimize(proto *binchunk.ProtoType) {
+	o := newOptimizer(proto)
+	passes := []func() bool{
+		o.threadJumps,
+		o.removeUnreachable,
+		o.mergeLoadNils,
+		o.removeMoves,
+		o.removeNopJumps,
+		o.tailCalls,
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, pass := range passes {
+			if pass() {
+				o.compact()
+				changed = true
+			}
+		}
+	}
+	o.finish()
+
+	for _, p := range proto.Protos {
+		Optimize(p)
+	}
+}
+
+// optimizer runs the passes one by one, a pass changing the code marks
+// the instructions to be removed as dead, then they are removed and the
+// flow is rebuilt by compact before the next pass
+type optimizer struct {
+	proto *binchunk.ProtoType
+	code  []vm.Instruction
+	dead  []bool
+	flow  *flow
+}
+
+func newOptimizer(proto *binchunk.ProtoType) *optimizer {
+	o := &optimizer{proto: proto, code: make([]vm.Instruction, len(proto.Code))}
+	for pc, c := range proto.Code {
+		o.code[pc] = vm.Instruction(c)
+	}
+	if len(proto.LineInfo) != len(proto.Code) {
+		proto.LineInfo = nil
+	}
+	o.dead = make([]bool, len(o.code))
+	o.flow = newFlow(o.code)
+	return o
+}
+
+// redirects the jumps to unconditional jumps to their final targets, the
+// jumps closing upvalues are threaded only if the jumps skipped close the
+// same upvalues or none
+func (o *optimizer) threadJumps() bool {
+	changed := false
+	for pc, i := range o.code {
+		if i.Opcode() != vm.OpJMP {
+			continue
+		}
+		a, _ := i.AsBx()
+		target := jumpTarget(i, pc)
+		for n := 0; n < len(o.code); n++ { // bounded by the loops of jumps
+			next := o.code[target]
+			if next.Opcode() != vm.OpJMP || target == pc {
+				break
+			}
+			nextA, _ := next.AsBx()
+			if nextA != 0 && a != 0 && nextA != a {
+				break
+			}
+			if a == 0 {
+				a = nextA
+			}
+			target = jumpTarget(next, target)
+		}
+		if j := setA(setSBx(i, target-pc-1), a); j != i {
+			o.code[pc] = j
+			changed = true
+		}
+	}
+	return changed
+}
+
+// drops the instructions never reached from the entry
+func (o *optimizer) removeUnreachable() bool {
+	changed := false
+	for pc := range o.code {
+		if !o.flow.live[pc] {
+			o.dead[pc] = true
+			changed = true
+		}
+	}
+	return changed
+}
+
+// merges a LOADNIL into the previous one if their registers are adjacent
+// or overlapped, and nothing jumps to it
+func (o *optimizer) mergeLoadNils() bool {
+	changed := false
+	for pc := 1; pc < len(o.code); pc++ {
+		prev, i := o.code[pc-1], o.code[pc]
+		if prev.Opcode() != vm.OpLOADNIL || i.Opcode() != vm.OpLOADNIL ||
+			o.flow.labels[pc] {
+			continue
+		}
+		a1, b1, _ := prev.ABC()
+		a2, b2, _ := i.ABC()
+		if a2 > a1+b1+1 || a1 > a2+b2+1 {
+			continue
+		}
+		from, to := min(a1, a2), max(a1+b1, a2+b2)
+		o.code[pc] = loadNil(from, to-from)
+		o.dead[pc-1] = true
+		changed = true
+	}
+	return changed
+}
+
+// removes `MOVE A A`, and `MOVE A B` following the instruction setting the
+// temporary register B, the instruction sets A instead if B is not read
+// later and nothing jumps to the MOVE
+func (o *optimizer) removeMoves() bool {
+	var live []regSet
+	var active []int
+	changed := false
+	for pc, i := range o.code {
+		if i.Opcode() != vm.OpMOVE {
+			continue
+		}
+		a, b, _ := i.ABC()
+		if a == b {
+			o.dead[pc] = true
+			changed = true
+			continue
+		}
+		if pc == 0 || o.dead[pc-1] || o.flow.labels[pc] || !setsOnlyA(o.code[pc-1], b) {
+			continue
+		}
+		if live == nil {
+			live = liveOut(o.code, o.flow, capturedRegs(o.proto, o.code))
+			active = activeLocals(o.proto, len(o.code))
+		}
+		if live[pc].has(b) || b < active[pc-1] || b < active[pc] || b < active[pc+1] {
+			continue
+		}
+		o.code[pc-1] = setA(o.code[pc-1], a)
+		o.dead[pc] = true
+		changed = true
+	}
+	return changed
+}
+
+// reports whether i sets only the register r and nothing else
+func setsOnlyA(i vm.Instruction, r int) bool {
+	a, b, c := i.ABC()
+	if a != r {
+		return false
+	}
+	switch i.Opcode() {
+	case vm.OpMOVE, vm.OpLOADK, vm.OpGETUPVAL, vm.OpGETTABUP, vm.OpGETTABLE,
+		vm.OpNEWTABLE, vm.OpADD, vm.OpSUB, vm.OpMUL, vm.OpMOD, vm.OpPOW, vm.OpDIV,
+		vm.OpIDIV, vm.OpBAND, vm.OpBOR, vm.OpBXOR, vm.OpSHL, vm.OpSHR, vm.OpUNM,
+		vm.OpBNOT, vm.OpNOT, vm.OpLEN, vm.OpCONCAT, vm.OpCLOSURE:
+		return true
+	case vm.OpLOADBOOL:
+		return c == 0
+	case vm.OpLOADNIL:
+		return b == 0
+	}
+	return false
+}
+
+// removes the jumps to next instruction which close no upvalues, the ones
+// following the tests are kept
+func (o *optimizer) removeNopJumps() bool {
+	changed := false
+	for pc, i := range o.code {
+		a, sBx := i.AsBx()
+		if i.Opcode() != vm.OpJMP || a != 0 || sBx != 0 {
+			continue
+		}
+		if pc > 0 && (isTest(o.code[pc-1]) || skipsNext(o.code[pc-1])) {
+			continue
+		}
+		o.dead[pc] = true
+		changed = true
+	}
+	return changed
+}
+
+func skipsNext(i vm.Instruction) bool {
+	_, _, c := i.ABC()
+	return i.Opcode() == vm.OpLOADBOOL && c != 0
+}
+
+// converts `CALL A B 0` followed by `RETURN A 0` into `TAILCALL A B 0`,
+// the RETURN is kept for the Go functions, which return to it like CALL
+func (o *optimizer) tailCalls() bool {
+	changed := false
+	for pc := 0; pc+1 < len(o.code); pc++ {
+		i, next := o.code[pc], o.code[pc+1]
+		if i.Opcode() != vm.OpCALL || next.Opcode() != vm.OpRETURN {
+			continue
+		}
+		a, b, c := i.ABC()
+		ra, rb, _ := next.ABC()
+		if c != 0 || ra != a || rb != 0 {
+			continue
+		}
+		o.code[pc] = abc(vm.OpTAILCALL, a, b, 0)
+		changed = true
+	}
+	return changed
+}
+
+// removes the dead instructions, fixes the jumps and the debug info
+func (o *optimizer) compact() {
+	newPCs := make([]int, len(o.code)+1) // pc of the instruction or the next one kept
+	n := 0
+	for pc := range o.code {
+		newPCs[pc] = n
+		if !o.dead[pc] {
+			n++
+		}
+	}
+	newPCs[len(o.code)] = n
+
+	code := make([]vm.Instruction, 0, n)
+	var lines []uint32
+	for pc, i := range o.code {
+		if o.dead[pc] {
+			continue
+		}
+		if target := jumpTarget(i, pc); target >= 0 {
+			i = setSBx(i, newPCs[target]-newPCs[pc]-1)
+		}
+		code = append(code, i)
+		if o.proto.LineInfo != nil {
+			lines = append(lines, o.proto.LineInfo[pc])
+		}
+	}
+	o.proto.LineInfo = lines
+	for k, v := range o.proto.LocVars {
+		o.proto.LocVars[k].StartPC = uint32(newPCs[min(int(v.StartPC), len(o.code))])
+		o.proto.LocVars[k].EndPC = uint32(newPCs[min(int(v.EndPC), len(o.code))])
+	}
+
+	o.code = code
+	o.dead = make([]bool, len(code))
+	o.flow = newFlow(code)
+}
+
+func (o *optimizer) finish() {
+	o.proto.Code = make([]uint32, len(o.code))
+	for pc, i := range o.code {
+		o.proto.Code[pc] = uint32(i)
+	}
+}
+
+func abc(op, a, b, c int) vm.Instruction {
+	return vm.Instruction(b<<23 | c<<14 | a<<6 | op)
+}
+
+func loadNil(a, n int) vm.Instruction {
+	return abc(vm.OpLOADNIL, a, n, 0)
+}
+
+func min(x, y int) int {
+	if x < y {
+		return x
+	}
+	return y
+}
+
+func max(x, y int) int {
+	if x > y {
+		return x
+	}
+	return y
+}
@@ -0,0 +1,288 @@
+package peephole_test
+
+import (
+	"fmt"
+	"luago/api"
+	"luago/binchunk"
+	"luago/compiler"
+	"luago/compiler/peephole"
+	"luago/state"
+	"luago/stdlib"
+	"luago/vm"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func abc(op, a, b, c int) uint32 {
+	return uint32(b<<23 | c<<14 | a<<6 | op)
+}
+
+func asbx(op, a, sBx int) uint32 {
+	return uint32((sBx+vm.MaxArgSBx)<<14 | a<<6 | op)
+}
+
+func listCode(code []uint32) string {
+	var lines []string
+	for _, c := range code {
+		i := vm.Instruction(c)
+		s := strings.TrimSpace(i.OpName())
+		switch i.OpMode() {
+		case vm.IABC:
+			a, b, c := i.ABC()
+			s += fmt.Sprintf(" %d %d %d", a, b, c)
+		case vm.IAsBx:
+			a, sBx := i.AsBx()
+			s += fmt.Sprintf(" %d %d", a, sBx)
+		default:
+			a, bx := i.ABx()
+			s += fmt.Sprintf(" %d %d", a, bx)
+		}
+		lines = append(lines, s)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func testOptimize(t *testing.T, proto *binchunk.ProtoType, want ...string) {
+	t.Helper()
+	before := listCode(proto.Code)
+	peephole.Optimize(proto)
+	if got := listCode(proto.Code); got != strings.Join(want, "\n") {
+		t.Errorf("%s\nwant:\n%s\ngot:\n%s", before, strings.Join(want, "\n"), got)
+	}
+	if len(proto.LineInfo) != len(proto.Code) {
+		t.Errorf("%d lines for %d instructions", len(proto.LineInfo), len(proto.Code))
+	}
+}
+
+func newProto(code ...uint32) *binchunk.ProtoType {
+	lines := make([]uint32, len(code))
+	for pc := range lines {
+		lines[pc] = uint32(pc + 1)
+	}
+	return &binchunk.ProtoType{Code: code, LineInfo: lines, MaxStackSize: 8}
+}
+
+func TestThreadJumps(t *testing.T) {
+	testOptimize(t, newProto(
+		abc(vm.OpTEST, 0, 0, 0),
+		asbx(vm.OpJMP, 0, 1),
+		abc(vm.OpLOADNIL, 1, 0, 0),
+		asbx(vm.OpJMP, 0, 1),
+		abc(vm.OpLOADK, 1, 0, 0),
+		asbx(vm.OpJMP, 3, 0),
+		abc(vm.OpRETURN, 1, 2, 0)),
+		"TEST 0 0 0",
+		"JMP 3 2",
+		"LOADNIL 1 0 0",
+		"JMP 3 0",
+		"RETURN 1 2 0")
+}
+
+func TestUnreachable(t *testing.T) {
+	proto := newProto(
+		abc(vm.OpLOADK, 0, 0, 0),
+		abc(vm.OpRETURN, 0, 2, 0),
+		abc(vm.OpLOADK, 1, 0, 0),
+		abc(vm.OpMOVE, 0, 1, 0),
+		abc(vm.OpRETURN, 0, 1, 0))
+	proto.LocVars = []binchunk.LocVar{{VarName: "a", StartPC: 1, EndPC: 5}, {VarName: "b", StartPC: 3, EndPC: 4}}
+	testOptimize(t, proto,
+		"LOADK 0 0",
+		"RETURN 0 2 0")
+	if want := []uint32{1, 2}; !reflect.DeepEqual(proto.LineInfo, want) {
+		t.Errorf("want lines %v, got %v", want, proto.LineInfo)
+	}
+	want := []binchunk.LocVar{{VarName: "a", StartPC: 1, EndPC: 2}, {VarName: "b", StartPC: 2, EndPC: 2}}
+	if !reflect.DeepEqual(proto.LocVars, want) {
+		t.Errorf("want locals %v, got %v", want, proto.LocVars)
+	}
+}
+
+func TestMergeLoadNils(t *testing.T) {
+	testOptimize(t, newProto(
+		abc(vm.OpLOADNIL, 0, 1, 0),
+		abc(vm.OpLOADNIL, 2, 0, 0),
+		abc(vm.OpLOADNIL, 1, 2, 0),
+		abc(vm.OpLOADNIL, 5, 0, 0),
+		abc(vm.OpRETURN, 0, 1, 0)),
+		"LOADNIL 0 3 0",
+		"LOADNIL 5 0 0",
+		"RETURN 0 1 0")
+
+	// the LOADNIL jumped to is kept
+	testOptimize(t, newProto(
+		abc(vm.OpTEST, 0, 0, 0),
+		asbx(vm.OpJMP, 0, 1),
+		abc(vm.OpLOADNIL, 1, 0, 0),
+		abc(vm.OpLOADNIL, 2, 0, 0),
+		abc(vm.OpRETURN, 0, 1, 0)),
+		"TEST 0 0 0",
+		"JMP 0 1",
+		"LOADNIL 1 0 0",
+		"LOADNIL 2 0 0",
+		"RETURN 0 1 0")
+}
+
+func TestRemoveMoves(t *testing.T) {
+	proto := newProto(
+		abc(vm.OpGETTABUP, 1, 0, 0x100),
+		abc(vm.OpMOVE, 0, 1, 0),
+		abc(vm.OpMOVE, 0, 0, 0),
+		abc(vm.OpADD, 1, 0, 0x101),
+		abc(vm.OpMOVE, 0, 1, 0),
+		abc(vm.OpLOADK, 1, 0, 0), // R(1) is read by RETURN
+		abc(vm.OpMOVE, 0, 1, 0),
+		abc(vm.OpRETURN, 0, 3, 0))
+	proto.LocVars = []binchunk.LocVar{{VarName: "x", StartPC: 0, EndPC: 8}}
+	testOptimize(t, proto,
+		"GETTABUP 0 0 256",
+		"ADD 0 0 257",
+		"LOADK 1 0",
+		"MOVE 0 1 0",
+		"RETURN 0 3 0")
+
+	// the registers of locals and the ones captured are kept
+	proto = newProto(
+		abc(vm.OpLOADK, 1, 0, 0),
+		abc(vm.OpMOVE, 0, 1, 0),
+		abc(vm.OpCLOSURE, 2, 0, 0),
+		abc(vm.OpLOADK, 1, 0, 0),
+		abc(vm.OpMOVE, 0, 1, 0),
+		abc(vm.OpRETURN, 0, 1, 0))
+	proto.LocVars = []binchunk.LocVar{{VarName: "x", StartPC: 0, EndPC: 6}, {VarName: "y", StartPC: 0, EndPC: 2}}
+	proto.Protos = []*binchunk.ProtoType{{Upvalues: []binchunk.Upvalue{{Instack: 1, Idx: 1}}}}
+	testOptimize(t, proto,
+		"LOADK 1 0",
+		"MOVE 0 1 0",
+		"CLOSURE 2 0",
+		"LOADK 1 0",
+		"MOVE 0 1 0",
+		"RETURN 0 1 0")
+}
+
+func TestTailCalls(t *testing.T) {
+	testOptimize(t, newProto(
+		abc(vm.OpTEST, 0, 0, 0),
+		asbx(vm.OpJMP, 0, 2),
+		abc(vm.OpCALL, 0, 2, 2), // one result
+		abc(vm.OpRETURN, 0, 0, 0),
+		abc(vm.OpCALL, 0, 2, 0),
+		abc(vm.OpRETURN, 0, 0, 0)),
+		"TEST 0 0 0",
+		"JMP 0 2",
+		"CALL 0 2 2",
+		"RETURN 0 0 0",
+		"TAILCALL 0 2 0",
+		"RETURN 0 0 0")
+}
+
+// runs the scripts with and without optimizing, compares the results
+func TestScripts(t *testing.T) {
+	for _, src := range []string{
+		`local t = {}
+for i = 1, 10 do
+  if i % 2 == 0 then t[#t+1] = i elseif i > 7 then break else t[#t+1] = -i end
+end
+for k, v in ipairs(t) do t[k] = v * 2 end
+return table.concat(t, ",")`,
+		`local a, b, c
+local d
+a, b = 1, 2
+a, b = b, a
+c = a and b or d
+d = not (a < b) and "x" or "y"
+return a, b, c, d`,
+		`local fs = {}
+for i = 1, 3 do
+  local j = i
+  fs[i] = function() j = j + i return j end
+end
+local n = 0
+while true do
+  n = n + 1
+  if n > 5 then goto done end
+end
+::done::
+return fs[1](), fs[2](), fs[3](), fs[1](), n`,
+		`local function f(...) return select("#", ...), ... end
+local function g(x, ...) return f(x, ...) end
+local function h(...) return (f(...)) end
+local s = tostring(1)
+return g(1, nil, 3), h(4, 5), s, string.format("%d-%s", 1, "x")`,
+		`local function fib(n) if n < 2 then return n end return fib(n-1) + fib(n-2) end
+local t = {fib(10), fib(5), n = fib(3); [10] = "x"}
+do
+  local x = 1
+  repeat local y = x; x = x * 2 until y > 10
+  t.x = x
+end
+return t[1], t[2], t.n, t[10], t.x`,
+		`local obj = {v = 1}
+function obj:inc(n) self.v = self.v + (n or 1) return self end
+obj:inc():inc(2)
+local ok, err = pcall(function()
+  local a = nil
+  return a.b
+end)
+local ok2, err2 = pcall(error, "boom")
+return obj.v, ok, err, ok2, err2`,
+		`local function f()
+  do return 1 end
+  return 2
+end
+local x = 0
+if false then x = 1 else x = 2 end
+return f(), x, #"abc" .. "d"`,
+	} {
+		want := run(t, src, false)
+		if got := run(t, src, true); got != want {
+			t.Errorf("%s\nwant: %s\ngot:  %s", src, want, got)
+		}
+	}
+}
+
+// runs src and returns its results, or the error
+func run(t *testing.T, src string, optimize bool) string {
+	t.Helper()
+	proto := compiler.Compile(src, "src")
+	if optimize {
+		peephole.Optimize(proto)
+		checkProto(t, proto)
+	}
+	ls := state.NewLuaState()
+	stdlib.OpenLibs(ls)
+	ls.PushProto(proto)
+	if ls.PCall(0, -1, 0) != api.LuaOk {
+		return "error: " + ls.ToString(-1)
+	}
+	var results []string
+	for i := 1; i <= ls.GetTop(); i++ {
+		results = append(results, fmt.Sprintf("%s:%s", ls.TypeName(ls.Type(i)), ls.ToString(i)))
+	}
+	return strings.Join(results, " ")
+}
+
+// checks the debug info and the jumps are in range
+func checkProto(t *testing.T, proto *binchunk.ProtoType) {
+	t.Helper()
+	if len(proto.LineInfo) != len(proto.Code) {
+		t.Errorf("%d lines for %d instructions", len(proto.LineInfo), len(proto.Code))
+	}
+	for _, v := range proto.LocVars {
+		if v.StartPC > v.EndPC || int(v.EndPC) > len(proto.Code) {
+			t.Errorf("local %s out of range: %d-%d", v.VarName, v.StartPC, v.EndPC)
+		}
+	}
+	for pc, c := range proto.Code {
+		i := vm.Instruction(c)
+		if i.OpMode() == vm.IAsBx {
+			if _, sBx := i.AsBx(); pc+1+sBx < 0 || pc+1+sBx >= len(proto.Code) {
+				t.Errorf("jump out of range at %d", pc)
+			}
+		}
+	}
+	for _, p := range proto.Protos {
+		checkProto(t, p)
+	}
+}
@@ -0,0 +1,238 @@
+package sema
+
+import "luago/compiler/ast"
+
+type analyzer struct {
+	info  *Info
+	fn    *Function
+	scope *Scope
+}
+
+// Analyze resolves the names of the main chunk block, the block should be
+// parsed without folding constants if the names in constant expressions
+// are needed
+func Analyze(block *ast.Block) *Info {
+	info := &Info{
+		Main:     &Function{},
+		Env:      &Decl{Name: "_ENV", Kind: DeclEnv},
+		Bindings: map[*ast.NameExp]*Binding{},
+		Funcs:    map[*ast.FuncDefExp]*Function{},
+		Declared: map[ast.Node][]*Decl{},
+	}
+	a := &analyzer{info: info, fn: info.Main}
+	a.openScope(block.Span)
+	info.Scope = a.scope
+	a.block(block)
+	a.closeScope()
+	return info
+}
+
+func (a *analyzer) openScope(span ast.Span) {
+	s := &Scope{Span: span, Parent: a.scope, Func: a.fn}
+	if a.scope != nil {
+		a.scope.Children = append(a.scope.Children, s)
+	}
+	a.scope = s
+}
+
+func (a *analyzer) closeScope() {
+	a.scope = a.scope.Parent
+}
+
+func (a *analyzer) declare(name string, kind DeclKind, span ast.Span, node ast.Node) {
+	d := &Decl{Name: name, Kind: kind, Span: span, Node: node, Func: a.fn, Scope: a.scope}
+	a.scope.Decls = append(a.scope.Decls, d)
+	a.fn.Decls = append(a.fn.Decls, d)
+	a.info.Decls = append(a.info.Decls, d)
+	a.info.Declared[node] = append(a.info.Declared[node], d)
+}
+
+// returns the declaration visible by name, _ENV of the main chunk is
+// visible to all
+func (a *analyzer) lookup(name string) *Decl {
+	for s := a.scope; s != nil; s = s.Parent {
+		for i := len(s.Decls) - 1; i >= 0; i-- {
+			if s.Decls[i].Name == name {
+				return s.Decls[i]
+			}
+		}
+	}
+	if name == "_ENV" {
+		return a.info.Env
+	}
+	return nil
+}
+
+// binds the name to the declaration visible, or to _ENV for global, the
+// functions between the name and the declaration capture it
+func (a *analyzer) bind(name *ast.NameExp, assign bool) {
+	kind := Local
+	d := a.lookup(name.Name)
+	if d == nil {
+		kind = Global
+		d = a.lookup("_ENV")
+	} else if assign {
+		d.Reassigned = true
+	}
+	d.Uses = append(d.Uses, name)
+
+	depth := 0
+	for fn := a.fn; fn != d.Func; fn = fn.Parent {
+		depth++
+		if !containsDecl(fn.Upvalues, d) {
+			fn.Upvalues = append(fn.Upvalues, d)
+		}
+		if !containsFunc(d.Captures, fn) {
+			d.Captures = append(d.Captures, fn)
+		}
+	}
+	if kind == Local && depth > 0 {
+		kind = Upvalue
+	}
+	a.info.Bindings[name] = &Binding{Kind: kind, Decl: d, Depth: depth}
+}
+
+func (a *analyzer) block(block *ast.Block) {
+	for _, stat := range block.Stats {
+		a.stat(stat)
+	}
+	a.exps(block.RetExps)
+}
+
+func (a *analyzer) scopedBlock(block *ast.Block) {
+	a.openScope(block.Span)
+	a.block(block)
+	a.closeScope()
+}
+
+func (a *analyzer) stat(stat ast.Stat) {
+	switch x := stat.(type) {
+	case *ast.LocalVarDeclStat:
+		a.exps(x.ExpList)
+		for i, name := range x.NameList {
+			a.declare(name, DeclLocal, spanAt(x.NameSpans, i), x)
+		}
+	case *ast.LocalFuncDefStat:
+		a.declare(x.Name, DeclLocalFunc, x.NameSpan, x)
+		a.funcBody(x.Func)
+	case *ast.AssignStat:
+		a.exps(x.ExpList)
+		for _, v := range x.VarList {
+			if name, ok := v.(*ast.NameExp); ok {
+				a.bind(name, true)
+			} else {
+				a.exp(v)
+			}
+		}
+	case *ast.FuncCallStat:
+		a.exp(x)
+	case *ast.DoStat:
+		a.scopedBlock(x.MBlock)
+	case *ast.WhileStat:
+		a.exp(x.BExp)
+		a.scopedBlock(x.MBlock)
+	case *ast.RepeatStat: // the locals of block are visible to exp
+		a.openScope(x.Span)
+		a.block(x.MBlock)
+		a.exp(x.BExp)
+		a.closeScope()
+	case *ast.IfStat:
+		for i, exp := range x.BExps {
+			a.exp(exp)
+			a.scopedBlock(x.Blocks[i])
+		}
+	case *ast.ForNumStat:
+		a.exps([]ast.Exp{x.InitExp, x.LimitExp, x.StepExp})
+		a.openScope(x.Span)
+		a.declare(x.VarName, DeclForNum, x.VarSpan, x)
+		a.block(x.MBlock)
+		a.closeScope()
+	case *ast.ForInStat:
+		a.exps(x.ExpList)
+		a.openScope(x.Span)
+		for i, name := range x.NameList {
+			a.declare(name, DeclForIn, spanAt(x.NameSpans, i), x)
+		}
+		a.block(x.MBlock)
+		a.closeScope()
+	}
+}
+
+func (a *analyzer) funcBody(f *ast.FuncDefExp) {
+	fn := &Function{Def: f, Parent: a.fn}
+	a.fn.Children = append(a.fn.Children, fn)
+	a.info.Funcs[f] = fn
+	a.fn = fn
+	a.openScope(f.Span)
+	for i, name := range f.ParList {
+		span := spanAt(f.ParSpans, i)
+		if name == "self" && span.End.Offset == 0 && i == 0 {
+			a.declare(name, DeclSelf, span, f)
+		} else {
+			a.declare(name, DeclParam, span, f)
+		}
+	}
+	a.block(f.MBlock)
+	a.closeScope()
+	a.fn = fn.Parent
+}
+
+func (a *analyzer) exps(exps []ast.Exp) {
+	for _, exp := range exps {
+		a.exp(exp)
+	}
+}
+
+func (a *analyzer) exp(exp ast.Exp) {
+	switch x := exp.(type) {
+	case *ast.NameExp:
+		a.bind(x, false)
+	case *ast.FuncDefExp:
+		a.funcBody(x)
+	case *ast.ParensExp:
+		a.exp(x.MExp)
+	case *ast.TableAccessExp:
+		a.exps([]ast.Exp{x.PrefixExp, x.Key})
+	case *ast.FuncCallExp:
+		a.exp(x.PrefixExp)
+		a.exps(x.Args)
+	case *ast.TableConstructionExp:
+		for i, key := range x.KeyExps {
+			if key != nil {
+				a.exp(key)
+			}
+			a.exp(x.ValExps[i])
+		}
+	case *ast.UnOpExp:
+		a.exp(x.MExp)
+	case *ast.BinOpExp:
+		a.exps([]ast.Exp{x.Exp1, x.Exp2})
+	case *ast.ConcatExp:
+		a.exps(x.Exps)
+	}
+}
+
+func spanAt(spans []ast.Span, i int) ast.Span {
+	if i < len(spans) {
+		return spans[i]
+	}
+	return ast.Span{}
+}
+
+func containsDecl(decls []*Decl, d *Decl) bool {
+	for _, x := range decls {
+		if x == d {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFunc(fns []*Function, fn *Function) bool {
+	for _, x := range fns {
+		if x == fn {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,141 @@
+package sema
+
+import (
+	"fmt"
+	"luago/compiler/ast"
+	"luago/compiler/parser"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestBindings(t *testing.T) {
+	testBindings(t, `local a = 1
+local function f(b)
+  return function() return a + b + c end
+end
+a = f`,
+		"3:28 a upvalue(2) local@1:7",
+		"3:32 b upvalue(1) parameter@2:18",
+		"3:36 c global(3) _ENV",
+		"5:1 a local local@1:7",
+		"5:5 f local local function@2:16")
+	testBindings(t, `local x = x
+do local x = x end
+for x = x, x do local y = x end
+for k, v in pairs(x) do print(k, v) end
+repeat local z = 1 until z`,
+		"1:11 x global(1) _ENV",
+		"2:14 x local local@1:7",
+		"3:9 x local local@1:7",
+		"3:12 x local local@1:7",
+		"3:27 x local for variable@3:5",
+		"4:13 pairs global(1) _ENV",
+		"4:19 x local local@1:7",
+		"4:25 print global(1) _ENV",
+		"4:31 k local for variable@4:5",
+		"4:34 v local for variable@4:8",
+		"5:26 z local local@5:14")
+	testBindings(t, `function t:m(a) return self, a end`,
+		"1:10 t global(1) _ENV",
+		"1:24 self local self",
+		"1:30 a local parameter@1:14")
+	testBindings(t, `local _ENV = {print = print}
+print(x)
+function f() _ENV = nil end`,
+		"1:23 print global(1) _ENV",
+		"2:1 print global local@1:7",
+		"2:7 x global local@1:7",
+		"3:10 f global local@1:7",
+		"3:14 _ENV upvalue(1) local@1:7")
+}
+
+func TestDecls(t *testing.T) {
+	block, _ := parser.ParseChunk(`local a, b = 1, 2
+local function f(x)
+  return function() b = x end
+end
+print(a, f)`, "src")
+	info := Analyze(block.Block)
+
+	if n := len(info.Decls); n != 4 {
+		t.Fatalf("want 4 decls, got %d", n)
+	}
+	a, b, f, x := info.Decls[0], info.Decls[1], info.Decls[2], info.Decls[3]
+	if a.Reassigned || !b.Reassigned || f.Reassigned || x.Reassigned {
+		t.Errorf("unexpected reassigned: %v %v %v %v", a.Reassigned, b.Reassigned, f.Reassigned, x.Reassigned)
+	}
+	if len(a.Uses) != 1 || len(b.Uses) != 1 || len(f.Uses) != 1 || len(x.Uses) != 1 {
+		t.Errorf("unexpected uses: %d %d %d %d", len(a.Uses), len(b.Uses), len(f.Uses), len(x.Uses))
+	}
+
+	fn := info.Funcs[f.Node.(*ast.LocalFuncDefStat).Func]
+	if len(fn.Children) != 1 || fn.Parent != info.Main || len(info.Main.Children) != 1 {
+		t.Fatalf("unexpected functions: %+v", fn)
+	}
+	closure := fn.Children[0]
+	if a.Captured() || len(b.Captures) != 2 || b.Captures[0] != closure || b.Captures[1] != fn ||
+		len(x.Captures) != 1 || x.Captures[0] != closure {
+		t.Errorf("unexpected captures: %v %v %v", a.Captures, b.Captures, x.Captures)
+	}
+	if names := declNames(closure.Upvalues); names != "x b" {
+		t.Errorf("unexpected upvalues of closure: %s", names)
+	}
+	if names := declNames(fn.Upvalues); names != "b" {
+		t.Errorf("unexpected upvalues of f: %s", names)
+	}
+	if names := declNames(info.Main.Upvalues); names != "_ENV" {
+		t.Errorf("unexpected upvalues of main: %s", names)
+	}
+	if names := declNames(info.Main.Decls); names != "a b f" {
+		t.Errorf("unexpected decls of main: %s", names)
+	}
+	if len(info.Declared[f.Node]) != 1 || len(info.Declared[a.Node]) != 2 {
+		t.Errorf("unexpected declared: %v", info.Declared)
+	}
+
+	if len(info.Scope.Children) != 1 || info.Scope.Children[0].Func != fn ||
+		declNames(info.Scope.Children[0].Decls) != "x" {
+		t.Errorf("unexpected scopes: %+v", info.Scope.Children)
+	}
+}
+
+// checks the bindings of all names, a binding is formatted like
+// `line:col name kind(depth) decl@line:col`
+func testBindings(t *testing.T, src string, want ...string) {
+	t.Helper()
+	chunk, diags := parser.ParseChunk(src, "src")
+	if len(diags) > 0 {
+		t.Fatalf("%s: %v", src, diags)
+	}
+	info := Analyze(chunk.Block)
+	var names []*ast.NameExp
+	for name := range info.Bindings {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i].Start.Offset < names[j].Start.Offset })
+	var got []string
+	for _, name := range names {
+		b := info.Bindings[name]
+		s := fmt.Sprintf("%d:%d %s %s", name.Start.Line, name.Start.Column, name.Name, b.Kind)
+		if b.Depth > 0 {
+			s += fmt.Sprintf("(%d)", b.Depth)
+		}
+		s += " " + b.Decl.Kind.String()
+		if b.Decl.Span.End.Offset > 0 {
+			s += fmt.Sprintf("@%d:%d", b.Decl.Span.Start.Line, b.Decl.Span.Start.Column)
+		}
+		got = append(got, s)
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("%s\nwant:\n%s\ngot:\n%s", src, strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+func declNames(decls []*Decl) string {
+	var names []string
+	for _, d := range decls {
+		names = append(names, d.Name)
+	}
+	return strings.Join(names, " ")
+}
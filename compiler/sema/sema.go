@@ -0,0 +1,123 @@
+// Package sema resolves the names of chunk by scopes, it binds each NameExp
+// to a local declaration, an upvalue captured from the enclosing functions,
+// or a global accessed through _ENV, and records the uses, captures and
+// assignments of each declaration for the tools and optimizations
+package sema
+
+import "luago/compiler/ast"
+
+// BindingKind is the kind of binding of a name
+type BindingKind int
+
+// kinds of binding
+const (
+	Global  BindingKind = iota // field of _ENV
+	Local                      // local of the function using it
+	Upvalue                    // local of an enclosing function
+)
+
+var bindingKindNames = [...]string{
+	Global:  "global",
+	Local:   "local",
+	Upvalue: "upvalue",
+}
+
+func (k BindingKind) String() string {
+	return bindingKindNames[k]
+}
+
+// Binding is the variable a NameExp refers to. Decl is the local or upvalue
+// declared, for a global it's the _ENV the global is accessed through,
+// which is Info.Env unless a local named _ENV is visible. Depth is the
+// count of functions between the use and Decl, 0 if Decl is a local of the
+// function using it
+type Binding struct {
+	Kind  BindingKind
+	Decl  *Decl
+	Depth int
+}
+
+// DeclKind is the kind of declaration
+type DeclKind int
+
+// kinds of declaration
+const (
+	DeclLocal     DeclKind = iota // local statement
+	DeclLocalFunc                 // local function statement
+	DeclParam                     // parameter
+	DeclSelf                      // implicit self of method
+	DeclForNum                    // variable of numerical for
+	DeclForIn                     // variable of generic for
+	DeclEnv                       // _ENV of the main chunk
+)
+
+var declKindNames = [...]string{
+	DeclLocal:     "local",
+	DeclLocalFunc: "local function",
+	DeclParam:     "parameter",
+	DeclSelf:      "self",
+	DeclForNum:    "for variable",
+	DeclForIn:     "for variable",
+	DeclEnv:       "_ENV",
+}
+
+func (k DeclKind) String() string {
+	return declKindNames[k]
+}
+
+// Decl is a local variable declared. Span is the span of the name, empty
+// for the implicit self and _ENV of the main chunk. Node is the stat or
+// FuncDefExp declaring it, nil for _ENV of the main chunk. Uses is the
+// accesses and assignments of it, including the globals accessed through
+// it if it's _ENV. Captures is the functions capturing it as upvalue, in
+// the order they're first captured. Reassigned reports whether it's ever
+// assigned after the declaration
+type Decl struct {
+	Name       string
+	Kind       DeclKind
+	Span       ast.Span
+	Node       ast.Node
+	Func       *Function // function declaring it, nil for _ENV of the main chunk
+	Scope      *Scope
+	Uses       []*ast.NameExp
+	Captures   []*Function
+	Reassigned bool
+}
+
+// Captured reports whether d is an upvalue of some function
+func (d *Decl) Captured() bool {
+	return len(d.Captures) > 0
+}
+
+// Function is a function body, Def is nil for the main chunk. Upvalues is
+// the locals of the enclosing functions it captures, in the order they're
+// first captured; the main chunk captures Info.Env
+type Function struct {
+	Def      *ast.FuncDefExp
+	Parent   *Function
+	Decls    []*Decl // locals declared, in the order of declaration
+	Upvalues []*Decl
+	Children []*Function
+}
+
+// Scope is a block the locals declared in are visible to, Span is the
+// range of source code it covers, like the whole for statement of the
+// loop variables
+type Scope struct {
+	Span     ast.Span
+	Parent   *Scope
+	Func     *Function
+	Decls    []*Decl // in the order of declaration
+	Children []*Scope
+}
+
+// Info is the result of analysis
+type Info struct {
+	Main     *Function
+	Env      *Decl // _ENV of the main chunk
+	Scope    *Scope
+	Decls    []*Decl // all declarations in the order of declaration
+	Bindings map[*ast.NameExp]*Binding
+	Funcs    map[*ast.FuncDefExp]*Function
+	Declared map[ast.Node][]*Decl // declarations of the stats and FuncDefExps
+}
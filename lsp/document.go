@@ -0,0 +1,94 @@
+package lsp
+
+import (
+	"luago/compiler/ast"
+	"luago/compiler/parser"
+	"net/url"
+	"sort"
+	"unicode/utf8"
+)
+
+// document is an opened text document, it's parsed and resolved when
+// it's opened or changed
+type document struct {
+	uri     string
+	version int
+	text    string
+	lines   []int // offsets of the beginnings of lines
+	chunk   *ast.Chunk
+	res     *resolution
+}
+
+func newDocument(uri string, version int, text string) *document {
+	d := &document{uri: uri, version: version, text: text, lines: []int{0}}
+	for i := 0; i < len(text); i++ {
+		if c := text[i]; c == '\n' || c == '\r' && (i+1 == len(text) || text[i+1] != '\n') {
+			d.lines = append(d.lines, i+1)
+		}
+	}
+	d.chunk, _ = parser.ParseChunk(text, d.name())
+	d.res = resolve(d.chunk.Block)
+	return d
+}
+
+// returns the chunk name of document, the path of file URI
+func (d *document) name() string {
+	if u, err := url.Parse(d.uri); err == nil && u.Scheme == "file" {
+		return u.Path
+	}
+	return d.uri
+}
+
+// returns the offset of p, the positions beyond line are clamped to it
+func (d *document) offset(p Position) int {
+	if p.Line < 0 {
+		return 0
+	}
+	if p.Line >= len(d.lines) {
+		return len(d.text)
+	}
+	start, end := d.lines[p.Line], len(d.text)
+	if p.Line+1 < len(d.lines) {
+		end = d.lines[p.Line+1]
+	}
+	units := 0
+	for i, r := range d.text[start:end] {
+		if units >= p.Character || r == '\n' || r == '\r' {
+			return start + i
+		}
+		units += utf16Len(r)
+	}
+	return end
+}
+
+// returns the position of offset
+func (d *document) position(offset int) Position {
+	if offset > len(d.text) {
+		offset = len(d.text)
+	}
+	line := sort.Search(len(d.lines), func(i int) bool { return d.lines[i] > offset }) - 1
+	units := 0
+	for _, r := range d.text[d.lines[line]:offset] {
+		units += utf16Len(r)
+	}
+	return Position{Line: line, Character: units}
+}
+
+func (d *document) rangeOf(span ast.Span) Range {
+	start, end := span.Start.Offset, span.End.Offset
+	if end < start {
+		end = start
+	}
+	return Range{d.position(start), d.position(end)}
+}
+
+func (d *document) location(span ast.Span) Location {
+	return Location{URI: d.uri, Range: d.rangeOf(span)}
+}
+
+func utf16Len(r rune) int {
+	if r >= 0x10000 && r != utf8.RuneError {
+		return 2
+	}
+	return 1
+}
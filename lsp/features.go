@@ -0,0 +1,374 @@
+package lsp
+
+import (
+	"fmt"
+	"luago/api"
+	"luago/compiler/ast"
+	"luago/compiler/sema"
+	"regexp"
+	"strings"
+)
+
+var symKindNames = [...]string{
+	sema.DeclLocal:     "local",
+	sema.DeclLocalFunc: "local function",
+	sema.DeclParam:     "parameter",
+	sema.DeclSelf:      "self",
+	sema.DeclForNum:    "loop variable",
+	sema.DeclForIn:     "loop variable",
+}
+
+// returns the functions and locals declared in document, the symbols
+// declared in a function are its children
+func documentSymbols(d *document) []DocumentSymbol {
+	symbols := blockSymbols(d, d.chunk.Block)
+	if symbols == nil {
+		return []DocumentSymbol{}
+	}
+	return symbols
+}
+
+func blockSymbols(d *document, block *ast.Block) (symbols []DocumentSymbol) {
+	for _, stat := range block.Stats {
+		symbols = append(symbols, statSymbols(d, stat)...)
+	}
+	for _, exp := range block.RetExps {
+		symbols = append(symbols, expSymbols(d, exp)...)
+	}
+	return
+}
+
+func statSymbols(d *document, stat ast.Stat) (symbols []DocumentSymbol) {
+	switch x := stat.(type) {
+	case *ast.LocalVarDeclStat:
+		for i, name := range x.NameList {
+			span := spanAt(x.NameSpans, i)
+			if f := funcAt(x.ExpList, i); f != nil {
+				symbols = append(symbols, funcSymbol(d, name, span, f))
+			} else {
+				symbols = append(symbols, DocumentSymbol{
+					Name:           name,
+					Detail:         "local",
+					Kind:           SymbolVariable,
+					Range:          d.rangeOf(x.Span),
+					SelectionRange: d.rangeOf(span),
+				})
+			}
+		}
+		for i, exp := range x.ExpList {
+			if funcAt(x.ExpList, i) == nil || i >= len(x.NameList) {
+				symbols = append(symbols, expSymbols(d, exp)...)
+			}
+		}
+	case *ast.LocalFuncDefStat:
+		symbols = append(symbols, funcSymbol(d, x.Name, x.NameSpan, x.Func))
+	case *ast.AssignStat:
+		for i, v := range x.VarList {
+			f := funcAt(x.ExpList, i)
+			if f == nil {
+				continue
+			}
+			if path := namePath(v); path != "" {
+				symbols = append(symbols, funcSymbol(d, path, v.Pos(), f))
+			}
+		}
+		for i, exp := range x.ExpList {
+			if f := funcAt(x.ExpList, i); f == nil || i >= len(x.VarList) || namePath(x.VarList[i]) == "" {
+				symbols = append(symbols, expSymbols(d, exp)...)
+			}
+		}
+	case *ast.FuncCallStat:
+		symbols = expSymbols(d, x)
+	case *ast.DoStat:
+		symbols = blockSymbols(d, x.MBlock)
+	case *ast.WhileStat:
+		symbols = blockSymbols(d, x.MBlock)
+	case *ast.RepeatStat:
+		symbols = blockSymbols(d, x.MBlock)
+	case *ast.IfStat:
+		for _, block := range x.Blocks {
+			symbols = append(symbols, blockSymbols(d, block)...)
+		}
+	case *ast.ForNumStat:
+		symbols = blockSymbols(d, x.MBlock)
+	case *ast.ForInStat:
+		symbols = blockSymbols(d, x.MBlock)
+	}
+	return
+}
+
+// returns the symbols in the anonymous functions of exp
+func expSymbols(d *document, exp ast.Exp) (symbols []DocumentSymbol) {
+	switch x := exp.(type) {
+	case *ast.FuncDefExp:
+		symbols = blockSymbols(d, x.MBlock)
+	case *ast.ParensExp:
+		symbols = expSymbols(d, x.MExp)
+	case *ast.FuncCallExp:
+		for _, arg := range x.Args {
+			symbols = append(symbols, expSymbols(d, arg)...)
+		}
+	case *ast.TableConstructionExp:
+		for _, val := range x.ValExps {
+			symbols = append(symbols, expSymbols(d, val)...)
+		}
+	}
+	return
+}
+
+func funcSymbol(d *document, name string, span ast.Span, f *ast.FuncDefExp) DocumentSymbol {
+	kind := SymbolFunction
+	if isMethod(f) {
+		kind = SymbolMethod
+		name = methodName(name)
+	}
+	return DocumentSymbol{
+		Name:           name,
+		Detail:         signature(name, f),
+		Kind:           kind,
+		Range:          d.rangeOf(f.Span),
+		SelectionRange: d.rangeOf(span),
+		Children:       blockSymbols(d, f.MBlock),
+	}
+}
+
+// reports whether f is defined by `function a.b:m()` with the implicit
+// self
+func isMethod(f *ast.FuncDefExp) bool {
+	return len(f.ParList) > 0 && f.ParList[0] == "self" &&
+		len(f.ParSpans) > 0 && f.ParSpans[0].End.Offset == 0
+}
+
+// returns `a.b:m` of path `a.b.m`
+func methodName(path string) string {
+	if i := strings.LastIndexByte(path, '.'); i >= 0 {
+		return path[:i] + ":" + path[i+1:]
+	}
+	return path
+}
+
+// returns the signature like `function name(a, b, ...)` of f, the
+// implicit self is omitted
+func signature(name string, f *ast.FuncDefExp) string {
+	pars := f.ParList
+	if isMethod(f) {
+		pars = pars[1:]
+	}
+	if f.IsVararg {
+		pars = append(pars[:len(pars):len(pars)], "...")
+	}
+	return fmt.Sprintf("function %s(%s)", name, strings.Join(pars, ", "))
+}
+
+// returns the declaration of the local, global or field at offset
+func definitionAt(d *document, offset int) *Location {
+	r := d.res.refAt(offset)
+	if r == nil {
+		return nil
+	}
+	if r.sym != nil {
+		if r.sym.span.End.Offset == 0 { // implicit self
+			return nil
+		}
+		loc := d.location(r.sym.span)
+		return &loc
+	}
+	if def := d.res.definition(r.name); def != nil {
+		loc := d.location(def.span)
+		return &loc
+	}
+	return nil
+}
+
+// returns the accesses and assignments of the local, global or field at
+// offset in the order of source code
+func referencesAt(d *document, offset int, includeDecl bool) []Location {
+	locs := []Location{}
+	r := d.res.refAt(offset)
+	if r == nil {
+		return locs
+	}
+	for _, x := range d.res.refs {
+		if (r.sym != nil && x.sym == r.sym || r.sym == nil && x.sym == nil && x.field == r.field && x.name == r.name) &&
+			(includeDecl || !x.decl) {
+			locs = append(locs, d.location(x.span))
+		}
+	}
+	return locs
+}
+
+// returns the signature of function or the kind of variable at offset
+func hoverAt(d *document, offset int) *Hover {
+	r := d.res.refAt(offset)
+	if r == nil {
+		return nil
+	}
+	var text string
+	switch {
+	case r.sym != nil && r.sym.fn != nil:
+		text = signature(r.sym.name, r.sym.fn)
+		if r.sym.kind == sema.DeclLocal || r.sym.kind == sema.DeclLocalFunc {
+			text = "local " + text
+		}
+	case r.sym != nil && r.sym.kind == sema.DeclSelf:
+		text = "self"
+	case r.sym != nil:
+		text = fmt.Sprintf("%s %s", symKindNames[r.sym.kind], r.sym.name)
+	default:
+		if def := d.res.definition(r.name); def != nil && def.fn != nil {
+			name := r.name
+			if isMethod(def.fn) {
+				name = methodName(name)
+			}
+			text = signature(name, def.fn)
+		} else if t, ok := libraryType(r.name); ok && t == api.LuaTFunction {
+			text = "(library function) " + r.name
+		} else if ok && t == api.LuaTTable {
+			text = "(library) " + r.name
+		} else if r.field {
+			text = "(field) " + r.name
+		} else {
+			text = "(global) " + r.name
+		}
+	}
+	rng := d.rangeOf(r.span)
+	return &Hover{
+		Contents: MarkupContent{Kind: "markdown", Value: "```lua\n" + text + "\n```"},
+		Range:    &rng,
+	}
+}
+
+// returns the type of global or field `lib.name` of the standard libraries
+func libraryType(path string) (api.LuaType, bool) {
+	lib := loadLibrary()
+	if i := strings.IndexByte(path, '.'); i >= 0 {
+		t, ok := lib.fields[path[:i]][path[i+1:]]
+		return t, ok
+	}
+	t, ok := lib.globals[path]
+	return t, ok
+}
+
+var (
+	reFieldPrefix = regexp.MustCompile(`([A-Za-z_][A-Za-z0-9_]*(?:\.[A-Za-z_][A-Za-z0-9_]*)*)\s*[.:]\s*([A-Za-z0-9_]*)$`)
+	reNamePrefix  = regexp.MustCompile(`[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// returns the candidates of the name or field being typed before offset,
+// the locals visible, the globals and fields assigned in document and
+// the ones of the standard libraries
+func completionAt(d *document, offset int) []CompletionItem {
+	line := d.text[d.lines[d.position(offset).Line]:offset]
+	items := []CompletionItem{}
+	seen := map[string]bool{}
+	add := func(label string, kind int, detail string) {
+		if !seen[label] {
+			seen[label] = true
+			items = append(items, CompletionItem{Label: label, Kind: kind, Detail: detail})
+		}
+	}
+
+	if m := reFieldPrefix.FindStringSubmatch(line); m != nil {
+		path, prefix := m[1], m[2]
+		for _, def := range d.res.defs {
+			if field := strings.TrimPrefix(def.path, path+"."); field != def.path &&
+				!strings.Contains(field, ".") && strings.HasPrefix(field, prefix) {
+				if def.fn != nil {
+					add(field, CompletionFunction, signature(def.path, def.fn))
+				} else {
+					add(field, CompletionField, "")
+				}
+			}
+		}
+		if root := strings.SplitN(path, ".", 2)[0]; d.res.lookupAt(root, offset) == nil {
+			fields := loadLibrary().fields[path]
+			for _, name := range sortedKeys(fields) {
+				if strings.HasPrefix(name, prefix) {
+					add(name, completionKind(fields[name]), path+"."+name)
+				}
+			}
+		}
+		return items
+	}
+
+	prefix := reNamePrefix.FindString(line)
+	for _, sym := range d.res.visible(offset) {
+		if strings.HasPrefix(sym.name, prefix) {
+			if sym.fn != nil {
+				add(sym.name, CompletionFunction, signature(sym.name, sym.fn))
+			} else {
+				add(sym.name, CompletionVariable, symKindNames[sym.kind])
+			}
+		}
+	}
+	for _, def := range d.res.defs {
+		if !strings.Contains(def.path, ".") && strings.HasPrefix(def.path, prefix) &&
+			def.span.End.Offset != offset {
+			if def.fn != nil {
+				add(def.path, CompletionFunction, signature(def.path, def.fn))
+			} else {
+				add(def.path, CompletionVariable, "global")
+			}
+		}
+	}
+	globals := loadLibrary().globals
+	for _, name := range sortedKeys(globals) {
+		if strings.HasPrefix(name, prefix) {
+			add(name, completionKind(globals[name]), "")
+		}
+	}
+	return items
+}
+
+func completionKind(t api.LuaType) int {
+	switch t {
+	case api.LuaTFunction:
+		return CompletionFunction
+	case api.LuaTTable:
+		return CompletionModule
+	}
+	return CompletionField
+}
+
+// returns the edits renaming the local at offset to newName, the rename
+// is refused if it changes what a name refers to: a reference of the
+// local would be bound to another local named newName, or a local or
+// global named newName would be bound to the local renamed
+func renameAt(d *document, offset int, newName string) (*WorkspaceEdit, error) {
+	if !isName(newName) {
+		return nil, &Error{CodeInvalidParams, fmt.Sprintf("invalid name '%s'", newName)}
+	}
+	r := d.res.refAt(offset)
+	if r == nil || r.sym == nil || r.sym.kind == sema.DeclSelf {
+		return nil, &Error{CodeInvalidParams, "only local variables can be renamed"}
+	}
+	sym := r.sym
+	edits := []TextEdit{}
+	for _, x := range d.res.refs {
+		if x.field || x.decl {
+			continue
+		}
+		b := d.res.lookupRenamed(newName, x.span.Start.Offset, sym, newName)
+		line := x.span.Start.Line
+		switch {
+		case x.sym == sym && b != nil && b != sym:
+			return nil, renameError(newName, "line %d would refer to the %s '%s' instead",
+				line, symKindNames[b.kind], newName)
+		case x.sym == sym || b != sym:
+		case x.sym != nil && x.name == newName:
+			return nil, renameError(newName, "line %d uses the %s '%s'", line, symKindNames[x.sym.kind], newName)
+		case x.sym == nil && (x.name == newName || newName == "_ENV"): // globals are fields of _ENV
+			return nil, renameError(newName, "line %d uses the global '%s'", line, x.name)
+		}
+	}
+	for _, x := range d.res.refs {
+		if x.sym == sym {
+			edits = append(edits, TextEdit{Range: d.rangeOf(x.span), NewText: newName})
+		}
+	}
+	return &WorkspaceEdit{Changes: map[string][]TextEdit{d.uri: edits}}, nil
+}
+
+func renameError(newName, format string, a ...interface{}) error {
+	return &Error{CodeInvalidParams, fmt.Sprintf("cannot rename to '%s', ", newName) + fmt.Sprintf(format, a...)}
+}
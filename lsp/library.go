@@ -0,0 +1,64 @@
+package lsp
+
+import (
+	"luago/api"
+	"luago/state"
+	"luago/stdlib"
+	"sort"
+	"sync"
+)
+
+// library is the globals registered by the standard libraries, the
+// fields of the library tables are in fields, name => field => type
+type library struct {
+	globals map[string]api.LuaType
+	fields  map[string]map[string]api.LuaType
+}
+
+var (
+	stdLibrary     *library
+	stdLibraryOnce sync.Once
+)
+
+// returns the globals and fields registered by opening the standard
+// libraries in a new state
+func loadLibrary() *library {
+	stdLibraryOnce.Do(func() {
+		ls := state.NewLuaState()
+		stdlib.OpenLibs(ls)
+		ls.PushGlobalTable()
+		stdLibrary = &library{globals: tableKeys(ls), fields: map[string]map[string]api.LuaType{}}
+		for name, t := range stdLibrary.globals {
+			if t == api.LuaTTable && name != stdlib.BaseLibName {
+				ls.GetField(-1, name)
+				stdLibrary.fields[name] = tableKeys(ls)
+				ls.Pop(1)
+			}
+		}
+		ls.Close()
+	})
+	return stdLibrary
+}
+
+// returns the string keys and the types of values of the table on top
+func tableKeys(ls api.ILuaState) map[string]api.LuaType {
+	keys := map[string]api.LuaType{}
+	ls.PushNil()
+	for ls.Next(-2) {
+		if ls.Type(-2) == api.LuaTString {
+			keys[ls.ToString(-2)] = ls.Type(-1)
+		}
+		ls.Pop(1)
+	}
+	return keys
+}
+
+// returns the keys of m in order
+func sortedKeys(m map[string]api.LuaType) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
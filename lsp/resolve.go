@@ -0,0 +1,275 @@
+package lsp
+
+import (
+	"luago/compiler/ast"
+	"luago/compiler/lexer"
+	"luago/compiler/sema"
+	"sort"
+)
+
+// symbol is a local variable declared, resolved by sema
+type symbol struct {
+	name    string
+	kind    sema.DeclKind
+	span    ast.Span        // span of the name declared, empty for the implicit self
+	visible int             // offset the symbol is visible from
+	fn      *ast.FuncDefExp // the function assigned by the declaration, if any
+}
+
+// ref is a name in source code, sym is the local it refers to, or nil for
+// a global or a field; name of field is the path like `a.b.c`
+type ref struct {
+	span  ast.Span
+	name  string
+	sym   *symbol
+	decl  bool // the name declares sym
+	field bool
+}
+
+// definition is a global or field assigned in source code
+type definition struct {
+	path string          // name of global, or path like `a.b.c` of field
+	span ast.Span        // span of the last name
+	fn   *ast.FuncDefExp // the function assigned, if any
+}
+
+// resolution is the locals and globals of a chunk, the names are bound by
+// sema, the fields and definitions are collected from the ast
+type resolution struct {
+	syms   map[*sema.Decl]*symbol
+	scopes []*sema.Scope // in the order they're opened
+	refs   []ref         // in the order of source code
+	defs   []*definition // in the order of source code
+}
+
+// resolves the names of chunk to the locals declaring them
+func resolve(block *ast.Block) *resolution {
+	info := sema.Analyze(block)
+	r := &resolution{syms: map[*sema.Decl]*symbol{}}
+	r.addScopes(info.Scope)
+	for _, d := range info.Decls {
+		sym := &symbol{name: d.Name, kind: d.Kind, span: d.Span,
+			visible: visibleFrom(d), fn: declFunc(d, info.Declared[d.Node])}
+		r.syms[d] = sym
+		if d.Span.End.Offset > 0 {
+			r.refs = append(r.refs, ref{span: d.Span, name: d.Name, sym: sym, decl: true})
+		}
+	}
+
+	ast.Inspect(block, func(node ast.Node) bool {
+		switch x := node.(type) {
+		case *ast.NameExp:
+			var sym *symbol
+			if b := info.Bindings[x]; b != nil && b.Kind != sema.Global {
+				sym = r.syms[b.Decl] // nil for _ENV of the main chunk
+			}
+			r.refs = append(r.refs, ref{span: x.Span, name: x.Name, sym: sym})
+		case *ast.TableAccessExp:
+			if path := fieldPath(x); path != "" {
+				r.refs = append(r.refs, ref{span: x.Key.Pos(), name: path, field: true})
+			}
+		case *ast.FuncCallExp:
+			if x.FNameExp != nil {
+				if prefix := namePath(x.PrefixExp); prefix != "" {
+					r.refs = append(r.refs, ref{span: x.FNameExp.Span, name: prefix + "." + x.FNameExp.Str, field: true})
+				}
+			}
+		}
+		return true
+	}, func(node ast.Node) { // after the definitions nested in the values
+		if x, ok := node.(*ast.AssignStat); ok {
+			r.assign(info, x)
+		}
+	})
+	sort.SliceStable(r.refs, func(i, j int) bool {
+		return r.refs[i].span.Start.Offset < r.refs[j].span.Start.Offset
+	})
+	return r
+}
+
+// adds scope and the scopes nested in the order they're opened
+func (r *resolution) addScopes(scope *sema.Scope) {
+	r.scopes = append(r.scopes, scope)
+	for _, child := range scope.Children {
+		r.addScopes(child)
+	}
+}
+
+// adds the definitions of the globals and fields assigned by stat
+func (r *resolution) assign(info *sema.Info, stat *ast.AssignStat) {
+	for i, v := range stat.VarList {
+		switch x := v.(type) {
+		case *ast.NameExp:
+			if b := info.Bindings[x]; b != nil && b.Kind == sema.Global {
+				r.defs = append(r.defs, &definition{x.Name, x.Span, funcAt(stat.ExpList, i)})
+			}
+		case *ast.TableAccessExp:
+			if path := fieldPath(x); path != "" {
+				r.defs = append(r.defs, &definition{path, x.Key.Pos(), funcAt(stat.ExpList, i)})
+			}
+		}
+	}
+}
+
+// returns the offset the local d is visible from
+func visibleFrom(d *sema.Decl) int {
+	switch x := d.Node.(type) {
+	case *ast.LocalVarDeclStat:
+		return x.End.Offset
+	case *ast.LocalFuncDefStat:
+		return x.NameSpan.Start.Offset
+	case *ast.FuncDefExp: // parameters
+		return x.Start.Offset
+	case *ast.ForNumStat:
+		return x.MBlock.Start.Offset
+	case *ast.ForInStat:
+		return x.MBlock.Start.Offset
+	}
+	return 0
+}
+
+// returns the function assigned by the declaration of d, declared is
+// the locals declared by the same node
+func declFunc(d *sema.Decl, declared []*sema.Decl) *ast.FuncDefExp {
+	switch x := d.Node.(type) {
+	case *ast.LocalVarDeclStat:
+		for i, decl := range declared {
+			if decl == d {
+				return funcAt(x.ExpList, i)
+			}
+		}
+	case *ast.LocalFuncDefStat:
+		return x.Func
+	}
+	return nil
+}
+
+// returns the name at offset, or nil if there isn't one
+func (r *resolution) refAt(offset int) *ref {
+	var found *ref
+	for i := range r.refs {
+		span := r.refs[i].span
+		if span.Start.Offset <= offset && offset <= span.End.Offset && span.End.Offset > 0 {
+			found = &r.refs[i]
+			if offset < span.End.Offset {
+				break
+			}
+		}
+	}
+	return found
+}
+
+// returns the first definition of the global or field path
+func (r *resolution) definition(path string) *definition {
+	for _, def := range r.defs {
+		if def.path == path {
+			return def
+		}
+	}
+	return nil
+}
+
+// calls f with the locals visible at offset, the inner ones first,
+// including the locals shadowed, until f returns false
+func (r *resolution) eachVisible(offset int, f func(*symbol) bool) {
+	for i := len(r.scopes) - 1; i >= 0; i-- {
+		s := r.scopes[i]
+		if offset < s.Span.Start.Offset || offset > s.Span.End.Offset {
+			continue
+		}
+		for j := len(s.Decls) - 1; j >= 0; j-- {
+			if sym := r.syms[s.Decls[j]]; sym.visible <= offset && !f(sym) {
+				return
+			}
+		}
+	}
+}
+
+// returns the locals visible at offset, the inner ones first, the
+// locals shadowed are excluded
+func (r *resolution) visible(offset int) []*symbol {
+	var syms []*symbol
+	seen := map[string]bool{}
+	r.eachVisible(offset, func(sym *symbol) bool {
+		if !seen[sym.name] {
+			seen[sym.name] = true
+			syms = append(syms, sym)
+		}
+		return true
+	})
+	return syms
+}
+
+// returns the local name visible at offset, or nil for global
+func (r *resolution) lookupAt(name string, offset int) *symbol {
+	return r.lookupRenamed(name, offset, nil, "")
+}
+
+// returns the local name visible at offset like lookupAt as if the local
+// renamed were named newName
+func (r *resolution) lookupRenamed(name string, offset int, renamed *symbol, newName string) *symbol {
+	var found *symbol
+	r.eachVisible(offset, func(sym *symbol) bool {
+		symName := sym.name
+		if sym == renamed {
+			symName = newName
+		}
+		if symName == name {
+			found = sym
+		}
+		return found == nil
+	})
+	return found
+}
+
+// returns the path like `a.b.c` of the field accessed, or "" if it's not
+// accessed by names
+func fieldPath(x *ast.TableAccessExp) string {
+	key, ok := x.Key.(*ast.StringExp)
+	if !ok || !isName(key.Str) {
+		return ""
+	}
+	if prefix := namePath(x.PrefixExp); prefix != "" {
+		return prefix + "." + key.Str
+	}
+	return ""
+}
+
+func namePath(exp ast.Exp) string {
+	switch x := exp.(type) {
+	case *ast.NameExp:
+		return x.Name
+	case *ast.TableAccessExp:
+		return fieldPath(x)
+	}
+	return ""
+}
+
+func isName(s string) bool {
+	if s == "" || lexer.IsKeyword(s) {
+		return false
+	}
+	for i, c := range s {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 0 && c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+func spanAt(spans []ast.Span, i int) ast.Span {
+	if i < len(spans) {
+		return spans[i]
+	}
+	return ast.Span{}
+}
+
+// returns the i-th expression if it's a function definition
+func funcAt(exps []ast.Exp, i int) *ast.FuncDefExp {
+	if i < len(exps) {
+		if f, ok := exps[i].(*ast.FuncDefExp); ok {
+			return f
+		}
+	}
+	return nil
+}
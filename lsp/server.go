@@ -0,0 +1,260 @@
+// Package lsp implements a language server of lua over the language server
+// protocol, it publishes the diagnostics of linter and provides document
+// symbols, definitions, references, hover, completion and rename of locals
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"luago/compiler/lint"
+	"strings"
+)
+
+// ErrNoShutdown is returned by Serve if the client exits or closes the
+// connection without the shutdown request
+var ErrNoShutdown = errors.New("lsp: exit without shutdown")
+
+// Server is a language server talking json-rpc over a reader and writer
+type Server struct {
+	in       *bufio.Reader
+	out      io.Writer
+	lint     *lint.Config
+	docs     map[string]*document // uri => document
+	shutdown bool
+}
+
+// NewServer returns a server reading the messages from r and writing the
+// messages to w, the stdin and stdout of the language server command
+func NewServer(r io.Reader, w io.Writer) *Server {
+	return &Server{
+		in:   bufio.NewReader(r),
+		out:  w,
+		lint: &lint.Config{},
+		docs: map[string]*document{},
+	}
+}
+
+// Serve handles the messages until the exit notification, it returns nil
+// if the shutdown request is received before, ErrNoShutdown if not, or the
+// error of reading and writing messages
+func (s *Server) Serve() error {
+	for {
+		msg, err := readMessage(s.in)
+		if err == io.EOF || err == nil && msg.Method == "exit" {
+			if s.shutdown {
+				return nil
+			}
+			return ErrNoShutdown
+		}
+		if e, ok := err.(*Error); ok {
+			err = s.reply(nil, nil, e)
+		} else if err == nil {
+			err = s.handle(msg)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// handles the request or notification, the unknown notifications are
+// ignored
+func (s *Server) handle(msg *message) error {
+	result, err := s.call(msg.Method, msg.Params)
+	if msg.ID == nil {
+		return nil
+	}
+	if err == nil && result == nil {
+		return s.reply(msg.ID, json.RawMessage("null"), nil)
+	}
+	if err != nil {
+		e, ok := err.(*Error)
+		if !ok {
+			e = &Error{CodeRequestFailed, err.Error()}
+		}
+		return s.reply(msg.ID, nil, e)
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return s.reply(msg.ID, nil, &Error{CodeInternalError, err.Error()})
+	}
+	return s.reply(msg.ID, data, nil)
+}
+
+func (s *Server) reply(id *json.RawMessage, result json.RawMessage, err *Error) error {
+	if id == nil {
+		null := json.RawMessage("null")
+		id = &null
+	}
+	return writeMessage(s.out, &message{ID: id, Result: result, Error: err})
+}
+
+func (s *Server) notify(method string, params interface{}) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return writeMessage(s.out, &message{Method: method, Params: data})
+}
+
+// calls the method with params, the result is nil for null
+func (s *Server) call(method string, params json.RawMessage) (interface{}, error) {
+	switch method {
+	case "initialize":
+		var p initializeParams
+		if err := unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+		return s.initialize(p), nil
+	case "initialized":
+		return nil, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var p didOpenParams
+		if err := unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+		return nil, s.open(newDocument(p.TextDocument.URI, p.TextDocument.Version, p.TextDocument.Text))
+	case "textDocument/didChange":
+		var p didChangeParams
+		if err := unmarshal(params, &p); err != nil || len(p.ContentChanges) == 0 {
+			return nil, err
+		}
+		text := p.ContentChanges[len(p.ContentChanges)-1].Text
+		return nil, s.open(newDocument(p.TextDocument.URI, p.TextDocument.Version, text))
+	case "textDocument/didClose":
+		var p didCloseParams
+		if err := unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+		delete(s.docs, p.TextDocument.URI)
+		return nil, s.notify("textDocument/publishDiagnostics",
+			publishDiagnosticsParams{URI: p.TextDocument.URI, Diagnostics: []Diagnostic{}})
+	case "textDocument/documentSymbol":
+		var p documentSymbolParams
+		if err := unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+		d, err := s.document(p.TextDocument.URI)
+		if err != nil {
+			return nil, err
+		}
+		return documentSymbols(d), nil
+	case "textDocument/definition":
+		return s.atPosition(params, func(d *document, offset int) (interface{}, error) {
+			if loc := definitionAt(d, offset); loc != nil {
+				return loc, nil
+			}
+			return nil, nil
+		})
+	case "textDocument/references":
+		var p referenceParams
+		if err := unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+		return s.atPosition(params, func(d *document, offset int) (interface{}, error) {
+			return referencesAt(d, offset, p.Context.IncludeDeclaration), nil
+		})
+	case "textDocument/hover":
+		return s.atPosition(params, func(d *document, offset int) (interface{}, error) {
+			if hover := hoverAt(d, offset); hover != nil {
+				return hover, nil
+			}
+			return nil, nil
+		})
+	case "textDocument/completion":
+		return s.atPosition(params, func(d *document, offset int) (interface{}, error) {
+			return completionAt(d, offset), nil
+		})
+	case "textDocument/rename":
+		var p renameParams
+		if err := unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+		return s.atPosition(params, func(d *document, offset int) (interface{}, error) {
+			return renameAt(d, offset, p.NewName)
+		})
+	}
+	if strings.HasPrefix(method, "$/") {
+		return nil, nil
+	}
+	return nil, &Error{CodeMethodNotFound, "method not found: " + method}
+}
+
+func (s *Server) initialize(p initializeParams) interface{} {
+	if opts := p.InitializationOptions; opts != nil {
+		s.lint = &lint.Config{Globals: opts.Globals, ReadOnly: opts.ReadOnly, Ignore: opts.Ignore}
+	}
+	return map[string]interface{}{
+		"capabilities": map[string]interface{}{
+			"textDocumentSync":       1, // full
+			"documentSymbolProvider": true,
+			"definitionProvider":     true,
+			"referencesProvider":     true,
+			"hoverProvider":          true,
+			"completionProvider":     map[string]interface{}{"triggerCharacters": []string{".", ":"}},
+			"renameProvider":         true,
+		},
+		"serverInfo": map[string]string{"name": "luago"},
+	}
+}
+
+// keeps the document opened or changed and publishes its diagnostics
+func (s *Server) open(d *document) error {
+	s.docs[d.uri] = d
+	diags := []Diagnostic{}
+	for _, w := range lint.Check(d.text, d.name(), s.lint) {
+		severity := SeverityWarning
+		if w.Code == lint.CodeSyntax {
+			severity = SeverityError
+		}
+		end := w.End
+		if end.Offset < w.Start.Offset {
+			end = w.Start
+		}
+		diags = append(diags, Diagnostic{
+			Range:    Range{d.position(w.Start.Offset), d.position(end.Offset)},
+			Severity: severity,
+			Code:     w.Code,
+			Source:   "luago",
+			Message:  w.Msg,
+		})
+	}
+	return s.notify("textDocument/publishDiagnostics",
+		publishDiagnosticsParams{URI: d.uri, Version: d.version, Diagnostics: diags})
+}
+
+func (s *Server) document(uri string) (*document, error) {
+	if d, ok := s.docs[uri]; ok {
+		return d, nil
+	}
+	return nil, &Error{CodeInvalidParams, "document not opened: " + uri}
+}
+
+// calls f with the document and the offset of the position in params
+func (s *Server) atPosition(params json.RawMessage,
+	f func(d *document, offset int) (interface{}, error)) (interface{}, error) {
+	var p textDocumentPositionParams
+	if err := unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+	d, err := s.document(p.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+	return f(d, d.offset(p.Position))
+}
+
+func unmarshal(params json.RawMessage, v interface{}) error {
+	if len(params) == 0 {
+		return &Error{CodeInvalidParams, "missing params"}
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return &Error{CodeInvalidParams, err.Error()}
+	}
+	return nil
+}
@@ -0,0 +1,454 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+const testURI = "file:///test.lua"
+
+const testSrc = `local M = {}
+local count = 0
+function M.add(a, b, ...)
+  count = count + a
+  return function() return count + b end
+end
+function M:get(key) return self[key] end
+local function helper(x) return M.add(x, 1) end
+print(helper(2), string.upper("x"))
+`
+
+func TestLifecycle(t *testing.T) {
+	c := newClient(t)
+	var result struct {
+		Capabilities map[string]interface{} `json:"capabilities"`
+	}
+	c.request("initialize", map[string]interface{}{"processId": nil}, &result)
+	for _, cap := range []string{"documentSymbolProvider", "definitionProvider", "referencesProvider",
+		"hoverProvider", "completionProvider", "renameProvider"} {
+		if result.Capabilities[cap] == nil {
+			t.Errorf("missing capability %s", cap)
+		}
+	}
+	c.notify("initialized", struct{}{})
+	if err := c.requestError("foo/bar", struct{}{}); err == nil || err.Code != CodeMethodNotFound {
+		t.Errorf("unexpected error: %v", err)
+	}
+	var null interface{}
+	c.request("shutdown", nil, &null)
+	if err := c.exit(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	c = newClient(t)
+	if err := c.exit(); err != ErrNoShutdown {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	c := newClient(t)
+	c.open(testURI, "local x = = 1\nlocal y\n")
+	diags := c.diagnostics()
+	want := []Diagnostic{
+		{Range{Position{0, 10}, Position{0, 11}}, SeverityError, "E011", "luago", "syntax error near '='"},
+		{Range{Position{1, 6}, Position{1, 7}}, SeverityWarning, "W211", "luago", "unused local variable 'y'"},
+	}
+	if !reflect.DeepEqual(diags, want) {
+		t.Errorf("want=%v, got=%v", want, diags)
+	}
+
+	c.notify("textDocument/didChange", map[string]interface{}{
+		"textDocument":   map[string]interface{}{"uri": testURI, "version": 2},
+		"contentChanges": []map[string]string{{"text": "local y = 1\nprint(y)\n"}},
+	})
+	if diags := c.diagnostics(); len(diags) != 0 {
+		t.Errorf("unexpected diagnostics: %v", diags)
+	}
+	c.open("file:///b.lua", "x = 1")
+	if diags := c.diagnostics(); len(diags) != 1 || diags[0].Code != "W112" {
+		t.Errorf("unexpected diagnostics: %v", diags)
+	}
+	c.notify("textDocument/didClose", map[string]interface{}{"textDocument": map[string]string{"uri": testURI}})
+	if diags := c.diagnostics(); len(diags) != 0 {
+		t.Errorf("unexpected diagnostics: %v", diags)
+	}
+	c.shutdown()
+}
+
+func TestDocumentSymbols(t *testing.T) {
+	c := newClient(t)
+	c.open(testURI, testSrc)
+	c.diagnostics()
+	var symbols []DocumentSymbol
+	c.request("textDocument/documentSymbol",
+		map[string]interface{}{"textDocument": map[string]string{"uri": testURI}}, &symbols)
+	var got []string
+	for _, s := range symbols {
+		got = append(got, s.Name+" "+s.Detail)
+	}
+	want := []string{"M local", "count local", "M.add function M.add(a, b, ...)",
+		"M:get function M:get(key)", "helper function helper(x)"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want=%q, got=%q", want, got)
+	}
+	if s := symbols[3]; s.Kind != SymbolMethod || s.SelectionRange != (Range{Position{6, 9}, Position{6, 14}}) {
+		t.Errorf("unexpected symbol: %+v", s)
+	}
+	c.shutdown()
+}
+
+func TestDefinitionReferences(t *testing.T) {
+	c := newClient(t)
+	c.open(testURI, testSrc)
+	c.diagnostics()
+
+	var loc *Location
+	c.request("textDocument/definition", positionParams(4, 29), &loc)
+	if want := (Location{testURI, Range{Position{1, 6}, Position{1, 11}}}); loc == nil || *loc != want {
+		t.Errorf("want=%v, got=%v", want, loc)
+	}
+	c.request("textDocument/definition", positionParams(7, 35), &loc)
+	if want := (Location{testURI, Range{Position{2, 11}, Position{2, 14}}}); loc == nil || *loc != want {
+		t.Errorf("want=%v, got=%v", want, loc)
+	}
+	c.request("textDocument/definition", positionParams(5, 1), &loc)
+	if loc != nil {
+		t.Errorf("unexpected definition: %v", loc)
+	}
+
+	params := positionParams(3, 4)
+	params["context"] = map[string]bool{"includeDeclaration": true}
+	var locs []Location
+	c.request("textDocument/references", params, &locs)
+	want := []Location{
+		{testURI, Range{Position{1, 6}, Position{1, 11}}},
+		{testURI, Range{Position{3, 2}, Position{3, 7}}},
+		{testURI, Range{Position{3, 10}, Position{3, 15}}},
+		{testURI, Range{Position{4, 27}, Position{4, 32}}},
+	}
+	if !reflect.DeepEqual(locs, want) {
+		t.Errorf("want=%v, got=%v", want, locs)
+	}
+	params["context"] = map[string]bool{"includeDeclaration": false}
+	c.request("textDocument/references", params, &locs)
+	if !reflect.DeepEqual(locs, want[1:]) {
+		t.Errorf("want=%v, got=%v", want[1:], locs)
+	}
+	c.shutdown()
+}
+
+func TestHover(t *testing.T) {
+	c := newClient(t)
+	c.open(testURI, testSrc)
+	c.diagnostics()
+	for _, test := range []struct {
+		line, char int
+		want       string
+	}{
+		{7, 35, "function M.add(a, b, ...)"},
+		{6, 11, "function M:get(key)"},
+		{8, 7, "local function helper(x)"},
+		{8, 25, "(library function) string.upper"},
+		{8, 19, "(library) string"},
+		{6, 28, "self"},
+		{3, 18, "parameter a"},
+		{0, 6, "local M"},
+		{8, 1, "(library function) print"},
+	} {
+		var hover *Hover
+		c.request("textDocument/hover", positionParams(test.line, test.char), &hover)
+		if want := "```lua\n" + test.want + "\n```"; hover == nil || hover.Contents.Value != want {
+			t.Errorf("%d:%d: want=%q, got=%+v", test.line, test.char, want, hover)
+		}
+	}
+	var hover *Hover
+	c.request("textDocument/hover", positionParams(5, 1), &hover)
+	if hover != nil {
+		t.Errorf("unexpected hover: %+v", hover)
+	}
+	c.shutdown()
+}
+
+func TestCompletion(t *testing.T) {
+	c := newClient(t)
+	c.open(testURI, "local value = 1\nlocal function f(alpha)\n  return al\nend\nstring.up\nM.\nfunction M.run() end\n")
+	c.diagnostics()
+	for _, test := range []struct {
+		line, char int
+		want       []string
+	}{
+		{2, 11, []string{"alpha"}},
+		{2, 10, []string{"alpha", "assert"}},
+		{4, 9, []string{"upper"}},
+		{5, 2, []string{"run"}},
+	} {
+		var items []CompletionItem
+		c.request("textDocument/completion", positionParams(test.line, test.char), &items)
+		var got []string
+		for _, item := range items {
+			got = append(got, item.Label)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%d:%d: want=%q, got=%q", test.line, test.char, test.want, got)
+		}
+	}
+
+	var items []CompletionItem
+	c.request("textDocument/completion", positionParams(4, 0), &items)
+	labels := map[string]int{}
+	for _, item := range items {
+		labels[item.Label] = item.Kind
+	}
+	if labels["value"] != CompletionVariable || labels["f"] != CompletionFunction ||
+		labels["print"] != CompletionFunction || labels["string"] != CompletionModule {
+		t.Errorf("unexpected completion: %v", items)
+	}
+	if _, ok := labels["alpha"]; ok {
+		t.Errorf("parameter completed out of function: %v", items)
+	}
+	c.shutdown()
+}
+
+func TestRename(t *testing.T) {
+	c := newClient(t)
+	c.open(testURI, testSrc)
+	c.diagnostics()
+	params := positionParams(4, 30)
+	params["newName"] = "total"
+	var edit WorkspaceEdit
+	c.request("textDocument/rename", params, &edit)
+	var got []Range
+	for _, e := range edit.Changes[testURI] {
+		if e.NewText != "total" {
+			t.Errorf("unexpected edit: %v", e)
+		}
+		got = append(got, e.Range)
+	}
+	want := []Range{
+		{Position{1, 6}, Position{1, 11}},
+		{Position{3, 2}, Position{3, 7}},
+		{Position{3, 10}, Position{3, 15}},
+		{Position{4, 27}, Position{4, 32}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want=%v, got=%v", want, got)
+	}
+
+	params = positionParams(8, 1)
+	params["newName"] = "echo"
+	if err := c.requestError("textDocument/rename", params); err == nil || err.Code != CodeInvalidParams {
+		t.Errorf("unexpected error: %v", err)
+	}
+	params = positionParams(4, 30)
+	params["newName"] = "end"
+	if err := c.requestError("textDocument/rename", params); err == nil || err.Code != CodeInvalidParams {
+		t.Errorf("unexpected error: %v", err)
+	}
+	c.shutdown()
+}
+
+func TestRenameCapture(t *testing.T) {
+	const src = `local x = 1
+print(x)
+local function f(y)
+  local z = 2
+  do local y = 3 end
+  return x + y + z
+end
+local w = 4
+`
+	for _, test := range []struct {
+		line, char int
+		newName    string
+		err        string // empty if the rename is allowed
+	}{
+		{0, 6, "print", "cannot rename to 'print', line 2 uses the global 'print'"},
+		{0, 6, "_ENV", "cannot rename to '_ENV', line 2 uses the global 'print'"},
+		{3, 8, "x", "cannot rename to 'x', line 6 uses the local 'x'"},
+		{0, 6, "z", "cannot rename to 'z', line 6 would refer to the local 'z' instead"},
+		{0, 6, "f", "cannot rename to 'f', line 6 would refer to the local function 'f' instead"},
+		{0, 6, "v", ""},
+		{2, 17, "w", ""}, // w isn't visible in f
+		{4, 11, "z", ""}, // the inner y isn't used
+		{3, 8, "y", "cannot rename to 'y', line 6 uses the parameter 'y'"},
+	} {
+		c := newClient(t)
+		c.open(testURI, src)
+		c.diagnostics()
+		params := positionParams(test.line, test.char)
+		params["newName"] = test.newName
+		if test.err == "" {
+			var edit WorkspaceEdit
+			c.request("textDocument/rename", params, &edit)
+			if len(edit.Changes[testURI]) == 0 {
+				t.Errorf("%d:%d to %s: no edits", test.line, test.char, test.newName)
+			}
+		} else if err := c.requestError("textDocument/rename", params); err == nil ||
+			err.Code != CodeInvalidParams || err.Message != test.err {
+			t.Errorf("%d:%d to %s: want %q, got %v", test.line, test.char, test.newName, test.err, err)
+		}
+		c.shutdown()
+	}
+}
+
+func TestPosition(t *testing.T) {
+	d := newDocument(testURI, 1, "a = 'é\U0001F600'\r\nb\rc")
+	for _, test := range []struct {
+		offset int
+		pos    Position
+	}{
+		{0, Position{0, 0}},
+		{5, Position{0, 5}},
+		{7, Position{0, 6}},
+		{11, Position{0, 8}},
+		{12, Position{0, 9}},
+		{14, Position{1, 0}},
+		{16, Position{2, 0}},
+	} {
+		if pos := d.position(test.offset); pos != test.pos {
+			t.Errorf("position(%d): want=%v, got=%v", test.offset, test.pos, pos)
+		}
+		if offset := d.offset(test.pos); offset != test.offset {
+			t.Errorf("offset(%v): want=%d, got=%d", test.pos, test.offset, offset)
+		}
+	}
+	if offset := d.offset(Position{0, 100}); offset != 12 {
+		t.Errorf("offset beyond line: %d", offset)
+	}
+}
+
+// client is a scripted client talking to a server over pipes
+type client struct {
+	t     *testing.T
+	in    *io.PipeWriter // stdin of server
+	msgs  chan *message  // messages from stdout of server
+	done  chan error     // result of Serve
+	id    int
+	notes []*message // notifications received
+}
+
+func newClient(t *testing.T) *client {
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	c := &client{t: t, in: inW, msgs: make(chan *message, 16), done: make(chan error, 1)}
+	go func() {
+		c.done <- NewServer(inR, outW).Serve()
+		outW.Close()
+	}()
+	go func() {
+		r := bufio.NewReader(outR)
+		for {
+			msg, err := readMessage(r)
+			if err != nil {
+				close(c.msgs)
+				return
+			}
+			c.msgs <- msg
+		}
+	}()
+	return c
+}
+
+func (c *client) send(msg *message) {
+	c.t.Helper()
+	if err := writeMessage(c.in, msg); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *client) notify(method string, params interface{}) {
+	c.t.Helper()
+	data, _ := json.Marshal(params)
+	c.send(&message{Method: method, Params: data})
+}
+
+// sends the request and waits for its response, the notifications
+// received before are kept
+func (c *client) call(method string, params interface{}) *message {
+	c.t.Helper()
+	c.id++
+	id := json.RawMessage(strconv.Itoa(c.id))
+	var data json.RawMessage
+	if params != nil {
+		data, _ = json.Marshal(params)
+	}
+	c.send(&message{ID: &id, Method: method, Params: data})
+	for msg := range c.msgs {
+		if msg.ID == nil {
+			c.notes = append(c.notes, msg)
+		} else if string(*msg.ID) == string(id) {
+			return msg
+		}
+	}
+	c.t.Fatalf("%s: no response", method)
+	return nil
+}
+
+func (c *client) request(method string, params, result interface{}) {
+	c.t.Helper()
+	msg := c.call(method, params)
+	if msg.Error != nil {
+		c.t.Fatalf("%s: %v", method, msg.Error)
+	}
+	if err := json.Unmarshal(msg.Result, result); err != nil {
+		c.t.Fatalf("%s: %v", method, err)
+	}
+}
+
+func (c *client) requestError(method string, params interface{}) *Error {
+	c.t.Helper()
+	return c.call(method, params).Error
+}
+
+func (c *client) open(uri, text string) {
+	c.t.Helper()
+	c.notify("textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri, "languageId": "lua", "version": 1, "text": text},
+	})
+}
+
+// returns the diagnostics of the next publishDiagnostics notification
+func (c *client) diagnostics() []Diagnostic {
+	c.t.Helper()
+	var msg *message
+	if len(c.notes) > 0 {
+		msg, c.notes = c.notes[0], c.notes[1:]
+	} else if msg = <-c.msgs; msg == nil {
+		c.t.Fatal("no diagnostics")
+	}
+	if msg.Method != "textDocument/publishDiagnostics" {
+		c.t.Fatalf("unexpected notification: %s", msg.Method)
+	}
+	var params publishDiagnosticsParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		c.t.Fatal(err)
+	}
+	return params.Diagnostics
+}
+
+func (c *client) exit() error {
+	c.t.Helper()
+	c.notify("exit", nil)
+	return <-c.done
+}
+
+func (c *client) shutdown() {
+	c.t.Helper()
+	var null interface{}
+	c.request("shutdown", nil, &null)
+	if err := c.exit(); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func positionParams(line, char int) map[string]interface{} {
+	return map[string]interface{}{
+		"textDocument": map[string]string{"uri": testURI},
+		"position":     Position{line, char},
+	}
+}
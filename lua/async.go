@@ -0,0 +1,204 @@
+package lua
+
+import (
+	"fmt"
+	"luago/api"
+)
+
+// registry key of the table of the threads run by loops, thread => true
+const tasksKey = "_TASKS"
+
+// pending is the Go function run asynchronously by Await
+type pending struct {
+	fn      func() ([]interface{}, error)
+	results []interface{}
+	err     error
+}
+
+// Await returns the results of fn to Lua like the results of a Go
+// function, its error is raised. In a task of Loop, the task is suspended
+// until fn returns on a goroutine of its own, meanwhile the other tasks
+// run; elsewhere fn is called at once. A Go function returns the result
+// of Await:
+//
+//	L.Register("query", func(ls api.ILuaState) int {
+//		q := ls.CheckString(1)
+//		return lua.Await(ls, func() ([]interface{}, error) {
+//			rows, err := db.Query(q)
+//			return []interface{}{rows}, err
+//		})
+//	})
+func Await(ls api.ILuaState, fn func() ([]interface{}, error)) int {
+	p := &pending{fn: fn}
+	if isTask(ls) {
+		ls.NewUserData(p)
+		ls.Yield(1) // resumed by the loop when fn returns
+	} else {
+		p.results, p.err = fn()
+	}
+	if p.err != nil {
+		return ls.Error2("%s", p.err)
+	}
+	ls.CheckStack2(len(p.results), "too many results")
+	for _, v := range p.results {
+		pushValue(ls, v)
+	}
+	return len(p.results)
+}
+
+// reports whether ls is the thread of a task
+func isTask(ls api.ILuaState) bool {
+	if !ls.IsYieldable() {
+		return false
+	}
+	ls.GetField(api.LuaRegistryIndex, tasksKey)
+	ls.PushThread()
+	isTask := ls.Type(-2) == api.LuaTTable && ls.RawGet(-2) != api.LuaTNil
+	ls.SetTop(ls.GetTop() - 2)
+	return isTask
+}
+
+// Loop runs Lua functions as tasks in coroutines of a State, a task
+// calling Await is suspended until the Go function returns, so many
+// tasks interleave in the goroutine calling Run; it's not safe for
+// concurrent use, but Go functions of tasks may spawn tasks
+type Loop struct {
+	s       *State
+	ready   []*Task    // tasks to be resumed
+	waiting int        // count of tasks waiting for Await
+	awoken  chan *Task // tasks whose functions of Await returned
+}
+
+// Task is a function run by Loop
+type Task struct {
+	Results []interface{} // the results of the function, see ToGo
+	Err     error         // the error of the function, *Error if it's raised
+
+	name   string
+	thread api.ILuaState
+	nArgs  int // count of values passed to the next resume
+	done   bool
+}
+
+// NewLoop returns a loop running tasks in s
+func (s *State) NewLoop() *Loop {
+	return &Loop{s: s, awoken: make(chan *Task)}
+}
+
+// Spawn adds the task calling the global function name with args,
+// it runs when Run is called
+func (l *Loop) Spawn(name string, args ...interface{}) *Task {
+	t := l.newTask(name)
+	t.thread.GetGlobal(name)
+	t.thread.CheckStack2(len(args), "too many arguments")
+	for _, arg := range args {
+		pushValue(t.thread, arg)
+	}
+	t.nArgs = len(args)
+	return t
+}
+
+// SpawnString adds the task running the chunk src,
+// it runs when Run is called
+func (l *Loop) SpawnString(src string) *Task {
+	t := l.newTask("chunk")
+	if status := t.thread.LoadString(src); status != api.LuaOk {
+		t.thread.XMove(l.s.ls, 1)
+		l.finish(t, l.s.popError(status))
+	}
+	return t
+}
+
+// makes the thread of a new task, keeps it in the table of tasks
+func (l *Loop) newTask(name string) *Task {
+	ls := l.s.ls
+	if ls.GetField(api.LuaRegistryIndex, tasksKey) != api.LuaTTable {
+		ls.Pop(1)
+		ls.CreateTable(0, 0)
+		ls.PushValue(-1)
+		ls.SetField(api.LuaRegistryIndex, tasksKey)
+	}
+	t := &Task{name: name, thread: ls.NewThread()}
+	ls.PushBoolean(true)
+	ls.RawSet(-3) // tasks[thread] = true
+	ls.Pop(1)
+	l.ready = append(l.ready, t)
+	return t
+}
+
+// Run runs the tasks until all of them are done,
+// including the tasks spawned meanwhile
+func (l *Loop) Run() {
+	for len(l.ready) > 0 || l.waiting > 0 {
+		if len(l.ready) == 0 {
+			t := <-l.awoken
+			l.waiting--
+			l.ready = append(l.ready, t)
+			continue
+		}
+		t := l.ready[0]
+		l.ready = l.ready[1:]
+		if !t.done {
+			l.resume(t)
+		}
+	}
+}
+
+// resumes the task until it yields or returns
+func (l *Loop) resume(t *Task) {
+	co := t.thread
+	status, n := co.Resume(l.s.ls, t.nArgs)
+	t.nArgs = 0
+	switch status {
+	case api.LuaYield:
+		p, ok := co.ToUserData(-1).(*pending)
+		co.Pop(n)
+		if n == 1 && ok {
+			l.waiting++
+			go func() {
+				p.results, p.err = p.fn()
+				l.awoken <- t
+			}()
+		} else {
+			l.ready = append(l.ready, t) // yields to the other tasks
+		}
+	case api.LuaOk:
+		results := make([]interface{}, n)
+		var err error
+		for i := range results {
+			if results[i], err = ToGo(co, co.GetTop()-n+1+i); err != nil {
+				err = fmt.Errorf("bad result #%d from '%s': %w", i+1, t.name, err)
+				break
+			}
+		}
+		co.Pop(n)
+		t.Results = results
+		l.finish(t, err)
+	default:
+		co.XMove(l.s.ls, 1)
+		l.finish(t, l.s.popError(status))
+	}
+}
+
+// marks the task done and forgets its thread
+func (l *Loop) finish(t *Task, err error) {
+	ls := l.s.ls
+	t.Err, t.done = err, true
+	ls.GetField(api.LuaRegistryIndex, tasksKey)
+	pushThread(ls, t.thread)
+	ls.PushNil()
+	ls.RawSet(-3) // tasks[thread] = nil
+	ls.Pop(1)
+	t.thread = nil
+}
+
+// pushes the thread co onto ls
+func pushThread(ls, co api.ILuaState) {
+	co.PushThread()
+	co.XMove(ls, 1)
+}
+
+// Done reports whether the task is done
+func (t *Task) Done() bool {
+	return t.done
+}
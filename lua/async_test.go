@@ -0,0 +1,67 @@
+package lua
+
+import (
+	"errors"
+	"luago/api"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoop(t *testing.T) {
+	L := New()
+	var order []string
+	L.Register("log", func(s string) { order = append(order, s) })
+	L.Register("sleep", func(ls api.ILuaState) int {
+		ms := ls.CheckInteger(1)
+		return Await(ls, func() ([]interface{}, error) {
+			time.Sleep(time.Duration(ms) * time.Millisecond)
+			return []interface{}{ms}, nil
+		})
+	})
+	L.Register("fail", func(ls api.ILuaState) int {
+		return Await(ls, func() ([]interface{}, error) {
+			return nil, errors.New("query failed")
+		})
+	})
+	doString(t, L, `
+		function job(name, ms)
+			log(name .. " start")
+			local slept = sleep(ms)
+			log(name .. " end")
+			return name, slept
+		end
+	`)
+
+	loop := L.NewLoop()
+	slow := loop.Spawn("job", "slow", 40)
+	fast := loop.Spawn("job", "fast", 1)
+	bad := loop.SpawnString(`
+		local ok, err = pcall(fail)
+		assert(not ok and err:find("query failed"))
+		coroutine.yield() -- lets other tasks run
+		fail()
+	`)
+	syntax := loop.SpawnString(`return +`)
+	loop.Run()
+
+	want := "slow start,fast start,fast end,slow end"
+	if got := strings.Join(order, ","); got != want {
+		t.Errorf("order = %s", got)
+	}
+	if !slow.Done() || slow.Err != nil || slow.Results[0] != "slow" || slow.Results[1] != int64(40) {
+		t.Errorf("slow = %v, %v", slow.Results, slow.Err)
+	}
+	if fast.Err != nil || fast.Results[0] != "fast" {
+		t.Errorf("fast = %v, %v", fast.Results, fast.Err)
+	}
+	if bad.Err == nil || !strings.Contains(bad.Err.Error(), "query failed") {
+		t.Errorf("bad = %v", bad.Err)
+	}
+	if !IsSyntaxError(syntax.Err) {
+		t.Errorf("syntax = %v", syntax.Err)
+	}
+
+	// out of loops, Await calls the function at once
+	doString(t, L, `assert(sleep(1) == 1)`)
+}
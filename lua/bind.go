@@ -0,0 +1,549 @@
+package lua
+
+import (
+	"fmt"
+	"luago/api"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// goValueMeta is the name of the metatable of Go values wrapped as userdata
+const goValueMeta = "GoValue"
+
+var goValueFuncs api.FuncReg
+
+func init() {
+	// set here, the metamethods push Go values as well
+	goValueFuncs = api.FuncReg{
+		"__call":     goCall,
+		"__eq":       goEq,
+		"__index":    goIndex,
+		"__len":      goLen,
+		"__newindex": goNewIndex,
+		"__pairs":    goPairs,
+		"__tostring": goToString,
+	}
+}
+
+// pushGoValue pushes v as userdata with the metatable of Go values:
+//
+//	s.Field, s:Method(...)     exported fields (or the name in `lua:"name"`
+//	                           tag, "-" hides the field) and methods
+//	a[i], #a                   elements of slices and arrays, i starts from 1
+//	m[k], #m                   elements of maps, m[k] = nil deletes k
+//	ch:send(v), ch:receive()   send to and receive from channels, ch:close()
+//	f(...)                     calls functions
+//	p:deref()                  the value p points to, fields, elements and
+//	                           methods of p are reached through p as well,
+//	                           deref hides the field named deref of them
+//	pairs(v)                   iterates fields, elements of slices and maps
+//
+// the userdata holds v itself, so v keeps its type when it comes back to Go
+func pushGoValue(ls api.ILuaState, v interface{}) {
+	ls.NewUserData(v)
+	if ls.NewMetatable(goValueMeta) {
+		ls.SetFuncs(goValueFuncs, 0)
+		ls.PushBoolean(false) // it's shared by all chunks
+		ls.SetField(-2, "__metatable")
+	}
+	ls.SetMetaTable(-2)
+}
+
+func checkGoValue(ls api.ILuaState, arg int) reflect.Value {
+	return reflect.ValueOf(ls.CheckUData(arg, goValueMeta))
+}
+
+// pushReflect pushes the value held by v
+func pushReflect(ls api.ILuaState, v reflect.Value) {
+	if !v.IsValid() {
+		ls.PushNil()
+	} else {
+		pushValue(ls, v.Interface())
+	}
+}
+
+// indirect returns the value v points to, raises an error if v is nil
+func indirect(ls api.ILuaState, v reflect.Value) reflect.Value {
+	if v.Kind() != reflect.Ptr {
+		return v
+	}
+	if v.IsNil() {
+		ls.Error2("attempt to index a nil pointer (%s)", v.Type())
+	}
+	return v.Elem()
+}
+
+// field describes an exported field of struct
+type field struct {
+	name      string
+	index     []int
+	embedded  bool // untagged embedded struct, its fields are promoted
+	omitEmpty bool // "omitempty" option of tag
+}
+
+var fieldCache sync.Map // reflect.Type => []field
+
+// fields returns the exported fields of struct type t, including the
+// fields promoted from embedded structs, in the order of declaration
+func fields(t reflect.Type) []field {
+	if fs, ok := fieldCache.Load(t); ok {
+		return fs.([]field)
+	}
+	var fs []field
+	for _, f := range reflect.VisibleFields(t) {
+		if !f.IsExported() {
+			continue
+		}
+		fd := field{name: f.Name, index: f.Index}
+		tag, tagged := f.Tag.Lookup("lua")
+		if tagged {
+			opts := strings.Split(tag, ",")
+			if opts[0] == "-" {
+				continue
+			} else if opts[0] != "" {
+				fd.name = opts[0]
+			}
+			for _, opt := range opts[1:] {
+				fd.omitEmpty = fd.omitEmpty || opt == "omitempty"
+			}
+		}
+		if f.Anonymous && (!tagged || tag == "" || tag[0] == ',') {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			fd.embedded = ft.Kind() == reflect.Struct
+		}
+		fs = append(fs, fd)
+	}
+	fieldCache.Store(t, fs)
+	return fs
+}
+
+// fieldByName returns the field name of struct v, false if there is no
+// such field, raises an error if the field is in a nil embedded pointer
+func fieldByName(ls api.ILuaState, v reflect.Value, name string) (reflect.Value, bool) {
+	for _, f := range fields(v.Type()) {
+		if f.name == name {
+			fv, err := v.FieldByIndexErr(f.index)
+			if err != nil {
+				ls.Error2("cannot access field '%s' (%s)", name, err)
+			}
+			return fv, true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+func goIndex(ls api.ILuaState) int {
+	v := checkGoValue(ls, 1)
+	name, isName := "", ls.Type(2) == api.LuaTString
+	if isName {
+		name = ls.ToString(2)
+		if v.MethodByName(name).IsValid() {
+			pushMethod(ls, name)
+			return 1
+		}
+		if name == "deref" && v.Kind() == reflect.Ptr {
+			ls.PushGoFunction(goDeref)
+			return 1
+		}
+	}
+
+	e := indirect(ls, v)
+	switch e.Kind() {
+	case reflect.Struct:
+		if isName {
+			if f, ok := fieldByName(ls, e, name); ok {
+				pushReflect(ls, f)
+				return 1
+			}
+		}
+		return ls.Error2("%s has no field or method '%s'", v.Type(), ls.ToStringMeta(2))
+	case reflect.Slice, reflect.Array:
+		i, ok := ls.ToIntegerX(2)
+		if !ok {
+			return ls.Error2("%s index must be an integer, got %s", v.Type(), ls.TypeName2(2))
+		}
+		if i >= 1 && i <= int64(e.Len()) {
+			pushReflect(ls, e.Index(int(i-1)))
+		} else {
+			ls.PushNil()
+		}
+		return 1
+	case reflect.Map:
+		key, err := toGoValue(ls, 2, e.Type().Key())
+		if err != nil {
+			ls.PushNil() // no such key
+		} else {
+			pushReflect(ls, e.MapIndex(key))
+		}
+		return 1
+	case reflect.Chan:
+		if f := chanMethod(name); f != nil && isName {
+			ls.PushGoFunction(f)
+			return 1
+		}
+	}
+	return ls.Error2("%s has no method '%s'", v.Type(), ls.ToStringMeta(2))
+}
+
+// pushes the function calling method name of the receiver at 1
+func pushMethod(ls api.ILuaState, name string) {
+	ls.PushGoFunction(func(ls api.ILuaState) int {
+		m := checkGoValue(ls, 1).MethodByName(name)
+		if !m.IsValid() {
+			return ls.ArgError(1, fmt.Sprintf("%s has no method '%s'",
+				reflect.TypeOf(ls.ToUserData(1)), name))
+		}
+		return callFunc(ls, m, 2)
+	})
+}
+
+func goNewIndex(ls api.ILuaState) int {
+	v := checkGoValue(ls, 1)
+	e := indirect(ls, v)
+	switch e.Kind() {
+	case reflect.Struct:
+		name := ls.ToStringMeta(2)
+		ls.Pop(1)
+		f, ok := reflect.Value{}, false
+		if ls.Type(2) == api.LuaTString {
+			f, ok = fieldByName(ls, e, name)
+		}
+		if !ok {
+			return ls.Error2("%s has no field '%s'", v.Type(), name)
+		}
+		if !f.CanSet() {
+			return ls.Error2("cannot assign to field '%s' of %s (not addressable, use a pointer)",
+				name, v.Type())
+		}
+		setReflect(ls, f, 3, "field '"+name+"'")
+	case reflect.Slice, reflect.Array:
+		i, ok := ls.ToIntegerX(2)
+		if !ok {
+			return ls.Error2("%s index must be an integer, got %s", v.Type(), ls.TypeName2(2))
+		}
+		if i < 1 || i > int64(e.Len()) {
+			return ls.Error2("index %d out of range [1, %d]", i, e.Len())
+		}
+		elem := e.Index(int(i - 1))
+		if !elem.CanSet() {
+			return ls.Error2("cannot assign to element of %s (not addressable, use a pointer)", v.Type())
+		}
+		setReflect(ls, elem, 3, fmt.Sprintf("index %d", i))
+	case reflect.Map:
+		if e.IsNil() {
+			return ls.Error2("assignment to entry in nil map (%s)", v.Type())
+		}
+		key, err := toGoValue(ls, 2, e.Type().Key())
+		if err != nil {
+			return ls.Error2("invalid map key (%s)", err)
+		}
+		if ls.IsNil(3) {
+			e.SetMapIndex(key, reflect.Value{}) // delete the key
+			break
+		}
+		val, err := toGoValue(ls, 3, e.Type().Elem())
+		if err != nil {
+			return ls.Error2("invalid map value (%s)", err)
+		}
+		e.SetMapIndex(key, val)
+	default:
+		return ls.Error2("cannot assign to an index of %s", v.Type())
+	}
+	return 0
+}
+
+// sets v to the value at idx, what describes v in the message of error
+func setReflect(ls api.ILuaState, v reflect.Value, idx int, what string) {
+	val, err := toGoValue(ls, idx, v.Type())
+	if err != nil {
+		ls.Error2("cannot assign to %s (%s)", what, err)
+	}
+	v.Set(val)
+}
+
+// returns the method name of channels, nil if there is no such method
+func chanMethod(name string) api.GoFunction {
+	switch name {
+	case "send":
+		return chanSend
+	case "receive":
+		return chanReceive
+	case "close":
+		return chanClose
+	}
+	return nil
+}
+
+func checkChan(ls api.ILuaState, dir reflect.ChanDir) reflect.Value {
+	ch := checkGoValue(ls, 1)
+	if ch.Kind() != reflect.Chan {
+		ls.ArgError(1, fmt.Sprintf("channel expected, got %s", ch.Type()))
+	}
+	if ch.Type().ChanDir()&dir == 0 {
+		ls.ArgError(1, fmt.Sprintf("invalid operation on %s", ch.Type()))
+	}
+	return ch
+}
+
+// ch:send (v)
+func chanSend(ls api.ILuaState) int {
+	ch := checkChan(ls, reflect.SendDir)
+	val, err := toGoValue(ls, 2, ch.Type().Elem())
+	if err != nil {
+		return ls.ArgError(2, err.Error())
+	}
+	ch.Send(val)
+	return 0
+}
+
+// ch:receive () returns the value and whether it's sent before the channel closed
+func chanReceive(ls api.ILuaState) int {
+	ch := checkChan(ls, reflect.RecvDir)
+	val, ok := ch.Recv()
+	pushReflect(ls, val)
+	ls.PushBoolean(ok)
+	return 2
+}
+
+// ch:close ()
+func chanClose(ls api.ILuaState) int {
+	checkChan(ls, reflect.SendDir).Close()
+	return 0
+}
+
+func goCall(ls api.ILuaState) int {
+	fn := checkGoValue(ls, 1)
+	if fn.Kind() != reflect.Func {
+		return ls.Error2("attempt to call a %s value", fn.Type())
+	}
+	if fn.IsNil() {
+		return ls.Error2("attempt to call a nil function (%s)", fn.Type())
+	}
+	ls.Remove(1) // arguments start from 1
+	return callFunc(ls, fn, 1)
+}
+
+func goLen(ls api.ILuaState) int {
+	v := checkGoValue(ls, 1)
+	switch e := indirect(ls, v); e.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map, reflect.Chan, reflect.String:
+		ls.PushInteger(int64(e.Len()))
+		return 1
+	}
+	return ls.Error2("attempt to get length of %s", v.Type())
+}
+
+func goPairs(ls api.ILuaState) int {
+	v := checkGoValue(ls, 1)
+	var next api.GoFunction
+	switch e := indirect(ls, v); e.Kind() {
+	case reflect.Map:
+		iter := e.MapRange()
+		next = func(ls api.ILuaState) int {
+			if !iter.Next() {
+				return 0
+			}
+			pushReflect(ls, iter.Key())
+			pushReflect(ls, iter.Value())
+			return 2
+		}
+	case reflect.Slice, reflect.Array:
+		i := 0
+		next = func(ls api.ILuaState) int {
+			if i >= e.Len() {
+				return 0
+			}
+			i++
+			ls.PushInteger(int64(i))
+			pushReflect(ls, e.Index(i-1))
+			return 2
+		}
+	case reflect.Struct:
+		fs, i := fields(e.Type()), 0
+		next = func(ls api.ILuaState) int {
+			for ; i < len(fs); i++ {
+				if fv, err := e.FieldByIndexErr(fs[i].index); err == nil {
+					ls.PushString(fs[i].name)
+					pushReflect(ls, fv)
+					i++
+					return 2
+				}
+			}
+			return 0
+		}
+	default:
+		return ls.Error2("attempt to iterate %s", v.Type())
+	}
+	ls.PushGoFunction(next)
+	ls.PushValue(1)
+	ls.PushNil()
+	return 3
+}
+
+func goEq(ls api.ILuaState) int {
+	a, b := ls.ToUserData(1), ls.ToUserData(2)
+	t := reflect.TypeOf(a)
+	ls.PushBoolean(t == reflect.TypeOf(b) && t.Comparable() && a == b)
+	return 1
+}
+
+func goToString(ls api.ILuaState) int {
+	ls.PushString(fmt.Sprint(ls.CheckUData(1, goValueMeta)))
+	return 1
+}
+
+// p:deref ()
+func goDeref(ls api.ILuaState) int {
+	p := checkGoValue(ls, 1)
+	if p.Kind() != reflect.Ptr {
+		return ls.ArgError(1, fmt.Sprintf("pointer expected, got %s", p.Type()))
+	}
+	if p.IsNil() {
+		return ls.Error2("attempt to dereference a nil pointer (%s)", p.Type())
+	}
+	pushReflect(ls, p.Elem())
+	return 1
+}
+
+var goFunctionType = reflect.TypeOf(api.GoFunction(nil))
+
+// toGoValue converts the Lua value at idx to type t, the userdata of Go
+// values gives its value back, numbers and strings are coerced like Lua,
+// tables are decoded to structs, slices, arrays, maps and pointers to
+// them by Decode
+func toGoValue(ls api.ILuaState, idx int, t reflect.Type) (reflect.Value, error) {
+	if ls.IsUserData(idx) {
+		v := reflect.ValueOf(ls.ToUserData(idx))
+		switch {
+		case !v.IsValid():
+		case v.Type().AssignableTo(t):
+			return v, nil
+		case v.Kind() == reflect.Ptr && !v.IsNil() && v.Elem().Type().AssignableTo(t):
+			return v.Elem(), nil // dereference pointer
+		}
+		return reflect.Value{}, fmt.Errorf("%s expected, got %s", typeName(t), goTypeName(ls, idx))
+	}
+
+	rv := reflect.New(t).Elem()
+	if ls.Type(idx) == api.LuaTTable {
+		switch t.Kind() {
+		case reflect.Struct, reflect.Slice, reflect.Array, reflect.Map, reflect.Ptr:
+			if err := newConverter(ls).decode(ls.AbsIndex(idx), rv, ""); err != nil {
+				return rv, err
+			}
+			return rv, nil
+		}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		rv.SetBool(ls.ToBoolean(idx))
+		return rv, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := toInteger(ls, idx)
+		if err == nil && rv.OverflowInt(n) {
+			err = fmt.Errorf("value out of range of %s", t)
+		}
+		if err != nil {
+			return rv, err
+		}
+		rv.SetInt(n)
+		return rv, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		n, err := toInteger(ls, idx)
+		if err == nil && (n < 0 || rv.OverflowUint(uint64(n))) {
+			err = fmt.Errorf("value out of range of %s", t)
+		}
+		if err != nil {
+			return rv, err
+		}
+		rv.SetUint(uint64(n))
+		return rv, nil
+	case reflect.Float32, reflect.Float64:
+		if n, ok := ls.ToNumberX(idx); ok {
+			rv.SetFloat(n)
+			return rv, nil
+		}
+	case reflect.String:
+		if s, ok := toString(ls, idx); ok {
+			rv.SetString(s)
+			return rv, nil
+		}
+	case reflect.Interface:
+		val, err := ToGo(ls, idx)
+		if err != nil {
+			return rv, err
+		}
+		if val == nil {
+			return rv, nil // nil interface
+		}
+		if v := reflect.ValueOf(val); v.Type().AssignableTo(t) {
+			return v, nil
+		}
+	case reflect.Slice:
+		if s, ok := toString(ls, idx); ok && t.Elem().Kind() == reflect.Uint8 {
+			rv.SetBytes([]byte(s))
+			return rv, nil
+		}
+		fallthrough
+	case reflect.Ptr, reflect.Map, reflect.Chan, reflect.Func:
+		if ls.IsNil(idx) {
+			return rv, nil // nil of t
+		}
+		if t == goFunctionType && ls.IsGoFunction(idx) {
+			return reflect.ValueOf(ls.ToGoFunction(idx)), nil
+		}
+	}
+	return rv, fmt.Errorf("%s expected, got %s", typeName(t), ls.TypeName2(idx))
+}
+
+// converts the value at idx to integer like CheckInteger
+func toInteger(ls api.ILuaState, idx int) (int64, error) {
+	if n, ok := ls.ToIntegerX(idx); ok {
+		return n, nil
+	}
+	if _, ok := ls.ToNumberX(idx); ok {
+		return 0, fmt.Errorf("number has no integer representation")
+	}
+	return 0, fmt.Errorf("number expected, got %s", ls.TypeName2(idx))
+}
+
+// converts strings and numbers to string without changing the value at idx
+func toString(ls api.ILuaState, idx int) (string, bool) {
+	switch ls.Type(idx) {
+	case api.LuaTString:
+		return ls.ToString(idx), true
+	case api.LuaTNumber:
+		s := ls.ToStringMeta(idx)
+		ls.Pop(1)
+		return s, true
+	}
+	return "", false
+}
+
+// returns the name of type t in the messages of error
+func typeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Uintptr, reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.String:
+		return "string"
+	}
+	return t.String()
+}
+
+// returns the type name of the value at idx, the type of Go value for userdata
+func goTypeName(ls api.ILuaState, idx int) string {
+	if data := ls.ToUserData(idx); data != nil {
+		return reflect.TypeOf(data).String()
+	}
+	return ls.TypeName2(idx)
+}
@@ -0,0 +1,186 @@
+package lua
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+type Base struct {
+	ID int
+}
+
+type Person struct {
+	Base
+	Name    string
+	Age     int `lua:"age"`
+	Tags    []string
+	Attrs   map[string]int
+	Secret  string `lua:"-"`
+	private int
+}
+
+func (p Person) Greet(greeting string) string {
+	return greeting + ", " + p.Name
+}
+
+func (p *Person) Birthday() {
+	p.Age++
+}
+
+func (p *Person) String() string {
+	return "Person(" + p.Name + ")"
+}
+
+func doString(t *testing.T, L *State, src string) {
+	t.Helper()
+	if err := L.DoString(src); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBindStruct(t *testing.T) {
+	L := New()
+	p := &Person{Base: Base{ID: 1}, Name: "Ann", Age: 30, Secret: "s"}
+	L.SetGlobal("p", p)
+	doString(t, L, `
+		assert(p.Name == "Ann" and p.age == 30 and p.ID == 1)
+		p.Name = "Bob"
+		p.age = 31.0
+		p.ID = 7
+		assert(p:Greet("Hi") == "Hi, Bob")
+		p:Birthday()
+		assert(tostring(p) == "Person(Bob)")
+		assert(not pcall(function() return p.Secret end))
+		assert(not pcall(function() return p.private end))
+		local ok, err = pcall(function() p.age = "x" end)
+		assert(not ok and err:find("cannot assign to field 'age' %(number expected, got string%)"))
+		ok, err = pcall(function() p.age = 1.5 end)
+		assert(not ok and err:find("number has no integer representation"))
+		local names = {}
+		for k, v in pairs(p) do names[#names + 1] = k end
+		assert(table.concat(names, ",") == "Base,ID,Name,age,Tags,Attrs")
+	`)
+	if p.Name != "Bob" || p.Age != 32 || p.ID != 7 {
+		t.Errorf("p = %+v", p)
+	}
+
+	// a struct value is a copy, its fields can't be assigned
+	L.SetGlobal("v", Person{Name: "Val"})
+	err := L.DoString(`v.Name = "x"`)
+	if err == nil || !strings.Contains(err.Error(), "not addressable") {
+		t.Errorf("err = %v", err)
+	}
+	doString(t, L, `assert(v:Greet("Yo") == "Yo, Val")`)
+	// methods with pointer receiver belong to the pointer
+	err = L.DoString(`v:Birthday()`)
+	if err == nil || !strings.Contains(err.Error(), "lua.Person has no field or method 'Birthday'") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestBindRoundTrip(t *testing.T) {
+	L := New()
+	p := &Person{Name: "Ann"}
+	L.SetGlobal("p", p)
+	L.SetGlobal("q", p) // another userdata of p
+	L.SetGlobal("r", &Person{Name: "Ann"})
+	L.SetGlobal("same", func(a, b *Person) bool { return a == b })
+	doString(t, L, `
+		function get() return p end
+		assert(same(p, q) and not same(p, r))
+		assert(p == q and p ~= r)
+	`)
+	results, err := L.CallGlobal("get")
+	if err != nil || results[0] != p {
+		t.Errorf("get = %#v, %v", results, err)
+	}
+}
+
+func TestBindSliceMap(t *testing.T) {
+	L := New()
+	s := []int{10, 20, 30}
+	m := map[string]int{"a": 1}
+	L.SetGlobal("s", s)
+	L.SetGlobal("m", m)
+	L.SetGlobal("arr", &[2]string{"x", "y"})
+	doString(t, L, `
+		assert(#s == 3 and s[1] == 10 and s[4] == nil)
+		s[2] = 21
+		local sum = 0
+		for i, v in pairs(s) do sum = sum + i * v end
+		assert(sum == 10 + 42 + 90)
+		assert(not pcall(function() s[4] = 1 end))
+
+		assert(m.a == 1 and m.b == nil and #m == 1)
+		m.b = 2
+		m.a = nil
+		assert(#m == 1 and m.b == 2)
+
+		assert(arr[2] == "y")
+		arr[1] = "z"
+	`)
+	if s[1] != 21 || m["b"] != 2 || len(m) != 1 {
+		t.Errorf("s = %v, m = %v", s, m)
+	}
+}
+
+func TestBindChanFuncPtr(t *testing.T) {
+	L := New()
+	ch := make(chan int, 1)
+	n := 5
+	L.SetGlobal("ch", ch)
+	L.SetGlobal("np", &n)
+	L.SetGlobal("add", func(a, b int) int { return a + b })
+	L.SetGlobal("split", func(s string) (string, string) {
+		parts := strings.SplitN(s, "=", 2)
+		return parts[0], parts[1]
+	})
+	doString(t, L, `
+		ch:send(1)
+		local v, ok = ch:receive()
+		assert(v == 1 and ok)
+		ch:send(2)
+		ch:close()
+		assert(ch:receive() == 2)
+		v, ok = ch:receive()
+		assert(v == 0 and not ok)
+
+		assert(np:deref() == 5)
+		assert(not pcall(function() return -np end))
+		assert(add(1, "2") == 3)
+		local k, val = split("a=b")
+		assert(k == "a" and val == "b")
+		local ok, err = pcall(function() return add(1, {}) end)
+		assert(not ok and err:find("bad argument #2 to 'add' %(number expected, got table%)"))
+	`)
+}
+
+func TestBindNil(t *testing.T) {
+	L := New()
+	var p *Person
+	L.SetGlobal("p", p)
+	err := L.DoString(`return p.Name`)
+	if err == nil || !strings.Contains(err.Error(), "attempt to index a nil pointer (*lua.Person)") {
+		t.Errorf("err = %v", err)
+	}
+	err = L.DoString(`return p:deref()`)
+	if err == nil || !strings.Contains(err.Error(), "attempt to dereference a nil pointer (*lua.Person)") {
+		t.Errorf("err = %v", err)
+	}
+	L.SetGlobal("e", fmt.Errorf("oops"))
+	doString(t, L, `assert(tostring(e) == "oops" and e:Error() == "oops")`)
+}
+
+func TestBindUnsigned(t *testing.T) {
+	L := New()
+	L.SetGlobal("small", uint64(math.MaxInt64))
+	L.SetGlobal("big", uint64(math.MaxUint64))
+	L.SetGlobal("u8", uint8(255))
+	doString(t, L, `
+		assert(math.type(small) == "integer" and small == math.maxinteger)
+		assert(math.type(big) == "float" and big == 2^64)
+		assert(math.type(u8) == "integer" and u8 == 255)
+	`)
+}
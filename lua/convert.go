@@ -0,0 +1,525 @@
+package lua
+
+import (
+	"fmt"
+	"luago/api"
+	"math"
+	"reflect"
+)
+
+// converter converts Lua values to Go values
+type converter struct {
+	ls       api.ILuaState
+	visiting map[interface{}]bool // tables being converted, to find cycles
+}
+
+func newConverter(ls api.ILuaState) *converter {
+	return &converter{ls: ls, visiting: map[interface{}]bool{}}
+}
+
+// ToGo converts the Lua value at idx deeply:
+//
+//	nil, booleans, strings   nil, bool, string
+//	integers, floats         int64, float64
+//	sequences                []interface{}
+//	other tables             map[interface{}]interface{}
+//	userdata                 the data held by userdata
+//	Go functions             api.GoFunction
+//
+// a table is a sequence if it's not empty and its keys are exactly 1..#t,
+// tables are read raw, that is their metatables are ignored; cyclic tables
+// and Lua functions can't be converted, the error is *ConvertError
+func ToGo(ls api.ILuaState, idx int) (interface{}, error) {
+	return newConverter(ls).toGo(ls.AbsIndex(idx), "")
+}
+
+func (c *converter) toGo(idx int, path string) (interface{}, error) {
+	ls := c.ls
+	switch ls.Type(idx) {
+	case api.LuaTNil, api.LuaTNone:
+		return nil, nil
+	case api.LuaTBoolean:
+		return ls.ToBoolean(idx), nil
+	case api.LuaTNumber:
+		if ls.IsInteger(idx) {
+			return ls.ToInteger(idx), nil
+		}
+		return ls.ToNumber(idx), nil
+	case api.LuaTString:
+		return ls.ToString(idx), nil
+	case api.LuaTUserData, api.LuaTLightUserData:
+		return ls.ToUserData(idx), nil
+	case api.LuaTTable:
+		return c.table(idx, path)
+	case api.LuaTFunction:
+		if ls.IsGoFunction(idx) {
+			return ls.ToGoFunction(idx), nil
+		}
+	}
+	return nil, &ConvertError{path, fmt.Sprintf("cannot convert a %s value", ls.TypeName2(idx))}
+}
+
+// enter marks the table at idx being converted, the returned function
+// must be called when it's done
+func (c *converter) enter(idx int, path string) (func(), error) {
+	p := c.ls.ToPointer(idx)
+	if c.visiting[p] {
+		return nil, &ConvertError{path, "cyclic table"}
+	}
+	if !c.ls.CheckStack(3) {
+		return nil, &ConvertError{path, "table nesting too deep"}
+	}
+	c.visiting[p] = true
+	return func() { delete(c.visiting, p) }, nil
+}
+
+func (c *converter) table(idx int, path string) (interface{}, error) {
+	leave, err := c.enter(idx, path)
+	if err != nil {
+		return nil, err
+	}
+	defer leave()
+
+	ls := c.ls
+	if n := int64(ls.RawLen(idx)); isSequence(ls, idx, n) {
+		s := make([]interface{}, n)
+		for i := range s {
+			ls.RawGetI(idx, int64(i+1))
+			s[i], err = c.toGo(ls.GetTop(), indexPath(path, i+1))
+			ls.Pop(1)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return s, nil
+	}
+
+	m := make(map[interface{}]interface{})
+	ls.PushNil()
+	for ls.Next(idx) {
+		top := ls.GetTop()
+		elemPath := keyPath(ls, path, top-1)
+		k, err := c.toGo(top-1, elemPath)
+		if err == nil && k != nil && !reflect.TypeOf(k).Comparable() {
+			err = &ConvertError{elemPath, fmt.Sprintf("cannot use %T as a key of map", k)}
+		}
+		if err == nil {
+			m[k], err = c.toGo(top, elemPath)
+		}
+		if err != nil {
+			ls.Pop(2) // pop key and value
+			return nil, err
+		}
+		ls.Pop(1) // pop value, keep key for next iteration
+	}
+	return m, nil
+}
+
+// reports whether the table at idx is not empty and its keys are 1..n
+func isSequence(ls api.ILuaState, idx int, n int64) bool {
+	if n == 0 {
+		return false
+	}
+	count := int64(0)
+	ls.PushNil()
+	for ls.Next(idx) {
+		count++
+		if k := ls.ToInteger(-2); !ls.IsInteger(-2) || k < 1 || k > n {
+			ls.Pop(2)
+			return false
+		}
+		ls.Pop(1)
+	}
+	return count == n
+}
+
+// Decode converts the Lua value at idx to the Go value v points to:
+//
+//	structs      tables, the keys are the names of exported fields or the
+//	             names in `lua:"name"` tags, "-" skips a field, the fields
+//	             of absent keys are unchanged
+//	slices       sequences, arrays as well if the sequence fits
+//	maps         tables, both keys and values are decoded
+//	pointers     allocated if they're nil, nil sets nil
+//	interfaces   the value converted by ToGo
+//	others       converted like the arguments of functions
+//
+// tables are read raw, the error is *ConvertError, its path locates
+// the value failed in the value at idx, like "servers[2].port"
+func Decode(ls api.ILuaState, idx int, v interface{}) error {
+	return decode(ls, idx, v, "")
+}
+
+func decode(ls api.ILuaState, idx int, v interface{}, path string) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return &ConvertError{path, fmt.Sprintf("cannot decode into non-pointer %T", v)}
+	}
+	return newConverter(ls).decode(ls.AbsIndex(idx), rv.Elem(), path)
+}
+
+// DecodeGlobal decodes the global name to the Go value v points to,
+// see Decode, the paths of errors start from name
+func (s *State) DecodeGlobal(name string, v interface{}) error {
+	s.ls.GetGlobal(name)
+	defer s.ls.Pop(1)
+	return decode(s.ls, -1, v, name)
+}
+
+func (c *converter) decode(idx int, v reflect.Value, path string) error {
+	ls, t := c.ls, v.Type()
+	if ls.IsUserData(idx) { // Go values come back as they are
+		return c.decodeScalar(idx, v, path)
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		if ls.IsNoneOrNil(idx) {
+			v.Set(reflect.Zero(t))
+			return nil
+		}
+		if v.IsNil() {
+			v.Set(reflect.New(t.Elem()))
+		}
+		return c.decode(idx, v.Elem(), path)
+	case reflect.Interface:
+		val, err := c.toGo(idx, path)
+		if err != nil {
+			return err
+		}
+		if val == nil {
+			v.Set(reflect.Zero(t))
+		} else if rv := reflect.ValueOf(val); rv.Type().AssignableTo(t) {
+			v.Set(rv)
+		} else {
+			return &ConvertError{path, fmt.Sprintf("cannot use %T as %s", val, t)}
+		}
+		return nil
+	case reflect.Struct:
+		return c.decodeStruct(idx, v, path)
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 && ls.Type(idx) == api.LuaTString {
+			return c.decodeScalar(idx, v, path) // []byte
+		}
+		fallthrough
+	case reflect.Array:
+		return c.decodeSequence(idx, v, path)
+	case reflect.Map:
+		return c.decodeMap(idx, v, path)
+	}
+	return c.decodeScalar(idx, v, path)
+}
+
+func (c *converter) decodeScalar(idx int, v reflect.Value, path string) error {
+	val, err := toGoValue(c.ls, idx, v.Type())
+	if err != nil {
+		return &ConvertError{path, err.Error()}
+	}
+	v.Set(val)
+	return nil
+}
+
+// checks the value at idx is a table, marks it being decoded
+func (c *converter) enterTable(idx int, t reflect.Type, path string) (func(), error) {
+	if c.ls.Type(idx) != api.LuaTTable {
+		return nil, &ConvertError{path, fmt.Sprintf("table expected for %s, got %s",
+			t, goTypeName(c.ls, idx))}
+	}
+	return c.enter(idx, path)
+}
+
+func (c *converter) decodeStruct(idx int, v reflect.Value, path string) error {
+	leave, err := c.enterTable(idx, v.Type(), path)
+	if err != nil {
+		return err
+	}
+	defer leave()
+
+	ls := c.ls
+	for _, f := range fields(v.Type()) {
+		ls.PushString(f.name)
+		if ls.RawGet(idx) == api.LuaTNil { // absent
+			ls.Pop(1)
+			continue
+		}
+		err := c.decode(ls.GetTop(), fieldByIndexAlloc(v, f.index), fieldPath(path, f.name))
+		ls.Pop(1)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// returns the field of struct v by index, the nil embedded pointers are allocated
+func fieldByIndexAlloc(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+func (c *converter) decodeSequence(idx int, v reflect.Value, path string) error {
+	leave, err := c.enterTable(idx, v.Type(), path)
+	if err != nil {
+		return err
+	}
+	defer leave()
+
+	ls := c.ls
+	n := int(ls.RawLen(idx))
+	if v.Kind() == reflect.Array {
+		if n > v.Len() {
+			return &ConvertError{path, fmt.Sprintf("sequence of %d elements is too long for %s",
+				n, v.Type())}
+		}
+		for i := n; i < v.Len(); i++ {
+			v.Index(i).Set(reflect.Zero(v.Type().Elem()))
+		}
+	} else {
+		v.Set(reflect.MakeSlice(v.Type(), n, n))
+	}
+	for i := 0; i < n; i++ {
+		ls.RawGetI(idx, int64(i+1))
+		err := c.decode(ls.GetTop(), v.Index(i), indexPath(path, i+1))
+		ls.Pop(1)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *converter) decodeMap(idx int, v reflect.Value, path string) error {
+	leave, err := c.enterTable(idx, v.Type(), path)
+	if err != nil {
+		return err
+	}
+	defer leave()
+
+	ls, t := c.ls, v.Type()
+	if v.IsNil() {
+		v.Set(reflect.MakeMap(t))
+	}
+	ls.PushNil()
+	for ls.Next(idx) {
+		top := ls.GetTop()
+		elemPath := keyPath(ls, path, top-1)
+		key := reflect.New(t.Key()).Elem()
+		err := c.decode(top-1, key, elemPath)
+		if err != nil {
+			err.(*ConvertError).Msg = "invalid key (" + err.(*ConvertError).Msg + ")"
+		} else {
+			elem := reflect.New(t.Elem()).Elem()
+			if err = c.decode(top, elem, elemPath); err == nil {
+				v.SetMapIndex(key, elem)
+			}
+		}
+		if err != nil {
+			ls.Pop(2) // pop key and value
+			return err
+		}
+		ls.Pop(1) // pop value, keep key for next iteration
+	}
+	return nil
+}
+
+// pusher pushes Go values deeply
+type pusher struct {
+	ls       api.ILuaState
+	visiting map[visitKey]bool // maps, slices and pointers being pushed
+}
+
+type visitKey struct {
+	t   reflect.Type
+	ptr uintptr
+	len int
+}
+
+// PushGo pushes the Go value v deeply, maps, slices, arrays and structs
+// become new tables without metatable, the fields of structs are named
+// like Decode, the fields of embedded structs are promoted, "omitempty"
+// of `lua` tag skips the field of zero value; pointers and interfaces
+// push the values they hold, []byte becomes string, nil maps and slices
+// become nil, other values are pushed like the arguments of CallGlobal;
+// cyclic values can't be pushed, the error is *ConvertError and nothing
+// is pushed on error
+func PushGo(ls api.ILuaState, v interface{}) error {
+	p := &pusher{ls: ls, visiting: map[visitKey]bool{}}
+	top := ls.GetTop()
+	if err := p.push(reflect.ValueOf(v), ""); err != nil {
+		ls.SetTop(top)
+		return err
+	}
+	return nil
+}
+
+// enter marks the value of key being pushed, the returned function
+// must be called when it's done
+func (p *pusher) enter(key visitKey, path string) (func(), error) {
+	if p.visiting[key] {
+		return nil, &ConvertError{path, fmt.Sprintf("cyclic value of %s", key.t)}
+	}
+	if !p.ls.CheckStack(4) {
+		return nil, &ConvertError{path, "value nesting too deep"}
+	}
+	p.visiting[key] = true
+	return func() { delete(p.visiting, key) }, nil
+}
+
+func (p *pusher) push(v reflect.Value, path string) error {
+	ls := p.ls
+	if !v.IsValid() {
+		ls.PushNil()
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			ls.PushNil()
+			return nil
+		}
+		if v.Kind() == reflect.Interface {
+			return p.push(v.Elem(), path)
+		}
+		leave, err := p.enter(visitKey{v.Type(), v.Pointer(), 0}, path)
+		if err != nil {
+			return err
+		}
+		defer leave()
+		return p.push(v.Elem(), path)
+	case reflect.Map:
+		if v.IsNil() {
+			ls.PushNil()
+			return nil
+		}
+		leave, err := p.enter(visitKey{v.Type(), v.Pointer(), 0}, path)
+		if err != nil {
+			return err
+		}
+		defer leave()
+		return p.pushMap(v, path)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			ls.PushString(string(v.Bytes()))
+			return nil
+		}
+		if v.IsNil() {
+			ls.PushNil()
+			return nil
+		}
+		leave, err := p.enter(visitKey{v.Type(), v.Pointer(), v.Len()}, path)
+		if err != nil {
+			return err
+		}
+		defer leave()
+		fallthrough
+	case reflect.Array:
+		ls.CreateTable(v.Len(), 0)
+		for i := 0; i < v.Len(); i++ {
+			if err := p.push(v.Index(i), indexPath(path, i+1)); err != nil {
+				return err
+			}
+			ls.RawSetI(-2, int64(i+1))
+		}
+		return nil
+	case reflect.Struct:
+		return p.pushStruct(v, path)
+	}
+	pushValue(ls, v.Interface())
+	return nil
+}
+
+func (p *pusher) pushMap(v reflect.Value, path string) error {
+	ls := p.ls
+	ls.CreateTable(0, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		elemPath := indexPath(path, iter.Key().Interface())
+		if err := p.push(iter.Key(), elemPath); err != nil {
+			return err
+		}
+		if ls.IsNil(-1) {
+			return &ConvertError{elemPath, "map key is nil"}
+		}
+		if n, ok := ls.ToNumberX(-1); ok && ls.Type(-1) == api.LuaTNumber && math.IsNaN(n) {
+			return &ConvertError{elemPath, "map key is NaN"}
+		}
+		if err := p.push(iter.Value(), elemPath); err != nil {
+			return err
+		}
+		ls.RawSet(-3)
+	}
+	return nil
+}
+
+func (p *pusher) pushStruct(v reflect.Value, path string) error {
+	ls := p.ls
+	fs := fields(v.Type())
+	ls.CreateTable(0, len(fs))
+	for _, f := range fs {
+		if f.embedded {
+			continue // its fields are promoted
+		}
+		fv, err := v.FieldByIndexErr(f.index)
+		if err != nil || f.omitEmpty && fv.IsZero() {
+			continue // in nil embedded pointer, or omitted
+		}
+		if err := p.push(fv, fieldPath(path, f.name)); err != nil {
+			return err
+		}
+		ls.SetField(-2, f.name)
+	}
+	return nil
+}
+
+// returns the path of field name of the value at path
+func fieldPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// returns the path of key of the value at path
+func indexPath(path string, key interface{}) string {
+	if s, ok := key.(string); ok {
+		if isName(s) {
+			return fieldPath(path, s)
+		}
+		return fmt.Sprintf("%s[%q]", path, s)
+	}
+	return fmt.Sprintf("%s[%v]", path, key)
+}
+
+// returns the path of the Lua key at idx of the value at path
+func keyPath(ls api.ILuaState, path string, idx int) string {
+	switch ls.Type(idx) {
+	case api.LuaTString:
+		return indexPath(path, ls.ToString(idx))
+	case api.LuaTNumber, api.LuaTBoolean:
+		s := ls.ToStringMeta(idx)
+		ls.Pop(1)
+		return path + "[" + s + "]"
+	}
+	return path + "[" + ls.TypeName2(idx) + "]"
+}
+
+// reports whether s is a name of Lua
+func isName(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' ||
+			i > 0 && '0' <= c && c <= '9') {
+			return false
+		}
+	}
+	return s != ""
+}
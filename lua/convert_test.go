@@ -0,0 +1,201 @@
+package lua
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestToGo(t *testing.T) {
+	L := New()
+	doString(t, L, `
+		function get()
+			return {1, 2.5, "x"}, {a = 1, [2] = true}, {}, {[1] = 1, [3] = 3},
+				setmetatable({1}, {__index = function() return 0 end}),
+				{list = {{n = 1}, {n = 2}}}
+		end
+	`)
+	results, err := L.CallGlobal("get")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []interface{}{
+		[]interface{}{int64(1), 2.5, "x"},
+		map[interface{}]interface{}{"a": int64(1), int64(2): true},
+		map[interface{}]interface{}{},
+		map[interface{}]interface{}{int64(1): int64(1), int64(3): int64(3)},
+		[]interface{}{int64(1)},
+		map[interface{}]interface{}{"list": []interface{}{
+			map[interface{}]interface{}{"n": int64(1)},
+			map[interface{}]interface{}{"n": int64(2)},
+		}},
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("got %#v", results)
+	}
+
+	doString(t, L, `
+		function shared() local t = {1} return {t, t} end
+		function deep() return {a = {b = {f = function() end}}} end
+		function tkey() return {[{}] = 1} end
+	`)
+	if _, err := L.CallGlobal("shared"); err != nil {
+		t.Errorf("shared tables are not cyclic: %v", err)
+	}
+	_, err = L.CallGlobal("deep")
+	if err == nil || err.Error() != "bad result #1 from 'deep': a.b.f: cannot convert a function value" {
+		t.Errorf("err = %v", err)
+	}
+	_, err = L.CallGlobal("tkey")
+	if err == nil || !strings.Contains(err.Error(), "[table]: cannot use map[interface {}]interface {} as a key of map") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+type Server struct {
+	Host string
+	Port uint16
+	TLS  *TLSConfig `lua:"tls"`
+}
+
+type TLSConfig struct {
+	Cert string `lua:"cert"`
+}
+
+type Config struct {
+	Name    string            `lua:"name"`
+	Debug   bool              `lua:"debug,omitempty"`
+	Servers []Server          `lua:"servers"`
+	Limits  map[string]int    `lua:"limits"`
+	Pair    [2]int            `lua:"pair"`
+	Extra   interface{}       `lua:"extra"`
+	Labels  map[string]string `lua:"labels,omitempty"`
+	Ignored string            `lua:"-"`
+}
+
+func TestDecode(t *testing.T) {
+	L := New()
+	doString(t, L, `
+		config = {
+			name = "demo",
+			servers = {
+				{Host = "a", Port = 80},
+				{Host = "b", Port = "443", tls = {cert = "b.pem"}},
+			},
+			limits = {cpu = 2, mem = 512},
+			pair = {1},
+			extra = {1, 2},
+			unknown = true,
+			Ignored = "x",
+		}
+	`)
+	c := Config{Name: "old", Pair: [2]int{7, 7}}
+	if err := L.DecodeGlobal("config", &c); err != nil {
+		t.Fatal(err)
+	}
+	want := Config{
+		Name: "demo",
+		Servers: []Server{
+			{Host: "a", Port: 80},
+			{Host: "b", Port: 443, TLS: &TLSConfig{Cert: "b.pem"}},
+		},
+		Limits: map[string]int{"cpu": 2, "mem": 512},
+		Pair:   [2]int{1, 0},
+		Extra:  []interface{}{int64(1), int64(2)},
+	}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("got %+v", c)
+	}
+
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`config = {servers = {{}, {Port = 70000}}}`, "config.servers[2].Port: value out of range of uint16"},
+		{`config = {servers = {{tls = 1}}}`, "config.servers[1].tls: table expected for lua.TLSConfig, got number"},
+		{`config = {limits = {cpu = "x"}}`, "config.limits.cpu: number expected, got string"},
+		{`config = {limits = {[true] = 1}}`, "config.limits[true]: invalid key (string expected, got boolean)"},
+		{`config = {pair = {1, 2, 3}}`, "config.pair: sequence of 3 elements is too long for [2]int"},
+		{`config = "x"`, "config: table expected for lua.Config, got string"},
+	}
+	for _, test := range tests {
+		doString(t, L, test.src)
+		var c Config
+		err := L.DecodeGlobal("config", &c)
+		if _, ok := err.(*ConvertError); !ok || err.Error() != test.want {
+			t.Errorf("%s: err = %v", test.src, err)
+		}
+	}
+
+	var n int
+	if err := L.DecodeGlobal("config", n); err == nil {
+		t.Error("decode into non-pointer")
+	}
+}
+
+type Node struct {
+	Name string
+	Next *Node
+}
+
+func TestDecodeCyclic(t *testing.T) {
+	L := New()
+	doString(t, L, `node = {Name = "a"} node.Next = {Name = "b", Next = node}`)
+	var n Node
+	err := L.DecodeGlobal("node", &n)
+	if err == nil || err.Error() != "node.Next.Next: cyclic table" {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestPushGo(t *testing.T) {
+	L := New()
+	ls := L.LuaState()
+	c := Config{
+		Name:    "demo",
+		Servers: []Server{{Host: "a", Port: 80, TLS: &TLSConfig{Cert: "a.pem"}}},
+		Limits:  map[string]int{"cpu": 2},
+		Extra:   []byte("raw"),
+		Ignored: "x",
+	}
+	if err := PushGo(ls, c); err != nil {
+		t.Fatal(err)
+	}
+	ls.SetGlobal("config")
+	if err := PushGo(ls, &Person{Base: Base{ID: 3}, Name: "Ann"}); err != nil {
+		t.Fatal(err)
+	}
+	ls.SetGlobal("person")
+	doString(t, L, `
+		assert(getmetatable(config) == nil)
+		assert(config.name == "demo" and config.debug == nil and config.labels == nil)
+		assert(config.Ignored == nil and config.extra == "raw")
+		assert(#config.servers == 1 and config.servers[1].Port == 80)
+		assert(math.type(config.servers[1].Port) == "integer")
+		assert(config.servers[1].tls.cert == "a.pem")
+		assert(config.limits.cpu == 2 and #config.pair == 2)
+		assert(person.ID == 3 and person.Base == nil and person.Name == "Ann")
+	`)
+
+	var back Config
+	if err := L.DecodeGlobal("config", &back); err != nil {
+		t.Fatal(err)
+	}
+	if back.Servers[0].TLS.Cert != "a.pem" || back.Limits["cpu"] != 2 || back.Extra != "raw" {
+		t.Errorf("back = %+v", back)
+	}
+
+	n := &Node{Name: "a"}
+	n.Next = &Node{Name: "b", Next: n}
+	top := ls.GetTop()
+	err := PushGo(ls, n)
+	if err == nil || err.Error() != "Next.Next: cyclic value of *lua.Node" {
+		t.Errorf("err = %v", err)
+	}
+	if ls.GetTop() != top {
+		t.Errorf("top = %d, want %d", ls.GetTop(), top)
+	}
+	if err := PushGo(ls, map[interface{}]int{nil: 1}); err == nil {
+		t.Error("nil key pushed")
+	}
+}
@@ -0,0 +1,137 @@
+package lua
+
+import (
+	"luago/api"
+	"luago/stdlib"
+)
+
+// Env is an environment table where chunks run isolated from the globals
+// and the other environments, it starts with shallow copies of the globals
+// and the tables in them like the libraries, so chunks can't change these
+// for others. load without env in the environment loads chunks into it.
+// The isolation stops at what the whole state shares: the metatables of
+// strings, Go values, files, lanes and json values are protected, NewEnv
+// protects the metatable of strings and the ones registered in the
+// registry, so getmetatable returns false for them everywhere after that;
+// the modules loaded by require and the debug library, if it's opened,
+// still reach the shared tables
+type Env struct {
+	s   *State
+	ref int // reference of the table in registry
+}
+
+// NewEnv returns a new environment made of the current globals and
+// protects the shared metatables by stdlib.ProtectMetatables, it should be
+// closed when it's not used any more
+func (s *State) NewEnv() *Env {
+	ls := s.ls
+	stdlib.ProtectMetatables(ls)
+	ls.CreateTable(0, 0)
+	ls.PushGlobalTable()
+	ls.PushNil()
+	for ls.Next(-2) {
+		if ls.Type(-1) == api.LuaTTable && !ls.RawEqual(-1, -3) {
+			copyTable(ls)
+		}
+		ls.PushValue(-2)
+		ls.Insert(-2)
+		ls.RawSet(-5) // env[k] = v
+	}
+	ls.Pop(1) // pop global table
+
+	ls.PushValue(-1)
+	ls.SetField(-2, "_G")
+	if ls.GetField(-1, "load") == api.LuaTFunction {
+		ls.PushValue(-2)
+		ls.Insert(-2) // env, load
+		ls.PushGoClosure(envLoad, 2)
+		ls.SetField(-2, "load")
+	} else {
+		ls.Pop(1)
+	}
+	return &Env{s: s, ref: ls.Ref(api.LuaRegistryIndex)}
+}
+
+// replaces the table on the top with its shallow copy
+func copyTable(ls api.ILuaState) {
+	ls.CreateTable(0, 0)
+	ls.PushNil()
+	for ls.Next(-3) {
+		ls.PushValue(-2)
+		ls.Insert(-2)
+		ls.RawSet(-4)
+	}
+	ls.Remove(-2)
+}
+
+// load (chunk [, chunkname [, mode [, env]]]), env defaults to
+// the environment, upvalue 1, instead of the globals
+func envLoad(ls api.ILuaState) int {
+	if ls.IsNone(4) {
+		ls.SetTop(3)
+		ls.PushValue(api.LuaUpvalueIndex(1))
+	}
+	ls.PushValue(api.LuaUpvalueIndex(2))
+	ls.Insert(1)
+	ls.Call(ls.GetTop()-1, -1)
+	return ls.GetTop()
+}
+
+// pushes the environment table
+func (e *Env) push() {
+	e.s.ls.RawGetI(api.LuaRegistryIndex, int64(e.ref))
+}
+
+// Close releases the environment, it can't be used after that
+func (e *Env) Close() {
+	e.s.ls.Unref(api.LuaRegistryIndex, e.ref)
+	e.ref = api.LuaNoRef
+}
+
+// SetGlobal sets the global name of the environment to the Go value v
+func (e *Env) SetGlobal(name string, v interface{}) {
+	e.push()
+	pushValue(e.s.ls, v)
+	e.s.ls.SetField(-2, name)
+	e.s.ls.Pop(1)
+}
+
+// DecodeGlobal decodes the global name of the environment, see Decode
+func (e *Env) DecodeGlobal(name string, v interface{}) error {
+	ls := e.s.ls
+	e.push()
+	ls.GetField(-1, name)
+	defer ls.Pop(2)
+	return decode(ls, -1, v, name)
+}
+
+// DoString runs the text chunk src in the environment,
+// binary chunks are refused
+func (e *Env) DoString(src string) error {
+	return e.doChunk(e.s.ls.Load([]byte(src), src, "t"))
+}
+
+// DoFile runs the text chunk in file path in the environment
+func (e *Env) DoFile(path string) error {
+	return e.doChunk(e.s.ls.LoadFileX(path, "t"))
+}
+
+func (e *Env) doChunk(status int) error {
+	if status == api.LuaOk {
+		e.push()
+		if _, ok := e.s.ls.SetUpvalue(-2, 1); !ok {
+			e.s.ls.Pop(1) // the chunk has no _ENV
+		}
+	}
+	return e.s.doChunk(status)
+}
+
+// CallGlobal calls the global function name of the environment,
+// see State.CallGlobal
+func (e *Env) CallGlobal(name string, args ...interface{}) ([]interface{}, error) {
+	ls := e.s.ls
+	e.push()
+	ls.GetField(-1, name)
+	ls.Remove(-2)
+	return e.s.call(name, args)
+}
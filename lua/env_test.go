@@ -0,0 +1,110 @@
+package lua
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEnv(t *testing.T) {
+	L := New(WithSafeLibs())
+	a, b := L.NewEnv(), L.NewEnv()
+	defer a.Close()
+	defer b.Close()
+	a.SetGlobal("name", "a")
+	b.SetGlobal("name", "b")
+	if err := a.DoString(`
+		x = 1
+		string.upper = nil
+		_G.y = 2
+		function who() return name end
+		load("z = 3")()
+	`); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.DoString(`
+		assert(x == nil and y == nil and z == nil and who == nil)
+		assert(string.upper("b") == "B" and _G == _ENV)
+		assert(not pcall(function() return io.open end))
+	`); err != nil {
+		t.Fatal(err)
+	}
+	doString(t, L, `assert(x == nil and name == nil and string.upper ~= nil)`)
+
+	results, err := a.CallGlobal("who")
+	if err != nil || len(results) != 1 || results[0] != "a" {
+		t.Errorf("who = %v, %v", results, err)
+	}
+	var z int
+	if err := a.DecodeGlobal("z", &z); err != nil || z != 3 {
+		t.Errorf("z = %d, %v", z, err)
+	}
+
+	err = a.DoString("\x1bLua")
+	if !IsSyntaxError(err) || !strings.Contains(err.Error(), "attempt to load a binary chunk") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestEnvStringMetatable(t *testing.T) {
+	L := New()
+	a, b := L.NewEnv(), L.NewEnv()
+	defer a.Close()
+	defer b.Close()
+	if err := a.DoString(`
+		assert(getmetatable("") == false)
+		assert(not pcall(function() getmetatable("").__index.upper = nil end))
+		assert(not pcall(function() ("").rep = nil end))
+		string.upper = nil
+	`); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.DoString(`assert(("b"):upper() == "B" and string.upper("b") == "B")`); err != nil {
+		t.Fatal(err)
+	}
+	doString(t, L, `assert(("x"):upper() == "X")`)
+}
+
+func TestEnvSharedMetatables(t *testing.T) {
+	L := New()
+	a, b := L.NewEnv(), L.NewEnv()
+	defer a.Close()
+	defer b.Close()
+	p := &Person{Name: "b"}
+	a.SetGlobal("p", p)
+	b.SetGlobal("p", p)
+	if err := a.DoString(`
+		local f = function() return "pwned" end
+		for _, v in ipairs({json.array(), json.object(), json.null, p, io.stdout,
+				lanes.channel(), lanes.spawn(function() end)}) do
+			assert(getmetatable(v) == false, tostring(v))
+			assert(not pcall(function() getmetatable(v).__index = f end))
+		end
+		assert(not pcall(setmetatable, json.object(), {__newindex = f}))
+	`); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.DoString(`
+		local o = json.object()
+		o.a = 1
+		assert(rawget(o, "a") == 1 and json.encode(o) == '{"a":1}')
+		assert(tostring(json.null) == "null" and p.Name == "b")
+		assert(io.stdout.write ~= nil and lanes.channel().send ~= nil)
+	`); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEnvRegisteredMetatables(t *testing.T) {
+	L := New()
+	ls := L.LuaState()
+	ls.NewMetatable("host.type")
+	ls.Pop(1)
+	e := L.NewEnv()
+	defer e.Close()
+	if err := e.DoString(`
+		local u = debug.getregistry()["host.type"]
+		assert(u.__metatable == false)
+	`); err != nil {
+		t.Fatal(err)
+	}
+}
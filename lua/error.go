@@ -0,0 +1,35 @@
+package lua
+
+import "luago/api"
+
+// Error is the error raised by Lua code or the failure of loading a chunk
+type Error struct {
+	Status    int         // api.LuaErrRun, api.LuaErrSyntax, api.LuaErrFile...
+	Message   string      // the message, made of the error object
+	Value     interface{} // the error object converted by ToGo
+	Traceback string      // traceback of the call stack where the error was raised
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// IsSyntaxError reports whether err is an error of loading a chunk
+// with syntax error
+func IsSyntaxError(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.Status == api.LuaErrSyntax
+}
+
+// ConvertError is the error of converting values between Lua and Go
+type ConvertError struct {
+	Path string // the location of the value failed, like "servers[2].port"
+	Msg  string
+}
+
+func (e *ConvertError) Error() string {
+	if e.Path == "" {
+		return e.Msg
+	}
+	return e.Path + ": " + e.Msg
+}
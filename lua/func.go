@@ -0,0 +1,80 @@
+package lua
+
+import (
+	"fmt"
+	"luago/api"
+	"reflect"
+)
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// Func adapts the Go function fn to api.GoFunction:
+//
+//	the arguments are converted to the types of parameters by toGoValue,
+//	with the "bad argument" error if it fails, tables are decoded to the
+//	structs, slices and maps like Decode
+//	the variadic parameter takes the rest arguments
+//	the results are pushed by pushValue, if the last result is an error,
+//	it's not pushed, but raised as a Lua error if it's not nil
+//
+// it panics if fn is not a function
+func Func(fn interface{}) api.GoFunction {
+	switch f := fn.(type) {
+	case api.GoFunction:
+		return f
+	case func(api.ILuaState) int:
+		return f
+	}
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		panic(fmt.Sprintf("lua: Func of non-function %T", fn))
+	}
+	return func(ls api.ILuaState) int {
+		return callFunc(ls, v, 1)
+	}
+}
+
+// Register sets the global name to the function fn adapted by Func
+func (s *State) Register(name string, fn interface{}) {
+	s.ls.Register(name, Func(fn))
+}
+
+// callFunc calls fn with the arguments from first, pushes its results
+func callFunc(ls api.ILuaState, fn reflect.Value, first int) int {
+	t := fn.Type()
+	nIn := t.NumIn()
+	if t.IsVariadic() {
+		nIn-- // the fixed parameters
+		if n := ls.GetTop() - first + 1; n > nIn {
+			nIn = n // all arguments are passed
+		}
+	}
+
+	args := make([]reflect.Value, nIn)
+	for i := range args {
+		var pt reflect.Type
+		if t.IsVariadic() && i >= t.NumIn()-1 {
+			pt = t.In(t.NumIn() - 1).Elem() // element of the variadic parameter
+		} else {
+			pt = t.In(i)
+		}
+		arg, err := toGoValue(ls, first+i, pt)
+		if err != nil {
+			return ls.ArgError(first+i, err.Error())
+		}
+		args[i] = arg
+	}
+
+	results := fn.Call(args)
+	if n := len(results); n > 0 && t.Out(n-1) == errorType {
+		if err := results[n-1]; !err.IsNil() {
+			return ls.Error2("%s", err.Interface().(error).Error())
+		}
+		results = results[:n-1]
+	}
+	ls.CheckStack2(len(results), "too many results")
+	for _, r := range results {
+		pushReflect(ls, r)
+	}
+	return len(results)
+}
@@ -0,0 +1,116 @@
+package lua
+
+import (
+	"errors"
+	"luago/api"
+	"strings"
+	"testing"
+)
+
+func TestFunc(t *testing.T) {
+	L := New()
+	L.Register("greet", func(name string, n int) (string, error) {
+		if n < 0 {
+			return "", errors.New("negative count")
+		}
+		return strings.Repeat("hi "+name+" ", n), nil
+	})
+	L.Register("sum", func(base float64, xs ...int) float64 {
+		for _, x := range xs {
+			base += float64(x)
+		}
+		return base
+	})
+	L.Register("join", func(sep string, parts ...string) string {
+		return strings.Join(parts, sep)
+	})
+	L.Register("pair", func() (int, string, bool) { return 1, "b", true })
+	L.Register("check", func(err error) error { return err })
+	L.Register("raw", func(ls api.ILuaState) int {
+		ls.PushInteger(int64(ls.GetTop()))
+		return 1
+	})
+
+	doString(t, L, `
+		assert(type(greet) == "function")
+		assert(greet("bo", 2) == "hi bo hi bo ")
+		assert(greet("bo", "1") == "hi bo ", "strings are coerced")
+		assert(select('#', greet("x", 0)) == 1, "nil error is not returned")
+		assert(sum(0.5) == 0.5 and sum(1, 2, 3) == 6)
+		assert(join(",") == "" and join(",", "a", 2, "c") == "a,2,c")
+		local a, b, c = pair()
+		assert(a == 1 and b == "b" and c == true)
+		assert(check(nil) == nil)
+		assert(raw(1, 2, 3) == 3)
+
+		local function try(f, ...)
+			local ok, err = pcall(f, ...)
+			assert(not ok)
+			return err
+		end
+		local err = try(function() return greet("x", -1) end)
+		assert(err:find(':%d+: negative count$'), err)
+		err = try(function() return greet("x") end)
+		assert(err:find("bad argument #2 to 'greet' %(number expected, got no value%)"), err)
+		err = try(function() return greet({}, 1) end)
+		assert(err:find("bad argument #1 to 'greet' %(string expected, got table%)"), err)
+		err = try(function() return sum(1, 2, "x") end)
+		assert(err:find("bad argument #3 to 'sum' %(number expected, got string%)"), err)
+		err = try(function() return sum(1, 2.5) end)
+		assert(err:find("bad argument #2 to 'sum' %(number has no integer representation%)"), err)
+	`)
+}
+
+func TestFuncOverflow(t *testing.T) {
+	L := New()
+	L.Register("byte", func(b uint8) uint8 { return b })
+	err := L.DoString(`local function f() return byte(256) end f()`)
+	if err == nil || !strings.Contains(err.Error(), "bad argument #1 to 'byte' (value out of range of uint8)") {
+		t.Errorf("err = %v", err)
+	}
+	err = L.DoString(`local function f() return byte(-1) end f()`)
+	if err == nil || !strings.Contains(err.Error(), "value out of range") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestFuncTables(t *testing.T) {
+	L := New()
+	L.Register("join", func(xs []string) string { return strings.Join(xs, ",") })
+	L.Register("greet", func(p Person) string { return p.Greet("Hi") })
+	L.Register("ages", func(m map[string]*Person) int {
+		n := 0
+		for _, p := range m {
+			n += p.Age
+		}
+		return n
+	})
+	doString(t, L, `
+		assert(join({"a", "b", 3}) == "a,b,3")
+		assert(join(nil) == "")
+		assert(greet({Name = "Ann", age = 3}) == "Hi, Ann")
+		assert(ages({a = {age = 1}, b = {age = 2}}) == 3)
+		local ok, err = pcall(join, {"a", {}})
+		assert(not ok and err:find("bad argument #1 to 'join' %(%[2%]: string expected, got table%)"), err)
+		ok, err = pcall(greet, {age = "x"})
+		assert(not ok and err:find("bad argument #1 to 'greet' %(age: number expected, got string%)"), err)
+	`)
+}
+
+func TestFuncMethod(t *testing.T) {
+	L := New()
+	L.SetGlobal("p", &Person{Name: "Ann"})
+	err := L.DoString(`p:Greet({})`)
+	if err == nil || !strings.Contains(err.Error(), "bad argument #1 to 'Greet' (string expected, got table)") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestFuncPanic(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("want panic")
+		}
+	}()
+	Func(42)
+}
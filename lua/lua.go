@@ -0,0 +1,282 @@
+// Package lua is the high-level API to embed Lua in Go programs,
+// errors of Lua come back as *Error instead of panics.
+package lua
+
+import (
+	"fmt"
+	"io"
+	"luago/api"
+	"luago/compiler"
+	"luago/state"
+	"luago/stdlib"
+	"path/filepath"
+	"strings"
+)
+
+// State is a Lua state with the standard libraries opened,
+// it's not safe for concurrent use
+type State struct {
+	ls        api.ILuaState
+	traceback string // traceback of the last error, set by message handler
+}
+
+type options struct {
+	libs   []string
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+	paths  []string
+	safe   bool
+	cache  *state.ProtoCache
+	level  compiler.Level
+}
+
+// Option configures the State made by New
+type Option func(*options)
+
+// WithLibs opens only the standard libraries named, see stdlib.LibNames,
+// all of them are opened by default
+func WithLibs(names ...string) Option {
+	return func(o *options) {
+		o.libs = names
+	}
+}
+
+// WithSafeLibs opens the libraries safe for untrusted code instead,
+// see stdlib.OpenSafeLibs, the libraries named by WithLibs after it
+// are opened as well
+func WithSafeLibs() Option {
+	return func(o *options) {
+		o.libs = nil
+		o.safe = true
+	}
+}
+
+// WithProtoCache makes the State look up the chunks it loads in c before
+// compiling them, c may be shared by States
+func WithProtoCache(c *state.ProtoCache) Option {
+	return func(o *options) {
+		o.cache = c
+	}
+}
+
+// WithOptLevel sets the level of optimizations of the chunks loaded,
+// compiler.LevelNone keeps the code faithful to the source for debugging
+func WithOptLevel(level compiler.Level) Option {
+	return func(o *options) {
+		o.level = level
+	}
+}
+
+// WithStdin sets the reader of io.stdin
+func WithStdin(r io.Reader) Option {
+	return func(o *options) {
+		o.stdin = r
+	}
+}
+
+// WithStdout sets the writer of print and io.stdout
+func WithStdout(w io.Writer) Option {
+	return func(o *options) {
+		o.stdout = w
+	}
+}
+
+// WithStderr sets the writer of io.stderr
+func WithStderr(w io.Writer) Option {
+	return func(o *options) {
+		o.stderr = w
+	}
+}
+
+// WithPath sets the paths where require searches Lua modules, a path is
+// a template like "./?.lua" or a directory which means "dir/?.lua" and
+// "dir/?/init.lua"
+func WithPath(paths ...string) Option {
+	return func(o *options) {
+		o.paths = append(o.paths, paths...)
+	}
+}
+
+// New returns a new State configured by opts
+func New(opts ...Option) *State {
+	o := options{libs: stdlib.LibNames, level: compiler.LevelFold}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	ls := state.NewLuaState()
+	ls.SetProtoCache(o.cache)
+	ls.SetOptLevel(o.level)
+	stdlib.SetStdio(ls, o.stdin, o.stdout, o.stderr)
+	if o.safe {
+		stdlib.OpenSafeLibs(ls)
+	}
+	for _, name := range o.libs {
+		if !stdlib.OpenLib(ls, name) {
+			panic(fmt.Sprintf("lua: unknown library '%s'", name))
+		}
+	}
+	if len(o.paths) > 0 {
+		setPath(ls, o.paths)
+	}
+	return &State{ls: ls}
+}
+
+// sets package.path to paths if package library is opened
+func setPath(ls api.ILuaState, paths []string) {
+	if ls.GetGlobal(stdlib.PackageLibName) != api.LuaTTable {
+		ls.Pop(1)
+		return
+	}
+	templates := make([]string, 0, len(paths))
+	for _, p := range paths {
+		if strings.Contains(p, "?") {
+			templates = append(templates, p)
+		} else {
+			templates = append(templates, filepath.Join(p, "?.lua"),
+				filepath.Join(p, "?", "init.lua"))
+		}
+	}
+	ls.PushString(strings.Join(templates, ";"))
+	ls.SetField(-2, "path")
+	ls.Pop(1)
+}
+
+// LuaState returns the underlying state for the low-level API
+func (s *State) LuaState() api.ILuaState {
+	return s.ls
+}
+
+// SetGlobal sets the global name to the Go value v, see pushValue
+// for the conversion of v
+func (s *State) SetGlobal(name string, v interface{}) {
+	pushValue(s.ls, v)
+	s.ls.SetGlobal(name)
+}
+
+// Close calls the pending finalizers of the state
+func (s *State) Close() {
+	s.ls.Close()
+}
+
+// DoString runs the chunk src
+func (s *State) DoString(src string) error {
+	return s.doChunk(s.ls.LoadString(src))
+}
+
+// DoFile runs the chunk in file path
+func (s *State) DoFile(path string) error {
+	return s.doChunk(s.ls.LoadFile(path))
+}
+
+func (s *State) doChunk(status int) error {
+	if status != api.LuaOk {
+		return s.popError(status)
+	}
+	top := s.ls.GetTop() - 1 // below the chunk
+	if err := s.pcall(0, -1); err != nil {
+		return err
+	}
+	s.ls.SetTop(top) // discard results
+	return nil
+}
+
+// CallGlobal calls the global function name with args, returns its results,
+// see pushValue and ToGo for the conversions of values
+func (s *State) CallGlobal(name string, args ...interface{}) ([]interface{}, error) {
+	s.ls.GetGlobal(name)
+	return s.call(name, args)
+}
+
+// calls the value on the top named name with args, pops it and returns
+// the results
+func (s *State) call(name string, args []interface{}) ([]interface{}, error) {
+	ls := s.ls
+	top := ls.GetTop() - 1 // below the function
+	if t := ls.Type(-1); t != api.LuaTFunction {
+		if ls.GetMetaField(-1, "__call") == api.LuaTNil {
+			ls.SetTop(top)
+			return nil, &Error{
+				Status:  api.LuaErrRun,
+				Message: fmt.Sprintf("global '%s' is not callable (a %s value)", name, ls.TypeName(t)),
+			}
+		}
+		ls.Pop(1) // pop __call, the call goes through it
+	}
+	ls.CheckStack2(len(args), "too many arguments")
+	for _, arg := range args {
+		pushValue(ls, arg)
+	}
+	if err := s.pcall(len(args), -1); err != nil {
+		return nil, err
+	}
+
+	results := make([]interface{}, ls.GetTop()-top)
+	var err error
+	for i := range results {
+		if results[i], err = ToGo(ls, top+1+i); err != nil {
+			err = fmt.Errorf("bad result #%d from '%s': %w", i+1, name, err)
+			break
+		}
+	}
+	ls.SetTop(top)
+	return results, err
+}
+
+// calls the function below the nArgs arguments with the message handler,
+// which keeps the traceback, pops the error and returns it as *Error
+func (s *State) pcall(nArgs, nResults int) error {
+	base := s.ls.GetTop() - nArgs // function index
+	s.ls.PushGoFunction(s.msgHandler)
+	s.ls.Insert(base) // put it under function and args
+	status := s.ls.PCall(nArgs, nResults, base)
+	s.ls.Remove(base) // remove message handler from the stack
+	if status != api.LuaOk {
+		return s.popError(status)
+	}
+	return nil
+}
+
+func (s *State) msgHandler(ls api.ILuaState) int {
+	ls.Traceback("", 1)
+	s.traceback = ls.ToString(-1)
+	ls.Pop(1)
+	return 1 // return the error object untouched
+}
+
+// converts the argument to a string by __tostring
+func toStringMeta(ls api.ILuaState) int {
+	ls.ToStringMeta(1)
+	return 1
+}
+
+// pops the error object on the top and makes the error with the traceback
+// kept by message handler
+func (s *State) popError(status int) error {
+	ls := s.ls
+	err := &Error{Status: status, Traceback: s.traceback}
+	s.traceback = ""
+	switch ls.Type(-1) {
+	case api.LuaTString, api.LuaTNumber:
+		err.Message = ls.ToStringMeta(-1) // keep the number untouched
+		ls.Pop(1)
+	default:
+		if ls.GetMetaField(-1, "__tostring") != api.LuaTNil {
+			ls.Pop(1)
+			ls.PushGoFunction(toStringMeta) // __tostring may fail
+			ls.PushValue(-2)
+			if ls.PCall(1, 1, 0) == api.LuaOk {
+				err.Message = ls.ToString(-1) // the error object produces a message
+			} else {
+				err.Message = fmt.Sprintf("(error object is a %s value)", ls.TypeName2(-2))
+			}
+			ls.Pop(1)
+		} else {
+			err.Message = fmt.Sprintf("(error object is a %s value)", ls.TypeName2(-1))
+		}
+	}
+	err.Value, _ = ToGo(ls, -1)
+	ls.Pop(1)
+	return err
+}
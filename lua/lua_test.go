@@ -0,0 +1,205 @@
+package lua
+
+import (
+	"bytes"
+	"io/ioutil"
+	"luago/api"
+	"luago/compiler"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDoString(t *testing.T) {
+	out := &bytes.Buffer{}
+	L := New(WithStdout(out))
+	defer L.Close()
+
+	if err := L.DoString(`print("hello", 1 + 1)`); err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); got != "hello\t2\n" {
+		t.Errorf("stdout = %q", got)
+	}
+	if top := L.LuaState().GetTop(); top != 0 {
+		t.Errorf("top = %d", top)
+	}
+}
+
+func TestRuntimeError(t *testing.T) {
+	L := New()
+	err := L.DoString(`
+local function inner() error("boom") end
+local function outer() inner() end
+outer()`)
+	e, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("err = %#v", err)
+	}
+	if e.Status != api.LuaErrRun || e.Message != `[string "..."]:2: boom` || e.Value != e.Message {
+		t.Errorf("err = %#v", e)
+	}
+	for _, want := range []string{"stack traceback:", "in upvalue 'inner'", "in local 'outer'", "in main chunk"} {
+		if !strings.Contains(e.Traceback, want) {
+			t.Errorf("traceback misses %q:\n%s", want, e.Traceback)
+		}
+	}
+	if top := L.LuaState().GetTop(); top != 0 {
+		t.Errorf("top = %d", top)
+	}
+}
+
+func TestErrorObject(t *testing.T) {
+	L := New()
+	err := L.DoString(`error(setmetatable({code = 7}, {__tostring = function(e) return "E" .. e.code end}))`)
+	e := err.(*Error)
+	if e.Message != "E7" {
+		t.Errorf("message = %q", e.Message)
+	}
+	if m, ok := e.Value.(map[interface{}]interface{}); !ok || m["code"] != int64(7) {
+		t.Errorf("value = %#v", e.Value)
+	}
+
+	err = L.DoString(`error({})`)
+	if err.Error() != "(error object is a table value)" {
+		t.Errorf("message = %q", err)
+	}
+
+	// __tostring fails or returns no string
+	for _, src := range []string{
+		`error(setmetatable({}, {__tostring = function() error("x") end}))`,
+		`error(setmetatable({}, {__tostring = function() return {} end}))`,
+	} {
+		err = L.DoString(src)
+		if e, ok := err.(*Error); !ok || e.Message != "(error object is a table value)" {
+			t.Errorf("%s: err = %v", src, err)
+		}
+	}
+	doString(t, L, `function fail() error(setmetatable({}, {__tostring = function() error("x") end})) end`)
+	if _, err := L.CallGlobal("fail"); err == nil || err.Error() != "(error object is a table value)" {
+		t.Errorf("err = %v", err)
+	}
+	if top := L.LuaState().GetTop(); top != 0 {
+		t.Errorf("top = %d", top)
+	}
+}
+
+func TestSyntaxError(t *testing.T) {
+	L := New()
+	err := L.DoString("x = = 1")
+	if err == nil || !IsSyntaxError(err) {
+		t.Fatalf("err = %v", err)
+	}
+	if err := L.DoFile("no/such/file.lua"); err == nil || err.(*Error).Status != api.LuaErrFile {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestCallGlobal(t *testing.T) {
+	L := New()
+	if err := L.DoString(`
+function add(a, b) return a + b, a .. b end
+function fail(msg) error(msg, 0) end
+function tab() return {1, x = {y = true}} end
+function gofn(f) return f(5) end`); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := L.CallGlobal("add", 1, uint8(2))
+	if err != nil || !reflect.DeepEqual(results, []interface{}{int64(3), "12"}) {
+		t.Errorf("add = %#v, %v", results, err)
+	}
+	results, err = L.CallGlobal("add", 0.5, int32(1))
+	if err != nil || results[0] != 1.5 {
+		t.Errorf("add = %#v, %v", results, err)
+	}
+
+	_, err = L.CallGlobal("fail", "bad")
+	if e, ok := err.(*Error); !ok || e.Message != "bad" || !strings.Contains(e.Traceback, `[string "..."]:3: in function`) {
+		t.Errorf("fail = %#v", err)
+	}
+
+	results, err = L.CallGlobal("tab")
+	want := map[interface{}]interface{}{int64(1): int64(1), "x": map[interface{}]interface{}{"y": true}}
+	if err != nil || !reflect.DeepEqual(results[0], want) {
+		t.Errorf("tab = %#v, %v", results, err)
+	}
+
+	double := func(ls api.ILuaState) int {
+		ls.PushInteger(ls.CheckInteger(1) * 2)
+		return 1
+	}
+	results, err = L.CallGlobal("gofn", double)
+	if err != nil || results[0] != int64(10) {
+		t.Errorf("gofn = %#v, %v", results, err)
+	}
+
+	if _, err = L.CallGlobal("nothing"); err == nil || err.Error() != "global 'nothing' is not callable (a nil value)" {
+		t.Errorf("nothing = %v", err)
+	}
+	if _, err = L.CallGlobal("add", struct{}{}, 1); err == nil ||
+		!strings.Contains(err.Error(), "attempt to perform arithmetic on a GoValue value") {
+		t.Errorf("add = %v", err)
+	}
+	if top := L.LuaState().GetTop(); top != 0 {
+		t.Errorf("top = %d", top)
+	}
+}
+
+func TestCyclicTable(t *testing.T) {
+	L := New()
+	L.DoString(`function cyc() local t = {} t.t = t return t end`)
+	if _, err := L.CallGlobal("cyc"); err == nil || !strings.Contains(err.Error(), "bad result #1 from 'cyc': t: cyclic table") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestWithLibs(t *testing.T) {
+	L := New(WithLibs("_G", "string"))
+	if err := L.DoString(`assert(io == nil and math == nil and ("x"):rep(2) == "xx")`); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWithOptLevel(t *testing.T) {
+	// the local propagated is not named
+	src := `local x = nil
+return x.y`
+	for level, want := range map[compiler.Level]string{
+		compiler.LevelNone: `:2: attempt to index a nil value (local 'x')`,
+		compiler.LevelFull: `:2: attempt to index a nil value`,
+	} {
+		L := New(WithOptLevel(level))
+		if err := L.DoString(src); err == nil || !strings.HasSuffix(err.Error(), want) {
+			t.Errorf("level %d: err = %v", level, err)
+		}
+	}
+}
+
+func TestWithPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "luapath")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "pkg"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "mod.lua"), []byte(`return {v = 1}`), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "pkg", "init.lua"), []byte(`return {v = 2}`), 0644)
+
+	out := &bytes.Buffer{}
+	L := New(WithPath(dir), WithStdout(out))
+	if err := L.DoString(`print(require("mod").v + require("pkg").v)`); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "3\n" {
+		t.Errorf("stdout = %q", out.String())
+	}
+
+	f := filepath.Join(dir, "main.lua")
+	ioutil.WriteFile(f, []byte("error('in file')"), 0644)
+	if err := L.DoFile(f); err == nil || err.Error() != f+":1: in file" {
+		t.Errorf("err = %v", err)
+	}
+}
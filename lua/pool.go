@@ -0,0 +1,245 @@
+package lua
+
+import (
+	"luago/api"
+	"luago/state"
+)
+
+// Pool is a pool of States for concurrent use, each goroutine takes
+// a State of its own by Get and returns it by Put, the States of a pool
+// share the compiled chunks by a state.ProtoCache
+type Pool struct {
+	opts   []Option
+	states chan *State // idle states
+}
+
+// NewPool returns a pool keeping up to size idle States, which are made
+// in advance by New with opts, the pool shares a new state.ProtoCache
+// unless opts has WithProtoCache
+func NewPool(size int, opts ...Option) *Pool {
+	p := &Pool{
+		opts:   append([]Option{WithProtoCache(state.NewProtoCache())}, opts...),
+		states: make(chan *State, size),
+	}
+	for i := 0; i < size; i++ {
+		p.states <- p.newState()
+	}
+	return p
+}
+
+func (p *Pool) newState() *State {
+	s := New(p.opts...)
+	s.snapshot()
+	return s
+}
+
+// Get takes an idle State, or makes a new one if there is none
+func (p *Pool) Get() *State {
+	select {
+	case s := <-p.states:
+		return s
+	default:
+		return p.newState()
+	}
+}
+
+// Put resets the globals, the tables in them, the loaded modules, their
+// metatables, the metatable of strings and the metatables in the registry
+// of s to the ones when it was made and returns it to the pool, it's closed instead if the pool is
+// full, it's interrupted or its garbage collector is stopped; s must come
+// from Get and not be used after Put
+func (p *Pool) Put(s *State) {
+	if s.ls.Interrupted() || s.ls.GC(api.LuaGCIsRunning, 0) == 0 {
+		s.Close()
+		return
+	}
+	s.reset()
+	select {
+	case p.states <- s:
+	default:
+		s.Close()
+	}
+}
+
+// Close closes the idle States
+func (p *Pool) Close() {
+	for {
+		select {
+		case s := <-p.states:
+			s.Close()
+		default:
+			return
+		}
+	}
+}
+
+// registry key of the snapshot of globals, its fields are
+//
+//	tables    table => its copy
+//	metas     table or userdata => its metatable, false if it has none
+//	string    the metatable of strings, absent if there is none
+//	registry  name => the metatable registered by NewMetatable
+const snapshotKey = "_SNAPSHOT"
+
+// saves the contents and metatables of the globals, the tables in them,
+// the loaded modules, the metatable of strings and the metatables in the
+// registry to be restored by reset
+func (s *State) snapshot() {
+	ls := s.ls
+	ls.CreateTable(0, 4)
+	ls.CreateTable(0, 0)
+	ls.CreateTable(0, 0)
+	tables, metas := ls.GetTop()-1, ls.GetTop()
+	ls.PushGlobalTable()
+	saveTable(ls, tables, metas)
+	ls.PushNil()
+	for ls.Next(-2) {
+		if ls.Type(-1) == api.LuaTTable {
+			saveTable(ls, tables, metas)
+		}
+		ls.Pop(1)
+	}
+	ls.Pop(1) // pop global table
+	if ls.GetField(api.LuaRegistryIndex, "_LOADED") == api.LuaTTable {
+		saveTable(ls, tables, metas)
+	}
+	ls.Pop(1)
+	ls.PushString("")
+	if ls.GetMetaTable(-1) {
+		saveTable(ls, tables, metas)
+		ls.SetField(tables-1, "string")
+	}
+	ls.Pop(1)
+	saveRegistry(ls, tables, metas)
+	ls.SetField(-4, "registry")
+	ls.SetField(tables-1, "metas")
+	ls.SetField(-2, "tables")
+	ls.SetField(api.LuaRegistryIndex, snapshotKey)
+}
+
+// saves the metatables registered by NewMetatable, their __index tables
+// and the metatables of the userdata in the registry like json.null into
+// the snapshot tables, pushes the table of registered metatables
+func saveRegistry(ls api.ILuaState, tables, metas int) {
+	ls.CreateTable(0, 0)
+	ls.PushNil()
+	for ls.Next(api.LuaRegistryIndex) {
+		if ls.Type(-2) != api.LuaTString {
+			ls.Pop(1)
+			continue
+		}
+		switch ls.Type(-1) {
+		case api.LuaTUserData:
+			ls.PushValue(-1)
+			if ls.GetMetaTable(-1) {
+				saveMetatable(ls, tables, metas)
+			} else {
+				ls.PushBoolean(false)
+			}
+			ls.RawSet(metas) // metas[u] = metatable
+		case api.LuaTTable:
+			ls.GetField(-1, "__name")
+			if ls.RawEqual(-1, -3) { // registry[tname].__name == tname
+				ls.Pop(1)
+				saveMetatable(ls, tables, metas)
+				ls.PushValue(-2)
+				ls.PushValue(-2)
+				ls.RawSet(-5) // registered[tname] = metatable
+			} else {
+				ls.Pop(1)
+			}
+		}
+		ls.Pop(1)
+	}
+}
+
+// saves the metatable on the top and its __index table
+func saveMetatable(ls api.ILuaState, tables, metas int) {
+	saveTable(ls, tables, metas)
+	ls.PushString("__index")
+	if ls.RawGet(-2) == api.LuaTTable && !ls.RawEqual(-1, -2) {
+		saveTable(ls, tables, metas)
+	}
+	ls.Pop(1)
+}
+
+// saves the copy and the metatable of the table on the top into the
+// snapshot tables at tables and metas
+func saveTable(ls api.ILuaState, tables, metas int) {
+	ls.PushValue(-1)
+	ls.PushValue(-1)
+	copyTable(ls)
+	ls.RawSet(tables) // tables[t] = copy
+	ls.PushValue(-1)
+	if !ls.GetMetaTable(-1) {
+		ls.PushBoolean(false)
+	}
+	ls.RawSet(metas) // metas[t] = metatable
+}
+
+// restores the tables saved by snapshot, clears the stack
+func (s *State) reset() {
+	ls := s.ls
+	ls.SetTop(0)
+	s.traceback = ""
+	if ls.GetField(api.LuaRegistryIndex, snapshotKey) != api.LuaTTable {
+		ls.Pop(1)
+		return
+	}
+	ls.GetField(1, "tables")
+	ls.PushNil()
+	for ls.Next(2) {
+		restoreTable(ls, 3, 4)
+		ls.Pop(1)
+	}
+	ls.GetField(1, "metas")
+	ls.PushNil()
+	for ls.Next(3) {
+		if ls.Type(-1) == api.LuaTBoolean {
+			ls.Pop(1)
+			ls.PushNil()
+		}
+		ls.SetMetaTable(-2)
+	}
+	ls.PushString("")
+	ls.GetField(1, "string") // nil if there was none
+	ls.SetMetaTable(-2)
+	ls.GetField(1, "registry")
+	ls.PushNil()
+	for ls.Next(-2) {
+		ls.PushValue(-2)
+		ls.Insert(-2)
+		ls.RawSet(api.LuaRegistryIndex)
+	}
+	ls.SetTop(0)
+}
+
+// makes the table at idx the same as its copy at cp
+func restoreTable(ls api.ILuaState, idx, cp int) {
+	ls.CreateTable(0, 0) // the keys not in copy
+	n := int64(0)
+	ls.PushNil()
+	for ls.Next(idx) {
+		ls.Pop(1)
+		ls.PushValue(-1)
+		if ls.RawGet(cp) == api.LuaTNil {
+			n++
+			ls.PushValue(-2)
+			ls.RawSetI(-4, n)
+		}
+		ls.Pop(1)
+	}
+	for i := int64(1); i <= n; i++ {
+		ls.RawGetI(-1, i)
+		ls.PushNil()
+		ls.RawSet(idx)
+	}
+	ls.Pop(1)
+
+	ls.PushNil()
+	for ls.Next(cp) {
+		ls.PushValue(-2)
+		ls.Insert(-2)
+		ls.RawSet(idx)
+	}
+}
@@ -0,0 +1,132 @@
+package lua
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestPool(t *testing.T) {
+	p := NewPool(2, WithSafeLibs())
+	defer p.Close()
+
+	s := p.Get()
+	doString(t, s, `
+		x = 1
+		string.upper = nil
+		print = nil
+		package = {}
+	`)
+	p.Put(s)
+	s = p.Get()
+	doString(t, s, `assert(x == nil and string.upper("a") == "A" and print ~= nil)`)
+	p.Put(s)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s := p.Get()
+			defer p.Put(s)
+			s.SetGlobal("n", i)
+			if err := s.DoString(`assert(m == nil) m = n return n`); err != nil {
+				errs <- err
+				return
+			}
+			results, err := s.CallGlobal("tostring", i)
+			if err != nil || results[0] != fmt.Sprint(i) {
+				errs <- fmt.Errorf("tostring(%d) = %v, %v", i, results, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestPoolMetatables(t *testing.T) {
+	p := NewPool(1)
+	defer p.Close()
+
+	s := p.Get()
+	doString(t, s, `
+		setmetatable(_G, {__index = function(_, k) return "leaked:" .. k end})
+		setmetatable(math, {__index = function() return "leaked" end})
+		getmetatable("").__index = function() return "pwned" end
+		getmetatable("").__len = function() return 0 end
+	`)
+	p.Put(s)
+	s2 := p.Get()
+	if s2 != s {
+		t.Fatal("want the state put back")
+	}
+	doString(t, s2, `
+		assert(foo == nil and getmetatable(_G) == nil)
+		assert(math.foo == nil and getmetatable(math) == nil)
+		assert(("x"):upper() == "X" and getmetatable("").__len == nil)
+	`)
+	p.Put(s2)
+
+	// the string metatable replaced by debug.setmetatable
+	s = p.Get()
+	doString(t, s, `debug.setmetatable("", {__index = function() return "pwned" end})`)
+	p.Put(s)
+	s = p.Get()
+	doString(t, s, `assert(("x"):upper() == "X")`)
+	p.Put(s)
+
+	// the metatables in the registry of files and json values
+	s = p.Get()
+	doString(t, s, `
+		local hijack = function() return "pwned" end
+		local file = debug.getmetatable(io.stdout)
+		file.write = hijack
+		file.foo = hijack
+		debug.getmetatable(json.object()).__jsontype = "array"
+		debug.getmetatable(json.null).__tostring = hijack
+		debug.setmetatable(json.null, nil)
+		debug.getregistry()["json.array"] = {}
+	`)
+	p.Put(s)
+	s = p.Get()
+	doString(t, s, `
+		assert(io.stdout:write("") == io.stdout)
+		assert(debug.getmetatable(io.stdout).foo == nil)
+		assert(json.encode(json.object({a = 1})) == '{"a":1}')
+		assert(json.encode(json.array({1})) == "[1]")
+		assert(tostring(json.null) == "null")
+	`)
+	p.Put(s)
+}
+
+func TestPoolDropsBrokenStates(t *testing.T) {
+	p := NewPool(1)
+	defer p.Close()
+
+	s := p.Get()
+	s.LuaState().Interrupt()
+	if err := s.DoString(`x = 1`); err == nil {
+		t.Fatal("want interrupted")
+	}
+	p.Put(s)
+	if s2 := p.Get(); s2 == s {
+		t.Error("the interrupted state is put back")
+	} else {
+		doString(t, s2, `x = 1`)
+		p.Put(s2)
+	}
+
+	s = p.Get()
+	doString(t, s, `collectgarbage("stop")`)
+	p.Put(s)
+	if s2 := p.Get(); s2 == s {
+		t.Error("the state with the collector stopped is put back")
+	} else {
+		doString(t, s2, `assert(collectgarbage("isrunning"))`)
+		p.Put(s2)
+	}
+}
@@ -0,0 +1,46 @@
+package lua
+
+import (
+	"luago/api"
+	"math"
+	"reflect"
+)
+
+// pushValue pushes the Go value v, nil, booleans, numbers, strings and
+// api.GoFunction become the Lua values, the unsigned integers above
+// math.MaxInt64 become floats, other values are wrapped by pushGoValue
+func pushValue(ls api.ILuaState, v interface{}) {
+	switch x := v.(type) {
+	case nil:
+		ls.PushNil()
+	case bool:
+		ls.PushBoolean(x)
+	case string:
+		ls.PushString(x)
+	case api.GoFunction:
+		ls.PushGoFunction(x)
+	case func(api.ILuaState) int:
+		ls.PushGoFunction(x)
+	default:
+		rv := reflect.ValueOf(v)
+		switch rv.Kind() {
+		case reflect.Bool:
+			ls.PushBoolean(rv.Bool())
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			ls.PushInteger(rv.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+			reflect.Uint64, reflect.Uintptr:
+			if n := rv.Uint(); n <= math.MaxInt64 {
+				ls.PushInteger(int64(n))
+			} else {
+				ls.PushNumber(float64(n))
+			}
+		case reflect.Float32, reflect.Float64:
+			ls.PushNumber(rv.Float())
+		case reflect.String:
+			ls.PushString(rv.String())
+		default:
+			pushGoValue(ls, v)
+		}
+	}
+}
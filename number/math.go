@@ -1,7 +1,6 @@
 package number
 
 import (
-	"fmt"
 	"math"
 	"regexp"
 	"strconv"
@@ -107,7 +106,6 @@ func ParseInteger(str string) (int64, bool) {
 	if str[0] == '-' {
 		sign = -1
 		str = str[3:] // -0x
-		fmt.Println("-")
 	} else {
 		sign = 1
 		str = str[2:] // 0x
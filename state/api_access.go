@@ -1,8 +1,8 @@
 package state
 
 import (
-	"fmt"
 	"luago/api"
+	"strconv"
 )
 
 // ------------------------------------
@@ -122,8 +122,12 @@ func (s *LuaState) ToStringX(idx int) (string, bool) {
 	switch x := val.(type) {
 	case string:
 		return x, true
-	case int64, float64:
-		str := fmt.Sprintf("%v", x)
+	case int64:
+		str := strconv.FormatInt(x, 10)
+		s.stack.set(idx, str) // NOTE: modify stack
+		return str, true
+	case float64:
+		str := formatFloat(x)
 		s.stack.set(idx, str) // NOTE: modify stack
 		return str, true
 	default:
@@ -136,3 +140,26 @@ func (s *LuaState) ToString(idx int) string {
 	str, _ := s.ToStringX(idx)
 	return str
 }
+
+// IsUserData returns if it is userdata
+func (s *LuaState) IsUserData(idx int) bool {
+	return s.Type(idx) == api.LuaTUserData
+}
+
+// ToUserData returns the data held by userdata, nil if it is not userdata
+func (s *LuaState) ToUserData(idx int) interface{} {
+	if ud, ok := s.stack.get(idx).(*userdata); ok {
+		return ud.data
+	}
+	return nil
+}
+
+// ToPointer returns the identity of table, function, userdata or thread at idx,
+// which is comparable and only used to tell objects apart, nil for other values
+func (s *LuaState) ToPointer(idx int) interface{} {
+	switch x := s.stack.get(idx).(type) {
+	case *LuaTable, *luaClosure, *userdata, *LuaState:
+		return x
+	}
+	return nil
+}
@@ -0,0 +1,516 @@
+package state
+
+import (
+	"fmt"
+	"io/ioutil"
+	"luago/api"
+	"strconv"
+	"strings"
+)
+
+// ------------------------------------
+//        auxiliary library
+// ------------------------------------
+
+// Error2 raises an error with the message formatted like fmt.Sprintf,
+// the position where the error occurred is added at the beginning
+func (s *LuaState) Error2(format string, a ...interface{}) int {
+	s.Where(1)
+	s.PushString(fmt.Sprintf(format, a...))
+	s.Concat(2)
+	return s.Error()
+}
+
+// ArgError raises an error reporting a problem with argument arg
+// of the Go function that called it
+func (s *LuaState) ArgError(arg int, extraMsg string) int {
+	frame := s.frameAt(0)
+	if frame == nil { // no stack frame
+		return s.Error2("bad argument #%d (%s)", arg, extraMsg)
+	}
+
+	name, kind := frame.funcName()
+	if kind == "method" {
+		arg-- // do not count 'self'
+		if arg == 0 {
+			return s.Error2("calling '%s' on bad self (%s)", name, extraMsg)
+		}
+	}
+	if name == "" {
+		name = s.globalFuncName(frame)
+	}
+	if name == "" {
+		name = "?"
+	}
+	return s.Error2("bad argument #%d to '%s' (%s)", arg, name, extraMsg)
+}
+
+// globalFuncName returns the name of the function of frame in the loaded
+// modules, like "string.rep", "_G." is dropped from the names of globals,
+// it's empty if the function isn't found
+func (s *LuaState) globalFuncName(frame *LuaStack) string {
+	top := s.GetTop()
+	defer s.SetTop(top)
+	if s.GetField(api.LuaRegistryIndex, "_LOADED") != api.LuaTTable {
+		return ""
+	}
+	fn := LuaValue(frame.closure)
+	s.PushNil()
+	for s.Next(-2) {
+		if s.Type(-2) == api.LuaTString && s.Type(-1) == api.LuaTTable {
+			s.PushNil()
+			for s.Next(-2) {
+				if s.Type(-2) == api.LuaTString && s.stack.get(-1) == fn {
+					name := s.ToString(-4) + "." + s.ToString(-2)
+					return strings.TrimPrefix(name, "_G.")
+				}
+				s.Pop(1)
+			}
+		}
+		s.Pop(1)
+	}
+	return ""
+}
+
+func (s *LuaState) typeError(arg int, tname string) int {
+	var typeArg string
+	if s.GetMetaField(arg, "__name") == api.LuaTString {
+		typeArg = s.ToString(-1)
+	} else if s.Type(arg) == api.LuaTLightUserData {
+		typeArg = "light userdata"
+	} else {
+		typeArg = s.TypeName2(arg)
+	}
+	return s.ArgError(arg, fmt.Sprintf("%s expected, got %s", tname, typeArg))
+}
+
+func (s *LuaState) tagError(arg int, tag api.LuaType) {
+	s.typeError(arg, s.TypeName(tag))
+}
+
+// CheckStack2 grows the stack, raises an error with msg if it fails
+func (s *LuaState) CheckStack2(sz int, msg string) {
+	if !s.CheckStack(sz) {
+		if msg != "" {
+			s.Error2("stack overflow (%s)", msg)
+		} else {
+			s.Error2("stack overflow")
+		}
+	}
+}
+
+// ArgCheck raises an error with extraMsg if cond is false
+func (s *LuaState) ArgCheck(cond bool, arg int, extraMsg string) {
+	if !cond {
+		s.ArgError(arg, extraMsg)
+	}
+}
+
+// CheckAny checks whether the function has an argument of any type at arg
+func (s *LuaState) CheckAny(arg int) {
+	if s.Type(arg) == api.LuaTNone {
+		s.ArgError(arg, "value expected")
+	}
+}
+
+// CheckType checks whether the argument arg has type t
+func (s *LuaState) CheckType(arg int, t api.LuaType) {
+	if s.Type(arg) != t {
+		s.tagError(arg, t)
+	}
+}
+
+// CheckInteger checks whether the argument arg is an integer
+// or can be converted to an integer, returns the integer
+func (s *LuaState) CheckInteger(arg int) int64 {
+	i, ok := s.ToIntegerX(arg)
+	if !ok {
+		if s.IsNumber(arg) {
+			s.ArgError(arg, "number has no integer representation")
+		} else {
+			s.tagError(arg, api.LuaTNumber)
+		}
+	}
+	return i
+}
+
+// CheckNumber checks whether the argument arg is a number, returns the number
+func (s *LuaState) CheckNumber(arg int) float64 {
+	f, ok := s.ToNumberX(arg)
+	if !ok {
+		s.tagError(arg, api.LuaTNumber)
+	}
+	return f
+}
+
+// CheckString checks whether the argument arg is a string, returns the string
+func (s *LuaState) CheckString(arg int) string {
+	str, ok := s.ToStringX(arg)
+	if !ok {
+		s.tagError(arg, api.LuaTString)
+	}
+	return str
+}
+
+// CheckOption checks whether the argument arg is a string in lst,
+// returns the index of the string, def is used if the argument is absent
+func (s *LuaState) CheckOption(arg int, def string, lst []string) int {
+	var name string
+	if def != "" {
+		name = s.OptString(arg, def)
+	} else {
+		name = s.CheckString(arg)
+	}
+
+	for i, opt := range lst {
+		if opt == name {
+			return i
+		}
+	}
+	return s.ArgError(arg, fmt.Sprintf("invalid option '%s'", name))
+}
+
+// OptInteger returns the integer at arg, d if the argument is absent or nil
+func (s *LuaState) OptInteger(arg int, d int64) int64 {
+	if s.IsNoneOrNil(arg) {
+		return d
+	}
+	return s.CheckInteger(arg)
+}
+
+// OptNumber returns the number at arg, d if the argument is absent or nil
+func (s *LuaState) OptNumber(arg int, d float64) float64 {
+	if s.IsNoneOrNil(arg) {
+		return d
+	}
+	return s.CheckNumber(arg)
+}
+
+// OptString returns the string at arg, d if the argument is absent or nil
+func (s *LuaState) OptString(arg int, d string) string {
+	if s.IsNoneOrNil(arg) {
+		return d
+	}
+	return s.CheckString(arg)
+}
+
+// NewMetatable creates a table with field __name = tname and registry[tname] = table,
+// returns false if the registry already has the key tname,
+// pushes the table associated with tname in both cases
+func (s *LuaState) NewMetatable(tname string) bool {
+	if s.GetField(api.LuaRegistryIndex, tname) != api.LuaTNil {
+		return false // name already in use
+	}
+	s.Pop(1)
+	s.CreateTable(0, 2)
+	s.PushString(tname)
+	s.SetField(-2, "__name")
+	s.PushValue(-1)
+	s.SetField(api.LuaRegistryIndex, tname)
+	return true
+}
+
+// SetMetatableByName sets the metatable of the object on the top
+// as the metatable associated with tname in registry
+func (s *LuaState) SetMetatableByName(tname string) {
+	s.GetField(api.LuaRegistryIndex, tname)
+	s.SetMetaTable(-2)
+}
+
+// GetMetaField pushes field e of the metatable of the object at index obj,
+// returns the type of the value, pushes nothing and returns LuaTNil
+// if the object has no metatable or no such field
+func (s *LuaState) GetMetaField(obj int, e string) api.LuaType {
+	if !s.GetMetaTable(obj) {
+		return api.LuaTNil
+	}
+	s.PushString(e)
+	tt := s.RawGet(-2)
+	if tt == api.LuaTNil {
+		s.Pop(2) // metatable and nil
+	} else {
+		s.Remove(-2) // metatable
+	}
+	return tt
+}
+
+// CallMeta calls the metamethod e of the object at index obj with the object,
+// pushes the result and returns true, returns false if there is no metamethod
+func (s *LuaState) CallMeta(obj int, e string) bool {
+	obj = s.AbsIndex(obj)
+	if s.GetMetaField(obj, e) == api.LuaTNil {
+		return false
+	}
+	s.PushValue(obj)
+	s.Call(1, 1)
+	return true
+}
+
+// TestUData returns the data of userdata at arg if its metatable is
+// the metatable associated with tname, nil otherwise
+func (s *LuaState) TestUData(arg int, tname string) interface{} {
+	ud, ok := s.stack.get(arg).(*userdata)
+	if !ok || ud.metatable == nil {
+		return nil
+	}
+	if mt, ok := s.registry.get(tname).(*LuaTable); !ok || mt != ud.metatable {
+		return nil
+	}
+	return ud.data
+}
+
+// CheckUData is like TestUData, but raises an error if the test fails
+func (s *LuaState) CheckUData(arg int, tname string) interface{} {
+	data := s.TestUData(arg, tname)
+	if data == nil {
+		s.typeError(arg, tname)
+	}
+	return data
+}
+
+// free list of references is kept in t[0]
+const freeList = 0
+
+// Ref pops the value on the top and stores it into the table at index t
+// with a fresh integer key, returns the key
+func (s *LuaState) Ref(t int) int {
+	if s.IsNil(-1) {
+		s.Pop(1)
+		return api.LuaRefNil
+	}
+
+	t = s.AbsIndex(t)
+	s.RawGetI(t, freeList)
+	ref := int64(s.ToInteger(-1)) // first free element
+	s.Pop(1)
+	if ref != 0 { // any free element?
+		s.RawGetI(t, ref)
+		s.RawSetI(t, freeList) // t[freeList] = t[ref]
+	} else {
+		ref = int64(s.RawLen(t)) + 1 // get a new reference
+	}
+	s.RawSetI(t, ref)
+	return int(ref)
+}
+
+// Unref releases the reference ref from the table at index t
+func (s *LuaState) Unref(t, ref int) {
+	if ref < 0 {
+		return
+	}
+	t = s.AbsIndex(t)
+	s.RawGetI(t, freeList)
+	s.RawSetI(t, int64(ref)) // t[ref] = t[freeList]
+	s.PushInteger(int64(ref))
+	s.RawSetI(t, freeList) // t[freeList] = ref
+}
+
+// NewLib creates a new table and registers the functions in l
+func (s *LuaState) NewLib(l api.FuncReg) {
+	s.CreateTable(0, len(l))
+	s.SetFuncs(l, 0)
+}
+
+// SetFuncs registers the functions in l into the table below the nup upvalues
+// on the top, all functions share the upvalues
+func (s *LuaState) SetFuncs(l api.FuncReg, nup int) {
+	s.CheckStack2(nup, "too many upvalues")
+	for name, fn := range l {
+		for i := 0; i < nup; i++ { // copy upvalues to the top
+			s.PushValue(-nup)
+		}
+		s.PushGoClosure(fn, nup)
+		s.SetField(-(nup + 2), name)
+	}
+	s.Pop(nup)
+}
+
+// GetSubTable pushes t[fname] where t is the table at idx, a new table is
+// created and assigned to t[fname] if it's not a table,
+// returns true if it finds a previous table
+func (s *LuaState) GetSubTable(idx int, fname string) bool {
+	if s.GetField(idx, fname) == api.LuaTTable {
+		return true // table already there
+	}
+	s.Pop(1) // remove previous result
+	idx = s.stack.absIndex(idx)
+	s.NewTable()
+	s.PushValue(-1)        // copy to be left at top
+	s.SetField(idx, fname) // assign new table to field
+	return false
+}
+
+// RequireF calls openf with modname to open a module if it's not in
+// package.loaded, sets package.loaded[modname] and the global modname
+// if glb is true to the result, leaves a copy of the module on the stack
+func (s *LuaState) RequireF(modname string, openf api.GoFunction, glb bool) {
+	s.GetSubTable(api.LuaRegistryIndex, "_LOADED")
+	s.GetField(-1, modname) // _LOADED[modname]
+	if !s.ToBoolean(-1) {   // package not already loaded?
+		s.Pop(1)
+		s.PushGoFunction(openf)
+		s.PushString(modname)   // argument to open function
+		s.Call(1, 1)            // call 'openf' to open module
+		s.PushValue(-1)         // make copy of module (call result)
+		s.SetField(-3, modname) // _LOADED[modname] = module
+	}
+	s.Remove(-2) // remove _LOADED table
+	if glb {
+		s.PushValue(-1)      // copy of module
+		s.SetGlobal(modname) // _G[modname] = module
+	}
+}
+
+// LoadString loads the string as a chunk
+func (s *LuaState) LoadString(str string) int {
+	return s.Load([]byte(str), str, "bt")
+}
+
+// LoadFile loads the file as a chunk
+func (s *LuaState) LoadFile(filename string) int {
+	return s.LoadFileX(filename, "bt")
+}
+
+// LoadFileX loads the file as a chunk with mode, the first line is
+// skipped if it starts with '#'
+func (s *LuaState) LoadFileX(filename, mode string) int {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		s.PushString(fmt.Sprintf("cannot open %s", filename))
+		return api.LuaErrFile
+	}
+	if len(data) > 0 && data[0] == '#' { // skip the first line
+		i := 0
+		for i < len(data) && data[i] != '\n' {
+			i++
+		}
+		data = data[i:]
+	}
+	return s.Load(data, "@"+filename, mode)
+}
+
+// DoString loads and runs the string, returns true if there are errors
+func (s *LuaState) DoString(str string) bool {
+	return s.LoadString(str) != api.LuaOk ||
+		s.PCall(0, -1, 0) != api.LuaOk
+}
+
+// DoFile loads and runs the file, returns true if there are errors
+func (s *LuaState) DoFile(filename string) bool {
+	return s.LoadFile(filename) != api.LuaOk ||
+		s.PCall(0, -1, 0) != api.LuaOk
+}
+
+// TypeName2 returns the name of type of the value at idx
+func (s *LuaState) TypeName2(idx int) string {
+	return s.TypeName(s.Type(idx))
+}
+
+// ToStringMeta converts the value at idx to a string in a reasonable format
+// like tostring, __tostring is used if the value has it,
+// the string is pushed and returned
+func (s *LuaState) ToStringMeta(idx int) string {
+	if s.CallMeta(idx, "__tostring") { // metafield?
+		if !s.IsString(-1) {
+			s.Error2("'__tostring' must return a string")
+		}
+		return s.ToString(-1)
+	}
+
+	val := s.stack.get(idx)
+	var str string
+	switch x := val.(type) {
+	case nil:
+		str = "nil"
+	case bool:
+		str = strconv.FormatBool(x)
+	case int64:
+		str = strconv.FormatInt(x, 10)
+	case float64:
+		str = formatFloat(x)
+	case string:
+		str = x
+	default:
+		tname := s.TypeName2(idx)
+		if s.GetMetaField(idx, "__name") == api.LuaTString {
+			tname = s.ToString(-1)
+			s.Pop(1)
+		}
+		str = fmt.Sprintf("%s: %p", tname, x)
+	}
+	s.PushString(str)
+	return str
+}
+
+// Len2 returns the length of the value at idx as an integer,
+// __len is used if the value has it
+func (s *LuaState) Len2(idx int) int64 {
+	s.Len(idx)
+	i, ok := s.ToIntegerX(-1)
+	if !ok {
+		s.Error2("object length is not an integer")
+	}
+	s.Pop(1)
+	return i
+}
+
+// Where pushes the current position of the function at level
+// in the form "chunkname:currentline: ", or "" if it's unknown
+func (s *LuaState) Where(level int) {
+	if frame := s.frameAt(level); frame != nil {
+		if line := frame.currentLine(); line > 0 {
+			s.PushString(fmt.Sprintf("%s:%d: ", frame.shortSource(), line))
+			return
+		}
+	}
+	s.PushString("")
+}
+
+// size of the first part and the second part of traceback
+const (
+	levels1 = 10
+	levels2 = 11
+)
+
+// Traceback pushes the traceback of the call stack from level,
+// msg is added at the beginning if it's not empty
+func (s *LuaState) Traceback(msg string, level int) {
+	var frames []*LuaStack
+	for frame := s.frameAt(level); frame != nil && frame.closure != nil; frame = frame.prev {
+		frames = append(frames, frame)
+	}
+
+	var buf strings.Builder
+	if msg != "" {
+		buf.WriteString(msg)
+		buf.WriteByte('\n')
+	}
+	buf.WriteString("stack traceback:")
+	for i, frame := range frames {
+		if len(frames) > levels1+levels2 && i >= levels1 && i < len(frames)-levels2 {
+			if i == levels1 {
+				buf.WriteString("\n\t...")
+			}
+			continue
+		}
+
+		buf.WriteString("\n\t")
+		buf.WriteString(frame.shortSource())
+		buf.WriteByte(':')
+		if line := frame.currentLine(); line > 0 {
+			buf.WriteString(strconv.Itoa(line))
+			buf.WriteByte(':')
+		}
+		buf.WriteString(" in ")
+		buf.WriteString(frame.funcDesc())
+		if frame.tailcall {
+			buf.WriteString("\n\t(...tail calls...)")
+		}
+	}
+	s.PushString(buf.String())
+}
+
+// GSub returns a copy of s in which all occurrences of p are replaced by r
+func (s *LuaState) GSub(str, p, r string) string {
+	return strings.Replace(str, p, r, -1)
+}
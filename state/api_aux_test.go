@@ -0,0 +1,162 @@
+package state
+
+import (
+	"luago/api"
+	"strings"
+	"testing"
+)
+
+func checkInt(ls api.ILuaState) int {
+	ls.PushInteger(ls.CheckInteger(1))
+	return 1
+}
+
+func TestAuxArgError(t *testing.T) {
+	ls := NewLuaState()
+	ls.Register("checkint", checkInt)
+
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"return checkint('x')", "[string \"return checkint('x')\"]:1: bad argument #1 to 'checkint' (number expected, got string)"},
+		{"return checkint(1.5)", "[string \"return checkint(1.5)\"]:1: bad argument #1 to 'checkint' (number has no integer representation)"},
+		{"local t = {f = checkint}\nreturn t.f()", "[string \"local t = {f = checkint}...\"]:2: bad argument #1 to 'f' (number expected, got no value)"},
+		{"local t = {f = checkint}\nreturn t:f()", "[string \"local t = {f = checkint}...\"]:2: calling 'f' on bad self (number expected, got table)"},
+	}
+	for _, test := range tests {
+		if !ls.DoString(test.src) {
+			t.Errorf("%q: want error", test.src)
+			continue
+		}
+		if msg := ls.ToString(-1); msg != test.want {
+			t.Errorf("%q: got %q", test.src, msg)
+		}
+		ls.Pop(1)
+	}
+
+	if ls.DoString("return checkint('42') + 1") {
+		t.Fatal(ls.ToString(-1))
+	}
+	if got := ls.ToInteger(-1); got != 43 {
+		t.Errorf("got %d", got)
+	}
+}
+
+func TestAuxArgErrorGlobalName(t *testing.T) {
+	ls := NewLuaState()
+	ls.Register("checkint", checkInt)
+	ls.GetSubTable(api.LuaRegistryIndex, "_LOADED")
+	ls.PushGlobalTable()
+	ls.SetField(-2, "_G")
+	ls.NewLib(api.FuncReg{"check": checkInt})
+	ls.SetField(-2, "mod")
+	ls.Pop(1)
+
+	// called from Go, the name is found in the loaded modules
+	for _, test := range []struct{ mod, name, want string }{
+		{"_G", "checkint", "bad argument #1 to 'checkint' (number expected, got no value)"},
+		{"mod", "check", "bad argument #1 to 'mod.check' (number expected, got no value)"},
+	} {
+		ls.GetSubTable(api.LuaRegistryIndex, "_LOADED")
+		ls.GetField(-1, test.mod)
+		ls.GetField(-1, test.name)
+		ls.Insert(1)
+		ls.SetTop(1)
+		if ls.PCall(0, 0, 0) == api.LuaOk {
+			t.Errorf("%s: want error", test.name)
+			continue
+		}
+		if msg := ls.ToString(-1); msg != test.want {
+			t.Errorf("%s: got %q", test.name, msg)
+		}
+		ls.Pop(1)
+	}
+	if top := ls.GetTop(); top != 0 {
+		t.Errorf("top = %d", top)
+	}
+}
+
+func TestAuxRef(t *testing.T) {
+	ls := NewLuaState()
+	ls.NewTable()
+	ls.PushString("a")
+	r1 := ls.Ref(1)
+	ls.PushString("b")
+	r2 := ls.Ref(1)
+	if r1 != 1 || r2 != 2 {
+		t.Fatalf("refs = %d, %d", r1, r2)
+	}
+	ls.PushNil()
+	if r := ls.Ref(1); r != api.LuaRefNil {
+		t.Errorf("ref of nil = %d", r)
+	}
+
+	ls.Unref(1, r1)
+	ls.PushString("c")
+	if r := ls.Ref(1); r != r1 { // reuses the freed reference
+		t.Errorf("ref = %d, want %d", r, r1)
+	}
+	ls.RawGetI(1, int64(r1))
+	if s := ls.ToString(-1); s != "c" {
+		t.Errorf("got %q", s)
+	}
+}
+
+func traceback(ls api.ILuaState) int {
+	ls.Traceback(ls.ToString(1), 1)
+	return 1
+}
+
+func TestAuxTraceback(t *testing.T) {
+	ls := NewLuaState()
+	ls.Register("traceback", traceback)
+	src := "local function f()\n  return (traceback('msg'))\nend\nfunction g()\n  return (f())\nend\nreturn (g())"
+	if ls.DoString(src) {
+		t.Fatal(ls.ToString(-1))
+	}
+	want := strings.Join([]string{
+		"msg",
+		"stack traceback:",
+		"\t[string \"local function f()...\"]:2: in upvalue 'f'",
+		"\t[string \"local function f()...\"]:5: in function 'g'",
+		"\t[string \"local function f()...\"]:7: in main chunk",
+	}, "\n")
+	if got := ls.ToString(-1); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestAuxToStringMeta(t *testing.T) {
+	ls := NewLuaState()
+	tests := []struct {
+		push func()
+		want string
+	}{
+		{ls.PushNil, "nil"},
+		{func() { ls.PushInteger(-3) }, "-3"},
+		{func() { ls.PushNumber(2) }, "2.0"},
+		{func() { ls.PushNumber(0.1) }, "0.1"},
+		{func() { ls.PushNumber(1e100) }, "1e+100"},
+		{func() { ls.PushBoolean(true) }, "true"},
+	}
+	for _, test := range tests {
+		test.push()
+		if got := ls.ToStringMeta(-1); got != test.want {
+			t.Errorf("got %q, want %q", got, test.want)
+		}
+		ls.SetTop(0)
+	}
+
+	ls.NewTable()
+	ls.NewMetatable("point")
+	ls.PushGoFunction(func(ls api.ILuaState) int {
+		ls.PushString("(1, 2)")
+		return 1
+	})
+	ls.SetField(-2, "__tostring")
+	ls.SetMetaTable(1)
+	if got := ls.ToStringMeta(1); got != "(1, 2)" {
+		t.Errorf("got %q", got)
+	}
+}
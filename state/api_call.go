@@ -1,109 +1,320 @@
 package state
 
 import (
+	"fmt"
 	"luago/api"
 	"luago/binchunk"
+	"luago/compiler"
 	"luago/vm"
+	"strings"
+	"sync/atomic"
 )
 
 // Load chunk from binary or text file(compile)
 // mode: b(binary), t(text file), bt
-// return status code, 0 is ok, 1 is error
+// return status code, 0 is ok, the error message is pushed if there is an error
 func (s *LuaState) Load(chunk []byte, chunkName, mode string) int {
-	// TODO: load from text file
-	proto := binchunk.Undump(chunk)
+	compile := CompileLevel
+	if s.protoCache != nil {
+		compile = s.protoCache.CompileLevel
+	}
+	proto, err := compile(chunk, chunkName, mode, s.optLevel)
+	if err != nil {
+		s.stack.push(err.Error())
+		return api.LuaErrSyntax
+	}
+	s.PushProto(proto)
+	return api.LuaOk
+}
+
+// SetOptLevel sets the level of optimizations of the text chunks loaded
+// by Load, compiler.LevelFold by default, the threads created later share
+// it. The lower levels keep the line info and variables of the code closer
+// to the source for debugging
+func (s *LuaState) SetOptLevel(level compiler.Level) {
+	s.optLevel = level
+}
+
+// Compile compiles the text chunk or undumps the binary chunk, mode is
+// the same as Load, returns the prototype of main function, which is
+// never changed so it can be shared by states, see PushProto
+func Compile(chunk []byte, chunkName, mode string) (*binchunk.ProtoType, error) {
+	return CompileLevel(chunk, chunkName, mode, compiler.LevelFold)
+}
+
+// CompileLevel is like Compile, but compiles the text chunk with the
+// optimizations of level
+func CompileLevel(chunk []byte, chunkName, mode string, level compiler.Level) (proto *binchunk.ProtoType, err error) {
+	if mode == "" {
+		mode = "bt"
+	}
+	isBinary := strings.HasPrefix(string(chunk), "\x1bLua")
+	if isBinary && !strings.Contains(mode, "b") {
+		return nil, fmt.Errorf("attempt to load a binary chunk (mode is '%s')", mode)
+	}
+	if !isBinary && !strings.Contains(mode, "t") {
+		return nil, fmt.Errorf("attempt to load a text chunk (mode is '%s')", mode)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			proto, err = nil, fmt.Errorf("%v", r)
+		}
+	}()
+
+	if isBinary {
+		return binchunk.Undump(chunk), nil
+	}
+	return compiler.CompileLevel(string(chunk), chunkName, level), nil
+}
+
+// PushProto pushes a new Lua function of the main function prototype proto,
+// its first upvalue, if any, is set to the global table like Load
+func (s *LuaState) PushProto(proto *binchunk.ProtoType) {
 	c := newLuaClosure(proto)
 	s.stack.push(c)
+	s.gc.debt++
 
 	if len(proto.Upvalues) > 0 { // set _ENV
 		env := s.registry.get(api.LuaRidxGlobals)
-		c.upvals[0] = &upvalue{&env}
+		c.upvals[0] = newClosedUpvalue(env)
 	}
+}
 
-	return api.LuaOk
+// ToProto returns the prototype of the Lua function at idx,
+// nil if the value is not a Lua function
+func (s *LuaState) ToProto(idx int) *binchunk.ProtoType {
+	if c, ok := s.stack.get(idx).(*luaClosure); ok {
+		return c.proto
+	}
+	return nil
+}
+
+// Interrupt makes the running Lua code of all threads raise error
+// "interrupted" at the next instruction and every later one, so the state
+// can't run Lua code any more, it's safe to call from other goroutines
+func (s *LuaState) Interrupt() {
+	atomic.StoreInt32(&s.main.interrupted, 1)
+}
+
+// Interrupted reports whether Interrupt is called on any thread of the state
+func (s *LuaState) Interrupted() bool {
+	return atomic.LoadInt32(&s.main.interrupted) != 0
 }
 
 // Call function in stack top
 func (s *LuaState) Call(nArgs, nResults int) {
-	// push args
+	c, nArgs := s.resolveCall(nArgs)
+
+	s.nGoCalls++
+	if s.nGoCalls > api.LuaMaxCCalls {
+		panic("Go stack overflow")
+	}
+
+	if c.proto != nil {
+		// fmt.Printf("call %s(%d, %d)\n", c.proto.Source,
+		// 	c.proto.LineDefined, c.proto.LastLineDefined) // debug info
+		s.callLuaClosure(nArgs, nResults, c)
+	} else {
+		s.callGoClosure(nArgs, nResults, c)
+	}
+	s.nGoCalls--
+}
+
+// SetMaxCallDepth sets the max depth of call stack, returns the old one
+// limit <= 0 only queries the current limit
+func (s *LuaState) SetMaxCallDepth(limit int) int {
+	old := s.maxCallDepth
+	if limit > 0 {
+		s.maxCallDepth = limit
+	}
+	return old
+}
+
+// PrepareCall calls the Go function in stack immediately and returns true,
+// or pushes the frame of the Lua function and returns false,
+// the frame is run by the dispatch loop
+func (s *LuaState) PrepareCall(nArgs, nResults int) bool {
+	c, nArgs := s.resolveCall(nArgs)
+	if c.proto == nil {
+		s.callGoClosure(nArgs, nResults, c)
+		return true
+	}
+
+	s.pushLuaStack(s.newLuaFrame(c, nArgs, nResults))
+	return false
+}
+
+// PrepareTailCall is like PrepareCall, but the frame of
+// the Lua function replaces the frame of current function
+func (s *LuaState) PrepareTailCall(nArgs int) bool {
+	c, nArgs := s.resolveCall(nArgs)
+	if c.proto == nil {
+		s.callGoClosure(nArgs, -1, c)
+		return true
+	}
+
+	caller := s.stack
+	s.CloseUpvalues(1)
+
+	// moves the function and arguments to the slot of current function
+	slots := caller.vs.slots
+	from := caller.base + caller.top - nArgs - 1
+	copy(slots[caller.funcIdx:], slots[from:from+nArgs+1])
+	s.popLuaStack()
+	s.stack.top = caller.funcIdx + nArgs + 1 - s.stack.base
+
+	frame := s.newLuaFrame(c, nArgs, caller.nResults)
+	frame.fresh = caller.fresh
+	frame.tailcall = true
+	s.pushLuaStack(frame)
+	return false
+}
+
+// gets the function to be called, tries the __call metamethod
+// if the value is not a function
+func (s *LuaState) resolveCall(nArgs int) (*luaClosure, int) {
 	val := s.stack.get(-(nArgs + 1))
-	c, ok := val.(*luaClosure)
-
-	if !ok {
-		if mf := getMetaField(val, "__call", s); mf != nil {
-			if c, ok = mf.(*luaClosure); ok {
-				s.stack.push(val)
-				s.Insert(-(nArgs + 2))
-				nArgs++
-			}
-		}
+	if c, ok := val.(*luaClosure); ok {
+		return c, nArgs
 	}
 
-	if ok {
-		// lua closure
-		if c.proto != nil {
-			// fmt.Printf("call %s(%d, %d)\n", c.proto.Source,
-			// 	c.proto.LineDefined, c.proto.LastLineDefined) // debug info
-			s.callLuaClosure(nArgs, nResults, c)
-		} else if c.goFunc != nil {
-			s.callGoClosure(nArgs, nResults, c)
+	if mf := getMetaField(val, "__call", s); mf != nil {
+		if c, ok := mf.(*luaClosure); ok {
+			s.stack.push(mf)
+			s.Insert(-(nArgs + 2))
+			return c, nArgs + 1
 		}
-	} else {
-		panic("no function!")
 	}
+	s.opTypeError(val, "call")
+	return nil, 0
 }
 
-func (s *LuaState) callLuaClosure(nArgs, nResults int, c *luaClosure) {
-	// prepare
+// makes the frame for the function and arguments on the top of
+// current frame, the arguments become the first slots of the frame
+func (s *LuaState) newFrame(c *luaClosure, nArgs, nResults int) *LuaStack {
+	caller := s.stack
+	funcIdx := caller.base + caller.top - nArgs - 1
+	caller.top -= nArgs + 1
+
+	return &LuaStack{
+		vs:       caller.vs,
+		base:     funcIdx + 1,
+		top:      nArgs,
+		closure:  c,
+		funcIdx:  funcIdx,
+		nResults: nResults,
+		state:    s,
+	}
+}
+
+func (s *LuaState) newLuaFrame(c *luaClosure, nArgs, nResults int) *LuaStack {
 	nRegs := int(c.proto.MaxStackSize)
 	nParams := int(c.proto.NumParams) // fixed parameters
 	isVararg := c.proto.IsVararg == 1
-	newStack := newLuaStack(nRegs+api.LuaMinStack, s)
-	newStack.closure = c
+	frame := s.newFrame(c, nArgs, nResults)
 
-	funcAndArgs := s.stack.popN(nArgs + 1)
-	newStack.pushN(funcAndArgs[1:], nParams)
-	newStack.top = nRegs
-	if nArgs > nParams && isVararg {
-		newStack.varargs = funcAndArgs[nParams+1:]
+	nFixed := nArgs
+	if nFixed > nParams {
+		nFixed = nParams
 	}
+	if isVararg && nArgs > nParams {
+		// the varargs stay below base
+		frame.nVarargs = nArgs - nParams
+		frame.base += nArgs
+	}
+	frame.top = 0
+	frame.check(nRegs + api.LuaMinStack)
 
-	s.pushLuaStack(newStack)
-	s.runLuaClosure()
-	s.popLuaStack()
-
-	if nResults != 0 {
-		results := newStack.popN(newStack.top - nRegs)
-		s.stack.check(len(results))
-		s.stack.pushN(results, nResults)
+	slots := frame.vs.slots
+	if frame.nVarargs > 0 { // moves fixed parameters above the varargs
+		from := frame.funcIdx + 1
+		copy(slots[frame.base:], slots[from:from+nFixed])
+		for i := from; i < from+nFixed; i++ {
+			slots[i] = nil
+		}
 	}
+	for i := frame.base + nFixed; i < frame.base+nRegs; i++ {
+		slots[i] = nil
+	}
+	frame.top = nRegs
+	return frame
 }
 
-func (s *LuaState) runLuaClosure() {
+func (s *LuaState) callLuaClosure(nArgs, nResults int, c *luaClosure) {
+	frame := s.newLuaFrame(c, nArgs, nResults)
+	frame.fresh = true
+	s.pushLuaStack(frame)
+	s.execute()
+}
+
+// execute runs the dispatch loop until the frame called from Go returns,
+// calls between Lua functions don't recurse on the Go stack
+func (s *LuaState) execute() {
 	for {
+		s.checkGC()
+		if atomic.LoadInt32(&s.main.interrupted) != 0 {
+			s.stack.push("interrupted")
+			s.Error()
+		}
 		inst := vm.Instruction(s.Fetch())
 		inst.Execute(s)
 
 		if inst.Opcode() == vm.OpRETURN {
-			break
+			if s.postCall() {
+				return
+			}
 		}
 	}
 }
 
+// pops the returned frame and moves the results to the caller,
+// returns true if the frame was called from Go
+func (s *LuaState) postCall() bool {
+	frame := s.stack
+	nRegs := int(frame.closure.proto.MaxStackSize)
+	s.popLuaStack()
+	s.moveResults(frame, frame.top-nRegs)
+	if frame.fresh {
+		return true
+	}
+
+	// the caller is a Lua function suspended in CALL instruction
+	caller := s.stack
+	inst := vm.Instruction(caller.closure.proto.Code[caller.pc-1])
+	inst.FinishCall(s)
+	return false
+}
+
 func (s *LuaState) callGoClosure(nArgs, nResults int, c *luaClosure) {
-	newStack := newLuaStack(nArgs+api.LuaMaxStack, s)
-	newStack.closure = c
-	args := s.stack.popN(nArgs)
-	newStack.pushN(args, nArgs)
-	s.stack.pop()
+	frame := s.newFrame(c, nArgs, nResults)
+	frame.check(api.LuaMinStack)
 
-	s.pushLuaStack(newStack)
+	s.pushLuaStack(frame)
 	r := c.goFunc(s) // call
 	s.popLuaStack()
+	s.moveResults(frame, r)
+}
+
+// moves the n values on the top of the returned frame to the slot
+// of the called function, adjusts them to the count wanted by caller
+func (s *LuaState) moveResults(frame *LuaStack, n int) {
+	caller := s.stack
+	wanted := frame.nResults
+	if wanted < 0 {
+		wanted = n
+	}
+	caller.check(wanted)
 
-	if nResults != 0 {
-		results := newStack.popN(r)
-		s.stack.check(len(results))
-		s.stack.pushN(results, nResults)
+	slots := frame.vs.slots
+	src := frame.base + frame.top - n
+	dst := frame.funcIdx
+	if n > wanted {
+		n = wanted
+	}
+	copy(slots[dst:dst+n], slots[src:src+n])
+	for i := dst + n; i < frame.base+frame.top || i < dst+wanted; i++ {
+		slots[i] = nil
 	}
+	caller.top = dst + wanted - caller.base
 }
@@ -0,0 +1,77 @@
+package state
+
+import (
+	"luago/api"
+	"testing"
+)
+
+func recurse(ls api.ILuaState) int {
+	ls.PushGoFunction(recurse)
+	ls.Call(0, 0)
+	return 0
+}
+
+func TestCallLimit(t *testing.T) {
+	ls := NewLuaState()
+	ls.PushGoFunction(recurse)
+	if ls.PCall(0, 0, 0) != api.LuaErrRun {
+		t.Fatal("want error")
+	}
+	if msg := ls.ToString(-1); msg != "Go stack overflow" {
+		t.Errorf("got %q", msg)
+	}
+	ls.Pop(1)
+
+	// the counter of nested calls is restored by PCall
+	if ls.nGoCalls != 0 {
+		t.Errorf("nGoCalls = %d", ls.nGoCalls)
+	}
+
+	if old := ls.SetMaxCallDepth(10); old != api.LuaMaxCallDepth {
+		t.Errorf("old limit = %d", old)
+	}
+	ls.PushGoFunction(recurse)
+	if ls.PCall(0, 0, 0) != api.LuaErrRun {
+		t.Fatal("want error")
+	}
+	if msg := ls.ToString(-1); msg != "stack overflow" {
+		t.Errorf("got %q", msg)
+	}
+	if ls.GetTop() != 1 {
+		t.Errorf("top = %d", ls.GetTop())
+	}
+}
+
+func add(ls api.ILuaState) int {
+	ls.PushInteger(ls.ToInteger(1) + ls.ToInteger(2))
+	return 1
+}
+
+func TestCallGoFunction(t *testing.T) {
+	ls := NewLuaState()
+	ls.PushInteger(100)
+	for i := 0; i < 1000; i++ {
+		ls.PushGoFunction(add)
+		ls.PushValue(1)
+		ls.PushInteger(int64(i))
+		ls.Call(2, 1)
+		if got := ls.ToInteger(-1); got != int64(100+i) {
+			t.Fatalf("got %d", got)
+		}
+		ls.Pop(1)
+	}
+	if ls.GetTop() != 1 || len(ls.stack.vs.slots) > 2*api.LuaMinStack {
+		t.Errorf("top = %d, slots = %d", ls.GetTop(), len(ls.stack.vs.slots))
+	}
+}
+
+func BenchmarkCallGoFunction(b *testing.B) {
+	ls := NewLuaState()
+	for i := 0; i < b.N; i++ {
+		ls.PushGoFunction(add)
+		ls.PushInteger(1)
+		ls.PushInteger(2)
+		ls.Call(2, 1)
+		ls.Pop(1)
+	}
+}
@@ -1,7 +1,9 @@
 package state
 
 import (
+	"fmt"
 	"luago/api"
+	"runtime"
 )
 
 func (s *LuaState) Error() int {
@@ -9,18 +11,53 @@ func (s *LuaState) Error() int {
 	panic(err)
 }
 
-// PCall catches the error and rethrows the error if there is an error
-// pushes the error status code
+// runError raises an error with the message formatted like fmt.Sprintf,
+// the position is added at the beginning if the running function is a Lua function
+func (s *LuaState) runError(format string, a ...interface{}) {
+	msg := fmt.Sprintf(format, a...)
+	if frame := s.stack; frame.isLua() {
+		msg = fmt.Sprintf("%s:%d: %s", frame.shortSource(), frame.currentLine(), msg)
+	}
+	panic(msg)
+}
+
+// extra depth of call stack for the message handler,
+// so that it can be called after a stack overflow
+const extraCallDepth = 20
+
+// PCall calls the function in protected mode, the function and arguments
+// are removed and the error object is pushed if there is an error,
+// msgh is the stack index of the message handler, 0 means no handler,
+// the handler is called with the error object before the stack is unwound
 func (s *LuaState) PCall(nArgs, nResults, msgh int) (status int) {
 	caller := s.stack
+	oldTop := caller.top - nArgs - 1
+	nGoCalls := s.nGoCalls
+	var handler LuaValue
+	if msgh != 0 {
+		handler = s.stack.get(msgh)
+	}
+
 	status = api.LuaErrRun
 	// catch error
 	defer func() {
 		if err := recover(); err != nil {
+			if _, ok := err.(threadKilled); ok {
+				panic(err)
+			}
+			errVal := errorValue(err)
+			if handler != nil {
+				s.nGoCalls = nGoCalls
+				errVal, status = s.callHandler(handler, errVal)
+			}
 			for s.stack != caller {
 				s.popLuaStack()
 			}
-			s.stack.push(err)
+			s.nGoCalls = nGoCalls
+			for caller.top > oldTop {
+				caller.pop()
+			}
+			s.stack.push(errVal)
 		}
 	}()
 
@@ -28,3 +65,40 @@ func (s *LuaState) PCall(nArgs, nResults, msgh int) (status int) {
 	status = api.LuaOk
 	return
 }
+
+// calls the message handler with the error object in the frame
+// where the error occurred, returns the handled error object
+func (s *LuaState) callHandler(handler, errVal LuaValue) (result LuaValue, status int) {
+	maxCallDepth := s.maxCallDepth
+	s.maxCallDepth += extraCallDepth
+	defer func() {
+		s.maxCallDepth = maxCallDepth
+		if err := recover(); err != nil {
+			if _, ok := err.(threadKilled); ok {
+				panic(err)
+			}
+			result, status = "error in error handling", api.LuaErrErr
+		}
+	}()
+
+	s.stack.check(2)
+	s.stack.push(handler)
+	s.stack.push(errVal)
+	s.Call(1, 1)
+	return s.stack.pop(), api.LuaErrRun
+}
+
+// converts the value recovered from a panic to a Lua value,
+// the panics of Go code become strings
+func errorValue(err interface{}) LuaValue {
+	switch x := err.(type) {
+	case bool, int64, float64, string, *LuaTable, *luaClosure, *userdata, *LuaState:
+		return x
+	case *runtime.PanicNilError: // error(nil)
+		return nil
+	case error:
+		return x.Error()
+	default:
+		return fmt.Sprint(x)
+	}
+}
@@ -0,0 +1,96 @@
+package state
+
+import (
+	"luago/api"
+	"testing"
+)
+
+func TestErrorMessages(t *testing.T) {
+	ls := NewLuaState()
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"return x.y", `[string "return x.y"]:1: attempt to index a nil value (global 'x')`},
+		{"local t = {} return t.a.b", `[string "local t = {} return t.a.b"]:1: attempt to index a nil value (field 'a')`},
+		{"local s return s()", `[string "local s return s()"]:1: attempt to call a nil value (local 's')`},
+		{"return {} + 1", `[string "return {} + 1"]:1: attempt to perform arithmetic on a table value`},
+		{"return 1.5 | 1", `[string "return 1.5 | 1"]:1: number has no integer representation`},
+		{"return 1 // 0", `[string "return 1 // 0"]:1: attempt to perform 'n//0'`},
+		{"return {} < {}", `[string "return {} < {}"]:1: attempt to compare two table values`},
+		{"return 1 < 'x'", `[string "return 1 < 'x'"]:1: attempt to compare number with string`},
+		{"return #nil", `[string "return #nil"]:1: attempt to get length of a nil value`},
+		{"return 'a' .. {}", `[string "return 'a' .. {}"]:1: attempt to concatenate a table value`},
+		{"local t = {} t[nil] = 1", `[string "local t = {} t[nil] = 1"]:1: table index is nil`},
+		{"local t = {} t[0/0] = 1", `[string "local t = {} t[0/0] = 1"]:1: table index is NaN`},
+	}
+	for _, test := range tests {
+		if !ls.DoString(test.src) {
+			t.Errorf("%q: want error", test.src)
+			continue
+		}
+		if msg := ls.ToString(-1); msg != test.want {
+			t.Errorf("%q: got %q", test.src, msg)
+		}
+		ls.SetTop(0)
+	}
+}
+
+func raise(ls api.ILuaState) int {
+	ls.PushString("x")
+	return ls.Error()
+}
+
+func TestPCallMessageHandler(t *testing.T) {
+	ls := NewLuaState()
+	ls.PushGoFunction(func(ls api.ILuaState) int {
+		ls.PushString("handled: " + ls.ToString(1))
+		return 1
+	})
+	ls.PushGoFunction(raise)
+	if status := ls.PCall(0, 0, 1); status != api.LuaErrRun {
+		t.Fatalf("status = %d", status)
+	}
+	if got := ls.ToString(-1); got != "handled: x" {
+		t.Errorf("got %q", got)
+	}
+	if top := ls.GetTop(); top != 2 {
+		t.Errorf("top = %d", top)
+	}
+
+	// error in the handler
+	ls.SetTop(0)
+	ls.PushGoFunction(func(ls api.ILuaState) int {
+		ls.PushString("again")
+		return ls.Error()
+	})
+	ls.PushGoFunction(raise)
+	if status := ls.PCall(0, 0, 1); status != api.LuaErrErr {
+		t.Fatalf("status = %d", status)
+	}
+	if got := ls.ToString(-1); got != "error in error handling" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestUpvalueAccess(t *testing.T) {
+	ls := NewLuaState()
+	if ls.DoString("local a, b = 1, 2 return function() return a + b end") {
+		t.Fatal(ls.ToString(-1))
+	}
+	if name, ok := ls.GetUpvalue(1, 2); !ok || name != "b" || ls.ToInteger(-1) != 2 {
+		t.Errorf("upvalue 2 = %q, %v", name, ok)
+	}
+	ls.Pop(1)
+	ls.PushInteger(40)
+	if name, ok := ls.SetUpvalue(1, 2); !ok || name != "b" {
+		t.Errorf("set upvalue 2 = %q, %v", name, ok)
+	}
+	if _, ok := ls.GetUpvalue(1, 3); ok {
+		t.Error("upvalue 3 exists")
+	}
+	ls.Call(0, 1)
+	if got := ls.ToInteger(-1); got != 41 {
+		t.Errorf("got %d", got)
+	}
+}
@@ -0,0 +1,51 @@
+package state
+
+import "luago/api"
+
+// GC controls the garbage collector, see api.LuaGCxxx for the options,
+// a step always runs a full cycle since the collection is not incremental
+func (s *LuaState) GC(what, data int) int {
+	g := s.gc
+	switch what {
+	case api.LuaGCStop:
+		g.running = false
+	case api.LuaGCRestart:
+		g.running = true
+	case api.LuaGCCollect:
+		s.fullGC()
+	case api.LuaGCCount:
+		return g.count() >> 10
+	case api.LuaGCCountB:
+		return g.count() & 0x3ff
+	case api.LuaGCStep:
+		s.fullGC()
+		return 1 // the cycle is finished
+	case api.LuaGCSetPause:
+		old := g.pause
+		g.pause = data
+		return old
+	case api.LuaGCSetStepMul:
+		old := g.stepMul
+		g.stepMul = data
+		return old
+	case api.LuaGCIsRunning:
+		if g.running {
+			return 1
+		}
+	default:
+		return -1 // invalid option
+	}
+	return 0
+}
+
+// Close ends the suspended threads and calls the finalizers of all objects
+// marked for finalization, the state should not be used after it is closed
+func (s *LuaState) Close() {
+	s.killThreads(true)
+	objs := s.gc.finobjs
+	s.gc.finobjs = nil
+	for _, obj := range objs {
+		headerOf(obj).fin = false
+	}
+	s.callFinalizers(objs)
+}
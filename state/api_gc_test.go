@@ -0,0 +1,116 @@
+package state
+
+import (
+	"luago/api"
+	"testing"
+)
+
+// pushes a table with metatable {__mode = mode}
+func pushWeakTable(ls *LuaState, mode string) {
+	ls.NewTable()
+	ls.NewTable()
+	ls.PushString(mode)
+	ls.SetField(-2, "__mode")
+	ls.SetMetaTable(-2)
+}
+
+func countPairs(ls *LuaState, idx int) int {
+	idx = ls.AbsIndex(idx)
+	n := 0
+	ls.PushNil()
+	for ls.Next(idx) {
+		ls.Pop(1)
+		n++
+	}
+	return n
+}
+
+func TestGCWeakTable(t *testing.T) {
+	ls := NewLuaState()
+	pushWeakTable(ls, "v") // 1
+	pushWeakTable(ls, "k") // 2
+	ls.NewTable()          // 3, reachable key and value
+
+	ls.NewTable()
+	ls.SetI(1, 1) // t1[1] = {}
+	ls.PushValue(3)
+	ls.SetI(1, 2) // t1[2] = t3
+	ls.PushString("str")
+	ls.SetI(1, 3) // t1[3] = "str"
+
+	ls.NewTable()
+	ls.PushInteger(1)
+	ls.SetTable(2) // t2[{}] = 1
+	ls.PushValue(3)
+	ls.PushValue(3)
+	ls.SetTable(2) // t2[t3] = t3
+	ls.NewTable()
+	ls.PushValue(-1)
+	ls.SetField(-2, "self")
+	ls.NewTable()
+	ls.Insert(-2)
+	ls.SetTable(2) // t2[{}] = {self = ...}, an ephemeron cycle
+
+	ls.GC(api.LuaGCCollect, 0)
+	if n := countPairs(ls, 1); n != 2 {
+		t.Errorf("weak values, got %d pairs, want 2", n)
+	}
+	if n := countPairs(ls, 2); n != 1 {
+		t.Errorf("weak keys, got %d pairs, want 1", n)
+	}
+}
+
+func TestGCFinalizer(t *testing.T) {
+	ls := NewLuaState()
+	var order []int64
+	ls.NewTable() // keeps the resurrected objects
+
+	pushFinalizable := func(id int64) {
+		ls.NewTable()
+		ls.PushInteger(id)
+		ls.SetField(-2, "id")
+		ls.NewTable()
+		ls.PushValue(1)
+		ls.PushGoClosure(gcSaver(&order), 1)
+		ls.SetField(-2, "__gc")
+		ls.SetMetaTable(-2)
+	}
+	for i := int64(1); i <= 3; i++ {
+		pushFinalizable(i)
+		ls.Pop(1)
+	}
+	pushFinalizable(4) // reachable
+
+	ls.GC(api.LuaGCCollect, 0)
+	if len(order) != 3 || order[0] != 3 || order[1] != 2 || order[2] != 1 {
+		t.Fatalf("order of finalizers = %v", order)
+	}
+	if n := countPairs(ls, 1); n != 3 {
+		t.Errorf("resurrected %d objects, want 3", n)
+	}
+
+	// finalizers are called only once
+	ls.Remove(1)
+	ls.GC(api.LuaGCCollect, 0)
+	if len(order) != 3 {
+		t.Errorf("order of finalizers = %v", order)
+	}
+
+	ls.Close()
+	if len(order) != 4 || order[3] != 4 {
+		t.Errorf("order of finalizers = %v", order)
+	}
+}
+
+// returns the __gc which records the id of object and resurrects
+// the object into the table in upvalue
+func gcSaver(order *[]int64) api.GoFunction {
+	return func(ls api.ILuaState) int {
+		ls.GetField(1, "id")
+		*order = append(*order, ls.ToInteger(-1))
+		ls.Pop(1)
+		ls.PushValue(1)
+		ls.SetI(api.LuaUpvalueIndex(1), int64(len(*order)))
+		return 0
+	}
+}
@@ -5,12 +5,50 @@ func (s *LuaState) Next(idx int) bool {
 	tbVal := s.stack.get(idx)
 	if tb, ok := tbVal.(*LuaTable); ok {
 		key := s.stack.pop()
-		if nextKey := tb.nextKey(key); nextKey != nil {
+		if nextKey, val := tb.next(key); nextKey != nil {
 			s.stack.push(nextKey)
-			s.stack.push(tb.get(nextKey))
+			s.stack.push(val)
 			return true
 		}
 		return false
 	}
 	panic("api_misc: Next: table expected")
 }
+
+// returns the n-th(from 1) upvalue of the function at funcIdx and its name
+func (s *LuaState) upvalueAt(funcIdx, n int) (*upvalue, string) {
+	c, ok := s.stack.get(funcIdx).(*luaClosure)
+	if !ok || n < 1 || n > len(c.upvals) {
+		return nil, ""
+	}
+	if c.proto == nil { // Go closure
+		return c.upvals[n-1], ""
+	}
+	if n <= len(c.proto.UpvalueNames) {
+		return c.upvals[n-1], c.proto.UpvalueNames[n-1]
+	}
+	return c.upvals[n-1], "(*no name)"
+}
+
+// GetUpvalue pushes the n-th(from 1) upvalue of the function at funcIdx,
+// returns the name of the upvalue, false if there is no such upvalue
+func (s *LuaState) GetUpvalue(funcIdx, n int) (string, bool) {
+	uv, name := s.upvalueAt(funcIdx, n)
+	if uv == nil {
+		return "", false
+	}
+	s.stack.push(uv.get())
+	return name, true
+}
+
+// SetUpvalue pops a value and sets it as the n-th(from 1) upvalue of the
+// function at funcIdx, returns the name of the upvalue,
+// pops nothing and returns false if there is no such upvalue
+func (s *LuaState) SetUpvalue(funcIdx, n int) (string, bool) {
+	uv, name := s.upvalueAt(funcIdx, n)
+	if uv == nil {
+		return "", false
+	}
+	uv.set(s.stack.pop())
+	return name, true
+}
@@ -49,7 +49,7 @@ var operators = []operator{
 	operator{"__idiv" /**/, iidiv, fidiv},
 	operator{"__band" /**/, band, nil},
 	operator{"__bor" /* */, bor, nil},
-	operator{"__nxor" /**/, bxor, nil},
+	operator{"__bxor" /**/, bxor, nil},
 	operator{"__shl" /* */, shl, nil},
 	operator{"__shr" /* */, shr, nil},
 	operator{"__unm" /* */, iunm, funm},
@@ -92,6 +92,14 @@ func (s *LuaState) Arithmetic(op api.ArithmeticOp) {
 	}
 
 	oper := operators[op]
+	if op == api.LuaOpMod || op == api.LuaOpIDiv {
+		if _, ok := a.(int64); ok && b == LuaValue(int64(0)) {
+			if op == api.LuaOpMod {
+				s.runError("attempt to perform 'n%%0'")
+			}
+			s.runError("attempt to perform 'n//0'")
+		}
+	}
 	if result := luaArith(a, b, oper); result != nil {
 		s.stack.push(result) // NOTE: modify stack
 		return
@@ -102,7 +110,23 @@ func (s *LuaState) Arithmetic(op api.ArithmeticOp) {
 		return
 	}
 
-	panic("arithmetic error")
+	s.arithError(a, b, oper)
+}
+
+// raises an error about the operand of arithmetic which is not a number
+func (s *LuaState) arithError(a, b LuaValue, op operator) {
+	_, ok1 := convertToFloat(a)
+	_, ok2 := convertToFloat(b)
+	if op.floatFunc == nil && ok1 && ok2 { // bitwise on floats
+		s.runError("number has no integer representation")
+	}
+	if ok1 { // the first operand is ok, blames the second
+		a = b
+	}
+	if op.floatFunc == nil {
+		s.opTypeError(a, "perform bitwise operation on")
+	}
+	s.opTypeError(a, "perform arithmetic on")
 }
 
 func callMetaMethod(a, b LuaValue, mmName string, state *LuaState) (LuaValue, bool) {
@@ -167,6 +191,13 @@ func (s *LuaState) luaEqual(a, b LuaValue) bool {
 			}
 		}
 		return a == b
+	case *userdata:
+		if y, ok := b.(*userdata); ok && x != y && s != nil {
+			if result, ok := callMetaMethod(x, y, "__eq", s); ok {
+				return convertToBoolean(result)
+			}
+		}
+		return a == b
 	default:
 		return a == b
 	}
@@ -197,7 +228,8 @@ func (s *LuaState) luaLt(a, b LuaValue) bool {
 	if result, ok := callMetaMethod(a, b, "__lt", s); ok {
 		return convertToBoolean(result)
 	}
-	panic("comparison error")
+	s.orderError(a, b)
+	return false
 }
 
 // why not: not(b < a), such as NaN
@@ -232,7 +264,16 @@ func (s *LuaState) luaLe(a, b LuaValue) bool {
 		return !convertToBoolean(result)
 	}
 
-	panic("comparison error")
+	s.orderError(a, b)
+	return false
+}
+
+func (s *LuaState) orderError(a, b LuaValue) {
+	t1, t2 := s.objTypeName(a), s.objTypeName(b)
+	if t1 == t2 {
+		s.runError("attempt to compare two %s values", t1)
+	}
+	s.runError("attempt to compare %s with %s", t1, t2)
 }
 
 // Compare stack.get(idx1) op stack.get(idx2)
@@ -255,18 +296,17 @@ func (s *LuaState) Compare(idx1, idx2 int, op api.CompareOp) bool {
 //             len method
 // ------------------------------------
 
-// Len pushes len(stack.get(idx))
-// TODO, only string
+// Len pushes len(stack.get(idx)), __len is used if the value has it
 func (s *LuaState) Len(idx int) {
 	val := s.stack.get(idx)
 	if str, ok := val.(string); ok {
 		s.stack.push(int64(len(str)))
-	} else if t, ok := val.(*LuaTable); ok {
+	} else if t, ok := val.(*LuaTable); ok && !t.hasMetaField("__len") {
 		s.stack.push(int64(t.len()))
 	} else if result, ok := callMetaMethod(val, val, "__len", s); ok {
 		s.stack.push(result)
 	} else {
-		panic("length method error")
+		s.opTypeError(val, "get length of")
 	}
 }
 
@@ -297,7 +337,10 @@ func (s *LuaState) Concat(n int) {
 				continue
 			}
 
-			panic("concatenation error")
+			if _, ok := a.(string); ok || typeOf(a) == api.LuaTNumber {
+				a = b
+			}
+			s.opTypeError(a, "concatenate")
 		}
 	}
 	// n == 1 do nothing
@@ -34,6 +34,7 @@ func (s *LuaState) PushString(str string) {
 // PushGoFunction pushes go function into stack
 func (s *LuaState) PushGoFunction(goFunc api.GoFunction) {
 	s.stack.push(newGoClosure(goFunc, 0))
+	s.gc.debt++
 }
 
 // IsGoFunction returns stack[idx] if is go function
@@ -60,7 +61,14 @@ func (s *LuaState) PushGoClosure(goFunc api.GoFunction, n int) {
 	closure := newGoClosure(goFunc, n)
 	for i := n; i > 0; i-- {
 		val := s.stack.pop()
-		closure.upvals[i-1] = &upvalue{&val}
+		closure.upvals[i-1] = newClosedUpvalue(val)
 	}
 	s.stack.push(closure)
+	s.gc.debt++
+}
+
+// NewUserData pushes a new userdata which holds data
+func (s *LuaState) NewUserData(data interface{}) {
+	s.stack.push(&userdata{data: data})
+	s.gc.debt++
 }
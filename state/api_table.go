@@ -1,6 +1,9 @@
 package state
 
-import "luago/api"
+import (
+	"luago/api"
+	"math"
+)
 
 // NewTable pushes a empty lua table
 func (s *LuaState) NewTable() {
@@ -11,6 +14,7 @@ func (s *LuaState) NewTable() {
 func (s *LuaState) CreateTable(nArr, nRecord int) {
 	t := NewLuaTable(nArr, nRecord)
 	s.stack.push(t)
+	s.gc.debt++
 }
 
 func (s *LuaState) getTable(t, key LuaValue, raw bool) api.LuaType {
@@ -39,7 +43,8 @@ func (s *LuaState) getTable(t, key LuaValue, raw bool) api.LuaType {
 		}
 	}
 
-	panic("index error")
+	s.opTypeError(t, "index")
+	return api.LuaTNil
 }
 
 // GetTable pushes the value with key(top) and return type of the value
@@ -64,6 +69,11 @@ func (s *LuaState) GetI(idx int, i int64) api.LuaType {
 func (s *LuaState) setTable(t, key, v LuaValue, raw bool) {
 	if tb, ok := t.(*LuaTable); ok {
 		if raw || tb.get(key) != nil || !tb.hasMetaField("__newindex") {
+			if key == nil {
+				s.runError("table index is nil")
+			} else if f, ok := key.(float64); ok && math.IsNaN(f) {
+				s.runError("table index is NaN")
+			}
 			tb.put(key, v)
 			return
 		}
@@ -85,7 +95,7 @@ func (s *LuaState) setTable(t, key, v LuaValue, raw bool) {
 			}
 		}
 	}
-	panic("index error")
+	s.opTypeError(t, "index")
 }
 
 // SetTable pops the val and pops the key, then puts kv into the table
@@ -0,0 +1,196 @@
+package state
+
+import "luago/api"
+
+// status of coroutine
+const (
+	coSuspended = iota // not started or yielded
+	coRunning
+	coNormal // resumed another coroutine
+	coDead
+)
+
+// coroutine is the state of a thread other than the main one. The thread
+// runs on a goroutine of its own, Resume and Yield hand over the control
+// through the channels, so only one thread of a state runs at a time.
+// The goroutine is started by the first Resume and ends when the function
+// returns or fails; a thread suspended in Yield keeps its goroutine parked,
+// a few KB of stack, until the thread is collected or the state is closed,
+// which ends it by killSignal
+type coroutine struct {
+	status  int
+	started bool
+	err     int // status of the error which killed the thread
+
+	resume chan int         // count of arguments on the stack, or killSignal
+	yield  chan resumeState // count of values yielded or returned
+}
+
+type resumeState struct {
+	status int
+	n      int
+}
+
+// sent to resume channel to end the goroutine of an unreachable thread
+const killSignal = -1
+
+// panic value which unwinds the goroutine of a killed thread,
+// PCall doesn't catch it
+type threadKilled struct{}
+
+// NewThread pushes a new thread sharing the globals and registry
+// with this state and returns it, the thread holds a goroutine while
+// it's suspended in Yield, see coroutine
+func (s *LuaState) NewThread() api.ILuaState {
+	t := &LuaState{
+		registry:     s.registry,
+		maxCallDepth: s.maxCallDepth,
+		gc:           s.gc,
+		protoCache:   s.protoCache,
+		optLevel:     s.optLevel,
+		main:         s.main,
+		co: &coroutine{
+			resume: make(chan int),
+			yield:  make(chan resumeState),
+		},
+	}
+	t.pushLuaStack(newLuaStack(api.LuaMinStack, t))
+	s.gc.threads = append(s.gc.threads, t)
+	s.gc.debt++
+	s.stack.push(t)
+	return t
+}
+
+// PushThread pushes this thread, returns true if it's the main thread
+func (s *LuaState) PushThread() bool {
+	s.stack.push(s)
+	return s.co == nil
+}
+
+// ToThread returns the thread at idx, nil if the value is not a thread
+func (s *LuaState) ToThread(idx int) api.ILuaState {
+	if t, ok := s.stack.get(idx).(*LuaState); ok {
+		return t
+	}
+	return nil
+}
+
+// XMove pops n values from this thread and pushes them onto thread to
+func (s *LuaState) XMove(to api.ILuaState, n int) {
+	t := to.(*LuaState)
+	if t == s {
+		return
+	}
+	vals := s.stack.popN(n)
+	t.stack.check(n)
+	t.stack.pushN(vals, n)
+}
+
+// Status returns api.LuaYield if the thread is suspended by Yield,
+// the error code if it's dead by error, api.LuaOk otherwise
+func (s *LuaState) Status() int {
+	switch {
+	case s.co == nil:
+		return api.LuaOk
+	case s.co.status == coSuspended && s.co.started:
+		return api.LuaYield
+	case s.co.status == coDead:
+		return s.co.err
+	}
+	return api.LuaOk
+}
+
+// IsYieldable reports whether the running function can yield,
+// that is it runs in a thread other than the main one
+func (s *LuaState) IsYieldable() bool {
+	return s.co != nil
+}
+
+// Resume starts or resumes the thread. To start it, push the function
+// and the arguments onto the thread; to resume it, push the values
+// returned by Yield. It returns api.LuaYield if the thread yields,
+// api.LuaOk if the function returns, or the error code, and the count of
+// the values yielded or returned, or 1 for the error object, on the top
+// of the thread; from is the thread calling Resume
+func (s *LuaState) Resume(from api.ILuaState, nArgs int) (int, int) {
+	co := s.co
+	if co == nil || co.status != coSuspended {
+		msg := "cannot resume non-suspended coroutine"
+		if co != nil && co.status == coDead {
+			msg = "cannot resume dead coroutine"
+		}
+		s.stack.check(1)
+		s.stack.push(msg)
+		return api.LuaErrRun, 1
+	}
+	if f, ok := from.(*LuaState); ok && f.co != nil {
+		f.co.status = coNormal
+		defer func() { f.co.status = coRunning }()
+	}
+	if !co.started {
+		co.started = true
+		go s.run()
+	}
+	co.status = coRunning
+	co.resume <- nArgs
+	r := <-co.yield
+	return r.status, r.n
+}
+
+// runs the function of thread on the goroutine of it
+func (s *LuaState) run() {
+	co := s.co
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(threadKilled); !ok {
+				panic(r)
+			}
+			co.yield <- resumeState{} // acknowledges the kill
+		}
+	}()
+	nArgs := <-co.resume
+	if nArgs == killSignal {
+		panic(threadKilled{})
+	}
+	status := s.PCall(nArgs, -1, 0)
+	co.status, co.err = coDead, status
+	co.yield <- resumeState{status, s.stack.top}
+}
+
+// Yield suspends the thread and returns the top nResults values to Resume.
+// The Go function calling it should return its result, the count of
+// the values passed to the next Resume, which are on the top of stack
+func (s *LuaState) Yield(nResults int) int {
+	co := s.co
+	if co == nil {
+		s.runError("attempt to yield from outside a coroutine")
+	}
+	co.status = coSuspended
+	co.yield <- resumeState{api.LuaYield, nResults}
+	nArgs := <-co.resume
+	if nArgs == killSignal {
+		panic(threadKilled{})
+	}
+	return nArgs
+}
+
+// ends the goroutines of the threads suspended in Yield, all of them
+// or the ones not marked by the collection, and forgets them
+func (s *LuaState) killThreads(all bool) {
+	g := s.gc
+	n := 0
+	for _, t := range g.threads {
+		if !all && g.isMarked(t) {
+			g.threads[n] = t
+			n++
+		} else if t.co.started && t.co.status == coSuspended {
+			t.co.status = coDead
+			t.co.resume <- killSignal
+			<-t.co.yield
+		}
+	}
+	for i := n; i < len(g.threads); i++ {
+		g.threads[i] = nil
+	}
+	g.threads = g.threads[:n]
+}
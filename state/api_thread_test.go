@@ -0,0 +1,105 @@
+package state
+
+import (
+	"luago/api"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func yield(ls api.ILuaState) int {
+	return ls.Yield(ls.GetTop())
+}
+
+func TestThread(t *testing.T) {
+	ls := NewLuaState()
+	co := ls.NewThread()
+	if ls.Type(-1) != api.LuaTThread || ls.ToThread(-1) != co {
+		t.Fatal("thread not pushed")
+	}
+	co.PushGoFunction(func(ls api.ILuaState) int {
+		ls.PushInteger(ls.ToInteger(1) + 1)
+		if n := ls.Yield(1); n != 1 { // the values of next Resume are on the top
+			panic("bad count of values")
+		}
+		ls.PushInteger(ls.ToInteger(-1) * 2)
+		return 1
+	})
+	co.PushInteger(1)
+	status, n := co.Resume(ls, 1)
+	if status != api.LuaYield || n != 1 || co.ToInteger(-1) != 2 || co.Status() != api.LuaYield {
+		t.Fatalf("status = %d, n = %d", status, n)
+	}
+	co.Pop(n)
+	co.PushInteger(21)
+	status, n = co.Resume(ls, 1)
+	if status != api.LuaOk || n != 1 || co.ToInteger(-1) != 42 {
+		t.Fatalf("status = %d, n = %d", status, n)
+	}
+	co.XMove(ls, 1)
+	if ls.ToInteger(-1) != 42 || co.GetTop() != 0 {
+		t.Errorf("results not moved")
+	}
+	if status, _ := co.Resume(ls, 0); status != api.LuaErrRun || co.ToString(-1) != "cannot resume dead coroutine" {
+		t.Errorf("status = %d", status)
+	}
+}
+
+// waits for the goroutines to end until there are at most n of them
+func waitGoroutines(n int) int {
+	for i := 0; i < 100 && runtime.NumGoroutine() > n; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	return runtime.NumGoroutine()
+}
+
+func TestThreadGoroutines(t *testing.T) {
+	ls := NewLuaState()
+	before := runtime.NumGoroutine()
+	for i := 0; i < 10; i++ {
+		ls.NewThread().PushGoFunction(yield) // never resumed
+		co := ls.NewThread()
+		co.PushGoFunction(func(ls api.ILuaState) int { return 0 })
+		if status, _ := co.Resume(ls, 0); status != api.LuaOk {
+			t.Fatalf("status = %d", status)
+		}
+		ls.Pop(2)
+	}
+	if n := waitGoroutines(before); n > before {
+		t.Errorf("goroutines = %d, before %d", n, before)
+	}
+
+	before = runtime.NumGoroutine()
+	ls.GC(api.LuaGCStop, 0) // the threads are closed instead
+	for i := 0; i < 10; i++ {
+		co := ls.NewThread()
+		co.PushGoFunction(yield)
+		co.Resume(ls, 0) // suspended for ever
+		ls.Pop(1)
+	}
+	if n := runtime.NumGoroutine(); n < before+10 {
+		t.Fatalf("goroutines = %d, before %d", n, before)
+	}
+	ls.Close()
+	if n := waitGoroutines(before); n > before {
+		t.Errorf("goroutines = %d after close, before %d", n, before)
+	}
+}
+
+func TestThreadCollected(t *testing.T) {
+	ls := NewLuaState()
+	before := runtime.NumGoroutine()
+	for i := 0; i < 10; i++ {
+		co := ls.NewThread()
+		co.PushGoFunction(yield)
+		co.Resume(ls, 0) // suspended for ever
+		ls.Pop(1)
+	}
+	if runtime.NumGoroutine() < before+10 {
+		t.Fatalf("goroutines = %d, before %d", runtime.NumGoroutine(), before)
+	}
+	ls.GC(api.LuaGCCollect, 0)
+	if n := waitGoroutines(before); n > before {
+		t.Errorf("goroutines = %d, before %d", n, before)
+	}
+}
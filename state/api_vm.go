@@ -39,12 +39,15 @@ func (s *LuaState) RegisterCount() int {
 
 // LoadVararg loads varargs
 func (s *LuaState) LoadVararg(n int) {
+	stack := s.stack
 	if n < 0 {
-		n = len(s.stack.varargs)
+		n = stack.nVarargs
 	}
 
-	s.stack.check(n)
-	s.stack.pushN(s.stack.varargs, n)
+	stack.check(n)
+	for i := 0; i < n; i++ {
+		stack.push(stack.vararg(i))
+	}
 }
 
 // LoadProto loads function prototype
@@ -53,6 +56,7 @@ func (s *LuaState) LoadProto(idx int) {
 	subProto := stack.closure.proto.Protos[idx]
 	closure := newLuaClosure(subProto)
 	stack.push(closure)
+	s.gc.debt++
 
 	for i, upvalInfo := range subProto.Upvalues {
 		uvIdx := int(upvalInfo.Idx)
@@ -64,7 +68,7 @@ func (s *LuaState) LoadProto(idx int) {
 			if openuv, found := stack.openuvs[uvIdx]; found { // search upvalue in openUpvalues
 				closure.upvals[i] = openuv
 			} else { // !found
-				closure.upvals[i] = &upvalue{&stack.slots[uvIdx]}
+				closure.upvals[i] = newOpenUpvalue(stack.vs, stack.base+uvIdx)
 				stack.openuvs[uvIdx] = closure.upvals[i]
 			}
 		} else { //  upvalue has captured by parent
@@ -75,13 +79,5 @@ func (s *LuaState) LoadProto(idx int) {
 
 // CloseUpvalues closes all upvalues >= a-1
 func (s *LuaState) CloseUpvalues(a int) {
-	for i, openuv := range s.stack.openuvs {
-		if i < a-1 {
-			continue
-		}
-
-		val := *openuv.val         // copy from register
-		openuv.val = &val          // update upvalue
-		delete(s.stack.openuvs, i) // close upvalue
-	}
+	s.stack.closeUpvalues(a - 1)
 }
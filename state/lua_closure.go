@@ -6,13 +6,47 @@ import (
 )
 
 type luaClosure struct {
+	gcHeader
 	proto  *binchunk.ProtoType
 	goFunc api.GoFunction
 	upvals []*upvalue // non-local variables captured by closure
 }
 
+// upvalue is open while it refers to a slot of value stack,
+// the slot is addressed by index so that it survives the reallocation
+// of value stack, the value is copied into upvalue when it's closed
 type upvalue struct {
-	val *LuaValue
+	vs    *valueStack // nil if closed
+	index int
+	val   LuaValue
+}
+
+func newOpenUpvalue(vs *valueStack, index int) *upvalue {
+	return &upvalue{vs: vs, index: index}
+}
+
+func newClosedUpvalue(val LuaValue) *upvalue {
+	return &upvalue{val: val}
+}
+
+func (uv *upvalue) get() LuaValue {
+	if uv.vs != nil {
+		return uv.vs.slots[uv.index]
+	}
+	return uv.val
+}
+
+func (uv *upvalue) set(val LuaValue) {
+	if uv.vs != nil {
+		uv.vs.slots[uv.index] = val
+	} else {
+		uv.val = val
+	}
+}
+
+func (uv *upvalue) close() {
+	uv.val = uv.vs.slots[uv.index]
+	uv.vs = nil
 }
 
 func newLuaClosure(proto *binchunk.ProtoType) *luaClosure {
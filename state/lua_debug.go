@@ -0,0 +1,293 @@
+package state
+
+import (
+	"fmt"
+	"luago/binchunk"
+	"luago/compiler"
+	"luago/vm"
+)
+
+// frameAt returns the frame of the function at level,
+// level 0 is the current running function, nil if there is no such level
+func (s *LuaState) frameAt(level int) *LuaStack {
+	for frame := s.stack; frame != nil && frame.closure != nil; frame = frame.prev {
+		if level == 0 {
+			return frame
+		}
+		level--
+	}
+	return nil
+}
+
+// GetStack reports whether there is a function running at level,
+// level 0 is the current running function
+func (s *LuaState) GetStack(level int) bool {
+	return s.frameAt(level) != nil
+}
+
+func (s *LuaStack) isLua() bool {
+	return s.closure != nil && s.closure.proto != nil
+}
+
+// currentLine returns the line of the instruction being executed,
+// -1 if it's not a Lua function or there is no line information
+func (s *LuaStack) currentLine() int {
+	if !s.isLua() {
+		return -1
+	}
+	lineInfo := s.closure.proto.LineInfo
+	if s.pc > 0 && s.pc <= len(lineInfo) {
+		return int(lineInfo[s.pc-1])
+	}
+	return -1
+}
+
+// shortSource returns the chunk name of function for messages
+func (s *LuaStack) shortSource() string {
+	if !s.isLua() {
+		return "[Go]"
+	}
+	return compiler.ChunkID(s.closure.proto.Source)
+}
+
+// funcName returns the name of the function of frame and what the name is,
+// "global", "local", "method", "field", "upvalue", "constant", "metamethod"
+// or "for iterator", the name is found by the instruction of caller
+func (s *LuaStack) funcName() (name, kind string) {
+	caller := s.prev
+	if s.tailcall || caller == nil || !caller.isLua() || caller.pc == 0 {
+		return "", ""
+	}
+
+	p := caller.closure.proto
+	pc := caller.pc - 1
+	inst := vm.Instruction(p.Code[pc])
+	switch op := inst.Opcode(); op {
+	case vm.OpCALL, vm.OpTAILCALL:
+		a, _, _ := inst.ABC()
+		return getObjName(p, pc, a)
+	case vm.OpTFORCALL:
+		return "for iterator", "for iterator"
+	default:
+		if tm, ok := opMetamethods[op]; ok {
+			return tm, "metamethod"
+		}
+	}
+	return "", ""
+}
+
+var opMetamethods = map[int]string{
+	vm.OpSELF: "index", vm.OpGETTABUP: "index", vm.OpGETTABLE: "index",
+	vm.OpSETTABUP: "newindex", vm.OpSETTABLE: "newindex",
+	vm.OpADD: "add", vm.OpSUB: "sub", vm.OpMUL: "mul", vm.OpMOD: "mod",
+	vm.OpPOW: "pow", vm.OpDIV: "div", vm.OpIDIV: "idiv", vm.OpBAND: "band",
+	vm.OpBOR: "bor", vm.OpBXOR: "bxor", vm.OpSHL: "shl", vm.OpSHR: "shr",
+	vm.OpUNM: "unm", vm.OpBNOT: "bnot", vm.OpLEN: "len", vm.OpCONCAT: "concat",
+	vm.OpEQ: "eq", vm.OpLT: "lt", vm.OpLE: "le",
+}
+
+// describes the function of frame in traceback
+func (s *LuaStack) funcDesc() string {
+	name, kind := s.funcName()
+	switch {
+	case kind == "global":
+		return fmt.Sprintf("function '%s'", name)
+	case kind != "":
+		return fmt.Sprintf("%s '%s'", kind, name)
+	case !s.isLua():
+		return "?"
+	case s.closure.proto.LineDefined == 0:
+		return "main chunk"
+	default:
+		return fmt.Sprintf("function <%s:%d>", s.shortSource(), s.closure.proto.LineDefined)
+	}
+}
+
+// returns the name of the reg-th local variable active at pc
+func getLocalName(p *binchunk.ProtoType, reg, pc int) string {
+	for _, locVar := range p.LocVars {
+		if int(locVar.StartPC) > pc {
+			break
+		}
+		if pc < int(locVar.EndPC) {
+			if reg == 0 {
+				return locVar.VarName
+			}
+			reg--
+		}
+	}
+	return ""
+}
+
+func getUpvalName(p *binchunk.ProtoType, idx int) string {
+	if idx < len(p.UpvalueNames) {
+		return p.UpvalueNames[idx]
+	}
+	return "?"
+}
+
+// getObjName finds the name of the value in register reg at lastpc
+// by the instructions which load it, like getobjname of C Lua
+func getObjName(p *binchunk.ProtoType, lastpc, reg int) (name, kind string) {
+	if name := getLocalName(p, reg, lastpc); name != "" {
+		return name, "local"
+	}
+
+	pc := findSetReg(p, lastpc, reg)
+	if pc < 0 {
+		return "", ""
+	}
+	inst := vm.Instruction(p.Code[pc])
+	switch inst.Opcode() {
+	case vm.OpMOVE:
+		a, b, _ := inst.ABC()
+		if b < a {
+			return getObjName(p, pc, b)
+		}
+	case vm.OpGETTABUP, vm.OpGETTABLE:
+		_, b, c := inst.ABC()
+		var tName string
+		if inst.Opcode() == vm.OpGETTABLE {
+			tName = getLocalName(p, b, pc)
+		} else {
+			tName = getUpvalName(p, b)
+		}
+		if tName == "_ENV" {
+			return constName(p, pc, c), "global"
+		}
+		return constName(p, pc, c), "field"
+	case vm.OpGETUPVAL:
+		_, b, _ := inst.ABC()
+		return getUpvalName(p, b), "upvalue"
+	case vm.OpLOADK, vm.OpLOADKX:
+		_, bx := inst.ABx()
+		if inst.Opcode() == vm.OpLOADKX {
+			bx = vm.Instruction(p.Code[pc+1]).Ax()
+		}
+		if str, ok := p.Constants[bx].(string); ok {
+			return str, "constant"
+		}
+	case vm.OpSELF:
+		_, _, c := inst.ABC()
+		return constName(p, pc, c), "method"
+	}
+	return "", ""
+}
+
+// returns the name of key rk if it's a string constant
+func constName(p *binchunk.ProtoType, pc, rk int) string {
+	if rk > 0xFF {
+		if str, ok := p.Constants[rk&0xFF].(string); ok {
+			return str
+		}
+	} else if name, kind := getObjName(p, pc, rk); kind == "constant" {
+		return name
+	}
+	return "?"
+}
+
+// findSetReg returns the pc of the last instruction before lastpc
+// which sets register reg, -1 if it's not sure
+func findSetReg(p *binchunk.ProtoType, lastpc, reg int) int {
+	setReg := -1
+	jmpTarget := 0 // any code before this address is conditional
+	for pc := 0; pc < lastpc; pc++ {
+		inst := vm.Instruction(p.Code[pc])
+		a, b, _ := inst.ABC()
+		change := false
+		switch inst.Opcode() {
+		case vm.OpLOADNIL:
+			change = a <= reg && reg <= a+b
+		case vm.OpTFORCALL:
+			change = reg >= a+2
+		case vm.OpCALL, vm.OpTAILCALL:
+			change = reg >= a
+		case vm.OpJMP:
+			_, sBx := inst.AsBx()
+			if dest := pc + 1 + sBx; pc < dest && dest <= lastpc && dest > jmpTarget {
+				jmpTarget = dest
+			}
+		default:
+			change = inst.SetsA() && reg == a
+		}
+		if change {
+			if pc < jmpTarget {
+				setReg = -1
+			} else {
+				setReg = pc
+			}
+		}
+	}
+	return setReg
+}
+
+// objTypeName returns the type name of val, __name of metatable is
+// used if it's a string
+func (s *LuaState) objTypeName(val LuaValue) string {
+	if mt := getMetaTable(val, s); mt != nil {
+		if name, ok := mt.get("__name").(string); ok {
+			return name
+		}
+	}
+	return s.TypeName(typeOf(val))
+}
+
+// varInfo describes the variable which holds val in the instruction
+// being executed, like " (global 'x')", "" if it's unknown
+func (s *LuaState) varInfo(val LuaValue) string {
+	frame := s.stack
+	if !frame.isLua() || frame.pc == 0 {
+		return ""
+	}
+
+	p := frame.closure.proto
+	pc := frame.pc - 1
+	inst := vm.Instruction(p.Code[pc])
+	a, b, c := inst.ABC()
+	var regs []int
+	switch op := inst.Opcode(); op {
+	case vm.OpGETTABUP, vm.OpSETTABUP:
+		uv := b
+		if op == vm.OpSETTABUP {
+			uv = a
+		}
+		if frame.closure.upvals[uv].get() == val {
+			return fmt.Sprintf(" (upvalue '%s')", getUpvalName(p, uv))
+		}
+		return ""
+	case vm.OpGETTABLE, vm.OpSELF, vm.OpUNM, vm.OpBNOT, vm.OpLEN:
+		regs = []int{b}
+	case vm.OpSETTABLE, vm.OpCALL, vm.OpTAILCALL, vm.OpTFORCALL:
+		regs = []int{a}
+	case vm.OpCONCAT:
+		for r := b; r <= c; r++ {
+			regs = append(regs, r)
+		}
+	default:
+		if _, ok := opMetamethods[op]; ok { // binary operators
+			regs = []int{b, c}
+		}
+	}
+
+	for _, r := range regs {
+		if r > 0xFF { // constant
+			if str, ok := p.Constants[r&0xFF].(string); ok && str == val {
+				return fmt.Sprintf(" (constant '%s')", str)
+			}
+			continue
+		}
+		if frame.vs.slots[frame.base+r] != val {
+			continue
+		}
+		if name, kind := getObjName(p, pc, r); kind != "" {
+			return fmt.Sprintf(" (%s '%s')", kind, name)
+		}
+		return ""
+	}
+	return ""
+}
+
+// opTypeError raises an error about an operation on a value of wrong type
+func (s *LuaState) opTypeError(val LuaValue, op string) {
+	s.runError("attempt to %s a %s value%s", op, s.objTypeName(val), s.varInfo(val))
+}
@@ -0,0 +1,338 @@
+package state
+
+import (
+	"luago/api"
+	"strings"
+	"sync/atomic"
+)
+
+// the memory is freed by the garbage collector of Go,
+// the collector of LuaState marks the objects reachable from the roots
+// like C Lua does, then clears the weak references to the unreachable
+// objects and calls the finalizers of them
+
+// gcHeader is embedded in collectable objects
+type gcHeader struct {
+	mark uint32 // id of the latest collection which marked the object
+	fin  bool   // marked for finalization
+}
+
+// ids of collections, unique among all states
+var gcEpoch uint32
+
+const (
+	gcPause   = 200  // waits the count of objects to double before a new cycle
+	gcStepMul = 200  // unused, there is no incremental collection
+	gcMinDebt = 1024 // min count of objects created between two cycles
+	gcObjSize = 64   // estimated size of a new object
+)
+
+type gcState struct {
+	running   bool
+	pause     int
+	stepMul   int
+	debt      int // count of objects created since the last cycle
+	threshold int // debt which starts a new cycle
+	estimate  int // estimated bytes in use after the last cycle
+
+	// objects marked for finalization, in order of marking
+	finobjs []LuaValue
+
+	// work lists of a cycle
+	epoch     uint32
+	gray      []LuaValue
+	weak      []*LuaTable // tables with weak values
+	ephemeron []*LuaTable // tables with weak keys
+	allweak   []*LuaTable // tables with weak keys and weak values
+	live      int         // count of marked objects
+	bytes     int         // estimated bytes of marked objects
+
+	// threads other than the main one, the unreachable ones are killed
+	threads []*LuaState
+}
+
+func newGCState() *gcState {
+	return &gcState{
+		running:   true,
+		pause:     gcPause,
+		stepMul:   gcStepMul,
+		threshold: gcMinDebt,
+	}
+}
+
+func headerOf(val LuaValue) *gcHeader {
+	switch x := val.(type) {
+	case *LuaTable:
+		return &x.gcHeader
+	case *luaClosure:
+		return &x.gcHeader
+	case *userdata:
+		return &x.gcHeader
+	case *LuaState:
+		return &x.gcHeader
+	}
+	return nil
+}
+
+// values which are not objects(strings, numbers ...) are never collected
+func (g *gcState) isMarked(val LuaValue) bool {
+	h := headerOf(val)
+	return h == nil || h.mark == g.epoch
+}
+
+func (g *gcState) markValue(val LuaValue) {
+	if h := headerOf(val); h != nil && h.mark != g.epoch {
+		h.mark = g.epoch
+		g.gray = append(g.gray, val)
+	}
+}
+
+func (g *gcState) propagate() {
+	for len(g.gray) > 0 {
+		val := g.gray[len(g.gray)-1]
+		g.gray = g.gray[:len(g.gray)-1]
+		g.live++
+
+		switch x := val.(type) {
+		case *LuaTable:
+			g.traverseTable(x)
+		case *luaClosure:
+			g.bytes += 48 + 16*len(x.upvals)
+			for _, uv := range x.upvals {
+				if uv != nil {
+					g.markValue(uv.get())
+				}
+			}
+		case *userdata:
+			g.bytes += 48
+			if x.metatable != nil {
+				g.markValue(x.metatable)
+			}
+		case *LuaState:
+			g.bytes += 256 + 16*len(x.stack.vs.slots)
+			g.markStack(x)
+		}
+	}
+}
+
+func (g *gcState) traverseTable(t *LuaTable) {
+	g.bytes += 64 + 16*len(t.arr) + 40*t.sizeNode
+	if t.metatable != nil {
+		g.markValue(t.metatable)
+	}
+
+	weakKey, weakVal := weakness(t)
+	switch {
+	case weakKey && weakVal:
+		g.allweak = append(g.allweak, t)
+	case weakKey:
+		g.ephemeron = append(g.ephemeron, t)
+		g.traverseEphemeron(t)
+	case weakVal:
+		g.weak = append(g.weak, t)
+		for _, n := range t.nodes {
+			if n.val != nil {
+				g.markValue(n.key)
+			}
+		}
+	default:
+		for _, v := range t.arr {
+			g.markValue(v)
+		}
+		for _, n := range t.nodes {
+			if n.val != nil {
+				g.markValue(n.key)
+				g.markValue(n.val)
+			}
+		}
+	}
+}
+
+func weakness(t *LuaTable) (weakKey, weakVal bool) {
+	if t.metatable != nil {
+		if mode, ok := t.metatable.get("__mode").(string); ok {
+			weakKey = strings.IndexByte(mode, 'k') >= 0
+			weakVal = strings.IndexByte(mode, 'v') >= 0
+		}
+	}
+	return
+}
+
+// marks the values whose keys are marked,
+// returns true if any value is marked
+func (g *gcState) traverseEphemeron(t *LuaTable) bool {
+	marked := false
+	for _, v := range t.arr { // integer keys are never collected
+		if !g.isMarked(v) {
+			g.markValue(v)
+			marked = true
+		}
+	}
+	for _, n := range t.nodes {
+		if n.val != nil && g.isMarked(n.key) && !g.isMarked(n.val) {
+			g.markValue(n.val)
+			marked = true
+		}
+	}
+	return marked
+}
+
+func (g *gcState) convergeEphemerons() {
+	for changed := true; changed; {
+		changed = false
+		for i := 0; i < len(g.ephemeron); i++ {
+			if g.traverseEphemeron(g.ephemeron[i]) {
+				g.propagate()
+				changed = true
+			}
+		}
+	}
+}
+
+// clears the entries whose values are not marked
+func (g *gcState) clearValues(tables []*LuaTable) {
+	for _, t := range tables {
+		for i, v := range t.arr {
+			if !g.isMarked(v) {
+				t.arr[i] = nil
+			}
+		}
+		for i, n := range t.nodes {
+			if !g.isMarked(n.val) {
+				t.nodes[i].val = nil
+			}
+		}
+	}
+}
+
+// clears the entries whose keys are not marked
+func (g *gcState) clearKeys(tables []*LuaTable) {
+	for _, t := range tables {
+		for i, n := range t.nodes {
+			if !g.isMarked(n.key) {
+				t.nodes[i].val = nil
+			}
+		}
+	}
+}
+
+// estimated bytes in use
+func (g *gcState) count() int {
+	return g.estimate + g.debt*gcObjSize
+}
+
+// marks the object for finalization if its metatable has a __gc field
+func (s *LuaState) checkFinalizer(obj LuaValue, mt *LuaTable) {
+	h := headerOf(obj)
+	if h.fin || mt == nil || mt.get("__gc") == nil {
+		return
+	}
+	h.fin = true
+	s.gc.finobjs = append(s.gc.finobjs, obj)
+}
+
+func (s *LuaState) markRoots() {
+	g := s.gc
+	g.markValue(s.registry)
+	g.markValue(s.main)
+	g.markValue(s) // the running thread
+}
+
+// marks the values on the stack of thread
+func (g *gcState) markStack(thread *LuaState) {
+	for _, v := range thread.stack.vs.slots[:thread.stack.base+thread.stack.top] {
+		g.markValue(v)
+	}
+	for frame := thread.stack; frame != nil; frame = frame.prev {
+		if frame.closure != nil {
+			g.markValue(frame.closure)
+		}
+	}
+}
+
+// fullGC runs a full cycle of garbage collection
+func (s *LuaState) fullGC() {
+	g := s.gc
+	g.epoch = atomic.AddUint32(&gcEpoch, 1)
+	g.live, g.bytes = 0, 0
+
+	s.markRoots()
+	g.propagate()
+	g.convergeEphemerons()
+
+	// the weak values are cleared before the resurrection
+	g.clearValues(g.weak)
+	g.clearValues(g.allweak)
+	nWeak, nAllWeak := len(g.weak), len(g.allweak)
+
+	// separates the unreachable objects to be finalized
+	var tobefnz []LuaValue
+	n := 0
+	for _, obj := range g.finobjs {
+		if g.isMarked(obj) {
+			g.finobjs[n] = obj
+			n++
+		} else {
+			headerOf(obj).fin = false
+			tobefnz = append(tobefnz, obj)
+		}
+	}
+	for i := n; i < len(g.finobjs); i++ {
+		g.finobjs[i] = nil
+	}
+	g.finobjs = g.finobjs[:n]
+
+	// resurrects them until their finalizers are called
+	for _, obj := range tobefnz {
+		g.markValue(obj)
+	}
+	g.propagate()
+	g.convergeEphemerons()
+
+	g.clearKeys(g.ephemeron)
+	g.clearKeys(g.allweak)
+	g.clearValues(g.weak[nWeak:])
+	g.clearValues(g.allweak[nAllWeak:])
+
+	g.gray, g.weak, g.ephemeron, g.allweak = nil, nil, nil, nil
+	s.killThreads(false)
+	g.estimate = g.bytes
+	g.debt = 0
+	g.threshold = g.live * (g.pause - 100) / 100
+	if g.threshold < gcMinDebt {
+		g.threshold = gcMinDebt
+	}
+
+	s.callFinalizers(tobefnz)
+}
+
+// calls the finalizers in the reverse order that the objects were marked,
+// the errors in finalizers are ignored
+func (s *LuaState) callFinalizers(objs []LuaValue) {
+	g := s.gc
+	running := g.running
+	g.running = false // avoids the collection in finalizers
+	defer func() { g.running = running }()
+
+	for i := len(objs) - 1; i >= 0; i-- {
+		mt := getMetaTable(objs[i], s)
+		if mt == nil {
+			continue
+		}
+		if gc, ok := mt.get("__gc").(*luaClosure); ok {
+			s.stack.check(2)
+			s.stack.push(gc)
+			s.stack.push(objs[i])
+			if s.PCall(1, 0, 0) != api.LuaOk {
+				s.stack.pop()
+			}
+		}
+	}
+}
+
+// checkGC runs a cycle if enough objects have been created
+func (s *LuaState) checkGC() {
+	if g := s.gc; g.running && g.debt >= g.threshold {
+		s.fullGC()
+	}
+}
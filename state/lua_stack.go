@@ -13,17 +13,29 @@ import "luago/api"
 //              lua         go
 // rIdx        absIdx
 
-// LuaStack is the Lua stack struct
+// valueStack is the growable array of values shared by all frames
+type valueStack struct {
+	slots []LuaValue
+}
+
+// LuaStack is the Lua stack struct, a frame on the value stack
 type LuaStack struct {
 	// virtual stack
-	slots []LuaValue
-	top   int
+	vs   *valueStack
+	base int // index of the first slot of the frame in value stack
+	top  int // relative to base
 
 	// call info
-	closure *luaClosure
-	openuvs map[int]*upvalue
-	varargs []LuaValue
-	pc      int
+	closure  *luaClosure
+	openuvs  map[int]*upvalue
+	nVarargs int // varargs are placed just below base
+	pc       int
+
+	funcIdx  int  // index of the called function in value stack
+	nResults int  // count of results wanted by caller, -1 means all
+	fresh    bool // called from Go, the dispatch loop returns after this frame
+	tailcall bool // called by tail call, the caller is unknown
+	depth    int  // depth of call stack
 
 	// linked list
 	prev *LuaStack
@@ -34,21 +46,31 @@ type LuaStack struct {
 
 func newLuaStack(size int, luastate *LuaState) *LuaStack {
 	return &LuaStack{
-		slots: make([]LuaValue, size),
-		top:   0,
-		state: luastate,
+		vs:      &valueStack{slots: make([]LuaValue, size)},
+		top:     0,
+		funcIdx: -1,
+		state:   luastate,
 	}
 }
 
 // check if there is enough space to place size elements
 func (s *LuaStack) check(size int) {
-	free := len(s.slots) - s.top
-	if free < size {
-		s.slots = append(s.slots, make([]LuaValue, size-free)...)
+	need := s.base + s.top + size
+	n := len(s.vs.slots)
+	if need <= n {
+		return
+	}
+	if need > api.LuaMaxStack {
+		panic("stack overflow")
+	}
+
+	n *= 2
+	if n < need {
+		n = need
 	}
-	// for i := free; i < n; i++ {
-	// 	s.slots = append(s.slots, nil)
-	// }
+	slots := make([]LuaValue, n)
+	copy(slots, s.vs.slots)
+	s.vs.slots = slots
 }
 
 func (s *LuaStack) empty() bool {
@@ -56,14 +78,14 @@ func (s *LuaStack) empty() bool {
 }
 
 func (s *LuaStack) full() bool {
-	return s.top == len(s.slots)
+	return s.base+s.top == len(s.vs.slots)
 }
 
 func (s *LuaStack) push(val LuaValue) {
-	if s.top == len(s.slots) {
+	if s.full() {
 		panic("stack overflow")
 	}
-	s.slots[s.top] = val
+	s.vs.slots[s.base+s.top] = val
 	s.top++
 }
 
@@ -87,8 +109,8 @@ func (s *LuaStack) pop() LuaValue {
 		panic("stack underflow")
 	}
 	s.top--
-	val := s.slots[s.top]
-	s.slots[s.top] = nil
+	val := s.vs.slots[s.base+s.top]
+	s.vs.slots[s.base+s.top] = nil
 	return val
 }
 
@@ -101,6 +123,14 @@ func (s *LuaStack) popN(n int) []LuaValue {
 	return vals
 }
 
+// vararg returns the i-th(from 0) vararg
+func (s *LuaStack) vararg(i int) LuaValue {
+	if i < s.nVarargs {
+		return s.vs.slots[s.base-s.nVarargs+i]
+	}
+	return nil
+}
+
 func (s *LuaStack) absIndex(idx int) int {
 	if idx <= api.LuaRegistryIndex { // pseudo index
 		return idx
@@ -137,7 +167,7 @@ func (s *LuaStack) get(idx int) LuaValue {
 		if c == nil || uvIdx >= len(c.upvals) {
 			return nil
 		}
-		return *(c.upvals[uvIdx].val)
+		return c.upvals[uvIdx].get()
 	}
 
 	if idx == api.LuaRegistryIndex {
@@ -148,7 +178,7 @@ func (s *LuaStack) get(idx int) LuaValue {
 	if !s.absIdxIsValid(absIdx) {
 		return nil // ?panic
 	}
-	return s.slots[absIdx-1]
+	return s.vs.slots[s.base+absIdx-1]
 }
 
 func (s *LuaStack) set(idx int, val LuaValue) {
@@ -156,7 +186,7 @@ func (s *LuaStack) set(idx int, val LuaValue) {
 		uvIdx := api.LuaRegistryIndex - idx - 1
 		c := s.closure
 		if c != nil && uvIdx <= len(c.upvals) {
-			*(c.upvals[uvIdx].val) = val
+			c.upvals[uvIdx].set(val)
 		}
 		return
 	}
@@ -169,13 +199,26 @@ func (s *LuaStack) set(idx int, val LuaValue) {
 	if !s.absIdxIsValid(absIdx) {
 		panic("invalid index!")
 	}
-	s.slots[absIdx-1] = val
+	s.vs.slots[s.base+absIdx-1] = val
 }
 
 func (s *LuaStack) reverse(from, to int) {
+	slots := s.vs.slots[s.base:]
 	for from < to {
-		s.slots[from], s.slots[to] = s.slots[to], s.slots[from]
+		slots[from], slots[to] = slots[to], slots[from]
 		from++
 		to--
 	}
 }
+
+// closes the open upvalues of registers >= r
+func (s *LuaStack) closeUpvalues(r int) {
+	for i, openuv := range s.openuvs {
+		if i < r {
+			continue
+		}
+
+		openuv.close()       // copy from register
+		delete(s.openuvs, i) // close upvalue
+	}
+}
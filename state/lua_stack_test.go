@@ -37,3 +37,23 @@ func TestStack2(t *testing.T) {
 		t.Error("1 `check` error")
 	}
 }
+
+func TestStackUpvalue(t *testing.T) {
+	stack := newLuaStack(2, nil)
+	stack.push(1)
+	stack.push(2)
+	uv := newOpenUpvalue(stack.vs, 1)
+
+	stack.check(100) // reallocates the slots
+	uv.set(3)
+	if got := stack.get(2); got != 3 {
+		t.Errorf("open upvalue, got = %v, want = 3", got)
+	}
+
+	stack.openuvs = map[int]*upvalue{1: uv}
+	stack.closeUpvalues(0)
+	stack.set(2, 4)
+	if got := uv.get(); got != 3 {
+		t.Errorf("closed upvalue, got = %v, want = 3", got)
+	}
+}
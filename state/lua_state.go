@@ -1,11 +1,26 @@
 package state
 
-import "luago/api"
+import (
+	"luago/api"
+	"luago/compiler"
+)
 
 // LuaState impl api.ILuaState
 type LuaState struct {
+	gcHeader
 	registry *LuaTable
 	stack    *LuaStack
+
+	maxCallDepth int
+	nGoCalls     int   // count of nested calls from Go
+	interrupted  int32 // set by Interrupt of main thread, accessed atomically
+
+	gc         *gcState    // shared by threads
+	protoCache *ProtoCache // shared compiled chunks, nil if not cached
+	optLevel   compiler.Level
+
+	main *LuaState  // the main thread
+	co   *coroutine // nil for the main thread
 }
 
 // NewLuaState new a LuaState
@@ -13,21 +28,32 @@ func NewLuaState() *LuaState {
 	registry := NewLuaTable(0, 0)
 	registry.put(api.LuaRidxGlobals, NewLuaTable(0, 0))
 	luastate := &LuaState{
-		registry: registry,
+		registry:     registry,
+		maxCallDepth: api.LuaMaxCallDepth,
+		gc:           newGCState(),
+		optLevel:     compiler.LevelFold,
 	}
 
+	luastate.main = luastate
 	luastate.pushLuaStack(newLuaStack(api.LuaMinStack, luastate))
 
 	return luastate
 }
 
 func (s *LuaState) pushLuaStack(stack *LuaStack) {
+	if s.stack != nil {
+		stack.depth = s.stack.depth + 1
+		if stack.depth > s.maxCallDepth {
+			panic("stack overflow")
+		}
+	}
 	stack.prev = s.stack
 	s.stack = stack
 }
 
 func (s *LuaState) popLuaStack() {
 	stack := s.stack
+	stack.closeUpvalues(0)
 	s.stack = s.stack.prev
 	stack.prev = nil
 }
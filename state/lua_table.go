@@ -3,27 +3,40 @@ package state
 import (
 	"luago/number"
 	"math"
+	"math/bits"
 )
 
 // LuaTable { key = value }, key is not nil or NaN
+//
+// like the table of C Lua, LuaTable has an array part and a hash part,
+// the array part keeps the values of integer keys 1..len(arr),
+// the hash part keeps the other keys in nodes, a node whose value is
+// cleared is kept as a dead node until the next rehash, so that
+// `next` can continue the traversal after fields are assigned or cleared
 type LuaTable struct {
+	gcHeader
 	metatable *LuaTable
 	arr       []LuaValue
-	m         map[LuaValue]LuaValue
-	keys      map[LuaValue]LuaValue
+	nodes     []tableNode
+	index     map[LuaValue]int // key => index of node
+	sizeNode  int              // capacity of hash part, a power of 2 or 0
 }
 
+type tableNode struct {
+	key LuaValue
+	val LuaValue // nil if the node is dead
+}
+
+// max bits of array size
+const maxABits = 31
+
 // NewLuaTable new a LuaTable
 func NewLuaTable(nArr, nRecord int) *LuaTable {
 	t := &LuaTable{}
 	if nArr > 0 {
-		t.arr = make([]LuaValue, 0, nArr)
-	}
-
-	if nRecord > 0 {
-		t.m = make(map[LuaValue]LuaValue, nRecord)
+		t.arr = make([]LuaValue, nArr)
 	}
-
+	t.resizeHash(nRecord)
 	return t
 }
 
@@ -44,11 +57,10 @@ func (t *LuaTable) get(key LuaValue) LuaValue {
 		}
 	}
 
-	// // debug
-	// for k, v := range t.m {
-	// 	fmt.Printf("get %v = %v\n", k, v)
-	// }
-	return t.m[key]
+	if i, found := t.index[key]; found {
+		return t.nodes[i].val
+	}
+	return nil
 }
 
 func (t *LuaTable) put(key, val LuaValue) {
@@ -60,88 +72,209 @@ func (t *LuaTable) put(key, val LuaValue) {
 	}
 
 	key = floatToInteger(key)
-	if idx, ok := key.(int64); ok && idx >= 1 {
-		arrLen := int64(len(t.arr))
-		if idx <= arrLen {
+	if idx, ok := key.(int64); ok {
+		if idx >= 1 && idx <= int64(len(t.arr)) {
 			t.arr[idx-1] = val
-			if idx == arrLen && val == nil {
-				t.shrinkArray()
-			}
 			return
 		}
+	}
 
-		if idx == arrLen+1 {
-			delete(t.m, key)
-			if val != nil {
-				t.arr = append(t.arr, val)
-				t.expandArray()
-			}
-			return
+	if i, found := t.index[key]; found { // existing or dead node
+		t.nodes[i].val = val
+		return
+	}
+	if val == nil {
+		return
+	}
+
+	if len(t.nodes) == t.sizeNode { // no free node
+		t.rehash(key)
+		t.put(key, val)
+		return
+	}
+	t.index[key] = len(t.nodes)
+	t.nodes = append(t.nodes, tableNode{key, val})
+}
+
+// rehash computes the new sizes of array part and hash part
+// by counting the keys, the key ek is going to be inserted
+func (t *LuaTable) rehash(ek LuaValue) {
+	var nums [maxABits + 1]int // nums[i] = count of keys in (2^(i-1), 2^i]
+	nArr := 0                  // count of integer keys could be in array part
+	total := 1                 // count of keys, including ek
+
+	for i, v := range t.arr {
+		if v != nil {
+			nums[ceilLog2(i+1)]++
+			nArr++
+			total++
 		}
 	}
-	if val != nil {
-		if t.m == nil {
-			t.m = make(map[LuaValue]LuaValue, 8)
+	for _, n := range t.nodes {
+		if n.val != nil {
+			nArr += countInt(n.key, &nums)
+			total++
 		}
-		t.m[key] = val
+	}
+	nArr += countInt(ek, &nums)
 
-	} else {
-		delete(t.m, key)
+	sizeArr, nInArr := computeSizes(&nums, nArr)
+	t.resize(sizeArr, total-nInArr)
+}
+
+// returns 1 if key is an integer could be in array part
+func countInt(key LuaValue, nums *[maxABits + 1]int) int {
+	if idx, ok := key.(int64); ok && idx >= 1 && idx <= 1<<maxABits {
+		nums[ceilLog2(int(idx))]++
+		return 1
 	}
+	return 0
 }
 
-func (t *LuaTable) shrinkArray() {
-	var i int
-	for i = len(t.arr); i > 0; i-- {
-		if t.arr[i-1] != nil {
-			break
+// computes the size of array part, the largest n such that
+// more than half of slots 1..n are in use,
+// returns the size and the count of keys which will go to array part
+func computeSizes(nums *[maxABits + 1]int, nArr int) (size, nInArr int) {
+	a := 0 // count of integer keys <= 2^i
+	for i, twoToI := 0, 1; i <= maxABits && twoToI/2 < nArr; i, twoToI = i+1, twoToI*2 {
+		if nums[i] > 0 {
+			a += nums[i]
+			if a > twoToI/2 {
+				size = twoToI
+				nInArr = a
+			}
 		}
 	}
-	t.arr = t.arr[0:i]
+	return
 }
 
-func (t *LuaTable) expandArray() {
-	for idx := int64(len(t.arr)) + 1; true; idx++ {
-		if val, found := t.m[idx]; found {
-			delete(t.m, idx)
-			t.arr = append(t.arr, val)
-		} else {
-			break
+// ceil(log2(x))
+func ceilLog2(x int) int {
+	return bits.Len(uint(x - 1))
+}
+
+// resize rebuilds the table with the given size of array part,
+// the hash part could hold nHash keys at least
+func (t *LuaTable) resize(sizeArr, nHash int) {
+	oldArr, oldNodes := t.arr, t.nodes
+
+	if sizeArr <= len(oldArr) {
+		t.arr = oldArr[:sizeArr:sizeArr]
+	} else {
+		t.arr = make([]LuaValue, sizeArr)
+		copy(t.arr, oldArr)
+	}
+	t.resizeHash(nHash)
+
+	for i := sizeArr; i < len(oldArr); i++ { // shrinks array part
+		if oldArr[i] != nil {
+			t.put(int64(i+1), oldArr[i])
+		}
+	}
+	for _, n := range oldNodes {
+		if n.val != nil {
+			t.put(n.key, n.val)
 		}
 	}
 }
 
+func (t *LuaTable) resizeHash(n int) {
+	t.sizeNode = 0
+	t.nodes = nil
+	t.index = nil
+	if n > 0 {
+		t.sizeNode = 1 << ceilLog2(n)
+		t.nodes = make([]tableNode, 0, t.sizeNode)
+		t.index = make(map[LuaValue]int, t.sizeNode)
+	}
+}
+
+// len returns a border of table, a border is a non-negative integer n
+// such that (n == 0 or t[n] ~= nil) and t[n+1] == nil
 func (t *LuaTable) len() int {
-	return len(t.arr) // map?
+	j := len(t.arr)
+	if j > 0 && t.arr[j-1] == nil {
+		// binary search a border in array part
+		i := 0
+		for j-i > 1 {
+			m := (i + j) / 2
+			if t.arr[m-1] == nil {
+				j = m
+			} else {
+				i = m
+			}
+		}
+		return i
+	}
+	if len(t.nodes) == 0 {
+		return j
+	}
+	return t.unboundSearch(j)
+}
+
+// searches a border in hash part, t[j] ~= nil or j == 0
+func (t *LuaTable) unboundSearch(j int) int {
+	i := j
+	j++
+	for t.get(int64(j)) != nil {
+		i = j
+		if j > math.MaxInt64/2 { // overflow, linear search
+			i = 1
+			for t.get(int64(i)) != nil {
+				i++
+			}
+			return i - 1
+		}
+		j *= 2
+	}
+
+	// binary search between i and j
+	for j-i > 1 {
+		m := i + (j-i)/2
+		if t.get(int64(m)) == nil {
+			j = m
+		} else {
+			i = m
+		}
+	}
+	return i
 }
 
 func (t *LuaTable) hasMetaField(fieldName string) bool {
 	return t.metatable != nil && t.metatable.get(fieldName) != nil
 }
 
-func (t *LuaTable) nextKey(key LuaValue) LuaValue {
-	if t.keys == nil || key == nil {
-		t.initKeys()
-		// t.changed = false
+// next returns the key-value pair after key in traversal order,
+// the first pair if key is nil, nil if there are no more pairs
+func (t *LuaTable) next(key LuaValue) (LuaValue, LuaValue) {
+	i := t.traversalIndex(key)
+	for ; i < len(t.arr); i++ {
+		if t.arr[i] != nil {
+			return int64(i + 1), t.arr[i]
+		}
 	}
-	return t.keys[key]
+	for i -= len(t.arr); i < len(t.nodes); i++ {
+		if n := t.nodes[i]; n.val != nil {
+			return n.key, n.val
+		}
+	}
+	return nil, nil
 }
 
-func (t *LuaTable) initKeys() {
-	t.keys = make(map[LuaValue]LuaValue)
-	var key LuaValue
-	for i, v := range t.arr {
-		if v != nil {
-			t.keys[key] = int64(i + 1)
-			key = int64(i + 1)
-		}
+// returns the index in traversal order after the given key
+func (t *LuaTable) traversalIndex(key LuaValue) int {
+	if key == nil {
+		return 0
 	}
 
-	for k, v := range t.m {
-		if v != nil {
-			t.keys[key] = k
-			key = k
+	key = floatToInteger(key)
+	if idx, ok := key.(int64); ok {
+		if idx >= 1 && idx <= int64(len(t.arr)) {
+			return int(idx)
 		}
 	}
-
+	if i, found := t.index[key]; found {
+		return len(t.arr) + i + 1
+	}
+	panic("invalid key to 'next'")
 }
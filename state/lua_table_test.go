@@ -0,0 +1,86 @@
+package state
+
+import "testing"
+
+func TestTableLen(t *testing.T) {
+	tb := NewLuaTable(0, 0)
+	for i := 1; i <= 100; i++ {
+		tb.put(int64(i), i)
+	}
+	if n := tb.len(); n != 100 {
+		t.Errorf("len = %d, want = 100", n)
+	}
+	if len(tb.arr) < 64 {
+		t.Errorf("integer keys are not moved to array part, len(arr) = %d", len(tb.arr))
+	}
+
+	tb.put(int64(100), nil)
+	if n := tb.len(); n != 99 {
+		t.Errorf("len = %d, want = 99", n)
+	}
+
+	// keys in hash part only
+	tb = NewLuaTable(0, 0)
+	tb.put("x", 1)
+	for i := 1; i <= 3; i++ {
+		tb.put(float64(i), i)
+	}
+	if n := tb.len(); n != 3 {
+		t.Errorf("len = %d, want = 3", n)
+	}
+
+	// any border is ok
+	tb = NewLuaTable(4, 0)
+	tb.put(int64(1), 1)
+	tb.put(int64(3), 3)
+	if n := tb.len(); n != 1 && n != 3 {
+		t.Errorf("len = %d, want = 1 or 3", n)
+	}
+}
+
+func TestTableNext(t *testing.T) {
+	tb := NewLuaTable(0, 0)
+	want := map[LuaValue]LuaValue{}
+	for i := 1; i <= 10; i++ {
+		tb.put(int64(i), i)
+		want[int64(i)] = i
+	}
+	for _, k := range []string{"a", "b", "c", "d", "e"} {
+		tb.put(k, k)
+		want[k] = k
+	}
+
+	// clears and assigns the fields during traversal
+	got := map[LuaValue]LuaValue{}
+	for k, v := tb.next(nil); k != nil; k, v = tb.next(k) {
+		got[k] = v
+		tb.put(k, nil)
+		if s, ok := k.(string); ok && s != "e" {
+			tb.put("e", "E")
+			want["e"] = "E"
+		}
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d pairs, want %d", len(got), len(want))
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("t[%v] = %v, want = %v", k, got[k], v)
+		}
+	}
+	if k, _ := tb.next(nil); k != nil {
+		t.Errorf("table is not empty, key = %v", k)
+	}
+}
+
+func TestTableNextInvalidKey(t *testing.T) {
+	defer func() {
+		if err := recover(); err != "invalid key to 'next'" {
+			t.Errorf("err = %v", err)
+		}
+	}()
+
+	tb := NewLuaTable(0, 0)
+	tb.put("a", 1)
+	tb.next("b")
+}
@@ -0,0 +1,8 @@
+package state
+
+// userdata holds a Go value in Lua
+type userdata struct {
+	gcHeader
+	metatable *LuaTable
+	data      interface{}
+}
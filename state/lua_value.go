@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"luago/api"
 	"luago/number"
+	"math"
+	"strconv"
+	"strings"
 )
 
 // LuaValue is the type of Lua value
@@ -25,6 +28,10 @@ func typeOf(val LuaValue) api.LuaType {
 		return api.LuaTTable
 	case *luaClosure:
 		return api.LuaTFunction
+	case *userdata:
+		return api.LuaTUserData
+	case *LuaState:
+		return api.LuaTThread
 	default:
 		panic("TODO")
 	}
@@ -81,19 +88,25 @@ func convertToInteger(val LuaValue) (int64, bool) {
 }
 
 func setMetaTable(val LuaValue, mt *LuaTable, state *LuaState) {
-	if t, ok := val.(*LuaTable); ok { // val is a table
-		t.metatable = mt
-		return
+	switch x := val.(type) {
+	case *LuaTable:
+		x.metatable = mt
+		state.checkFinalizer(x, mt)
+	case *userdata:
+		x.metatable = mt
+		state.checkFinalizer(x, mt)
+	default: // register into registry table
+		key := fmt.Sprintf("_MT%d", typeOf(val))
+		state.registry.put(key, mt)
 	}
-
-	// val is not a table, register into registry table
-	key := fmt.Sprintf("_MT%d", typeOf(val))
-	state.registry.put(key, val)
 }
 
 func getMetaTable(val LuaValue, state *LuaState) *LuaTable {
-	if t, ok := val.(*LuaTable); ok {
-		return t.metatable
+	switch x := val.(type) {
+	case *LuaTable:
+		return x.metatable
+	case *userdata:
+		return x.metatable
 	}
 
 	key := fmt.Sprintf("_MT%d", typeOf(val))
@@ -103,3 +116,20 @@ func getMetaTable(val LuaValue, state *LuaState) *LuaTable {
 
 	return nil
 }
+
+// formats float like "%.14g" of Lua, looks like a float
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	}
+	str := strconv.FormatFloat(f, 'g', 14, 64)
+	if !strings.ContainsAny(str, ".e") {
+		str += ".0"
+	}
+	return str
+}
@@ -0,0 +1,56 @@
+package state
+
+import (
+	"crypto/sha256"
+	"luago/binchunk"
+	"luago/compiler"
+	"sync"
+)
+
+// ProtoCache is the cache of compiled chunks shared by states, the
+// prototypes are keyed by chunk name, mode, level of optimizations and
+// the hash of content,
+// it's safe for concurrent use. The cache only grows, it's meant for
+// the fixed set of scripts of a program
+type ProtoCache struct {
+	protos sync.Map // protoKey => *binchunk.ProtoType
+}
+
+type protoKey struct {
+	chunkName string
+	mode      string
+	level     compiler.Level
+	sum       [sha256.Size]byte
+}
+
+// NewProtoCache returns an empty cache
+func NewProtoCache() *ProtoCache {
+	return &ProtoCache{}
+}
+
+// Compile is like Compile of the package, but returns the cached prototype
+// of the same chunk, the failures are not cached
+func (c *ProtoCache) Compile(chunk []byte, chunkName, mode string) (*binchunk.ProtoType, error) {
+	return c.CompileLevel(chunk, chunkName, mode, compiler.LevelFold)
+}
+
+// CompileLevel is like CompileLevel of the package, but returns the cached
+// prototype of the same chunk and level
+func (c *ProtoCache) CompileLevel(chunk []byte, chunkName, mode string, level compiler.Level) (*binchunk.ProtoType, error) {
+	key := protoKey{chunkName, mode, level, sha256.Sum256(chunk)}
+	if proto, ok := c.protos.Load(key); ok {
+		return proto.(*binchunk.ProtoType), nil
+	}
+	proto, err := CompileLevel(chunk, chunkName, mode, level)
+	if err != nil {
+		return nil, err
+	}
+	actual, _ := c.protos.LoadOrStore(key, proto) // keep the first one of racing compiles
+	return actual.(*binchunk.ProtoType), nil
+}
+
+// SetProtoCache makes Load look up the chunks in c before compiling them,
+// nil stops caching
+func (s *LuaState) SetProtoCache(c *ProtoCache) {
+	s.protoCache = c
+}
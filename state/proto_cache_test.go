@@ -0,0 +1,44 @@
+package state
+
+import (
+	"luago/api"
+	"testing"
+)
+
+func TestProtoCache(t *testing.T) {
+	c := NewProtoCache()
+	p1, err := c.Compile([]byte("return 1"), "a", "bt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p2, _ := c.Compile([]byte("return 1"), "a", "bt"); p2 != p1 {
+		t.Error("same chunk compiled twice")
+	}
+	if p2, _ := c.Compile([]byte("return 2"), "a", "bt"); p2 == p1 {
+		t.Error("changed chunk not compiled")
+	}
+	if p2, _ := c.Compile([]byte("return 1"), "b", "bt"); p2 == p1 {
+		t.Error("chunk of another name is shared")
+	}
+	if _, err := c.Compile([]byte("return +"), "c", "bt"); err == nil {
+		t.Error("want syntax error")
+	}
+	if _, err := c.Compile([]byte("return 1"), "a", "b"); err == nil {
+		t.Error("text chunk loaded in binary mode")
+	}
+
+	// states share the prototype but not the globals
+	for i := int64(1); i <= 2; i++ {
+		ls := NewLuaState()
+		ls.SetProtoCache(c)
+		ls.PushInteger(i)
+		ls.SetGlobal("x")
+		if ls.Load([]byte("y = (y or 0) + 1 return x * 10 + y"), "d", "t") != api.LuaOk {
+			t.Fatal(ls.ToString(-1))
+		}
+		ls.Call(0, 1)
+		if got := ls.ToInteger(-1); got != i*10+1 {
+			t.Errorf("state %d: got %d", i, got)
+		}
+	}
+}
@@ -0,0 +1,412 @@
+package stdlib
+
+import (
+	"io"
+	"luago/api"
+	"luago/number"
+	"strconv"
+	"strings"
+)
+
+var baseFuncs = api.FuncReg{
+	"assert":         baseAssert,
+	"collectgarbage": baseCollectGarbage,
+	"dofile":         baseDoFile,
+	"error":          baseError,
+	"getmetatable":   baseGetMetatable,
+	"ipairs":         baseIPairs,
+	"loadfile":       baseLoadFile,
+	"load":           baseLoad,
+	"next":           baseNext,
+	"pairs":          basePairs,
+	"pcall":          basePCall,
+	"print":          basePrint,
+	"rawequal":       baseRawEqual,
+	"rawlen":         baseRawLen,
+	"rawget":         baseRawGet,
+	"rawset":         baseRawSet,
+	"select":         baseSelect,
+	"setmetatable":   baseSetMetatable,
+	"tonumber":       baseToNumber,
+	"tostring":       baseToString,
+	"type":           baseType,
+	"xpcall":         baseXPCall,
+}
+
+// OpenBaseLib opens the basic library into the global table
+func OpenBaseLib(ls api.ILuaState) int {
+	// open lib into global table
+	ls.PushGlobalTable()
+	ls.SetFuncs(baseFuncs, 0)
+	// set global _G
+	ls.PushValue(-1)
+	ls.SetField(-2, "_G")
+	// set global _VERSION
+	ls.PushString("Lua 5.3")
+	ls.SetField(-2, "_VERSION")
+	return 1
+}
+
+// print (···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-print
+func basePrint(ls api.ILuaState) int {
+	w := stdout(ls)
+	n := ls.GetTop() // number of arguments
+	for i := 1; i <= n; i++ {
+		s := ls.ToStringMeta(i)
+		ls.Pop(1)
+		if i > 1 {
+			io.WriteString(w, "\t")
+		}
+		io.WriteString(w, s)
+	}
+	io.WriteString(w, "\n")
+	return 0
+}
+
+// assert (v [, message])
+// http://www.lua.org/manual/5.3/manual.html#pdf-assert
+func baseAssert(ls api.ILuaState) int {
+	if ls.ToBoolean(1) { // condition is true?
+		return ls.GetTop() // return all arguments
+	}
+	ls.CheckAny(1)                     // there must be a condition
+	ls.Remove(1)                       // remove it
+	ls.PushString("assertion failed!") // default message
+	ls.SetTop(1)                       // leave only message (default if no other one)
+	return baseError(ls)               // call 'error'
+}
+
+// error (message [, level])
+// http://www.lua.org/manual/5.3/manual.html#pdf-error
+func baseError(ls api.ILuaState) int {
+	level := int(ls.OptInteger(2, 1))
+	ls.SetTop(1)
+	if ls.Type(1) == api.LuaTString && level > 0 {
+		ls.Where(level) // add extra information
+		ls.PushValue(1)
+		ls.Concat(2)
+	}
+	return ls.Error()
+}
+
+// pcall (f [, arg1, ···])
+// http://www.lua.org/manual/5.3/manual.html#pdf-pcall
+func basePCall(ls api.ILuaState) int {
+	ls.CheckAny(1)
+	nArgs := ls.GetTop() - 1
+	status := ls.PCall(nArgs, -1, 0)
+	ls.PushBoolean(status == api.LuaOk)
+	ls.Insert(1)
+	return ls.GetTop()
+}
+
+// xpcall (f, msgh [, arg1, ···])
+// http://www.lua.org/manual/5.3/manual.html#pdf-xpcall
+func baseXPCall(ls api.ILuaState) int {
+	n := ls.GetTop()
+	ls.CheckType(2, api.LuaTFunction) // check error function
+	ls.PushBoolean(true)              // first result if no errors
+	ls.PushValue(1)                   // function
+	ls.Rotate(3, 2)                   // move them below function's arguments
+	status := ls.PCall(n-2, -1, 2)
+	ls.PushBoolean(status == api.LuaOk)
+	ls.Replace(3)
+	return ls.GetTop() - 2
+}
+
+// getmetatable (object)
+// http://www.lua.org/manual/5.3/manual.html#pdf-getmetatable
+func baseGetMetatable(ls api.ILuaState) int {
+	ls.CheckAny(1)
+	if !ls.GetMetaTable(1) {
+		ls.PushNil()
+		return 1 // no metatable
+	}
+	ls.GetMetaField(1, "__metatable")
+	return 1 // returns either __metatable field (if present) or metatable
+}
+
+// setmetatable (table, metatable)
+// http://www.lua.org/manual/5.3/manual.html#pdf-setmetatable
+func baseSetMetatable(ls api.ILuaState) int {
+	t := ls.Type(2)
+	ls.CheckType(1, api.LuaTTable)
+	ls.ArgCheck(t == api.LuaTNil || t == api.LuaTTable, 2, "nil or table expected")
+	if ls.GetMetaField(1, "__metatable") != api.LuaTNil {
+		return ls.Error2("cannot change a protected metatable")
+	}
+	ls.SetTop(2)
+	ls.SetMetaTable(1)
+	return 1
+}
+
+// rawequal (v1, v2)
+// http://www.lua.org/manual/5.3/manual.html#pdf-rawequal
+func baseRawEqual(ls api.ILuaState) int {
+	ls.CheckAny(1)
+	ls.CheckAny(2)
+	ls.PushBoolean(ls.RawEqual(1, 2))
+	return 1
+}
+
+// rawlen (v)
+// http://www.lua.org/manual/5.3/manual.html#pdf-rawlen
+func baseRawLen(ls api.ILuaState) int {
+	t := ls.Type(1)
+	ls.ArgCheck(t == api.LuaTTable || t == api.LuaTString, 1,
+		"table or string expected")
+	ls.PushInteger(int64(ls.RawLen(1)))
+	return 1
+}
+
+// rawget (table, index)
+// http://www.lua.org/manual/5.3/manual.html#pdf-rawget
+func baseRawGet(ls api.ILuaState) int {
+	ls.CheckType(1, api.LuaTTable)
+	ls.CheckAny(2)
+	ls.SetTop(2)
+	ls.RawGet(1)
+	return 1
+}
+
+// rawset (table, index, value)
+// http://www.lua.org/manual/5.3/manual.html#pdf-rawset
+func baseRawSet(ls api.ILuaState) int {
+	ls.CheckType(1, api.LuaTTable)
+	ls.CheckAny(2)
+	ls.CheckAny(3)
+	ls.SetTop(3)
+	ls.RawSet(1)
+	return 1
+}
+
+// type (v)
+// http://www.lua.org/manual/5.3/manual.html#pdf-type
+func baseType(ls api.ILuaState) int {
+	t := ls.Type(1)
+	ls.ArgCheck(t != api.LuaTNone, 1, "value expected")
+	ls.PushString(ls.TypeName(t))
+	return 1
+}
+
+// tostring (v)
+// http://www.lua.org/manual/5.3/manual.html#pdf-tostring
+func baseToString(ls api.ILuaState) int {
+	ls.CheckAny(1)
+	ls.ToStringMeta(1)
+	return 1
+}
+
+// tonumber (e [, base])
+// http://www.lua.org/manual/5.3/manual.html#pdf-tonumber
+func baseToNumber(ls api.ILuaState) int {
+	if ls.IsNoneOrNil(2) { // standard conversion?
+		if ls.Type(1) == api.LuaTNumber {
+			ls.SetTop(1) // yes; return it
+			return 1
+		}
+		if s, ok := ls.ToStringX(1); ok {
+			if i, ok := number.ParseInteger(s); ok {
+				ls.PushInteger(i)
+				return 1
+			}
+			if f, ok := number.ParseFloat(s); ok {
+				ls.PushNumber(f)
+				return 1
+			}
+		}
+		ls.CheckAny(1) // (but there must be some parameter)
+	} else {
+		base := ls.CheckInteger(2)
+		ls.CheckType(1, api.LuaTString) // no numbers as strings
+		s := strings.ToLower(strings.TrimSpace(ls.ToString(1)))
+		ls.ArgCheck(2 <= base && base <= 36, 2, "base out of range")
+		neg := strings.HasPrefix(s, "-")
+		if neg {
+			s = s[1:]
+		}
+		if u, err := strconv.ParseUint(s, int(base), 64); err == nil && !strings.HasPrefix(s, "+") {
+			if neg {
+				u = -u
+			}
+			ls.PushInteger(int64(u))
+			return 1
+		}
+	}
+	ls.PushNil() // not a number
+	return 1
+}
+
+// select (index, ···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-select
+func baseSelect(ls api.ILuaState) int {
+	n := int64(ls.GetTop())
+	if ls.Type(1) == api.LuaTString && ls.ToString(1) == "#" {
+		ls.PushInteger(n - 1)
+		return 1
+	}
+	i := ls.CheckInteger(1)
+	if i < 0 {
+		i = n + i
+	} else if i > n {
+		i = n
+	}
+	ls.ArgCheck(1 <= i, 1, "index out of range")
+	return int(n - i)
+}
+
+// next (table [, index])
+// http://www.lua.org/manual/5.3/manual.html#pdf-next
+func baseNext(ls api.ILuaState) int {
+	ls.CheckType(1, api.LuaTTable)
+	ls.SetTop(2) // create a 2nd argument if there isn't one
+	if ls.Next(1) {
+		return 2
+	}
+	ls.PushNil()
+	return 1
+}
+
+// pairs (t)
+// http://www.lua.org/manual/5.3/manual.html#pdf-pairs
+func basePairs(ls api.ILuaState) int {
+	ls.CheckAny(1)
+	if ls.GetMetaField(1, "__pairs") == api.LuaTNil { // no metamethod?
+		ls.PushGoFunction(baseNext) // will return generator,
+		ls.PushValue(1)             // state,
+		ls.PushNil()                // and initial value
+	} else {
+		ls.PushValue(1) // argument 'self' to metamethod
+		ls.Call(1, 3)   // get 3 values from metamethod
+	}
+	return 3
+}
+
+// ipairs (t)
+// http://www.lua.org/manual/5.3/manual.html#pdf-ipairs
+func baseIPairs(ls api.ILuaState) int {
+	ls.CheckAny(1)
+	ls.PushGoFunction(iPairsAux) // iteration function
+	ls.PushValue(1)              // state
+	ls.PushInteger(0)            // initial value
+	return 3
+}
+
+func iPairsAux(ls api.ILuaState) int {
+	i := ls.CheckInteger(2) + 1
+	ls.PushInteger(i)
+	if ls.GetI(1, i) == api.LuaTNil {
+		return 1
+	}
+	return 2
+}
+
+// load (chunk [, chunkname [, mode [, env]]])
+// http://www.lua.org/manual/5.3/manual.html#pdf-load
+func baseLoad(ls api.ILuaState) int {
+	var status int
+	mode := ls.OptString(3, "bt")
+	env := 0 // 'env' index or 0 if no 'env'
+	if !ls.IsNone(4) {
+		env = 4
+	}
+	if s, ok := ls.ToStringX(1); ok { // loading a string?
+		chunkName := ls.OptString(2, s)
+		status = ls.Load([]byte(s), chunkName, mode)
+	} else { // loading from a reader function
+		chunkName := ls.OptString(2, "=(load)")
+		ls.CheckType(1, api.LuaTFunction)
+		chunk := readChunk(ls)
+		status = ls.Load(chunk, chunkName, mode)
+	}
+	return loadAux(ls, status, env)
+}
+
+// calls the reader function at index 1 until it returns nil or empty string,
+// returns the concatenation of the pieces
+func readChunk(ls api.ILuaState) []byte {
+	var chunk []byte
+	for {
+		ls.PushValue(1)
+		ls.Call(0, 1)
+		if ls.IsNil(-1) {
+			ls.Pop(1)
+			return chunk
+		}
+		piece, ok := ls.ToStringX(-1)
+		if !ok || ls.Type(-1) != api.LuaTString {
+			ls.Error2("reader function must return a string")
+		}
+		ls.Pop(1)
+		if piece == "" {
+			return chunk
+		}
+		chunk = append(chunk, piece...)
+	}
+}
+
+func loadAux(ls api.ILuaState, status, envIdx int) int {
+	if status == api.LuaOk {
+		if envIdx != 0 { // 'env' parameter?
+			ls.PushValue(envIdx)                    // environment for loaded function
+			if _, ok := ls.SetUpvalue(-2, 1); !ok { // set it as 1st upvalue
+				ls.Pop(1) // remove 'env' if not used by previous call
+			}
+		}
+		return 1
+	}
+	// error (message is on top of the stack)
+	ls.PushNil()
+	ls.Insert(-2) // put before error message
+	return 2      // return nil plus error message
+}
+
+// loadfile ([filename [, mode [, env]]])
+// http://www.lua.org/manual/5.3/manual.html#pdf-loadfile
+func baseLoadFile(ls api.ILuaState) int {
+	fname := ls.OptString(1, "")
+	mode := ls.OptString(2, "bt")
+	env := 0 // 'env' index or 0 if no 'env'
+	if !ls.IsNone(3) {
+		env = 3
+	}
+	status := ls.LoadFileX(fname, mode)
+	return loadAux(ls, status, env)
+}
+
+// dofile ([filename])
+// http://www.lua.org/manual/5.3/manual.html#pdf-dofile
+func baseDoFile(ls api.ILuaState) int {
+	fname := ls.OptString(1, "")
+	ls.SetTop(1)
+	if ls.LoadFile(fname) != api.LuaOk {
+		return ls.Error()
+	}
+	ls.Call(0, -1)
+	return ls.GetTop() - 1
+}
+
+// collectgarbage ([opt [, arg]])
+// http://www.lua.org/manual/5.3/manual.html#pdf-collectgarbage
+func baseCollectGarbage(ls api.ILuaState) int {
+	opts := []string{"stop", "restart", "collect",
+		"count", "step", "setpause", "setstepmul",
+		"isrunning"}
+	optsNum := []int{api.LuaGCStop, api.LuaGCRestart, api.LuaGCCollect,
+		api.LuaGCCount, api.LuaGCStep, api.LuaGCSetPause, api.LuaGCSetStepMul,
+		api.LuaGCIsRunning}
+	o := optsNum[ls.CheckOption(1, "collect", opts)]
+	ex := int(ls.OptInteger(2, 0))
+	res := ls.GC(o, ex)
+	switch o {
+	case api.LuaGCCount:
+		b := ls.GC(api.LuaGCCountB, 0)
+		ls.PushNumber(float64(res) + float64(b)/1024)
+	case api.LuaGCStep, api.LuaGCIsRunning:
+		ls.PushBoolean(res != 0)
+	default:
+		ls.PushInteger(int64(res))
+	}
+	return 1
+}
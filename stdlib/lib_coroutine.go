@@ -0,0 +1,147 @@
+package stdlib
+
+import "luago/api"
+
+var coFuncs = api.FuncReg{
+	"create":      coCreate,
+	"resume":      coResume,
+	"yield":       coYield,
+	"status":      coStatus,
+	"isyieldable": coYieldable,
+	"running":     coRunning,
+	"wrap":        coWrap,
+}
+
+// OpenCoroutineLib opens the coroutine library
+func OpenCoroutineLib(ls api.ILuaState) int {
+	ls.NewLib(coFuncs)
+	return 1
+}
+
+func getCo(ls api.ILuaState) api.ILuaState {
+	co := ls.ToThread(1)
+	ls.ArgCheck(co != nil, 1, "coroutine expected")
+	return co
+}
+
+// resumes co with the narg values on the top, returns the count of
+// results moved onto ls, or -1 and pushes the error message
+func auxResume(ls, co api.ILuaState, narg int) int {
+	if status := auxStatus(ls, co); status == "dead" {
+		ls.PushString("cannot resume dead coroutine")
+		return -1
+	} else if status != "suspended" {
+		ls.PushString("cannot resume non-suspended coroutine")
+		return -1
+	}
+	if !co.CheckStack(narg) {
+		ls.PushString("too many arguments to resume")
+		return -1
+	}
+	ls.XMove(co, narg)
+	status, nres := co.Resume(ls, narg)
+	if status == api.LuaOk || status == api.LuaYield {
+		if !ls.CheckStack(nres + 1) {
+			co.Pop(nres)
+			ls.PushString("too many results to resume")
+			return -1
+		}
+		co.XMove(ls, nres)
+		return nres
+	}
+	co.XMove(ls, 1) // move error message
+	return -1
+}
+
+// coroutine.resume (co [, val1, ···])
+// http://www.lua.org/manual/5.3/manual.html#pdf-coroutine.resume
+func coResume(ls api.ILuaState) int {
+	co := getCo(ls)
+	r := auxResume(ls, co, ls.GetTop()-1)
+	if r < 0 {
+		ls.PushBoolean(false)
+		ls.Insert(-2)
+		return 2 // return false + error message
+	}
+	ls.PushBoolean(true)
+	ls.Insert(-(r + 1))
+	return r + 1 // return true + 'resume' returns
+}
+
+func auxWrap(ls api.ILuaState) int {
+	co := ls.ToThread(api.LuaUpvalueIndex(1))
+	r := auxResume(ls, co, ls.GetTop())
+	if r < 0 {
+		if ls.Type(-1) == api.LuaTString { // error object is a string?
+			ls.Where(1) // get extra info
+			ls.Insert(-2)
+			ls.Concat(2)
+		}
+		return ls.Error() // propagate error
+	}
+	return r
+}
+
+// coroutine.create (f)
+// http://www.lua.org/manual/5.3/manual.html#pdf-coroutine.create
+func coCreate(ls api.ILuaState) int {
+	ls.CheckType(1, api.LuaTFunction)
+	co := ls.NewThread()
+	ls.PushValue(1) // move function to top
+	ls.XMove(co, 1) // move function from ls to co
+	return 1
+}
+
+// coroutine.wrap (f)
+// http://www.lua.org/manual/5.3/manual.html#pdf-coroutine.wrap
+func coWrap(ls api.ILuaState) int {
+	coCreate(ls)
+	ls.PushGoClosure(auxWrap, 1)
+	return 1
+}
+
+// coroutine.yield (···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-coroutine.yield
+func coYield(ls api.ILuaState) int {
+	return ls.Yield(ls.GetTop())
+}
+
+func auxStatus(ls, co api.ILuaState) string {
+	if ls == co {
+		return "running"
+	}
+	switch co.Status() {
+	case api.LuaYield:
+		return "suspended"
+	case api.LuaOk:
+		if co.GetStack(0) { // does it have frames?
+			return "normal" // it is running
+		} else if co.GetTop() == 0 {
+			return "dead"
+		}
+		return "suspended" // initial state
+	}
+	return "dead" // some error occurred
+}
+
+// coroutine.status (co)
+// http://www.lua.org/manual/5.3/manual.html#pdf-coroutine.status
+func coStatus(ls api.ILuaState) int {
+	ls.PushString(auxStatus(ls, getCo(ls)))
+	return 1
+}
+
+// coroutine.isyieldable ()
+// http://www.lua.org/manual/5.3/manual.html#pdf-coroutine.isyieldable
+func coYieldable(ls api.ILuaState) int {
+	ls.PushBoolean(ls.IsYieldable())
+	return 1
+}
+
+// coroutine.running ()
+// http://www.lua.org/manual/5.3/manual.html#pdf-coroutine.running
+func coRunning(ls api.ILuaState) int {
+	isMain := ls.PushThread()
+	ls.PushBoolean(isMain)
+	return 2
+}
@@ -0,0 +1,92 @@
+package stdlib
+
+import "luago/api"
+
+var dbLib = api.FuncReg{
+	"getmetatable": dbGetMetatable,
+	"getregistry":  dbGetRegistry,
+	"getupvalue":   dbGetUpvalue,
+	"setmetatable": dbSetMetatable,
+	"setupvalue":   dbSetUpvalue,
+	"traceback":    dbTraceback,
+}
+
+// OpenDebugLib opens the debug library
+func OpenDebugLib(ls api.ILuaState) int {
+	ls.NewLib(dbLib)
+	return 1
+}
+
+// debug.getregistry ()
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.getregistry
+func dbGetRegistry(ls api.ILuaState) int {
+	ls.PushValue(api.LuaRegistryIndex)
+	return 1
+}
+
+// debug.getmetatable (value)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.getmetatable
+func dbGetMetatable(ls api.ILuaState) int {
+	ls.CheckAny(1)
+	if !ls.GetMetaTable(1) {
+		ls.PushNil() // no metatable
+	}
+	return 1
+}
+
+// debug.setmetatable (value, table)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.setmetatable
+func dbSetMetatable(ls api.ILuaState) int {
+	t := ls.Type(2)
+	ls.ArgCheck(t == api.LuaTNil || t == api.LuaTTable, 2, "nil or table expected")
+	ls.SetTop(2)
+	ls.SetMetaTable(1)
+	return 1 // return 1st argument
+}
+
+// debug.getupvalue (f, up)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.getupvalue
+func dbGetUpvalue(ls api.ILuaState) int {
+	return auxUpvalue(ls, true)
+}
+
+// debug.setupvalue (f, up, value)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.setupvalue
+func dbSetUpvalue(ls api.ILuaState) int {
+	ls.CheckAny(3)
+	return auxUpvalue(ls, false)
+}
+
+func auxUpvalue(ls api.ILuaState, get bool) int {
+	n := int(ls.CheckInteger(2))
+	ls.CheckType(1, api.LuaTFunction)
+	var name string
+	var ok bool
+	if get {
+		name, ok = ls.GetUpvalue(1, n)
+	} else {
+		name, ok = ls.SetUpvalue(1, n)
+	}
+	if !ok {
+		return 0
+	}
+	ls.PushString(name)
+	if get {
+		ls.Insert(-2) // name, value
+		return 2
+	}
+	return 1
+}
+
+// debug.traceback ([message [, level]])
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.traceback
+func dbTraceback(ls api.ILuaState) int {
+	msg, isStr := ls.ToStringX(1)
+	if !isStr && !ls.IsNoneOrNil(1) { // non-string 'msg'?
+		ls.PushValue(1) // return it untouched
+	} else {
+		level := int(ls.OptInteger(2, 1))
+		ls.Traceback(msg, level)
+	}
+	return 1
+}
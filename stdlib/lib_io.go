@@ -0,0 +1,602 @@
+package stdlib
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"luago/api"
+	"luago/number"
+	"os"
+	"strings"
+	"syscall"
+)
+
+const fileHandle = "FILE*" // name of the metatable of files
+
+// registry keys of the default files
+const (
+	ioInput  = "_IO_input"
+	ioOutput = "_IO_output"
+)
+
+// luaFile is the userdata of files, the reader is buffered,
+// writes go straight to the writer
+type luaFile struct {
+	r      *bufio.Reader
+	w      io.Writer
+	f      interface{} // the underlying file
+	closed bool
+	std    bool // standard files can't be closed
+}
+
+func newLuaFile(f interface{}, std bool) *luaFile {
+	lf := &luaFile{f: f, std: std}
+	if r, ok := f.(io.Reader); ok {
+		lf.r = bufio.NewReader(r)
+	}
+	if w, ok := f.(io.Writer); ok {
+		lf.w = w
+	}
+	return lf
+}
+
+var ioLib = api.FuncReg{
+	"close":  ioClose,
+	"flush":  ioFlush,
+	"input":  ioInputFn,
+	"lines":  ioLines,
+	"open":   ioOpen,
+	"output": ioOutputFn,
+	"popen":  ioPopen,
+	"read":   ioRead,
+	"type":   ioType,
+	"write":  ioWrite,
+}
+
+var fileMethods = api.FuncReg{
+	"close":   fClose,
+	"flush":   fFlush,
+	"lines":   fLines,
+	"read":    fRead,
+	"seek":    fSeek,
+	"setvbuf": fSetvbuf,
+	"write":   fWrite,
+}
+
+// OpenIOLib opens the io library, io.stdin, io.stdout and io.stderr
+// are made of the standard files of the state
+func OpenIOLib(ls api.ILuaState) int {
+	ls.NewLib(ioLib)
+	createMeta(ls)
+	// create (and set) default files
+	createStdFile(ls, newLuaFile(stdin(ls), true), ioInput, "stdin")
+	createStdFile(ls, newLuaFile(stdout(ls), true), ioOutput, "stdout")
+	createStdFile(ls, newLuaFile(stderr(ls), true), "", "stderr")
+	return 1
+}
+
+func createMeta(ls api.ILuaState) {
+	ls.NewMetatable(fileHandle) // create metatable for file handles
+	ls.PushValue(-1)            // push metatable
+	ls.SetField(-2, "__index")  // metatable.__index = metatable
+	ls.SetFuncs(api.FuncReg{"__gc": fGC, "__tostring": fToString}, 0)
+	ls.SetFuncs(fileMethods, 0) // add file methods to new metatable
+	protectMetatable(ls)        // it's shared by all chunks
+	ls.Pop(1)                   // pop new metatable
+}
+
+func createStdFile(ls api.ILuaState, lf *luaFile, key, fname string) {
+	pushFile(ls, lf)
+	if key != "" {
+		ls.PushValue(-1)
+		ls.SetField(api.LuaRegistryIndex, key) // add file to registry
+	}
+	ls.SetField(-2, fname) // add file to module
+}
+
+func pushFile(ls api.ILuaState, lf *luaFile) {
+	ls.NewUserData(lf)
+	ls.SetMetatableByName(fileHandle)
+}
+
+func toFile(ls api.ILuaState, arg int) *luaFile {
+	lf := ls.CheckUData(arg, fileHandle).(*luaFile)
+	if lf.closed {
+		ls.Error2("attempt to use a closed file")
+	}
+	return lf
+}
+
+// io.type (obj)
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.type
+func ioType(ls api.ILuaState) int {
+	ls.CheckAny(1)
+	if lf, ok := ls.TestUData(1, fileHandle).(*luaFile); !ok {
+		ls.PushNil() // not a file
+	} else if lf.closed {
+		ls.PushString("closed file")
+	} else {
+		ls.PushString("file")
+	}
+	return 1
+}
+
+func fToString(ls api.ILuaState) int {
+	lf := ls.CheckUData(1, fileHandle).(*luaFile)
+	if lf.closed {
+		ls.PushString("file (closed)")
+	} else {
+		ls.PushString(fmt.Sprintf("file (%p)", lf))
+	}
+	return 1
+}
+
+func fGC(ls api.ILuaState) int {
+	lf := ls.CheckUData(1, fileHandle).(*luaFile)
+	if !lf.closed && !lf.std {
+		closeFile(lf) // ignore closed and incompletely open files
+	}
+	return 0
+}
+
+func closeFile(lf *luaFile) error {
+	lf.closed = true
+	if c, ok := lf.f.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+func auxClose(ls api.ILuaState) int {
+	lf := toFile(ls, 1)
+	if lf.std {
+		ls.PushNil()
+		ls.PushString("cannot close standard file")
+		return 2
+	}
+	return fileResult(ls, closeFile(lf), "")
+}
+
+// io.close ([file])
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.close
+func ioClose(ls api.ILuaState) int {
+	if ls.IsNone(1) { // no argument?
+		ls.GetField(api.LuaRegistryIndex, ioOutput) // use standard output
+	}
+	return fClose(ls)
+}
+
+// file:close ()
+// http://www.lua.org/manual/5.3/manual.html#pdf-file:close
+func fClose(ls api.ILuaState) int {
+	toFile(ls, 1) // make sure argument is an open stream
+	return auxClose(ls)
+}
+
+// io.open (filename [, mode])
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.open
+func ioOpen(ls api.ILuaState) int {
+	filename := ls.CheckString(1)
+	mode := ls.OptString(2, "r")
+	flag, ok := openFlag(mode)
+	ls.ArgCheck(ok, 2, "invalid mode")
+	f, err := os.OpenFile(filename, flag, 0666)
+	if err != nil {
+		return fileResult(ls, err, filename)
+	}
+	pushFile(ls, newLuaFile(f, false))
+	return 1
+}
+
+// converts the mode of fopen to the flag of os.OpenFile
+func openFlag(mode string) (int, bool) {
+	mode = strings.TrimSuffix(mode, "b")
+	switch mode {
+	case "r":
+		return os.O_RDONLY, true
+	case "w":
+		return os.O_WRONLY | os.O_CREATE | os.O_TRUNC, true
+	case "a":
+		return os.O_WRONLY | os.O_CREATE | os.O_APPEND, true
+	case "r+":
+		return os.O_RDWR, true
+	case "w+":
+		return os.O_RDWR | os.O_CREATE | os.O_TRUNC, true
+	case "a+":
+		return os.O_RDWR | os.O_CREATE | os.O_APPEND, true
+	}
+	return 0, false
+}
+
+// io.popen (prog [, mode])
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.popen
+func ioPopen(ls api.ILuaState) int {
+	return ls.Error2("'popen' not supported")
+}
+
+// returns the default file with key in registry
+func getIOFile(ls api.ILuaState, key string) *luaFile {
+	ls.GetField(api.LuaRegistryIndex, key)
+	lf := ls.ToUserData(-1).(*luaFile)
+	if lf.closed {
+		ls.Error2("standard %s file is closed", key[len("_IO_"):])
+	}
+	return lf
+}
+
+// function to (not) close the standard files stdin, stdout, and stderr
+func gIOFile(ls api.ILuaState, key, mode string) int {
+	if !ls.IsNoneOrNil(1) {
+		if filename, ok := ls.ToStringX(1); ok && ls.Type(1) == api.LuaTString {
+			flag, _ := openFlag(mode)
+			f, err := os.OpenFile(filename, flag, 0666)
+			if err != nil {
+				return ls.Error2("cannot open file '%s' (%s)", filename,
+					pathError(err))
+			}
+			pushFile(ls, newLuaFile(f, false))
+		} else {
+			toFile(ls, 1) // check that it's a valid file handle
+			ls.PushValue(1)
+		}
+		ls.SetField(api.LuaRegistryIndex, key)
+	}
+	// return current value
+	ls.GetField(api.LuaRegistryIndex, key)
+	return 1
+}
+
+// io.input ([file])
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.input
+func ioInputFn(ls api.ILuaState) int {
+	return gIOFile(ls, ioInput, "r")
+}
+
+// io.output ([file])
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.output
+func ioOutputFn(ls api.ILuaState) int {
+	return gIOFile(ls, ioOutput, "w")
+}
+
+// io.lines ([filename ···])
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.lines
+func ioLines(ls api.ILuaState) int {
+	if ls.IsNone(1) {
+		ls.PushNil() // at least one argument
+	}
+	toClose := false
+	if ls.IsNil(1) { // no file name?
+		ls.GetField(api.LuaRegistryIndex, ioInput) // get default input
+		ls.Replace(1)                              // put it at index 1
+		toFile(ls, 1)                              // check that it's a valid file handle
+	} else { // open a new file
+		filename := ls.CheckString(1)
+		f, err := os.Open(filename)
+		if err != nil {
+			return ls.Error2("%s: %s", filename, pathError(err))
+		}
+		pushFile(ls, newLuaFile(f, false))
+		ls.Replace(1) // put file at index 1
+		toClose = true
+	}
+	auxLines(ls, toClose)
+	return 1
+}
+
+// file:lines (···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-file:lines
+func fLines(ls api.ILuaState) int {
+	toFile(ls, 1) // check that it's a valid file handle
+	auxLines(ls, false)
+	return 1
+}
+
+// pushes the iterator of lines, the upvalues are the file at index 1,
+// the number of formats, toClose and the formats after the file
+func auxLines(ls api.ILuaState, toClose bool) {
+	n := ls.GetTop() - 1 // number of arguments to read
+	ls.ArgCheck(n <= 250, 252, "too many arguments")
+	ls.PushValue(1) // file
+	ls.PushInteger(int64(n))
+	ls.PushBoolean(toClose)
+	ls.Rotate(2, 3) // move file, n and toClose to index 2
+	ls.PushGoClosure(ioReadLine, 3+n)
+}
+
+func ioReadLine(ls api.ILuaState) int {
+	lf := ls.ToUserData(api.LuaUpvalueIndex(1)).(*luaFile)
+	if lf.closed { // file is already closed?
+		return ls.Error2("file is already closed")
+	}
+	n := int(ls.ToInteger(api.LuaUpvalueIndex(2)))
+	ls.SetTop(1)
+	ls.CheckStack2(n, "too many arguments")
+	for i := 1; i <= n; i++ { // push arguments to 'gRead'
+		ls.PushValue(api.LuaUpvalueIndex(3 + i))
+	}
+	n = gRead(ls, lf, 2)  // 'n' is number of results
+	if ls.ToBoolean(-n) { // read at least one value?
+		return n // return them
+	}
+	// first result is nil: EOF or error
+	if n > 1 { // is there error information?
+		return ls.Error2("%s", ls.ToString(-n+1))
+	}
+	if ls.ToBoolean(api.LuaUpvalueIndex(3)) { // generator created file?
+		closeFile(lf)
+	}
+	return 0
+}
+
+// io.read (···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.read
+func ioRead(ls api.ILuaState) int {
+	return gRead(ls, getIOFile(ls, ioInput), 1)
+}
+
+// file:read (···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-file:read
+func fRead(ls api.ILuaState) int {
+	return gRead(ls, toFile(ls, 1), 2)
+}
+
+func gRead(ls api.ILuaState, lf *luaFile, first int) int {
+	if lf.r == nil {
+		return fileResult(ls, syscall.EBADF, "")
+	}
+	nargs := ls.GetTop() - 1
+	var err error
+	success := true
+	n := first
+	if nargs == 0 { // no arguments?
+		success, err = readLine(ls, lf, true)
+		n = first + 1 // to return 1 result
+	} else {
+		// ensure stack space for all results and for auxlib's buffer
+		ls.CheckStack2(nargs+20, "too many arguments")
+		for ; nargs > 0 && success; n++ {
+			nargs--
+			if ls.Type(n) == api.LuaTNumber {
+				l := ls.CheckInteger(n)
+				if l == 0 {
+					success, err = testEOF(ls, lf)
+				} else {
+					success, err = readChars(ls, lf, l)
+				}
+				continue
+			}
+			p := strings.TrimPrefix(ls.CheckString(n), "*") // skip optional '*' (for compatibility)
+			if p == "" {
+				return ls.ArgError(n, "invalid format")
+			}
+			switch p[0] {
+			case 'n': // number
+				success, err = readNumber(ls, lf)
+			case 'l': // line
+				success, err = readLine(ls, lf, true)
+			case 'L': // line with end-of-line
+				success, err = readLine(ls, lf, false)
+			case 'a': // file
+				err = readAll(ls, lf) // read entire file
+				success = true        // always success
+			default:
+				return ls.ArgError(n, "invalid format")
+			}
+		}
+	}
+	if err != nil && err != io.EOF {
+		return fileResult(ls, err, "")
+	}
+	if !success {
+		ls.Pop(1)    // remove last result
+		ls.PushNil() // push nil instead
+	}
+	return n - first
+}
+
+func testEOF(ls api.ILuaState, lf *luaFile) (bool, error) {
+	_, err := lf.r.Peek(1)
+	ls.PushString("")
+	return err == nil, err
+}
+
+func readLine(ls api.ILuaState, lf *luaFile, chop bool) (bool, error) {
+	line, err := lf.r.ReadString('\n')
+	if chop {
+		line = strings.TrimSuffix(line, "\n")
+	}
+	ls.PushString(line)
+	if err == io.EOF {
+		return len(line) > 0, err
+	}
+	return err == nil, err
+}
+
+func readChars(ls api.ILuaState, lf *luaFile, n int64) (bool, error) {
+	var b strings.Builder
+	_, err := io.CopyN(&b, lf.r, n)
+	ls.PushString(b.String())
+	return b.Len() > 0, err
+}
+
+func readAll(ls api.ILuaState, lf *luaFile) error {
+	var b strings.Builder
+	_, err := io.Copy(&b, lf.r)
+	ls.PushString(b.String())
+	return err
+}
+
+// reads a numeral like the scanner of Lua, at most 200 characters
+func readNumber(ls api.ILuaState, lf *luaFile) (bool, error) {
+	var b strings.Builder
+	next := func(set string) bool {
+		c, err := lf.r.ReadByte()
+		if err != nil {
+			return false
+		}
+		if b.Len() < 200 && strings.IndexByte(set, c) >= 0 {
+			b.WriteByte(c)
+			return true
+		}
+		lf.r.UnreadByte()
+		return false
+	}
+	digits := func(hex bool) int {
+		set := "0123456789"
+		if hex {
+			set += "abcdefABCDEF"
+		}
+		n := 0
+		for next(set) {
+			n++
+		}
+		return n
+	}
+
+	for next(" \t\n\v\f\r") { // skip spaces
+		b.Reset()
+	}
+	next("+-") // optional sign
+	hex, count := false, 0
+	if next("0") {
+		if next("xX") { // numeral is hexadecimal
+			hex = true
+		} else {
+			count = 1 // count initial '0' as a valid digit
+		}
+	}
+	count += digits(hex) // integral part
+	if next(".") {
+		count += digits(hex) // fractional part
+	}
+	if count > 0 && ((hex && next("pP")) || (!hex && next("eE"))) { // exponent mark?
+		next("+-") // exponent sign
+		digits(false)
+	}
+
+	s := b.String()
+	if i, ok := number.ParseInteger(s); ok {
+		ls.PushInteger(i)
+		return true, nil
+	}
+	if f, ok := number.ParseFloat(s); ok {
+		ls.PushNumber(f)
+		return true, nil
+	}
+	ls.PushNil()      // "result" to be removed
+	return false, nil // read fails
+}
+
+// io.write (···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.write
+func ioWrite(ls api.ILuaState) int {
+	return gWrite(ls, getIOFile(ls, ioOutput), 1)
+}
+
+// file:write (···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-file:write
+func fWrite(ls api.ILuaState) int {
+	lf := toFile(ls, 1)
+	ls.PushValue(1) // push file at the stack top (to be returned)
+	return gWrite(ls, lf, 2)
+}
+
+func gWrite(ls api.ILuaState, lf *luaFile, arg int) int {
+	if lf.w == nil {
+		return fileResult(ls, syscall.EBADF, "")
+	}
+	nargs := ls.GetTop() - arg // the file is on the top
+	for ; nargs > 0; nargs-- {
+		var s string
+		if ls.Type(arg) == api.LuaTNumber {
+			s = ls.ToStringMeta(arg)
+			ls.Pop(1)
+		} else {
+			s = ls.CheckString(arg)
+		}
+		if _, err := io.WriteString(lf.w, s); err != nil {
+			return fileResult(ls, err, "")
+		}
+		arg++
+	}
+	return 1 // file handle already on stack top
+}
+
+// file:seek ([whence [, offset]])
+// http://www.lua.org/manual/5.3/manual.html#pdf-file:seek
+func fSeek(ls api.ILuaState) int {
+	lf := toFile(ls, 1)
+	whence := ls.CheckOption(2, "cur", []string{"set", "cur", "end"})
+	offset := ls.OptInteger(3, 0)
+	s, ok := lf.f.(io.Seeker)
+	if !ok {
+		return fileResult(ls, syscall.ESPIPE, "")
+	}
+	if whence == io.SeekCurrent && lf.r != nil {
+		offset -= int64(lf.r.Buffered()) // bytes read ahead
+	}
+	pos, err := s.Seek(offset, whence)
+	if err != nil {
+		return fileResult(ls, err, "")
+	}
+	if lf.r != nil {
+		lf.r.Reset(lf.f.(io.Reader)) // discard the buffer
+	}
+	ls.PushInteger(pos)
+	return 1
+}
+
+// file:setvbuf (mode [, size])
+// http://www.lua.org/manual/5.3/manual.html#pdf-file:setvbuf
+func fSetvbuf(ls api.ILuaState) int {
+	toFile(ls, 1)
+	ls.CheckOption(2, "", []string{"no", "full", "line"})
+	// writes are not buffered
+	ls.PushBoolean(true)
+	return 1
+}
+
+// io.flush ()
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.flush
+func ioFlush(ls api.ILuaState) int {
+	return fileResult(ls, flush(getIOFile(ls, ioOutput)), "")
+}
+
+// file:flush ()
+// http://www.lua.org/manual/5.3/manual.html#pdf-file:flush
+func fFlush(ls api.ILuaState) int {
+	return fileResult(ls, flush(toFile(ls, 1)), "")
+}
+
+func flush(lf *luaFile) error {
+	if f, ok := lf.w.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// returns the message of err without the operation and path
+func pathError(err error) string {
+	switch e := err.(type) {
+	case *os.PathError:
+		return e.Err.Error()
+	case *os.LinkError:
+		return e.Err.Error()
+	}
+	return err.Error()
+}
+
+// returns the error number of err, 0 if it's unknown
+func errno(err error) int {
+	switch e := err.(type) {
+	case syscall.Errno:
+		return int(e)
+	case *os.PathError:
+		return errno(e.Err)
+	case *os.LinkError:
+		return errno(e.Err)
+	case *os.SyscallError:
+		return errno(e.Err)
+	}
+	return 0
+}
@@ -0,0 +1,688 @@
+package stdlib
+
+import (
+	"bytes"
+	"fmt"
+	"luago/api"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// metatable names of the tables encoded as arrays and objects
+const (
+	jsonArrayMeta  = "json.array"
+	jsonObjectMeta = "json.object"
+)
+
+// registry key of json.null
+const jsonNullKey = "json.null"
+
+// max nesting of arrays and objects
+const jsonMaxDepth = 1000
+
+// jsonNull is the data of json.null
+type jsonNull struct{}
+
+var jsonLib = api.FuncReg{
+	"encode": jsonEncode,
+	"decode": jsonDecode,
+	"array":  jsonArray,
+	"object": jsonObject,
+}
+
+// OpenJSONLib opens the json library, the metatables of arrays, objects
+// and json.null are protected, they're shared by all chunks of the state
+func OpenJSONLib(ls api.ILuaState) int {
+	ls.NewLib(jsonLib)
+	for _, mt := range [][2]string{{jsonArrayMeta, "array"}, {jsonObjectMeta, "object"}} {
+		ls.NewMetatable(mt[0])
+		ls.PushString(mt[1])
+		ls.SetField(-2, "__jsontype")
+		protectMetatable(ls)
+		ls.Pop(1)
+	}
+	ls.NewUserData(jsonNull{})
+	ls.CreateTable(0, 2)
+	ls.PushGoFunction(func(ls api.ILuaState) int {
+		ls.PushString("null")
+		return 1
+	})
+	ls.SetField(-2, "__tostring")
+	protectMetatable(ls)
+	ls.SetMetaTable(-2)
+	ls.PushValue(-1)
+	ls.SetField(api.LuaRegistryIndex, jsonNullKey)
+	ls.SetField(-2, "null")
+	return 1
+}
+
+// json.array ([t])
+// marks table t, a new table if it's absent, to be encoded as an array
+func jsonArray(ls api.ILuaState) int {
+	return jsonMark(ls, jsonArrayMeta)
+}
+
+// json.object ([t])
+// marks table t, a new table if it's absent, to be encoded as an object
+func jsonObject(ls api.ILuaState) int {
+	return jsonMark(ls, jsonObjectMeta)
+}
+
+func jsonMark(ls api.ILuaState, tname string) int {
+	if ls.IsNoneOrNil(1) {
+		ls.SetTop(0)
+		ls.NewTable()
+	} else {
+		ls.CheckType(1, api.LuaTTable)
+		ls.SetTop(1)
+	}
+	ls.GetField(api.LuaRegistryIndex, tname)
+	ls.SetMetaTable(1)
+	return 1
+}
+
+type jsonEncoder struct {
+	ls        api.ILuaState
+	buf       bytes.Buffer
+	indent    string // "" for compact
+	sortKeys  bool
+	nonFinite string // policy of NaN and infinities: "error", "null" or "string"
+	visiting  map[interface{}]bool
+}
+
+// json.encode (v [, options])
+// returns the JSON text of v, options is a table of fields:
+//
+//	pretty     whether to indent the text
+//	indent     the string of one level of indentation, two spaces by default
+//	sortkeys   whether to sort the keys of objects
+//	nonfinite  how NaN and infinities are encoded, "error" (default),
+//	           "null" or "string" ("NaN", "Infinity" and "-Infinity")
+//
+// a table is an array if its __jsontype metafield is "array" or its keys
+// are the positive integers up to its border, with holes of null less
+// than half; empty tables are objects, the keys of objects encoded as the
+// same name, like 1 and "1", are errors; json.null is encoded as null
+func jsonEncode(ls api.ILuaState) int {
+	ls.CheckAny(1)
+	e := &jsonEncoder{ls: ls, nonFinite: "error", visiting: map[interface{}]bool{}}
+	if !ls.IsNoneOrNil(2) {
+		ls.CheckType(2, api.LuaTTable)
+		if optField(ls, "pretty") {
+			e.indent = "  "
+			if ls.GetField(2, "indent") != api.LuaTNil {
+				e.indent = ls.CheckString(-1)
+			}
+			ls.Pop(1)
+		}
+		e.sortKeys = optField(ls, "sortkeys")
+		if ls.GetField(2, "nonfinite") != api.LuaTNil {
+			opts := []string{"error", "null", "string"}
+			e.nonFinite = opts[ls.CheckOption(-1, "", opts)]
+		}
+		ls.Pop(1)
+	}
+	ls.SetTop(1)
+	e.encode(1, 0)
+	ls.PushString(e.buf.String())
+	return 1
+}
+
+// returns the field name of options at index 2 as a boolean
+func optField(ls api.ILuaState, name string) bool {
+	ls.GetField(2, name)
+	b := ls.ToBoolean(-1)
+	ls.Pop(1)
+	return b
+}
+
+func (e *jsonEncoder) encode(idx, depth int) {
+	ls := e.ls
+	switch ls.Type(idx) {
+	case api.LuaTNil:
+		e.buf.WriteString("null")
+	case api.LuaTBoolean:
+		e.buf.WriteString(strconv.FormatBool(ls.ToBoolean(idx)))
+	case api.LuaTNumber:
+		if ls.IsInteger(idx) {
+			e.buf.WriteString(strconv.FormatInt(ls.ToInteger(idx), 10))
+		} else {
+			e.number(ls.ToNumber(idx))
+		}
+	case api.LuaTString:
+		e.string(ls.ToString(idx))
+	case api.LuaTTable:
+		e.table(idx, depth)
+	case api.LuaTUserData:
+		if _, ok := ls.ToUserData(idx).(jsonNull); ok {
+			e.buf.WriteString("null")
+			return
+		}
+		fallthrough
+	default:
+		ls.Error2("cannot encode %s value", ls.TypeName2(idx))
+	}
+}
+
+func (e *jsonEncoder) number(f float64) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		switch e.nonFinite {
+		case "null":
+			e.buf.WriteString("null")
+		case "string":
+			switch {
+			case math.IsNaN(f):
+				e.buf.WriteString(`"NaN"`)
+			case f > 0:
+				e.buf.WriteString(`"Infinity"`)
+			default:
+				e.buf.WriteString(`"-Infinity"`)
+			}
+		default:
+			e.ls.Error2("cannot encode %s", formatNonFinite(f))
+		}
+		return
+	}
+	e.buf.WriteString(strconv.FormatFloat(f, 'g', -1, 64))
+}
+
+func formatNonFinite(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case f > 0:
+		return "inf"
+	}
+	return "-inf"
+}
+
+func (e *jsonEncoder) string(s string) {
+	const hex = "0123456789abcdef"
+	buf := &e.buf
+	buf.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"', '\\':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		default:
+			if c < 0x20 || c == 0x7f {
+				buf.WriteString(`\u00`)
+				buf.WriteByte(hex[c>>4])
+				buf.WriteByte(hex[c&0xf])
+			} else {
+				buf.WriteByte(c)
+			}
+		}
+	}
+	buf.WriteByte('"')
+}
+
+func (e *jsonEncoder) newline(depth int) {
+	if e.indent != "" {
+		e.buf.WriteByte('\n')
+		e.buf.WriteString(strings.Repeat(e.indent, depth))
+	}
+}
+
+// jsonKey is a key of table encoded as the key of object
+type jsonKey struct {
+	name string      // the key of object
+	key  interface{} // the key of table, int64, float64 or string
+}
+
+func (e *jsonEncoder) table(idx, depth int) {
+	ls := e.ls
+	p := ls.ToPointer(idx)
+	if e.visiting[p] {
+		ls.Error2("cannot encode cyclic table")
+	}
+	if depth >= jsonMaxDepth || !ls.CheckStack(4) {
+		ls.Error2("cannot encode table nested too deep")
+	}
+	e.visiting[p] = true
+	defer delete(e.visiting, p)
+
+	if n, isArray := e.arrayLen(idx); isArray {
+		e.buf.WriteByte('[')
+		for i := int64(1); i <= n; i++ {
+			if i > 1 {
+				e.buf.WriteByte(',')
+			}
+			e.newline(depth + 1)
+			ls.RawGetI(idx, i)
+			e.encode(ls.GetTop(), depth+1)
+			ls.Pop(1)
+		}
+		if n > 0 {
+			e.newline(depth)
+		}
+		e.buf.WriteByte(']')
+		return
+	}
+
+	keys := e.objectKeys(idx)
+	e.buf.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			e.buf.WriteByte(',')
+		}
+		e.newline(depth + 1)
+		e.string(k.name)
+		e.buf.WriteByte(':')
+		if e.indent != "" {
+			e.buf.WriteByte(' ')
+		}
+		switch x := k.key.(type) {
+		case int64:
+			ls.PushInteger(x)
+		case float64:
+			ls.PushNumber(x)
+		case string:
+			ls.PushString(x)
+		}
+		ls.RawGet(idx)
+		e.encode(ls.GetTop(), depth+1)
+		ls.Pop(1)
+	}
+	if len(keys) > 0 {
+		e.newline(depth)
+	}
+	e.buf.WriteByte('}')
+}
+
+// returns the length of table at idx if it's encoded as an array
+func (e *jsonEncoder) arrayLen(idx int) (int64, bool) {
+	ls := e.ls
+	jsonType := ""
+	if ls.GetMetaField(idx, "__jsontype") != api.LuaTNil {
+		jsonType = ls.ToString(-1)
+		ls.Pop(1)
+	}
+	if jsonType == "object" {
+		return 0, false
+	}
+
+	border := int64(ls.RawLen(idx))
+	count, max := int64(0), int64(0)
+	ls.PushNil()
+	for ls.Next(idx) {
+		ls.Pop(1)
+		k, ok := ls.ToInteger(-1), ls.IsInteger(-1)
+		if !ok || k < 1 {
+			ls.Pop(1)
+			if jsonType == "array" {
+				ls.Error2("cannot encode array with non-positive-integer keys")
+			}
+			return 0, false
+		}
+		count++
+		if k > max {
+			max = k
+		}
+	}
+	switch {
+	case jsonType == "array":
+		return max, true
+	case count == 0:
+		return 0, false
+	case max == border && count == border:
+		return max, true
+	}
+	return max, max <= 2*count // not too sparse
+}
+
+// returns the keys of table at idx encoded as an object, the keys of
+// numbers and strings encoded as the same name, like 1 and "1", are errors
+func (e *jsonEncoder) objectKeys(idx int) []jsonKey {
+	ls := e.ls
+	var keys []jsonKey
+	names := map[string]bool{}
+	ls.PushNil()
+	for ls.Next(idx) {
+		ls.Pop(1)
+		var k jsonKey
+		switch ls.Type(-1) {
+		case api.LuaTString:
+			s := ls.ToString(-1)
+			k = jsonKey{s, s}
+		case api.LuaTNumber:
+			if ls.IsInteger(-1) {
+				n := ls.ToInteger(-1)
+				k = jsonKey{strconv.FormatInt(n, 10), n}
+			} else {
+				f := ls.ToNumber(-1)
+				k = jsonKey{strconv.FormatFloat(f, 'g', -1, 64), f}
+			}
+		default:
+			ls.Error2("cannot encode table key of type %s", ls.TypeName2(-1))
+		}
+		if names[k.name] {
+			ls.Error2("cannot encode duplicate key '%s'", k.name)
+		}
+		names[k.name] = true
+		keys = append(keys, k)
+	}
+	if e.sortKeys {
+		sort.Slice(keys, func(i, j int) bool { return keys[i].name < keys[j].name })
+	}
+	return keys
+}
+
+type jsonDecoder struct {
+	ls       api.ILuaState
+	s        string
+	pos      int
+	integers bool
+	null     int // index of the value of null
+	depth    int
+}
+
+// the error of decoding, recovered by json.decode
+type jsonError struct {
+	msg string
+	pos int
+}
+
+// json.decode (s [, options])
+// returns the value of JSON text s, or nil and the error message,
+// options is a table of fields:
+//
+//	null      the value of null, json.null by default
+//	integers  whether to decode the numbers which are integers as integers,
+//	          they're floats by default
+//
+// arrays and objects are decoded with the metatables of json.array
+// and json.object
+func jsonDecode(ls api.ILuaState) (n int) {
+	d := &jsonDecoder{ls: ls, s: ls.CheckString(1)}
+	if ls.IsNoneOrNil(2) {
+		ls.SetTop(1)
+		ls.NewTable()
+	} else {
+		ls.CheckType(2, api.LuaTTable)
+		ls.SetTop(2)
+	}
+	d.integers = optField(ls, "integers")
+	if ls.GetField(2, "null") == api.LuaTNil {
+		ls.Pop(1)
+		ls.GetField(api.LuaRegistryIndex, jsonNullKey)
+	}
+	d.null = ls.GetTop()
+
+	defer func() {
+		if r := recover(); r != nil {
+			err, ok := r.(jsonError)
+			if !ok {
+				panic(r)
+			}
+			ls.SetTop(d.null)
+			ls.PushNil()
+			line := 1 + strings.Count(d.s[:err.pos], "\n")
+			col := err.pos - strings.LastIndexByte(d.s[:err.pos], '\n')
+			ls.PushString(fmt.Sprintf("%s at line %d, column %d", err.msg, line, col))
+			n = 2
+		}
+	}()
+	d.skipSpace()
+	d.value()
+	if d.skipSpace(); d.pos < len(d.s) {
+		d.error("unexpected character after value")
+	}
+	return 1
+}
+
+func (d *jsonDecoder) error(format string, a ...interface{}) {
+	panic(jsonError{fmt.Sprintf(format, a...), d.pos})
+}
+
+func (d *jsonDecoder) skipSpace() {
+	for d.pos < len(d.s) {
+		switch d.s[d.pos] {
+		case ' ', '\t', '\n', '\r':
+			d.pos++
+		default:
+			return
+		}
+	}
+}
+
+// pushes the value at pos
+func (d *jsonDecoder) value() {
+	if d.pos >= len(d.s) {
+		d.error("unexpected end of text")
+	}
+	switch c := d.s[d.pos]; {
+	case c == '{':
+		d.object()
+	case c == '[':
+		d.array()
+	case c == '"':
+		d.ls.PushString(d.string())
+	case c == '-' || '0' <= c && c <= '9':
+		d.number()
+	case strings.HasPrefix(d.s[d.pos:], "true"):
+		d.pos += 4
+		d.ls.PushBoolean(true)
+	case strings.HasPrefix(d.s[d.pos:], "false"):
+		d.pos += 5
+		d.ls.PushBoolean(false)
+	case strings.HasPrefix(d.s[d.pos:], "null"):
+		d.pos += 4
+		d.ls.PushValue(d.null)
+	default:
+		d.error("unexpected character '%c'", c)
+	}
+}
+
+// enters an array or object
+func (d *jsonDecoder) enter() {
+	d.depth++
+	if d.depth > jsonMaxDepth || !d.ls.CheckStack(3) {
+		d.error("nesting too deep")
+	}
+	d.pos++ // skip '[' or '{'
+	d.skipSpace()
+}
+
+// ends the array or object with the closing character c,
+// returns false if there are more elements
+func (d *jsonDecoder) next(c byte) bool {
+	d.skipSpace()
+	if d.pos < len(d.s) {
+		switch d.s[d.pos] {
+		case c:
+			d.pos++
+			d.depth--
+			return true
+		case ',':
+			d.pos++
+			d.skipSpace()
+			return false
+		}
+	}
+	d.error("',' or '%c' expected", c)
+	return false
+}
+
+func (d *jsonDecoder) array() {
+	ls := d.ls
+	d.enter()
+	ls.NewTable()
+	ls.GetField(api.LuaRegistryIndex, jsonArrayMeta)
+	ls.SetMetaTable(-2)
+	if d.pos < len(d.s) && d.s[d.pos] == ']' {
+		d.pos++
+		d.depth--
+		return
+	}
+	for i := int64(1); ; i++ {
+		d.value()
+		ls.RawSetI(-2, i)
+		if d.next(']') {
+			return
+		}
+	}
+}
+
+func (d *jsonDecoder) object() {
+	ls := d.ls
+	d.enter()
+	ls.NewTable()
+	ls.GetField(api.LuaRegistryIndex, jsonObjectMeta)
+	ls.SetMetaTable(-2)
+	if d.pos < len(d.s) && d.s[d.pos] == '}' {
+		d.pos++
+		d.depth--
+		return
+	}
+	for {
+		if d.pos >= len(d.s) || d.s[d.pos] != '"' {
+			d.error("string key expected")
+		}
+		ls.PushString(d.string())
+		d.skipSpace()
+		if d.pos >= len(d.s) || d.s[d.pos] != ':' {
+			d.error("':' expected")
+		}
+		d.pos++
+		d.skipSpace()
+		d.value()
+		ls.RawSet(-3)
+		if d.next('}') {
+			return
+		}
+	}
+}
+
+// returns the string at pos
+func (d *jsonDecoder) string() string {
+	start := d.pos
+	d.pos++ // skip '"'
+	var buf []byte
+	for {
+		if d.pos >= len(d.s) {
+			d.pos = start
+			d.error("unfinished string")
+		}
+		c := d.s[d.pos]
+		switch {
+		case c == '"':
+			d.pos++
+			return string(buf)
+		case c < 0x20:
+			d.error("control character in string")
+		case c != '\\':
+			buf = append(buf, c)
+			d.pos++
+			continue
+		}
+		if d.pos+1 >= len(d.s) {
+			d.error("unfinished string")
+		}
+		d.pos++
+		switch e := d.s[d.pos]; e {
+		case '"', '\\', '/':
+			buf = append(buf, e)
+		case 'b':
+			buf = append(buf, '\b')
+		case 'f':
+			buf = append(buf, '\f')
+		case 'n':
+			buf = append(buf, '\n')
+		case 'r':
+			buf = append(buf, '\r')
+		case 't':
+			buf = append(buf, '\t')
+		case 'u':
+			r := d.hex4()
+			if utf16.IsSurrogate(r) {
+				r2 := rune(-1)
+				if strings.HasPrefix(d.s[d.pos+1:], `\u`) {
+					d.pos += 2
+					r2 = d.hex4()
+				}
+				r = utf16.DecodeRune(r, r2)
+			}
+			buf = utf8.AppendRune(buf, r)
+		default:
+			d.error("invalid escape '\\%c'", e)
+		}
+		d.pos++
+	}
+}
+
+// returns the 4 hex digits after pos, pos is at the last one after that
+func (d *jsonDecoder) hex4() rune {
+	if d.pos+4 >= len(d.s) {
+		d.error("invalid unicode escape")
+	}
+	n, err := strconv.ParseUint(d.s[d.pos+1:d.pos+5], 16, 32)
+	if err != nil {
+		d.error("invalid unicode escape")
+	}
+	d.pos += 4
+	return rune(n)
+}
+
+// pushes the number at pos
+func (d *jsonDecoder) number() {
+	start := d.pos
+	isFloat := false
+	if d.s[d.pos] == '-' {
+		d.pos++
+	}
+	digits := func() int {
+		n := 0
+		for d.pos < len(d.s) && '0' <= d.s[d.pos] && d.s[d.pos] <= '9' {
+			d.pos++
+			n++
+		}
+		return n
+	}
+	if n := digits(); n == 0 || n > 1 && d.s[d.pos-n] == '0' {
+		d.pos = start
+		d.error("invalid number")
+	}
+	if d.pos < len(d.s) && d.s[d.pos] == '.' {
+		d.pos++
+		isFloat = true
+		if digits() == 0 {
+			d.error("invalid number")
+		}
+	}
+	if d.pos < len(d.s) && (d.s[d.pos] == 'e' || d.s[d.pos] == 'E') {
+		d.pos++
+		isFloat = true
+		if d.pos < len(d.s) && (d.s[d.pos] == '+' || d.s[d.pos] == '-') {
+			d.pos++
+		}
+		if digits() == 0 {
+			d.error("invalid number")
+		}
+	}
+
+	text := d.s[start:d.pos]
+	if d.integers && !isFloat {
+		if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+			d.ls.PushInteger(i)
+			return
+		}
+	}
+	f, _ := strconv.ParseFloat(text, 64) // out of range becomes infinity
+	if d.integers && f == math.Trunc(f) && f >= -(1<<63) && f < 1<<63 {
+		d.ls.PushInteger(int64(f))
+		return
+	}
+	d.ls.PushNumber(f)
+}
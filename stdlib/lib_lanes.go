@@ -0,0 +1,465 @@
+package stdlib
+
+import (
+	"fmt"
+	"luago/api"
+	"luago/state"
+	"reflect"
+	"sync/atomic"
+	"time"
+)
+
+// metatable names of lane handles and channels
+const (
+	laneHandle    = "lanes.lane"
+	channelHandle = "lanes.channel"
+)
+
+// registry key of the lane running in the state
+const laneKey = "_LANE"
+
+var lanesLib api.FuncReg
+
+func init() { // set here as spawn opens the libraries, including this one
+	lanesLib = api.FuncReg{
+		"spawn":   lanesSpawn,
+		"channel": lanesChannel,
+		"select":  lanesSelect,
+	}
+}
+
+var laneMethods = api.FuncReg{
+	"join":   laneJoin,
+	"status": laneStatus,
+	"cancel": laneCancel,
+}
+
+var channelMethods = api.FuncReg{
+	"send":    chanSend,
+	"receive": chanReceive,
+	"close":   chanClose,
+}
+
+// OpenLanesLib opens the lanes library, which runs Lua functions in new
+// states on goroutines of their own, the states pass values by channels
+func OpenLanesLib(ls api.ILuaState) int {
+	ls.NewLib(lanesLib)
+	createMetatable2(ls, laneHandle, laneMethods)
+	createMetatable2(ls, channelHandle, channelMethods)
+	return 1
+}
+
+// creates the protected metatable tname whose __index is methods if it
+// doesn't exist
+func createMetatable2(ls api.ILuaState, tname string, methods api.FuncReg) {
+	if ls.NewMetatable(tname) {
+		ls.CreateTable(0, len(methods))
+		ls.SetFuncs(methods, 0)
+		ls.SetField(-2, "__index")
+		protectMetatable(ls)
+	}
+	ls.Pop(1)
+}
+
+// lane is a function running in a new state
+type lane struct {
+	ls        api.ILuaState
+	done      chan struct{} // closed when the function returns
+	cancel    chan struct{} // closed by cancel
+	cancelled int32         // set by cancel, accessed atomically
+	ok        bool          // the function returned without error
+	results   []interface{} // results or the error object, packed
+}
+
+// channel is a channel between states, its values are packed
+type channel struct {
+	c     chan interface{}
+	vtype api.LuaType // type of values, api.LuaTNone if they're of any type
+}
+
+// the packed table, the pairs of keys and values
+type packedTable []interface{}
+
+// lanes.spawn (f, ...)
+// runs f(...) in a new state, f must be a Lua function without upvalues
+// other than _ENV, the arguments are copied, see pack. The new state has
+// the prototype of f itself instead of a dump of it, prototypes are never
+// changed after compiling, so states share them like state.ProtoCache
+func lanesSpawn(ls api.ILuaState) int {
+	proto := ls.ToProto(1)
+	if proto == nil {
+		return ls.ArgError(1, "Lua function expected")
+	}
+	for i, name := range proto.UpvalueNames {
+		if i > 0 || name != "_ENV" {
+			return ls.ArgError(1, fmt.Sprintf("function has upvalue '%s'", name))
+		}
+	}
+	nArgs := ls.GetTop() - 1
+	args := make([]interface{}, nArgs)
+	for i := range args {
+		args[i] = checkPack(ls, i+2)
+	}
+
+	l := &lane{done: make(chan struct{}), cancel: make(chan struct{})}
+	l.ls = newLaneState(ls)
+	l.ls.NewUserData(l)
+	l.ls.SetField(api.LuaRegistryIndex, laneKey)
+	l.ls.PushProto(proto)
+	for _, arg := range args {
+		unpack(l.ls, arg)
+	}
+	go l.run(nArgs)
+
+	ls.NewUserData(l)
+	ls.GetField(api.LuaRegistryIndex, laneHandle)
+	ls.SetMetaTable(-2)
+	return 1
+}
+
+// returns a new state sharing the standard files of ls with the
+// libraries opened in ls by OpenLib, OpenLibs and OpenSafeLibs, so a lane
+// of a sandbox stays in it
+func newLaneState(ls api.ILuaState) api.ILuaState {
+	ls2 := state.NewLuaState()
+	SetStdio(ls2, stdin(ls), stdout(ls), stderr(ls))
+	openLibsOf(ls2, ls)
+	return ls2
+}
+
+func (l *lane) run(nArgs int) {
+	defer close(l.done)
+	ls := l.ls
+	l.ok = ls.PCall(nArgs, -1, 0) == api.LuaOk
+	if !l.ok {
+		l.results = []interface{}{errorValue(ls, -1)}
+		return
+	}
+	l.results = make([]interface{}, ls.GetTop())
+	for i := range l.results {
+		v, err := pack(ls, i+1, map[interface{}]bool{})
+		if err != nil {
+			l.ok, l.results = false, []interface{}{err.Error()}
+			return
+		}
+		l.results[i] = v
+	}
+}
+
+// packs the error object at idx, as its string if it can't be packed
+func errorValue(ls api.ILuaState, idx int) interface{} {
+	if v, err := pack(ls, idx, map[interface{}]bool{}); err == nil {
+		return v
+	}
+	s := ls.ToStringMeta(idx)
+	ls.Pop(1)
+	return s
+}
+
+// lane:join ([timeout])
+// waits for the lane for up to timeout seconds, forever if timeout is
+// absent, returns true and the results of function, or false and the
+// error, or nil and "timeout"
+func laneJoin(ls api.ILuaState) int {
+	l := ls.CheckUData(1, laneHandle).(*lane)
+	select {
+	case <-l.done:
+	case <-after(ls, 2):
+		ls.PushNil()
+		ls.PushString("timeout")
+		return 2
+	}
+	ls.PushBoolean(l.ok)
+	ls.CheckStack2(len(l.results), "too many results")
+	for _, v := range l.results {
+		unpack(ls, v)
+	}
+	return 1 + len(l.results)
+}
+
+// lane:status ()
+// returns "running", "done", "error" or "cancelled"
+func laneStatus(ls api.ILuaState) int {
+	l := ls.CheckUData(1, laneHandle).(*lane)
+	select {
+	case <-l.done:
+		switch {
+		case l.ok:
+			ls.PushString("done")
+		case atomic.LoadInt32(&l.cancelled) != 0:
+			ls.PushString("cancelled")
+		default:
+			ls.PushString("error")
+		}
+	default:
+		ls.PushString("running")
+	}
+	return 1
+}
+
+// lane:cancel ()
+// interrupts the running lane, the waiting operations of channels in
+// the lane fail at once
+func laneCancel(ls api.ILuaState) int {
+	l := ls.CheckUData(1, laneHandle).(*lane)
+	if atomic.CompareAndSwapInt32(&l.cancelled, 0, 1) {
+		close(l.cancel)
+		l.ls.Interrupt()
+	}
+	return 0
+}
+
+// lanes.channel ([capacity [, type]])
+// returns a new channel, type is the name of type of values, like "number"
+func lanesChannel(ls api.ILuaState) int {
+	n := ls.OptInteger(1, 0)
+	ls.ArgCheck(n >= 0, 1, "invalid capacity")
+	ch := &channel{c: make(chan interface{}, n), vtype: api.LuaTNone}
+	if !ls.IsNoneOrNil(2) {
+		name := ls.CheckString(2)
+		for t := api.LuaTNil; t <= api.LuaTThread; t++ {
+			if ls.TypeName(t) == name {
+				ch.vtype = t
+			}
+		}
+		ls.ArgCheck(ch.vtype != api.LuaTNone, 2, "invalid type name '"+name+"'")
+	}
+	pushChannel(ls, ch)
+	return 1
+}
+
+// pushes the channel ch, the states passing channels have lanes library opened
+func pushChannel(ls api.ILuaState, ch *channel) {
+	ls.NewUserData(ch)
+	ls.GetField(api.LuaRegistryIndex, channelHandle)
+	ls.SetMetaTable(-2)
+}
+
+func checkChannel(ls api.ILuaState, arg int) *channel {
+	return ls.CheckUData(arg, channelHandle).(*channel)
+}
+
+// ch:send (v [, timeout])
+// sends v to the channel, waits for up to timeout seconds, forever if it's
+// absent, returns true, or false and "timeout" or "closed"
+func chanSend(ls api.ILuaState) int {
+	ch := checkChannel(ls, 1)
+	if ch.vtype != api.LuaTNone && ls.Type(2) != ch.vtype {
+		return ls.ArgError(2, fmt.Sprintf("%s expected, got %s",
+			ls.TypeName(ch.vtype), ls.TypeName2(2)))
+	}
+	v := checkPack(ls, 2)
+	reason := func() (reason string) {
+		defer func() {
+			if recover() != nil {
+				reason = "closed" // send on closed channel
+			}
+		}()
+		select {
+		case ch.c <- v:
+			return ""
+		case <-after(ls, 3):
+			return "timeout"
+		case <-cancelled(ls):
+			return "cancelled"
+		}
+	}()
+	return pushStatus(ls, reason)
+}
+
+// pushes true if reason is empty, or false and reason,
+// raises error if the lane is cancelled
+func pushStatus(ls api.ILuaState, reason string) int {
+	switch reason {
+	case "":
+		ls.PushBoolean(true)
+		return 1
+	case "cancelled":
+		ls.PushString("interrupted")
+		return ls.Error()
+	}
+	ls.PushBoolean(false)
+	ls.PushString(reason)
+	return 2
+}
+
+// ch:receive ([timeout])
+// receives a value from the channel, waits for up to timeout seconds,
+// forever if it's absent, returns true and the value, or false and
+// "timeout" or "closed"
+func chanReceive(ls api.ILuaState) int {
+	ch := checkChannel(ls, 1)
+	select {
+	case v, ok := <-ch.c:
+		if !ok {
+			return pushStatus(ls, "closed")
+		}
+		ls.PushBoolean(true)
+		unpack(ls, v)
+		return 2
+	case <-after(ls, 2):
+		return pushStatus(ls, "timeout")
+	case <-cancelled(ls):
+		return pushStatus(ls, "cancelled")
+	}
+}
+
+// ch:close ()
+func chanClose(ls api.ILuaState) int {
+	ch := checkChannel(ls, 1)
+	func() {
+		defer func() { recover() }() // already closed
+		close(ch.c)
+	}()
+	return 0
+}
+
+// lanes.select (channels [, timeout])
+// receives a value from the first ready one of the sequence of channels,
+// waits for up to timeout seconds, forever if it's absent, returns the
+// channel, true and the value, or the channel, false and "closed",
+// or nil, false and "timeout"
+func lanesSelect(ls api.ILuaState) int {
+	ls.CheckType(1, api.LuaTTable)
+	n := int(ls.RawLen(1))
+	cases := make([]reflect.SelectCase, n, n+2)
+	for i := range cases {
+		ls.RawGetI(1, int64(i+1))
+		ch, ok := ls.TestUData(-1, channelHandle).(*channel)
+		if !ok {
+			return ls.Error2("bad channel #%d in channels", i+1)
+		}
+		ls.Pop(1)
+		cases[i] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ch.c)}
+	}
+	cases = append(cases,
+		reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(after(ls, 2))},
+		reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(cancelled(ls))})
+
+	chosen, v, ok := reflect.Select(cases)
+	switch chosen {
+	case n:
+		ls.PushNil()
+		pushStatus(ls, "timeout")
+	case n + 1:
+		return pushStatus(ls, "cancelled")
+	default:
+		ls.RawGetI(1, int64(chosen+1))
+		if !ok {
+			pushStatus(ls, "closed")
+		} else {
+			ls.PushBoolean(true)
+			unpack(ls, v.Interface())
+		}
+	}
+	return 3
+}
+
+// returns the channel of timeout at arg in seconds, nil if it's absent
+func after(ls api.ILuaState, arg int) <-chan time.Time {
+	if ls.IsNoneOrNil(arg) {
+		return nil
+	}
+	sec := ls.CheckNumber(arg)
+	return time.After(time.Duration(sec * float64(time.Second)))
+}
+
+// returns the channel closed when the lane running in ls is cancelled,
+// nil if ls doesn't run a lane
+func cancelled(ls api.ILuaState) <-chan struct{} {
+	ls.GetField(api.LuaRegistryIndex, laneKey)
+	l, _ := ls.ToUserData(-1).(*lane)
+	ls.Pop(1)
+	if l == nil {
+		return nil
+	}
+	return l.cancel
+}
+
+// packs the value at arg to be passed to other states, raises error
+// if it can't be passed
+func checkPack(ls api.ILuaState, arg int) interface{} {
+	v, err := pack(ls, arg, map[interface{}]bool{})
+	if err != nil {
+		ls.ArgError(arg, err.Error())
+	}
+	return v
+}
+
+// packs the value at idx, which is nil, boolean, number, string, channel
+// or table of them, metatables are dropped
+func pack(ls api.ILuaState, idx int, visiting map[interface{}]bool) (interface{}, error) {
+	switch ls.Type(idx) {
+	case api.LuaTNil, api.LuaTNone:
+		return nil, nil
+	case api.LuaTBoolean:
+		return ls.ToBoolean(idx), nil
+	case api.LuaTNumber:
+		if ls.IsInteger(idx) {
+			return ls.ToInteger(idx), nil
+		}
+		return ls.ToNumber(idx), nil
+	case api.LuaTString:
+		return ls.ToString(idx), nil
+	case api.LuaTUserData:
+		if ch, ok := ls.TestUData(idx, channelHandle).(*channel); ok {
+			return ch, nil
+		}
+	case api.LuaTTable:
+		idx = ls.AbsIndex(idx)
+		p := ls.ToPointer(idx)
+		if visiting[p] {
+			return nil, fmt.Errorf("cannot pass cyclic table")
+		}
+		if !ls.CheckStack(3) {
+			return nil, fmt.Errorf("table nesting too deep")
+		}
+		visiting[p] = true
+		defer delete(visiting, p)
+
+		var t packedTable
+		ls.PushNil()
+		for ls.Next(idx) {
+			k, err := pack(ls, -2, visiting)
+			if err == nil {
+				var v interface{}
+				if v, err = pack(ls, -1, visiting); err == nil {
+					t = append(t, k, v)
+				}
+			}
+			if err != nil {
+				ls.Pop(2)
+				return nil, err
+			}
+			ls.Pop(1)
+		}
+		return t, nil
+	}
+	return nil, fmt.Errorf("cannot pass %s value", ls.TypeName2(idx))
+}
+
+// pushes the packed value v
+func unpack(ls api.ILuaState, v interface{}) {
+	switch x := v.(type) {
+	case nil:
+		ls.PushNil()
+	case bool:
+		ls.PushBoolean(x)
+	case int64:
+		ls.PushInteger(x)
+	case float64:
+		ls.PushNumber(x)
+	case string:
+		ls.PushString(x)
+	case *channel:
+		pushChannel(ls, x)
+	case packedTable:
+		ls.CheckStack2(3, "table nesting too deep")
+		ls.CreateTable(0, len(x)/2)
+		for i := 0; i < len(x); i += 2 {
+			unpack(ls, x[i])
+			unpack(ls, x[i+1])
+			ls.RawSet(-3)
+		}
+	}
+}
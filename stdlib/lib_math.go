@@ -0,0 +1,326 @@
+package stdlib
+
+import (
+	"luago/api"
+	"luago/number"
+	"math"
+	"math/rand"
+	"time"
+)
+
+var mathLib = api.FuncReg{
+	"abs":        mathAbs,
+	"ceil":       mathCeil,
+	"floor":      mathFloor,
+	"fmod":       mathFmod,
+	"modf":       mathModf,
+	"sqrt":       mathSqrt,
+	"exp":        mathExp,
+	"log":        mathLog,
+	"sin":        mathSin,
+	"cos":        mathCos,
+	"tan":        mathTan,
+	"asin":       mathAsin,
+	"acos":       mathAcos,
+	"atan":       mathAtan,
+	"tointeger":  mathToInt,
+	"type":       mathType,
+	"ult":        mathUlt,
+	"max":        mathMax,
+	"min":        mathMin,
+	"random":     mathRandom,
+	"randomseed": mathRandomSeed,
+}
+
+// OpenMathLib opens the math library, each state has its own
+// random generator
+func OpenMathLib(ls api.ILuaState) int {
+	ls.CreateTable(0, len(mathLib)+4)
+	ls.NewUserData(rand.New(rand.NewSource(time.Now().UnixNano())))
+	ls.SetFuncs(mathLib, 1) // the generator is the upvalue of functions
+	ls.PushNumber(math.Pi)
+	ls.SetField(-2, "pi")
+	ls.PushNumber(math.Inf(1))
+	ls.SetField(-2, "huge")
+	ls.PushInteger(math.MaxInt64)
+	ls.SetField(-2, "maxinteger")
+	ls.PushInteger(math.MinInt64)
+	ls.SetField(-2, "mininteger")
+	return 1
+}
+
+// math.abs (x)
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.abs
+func mathAbs(ls api.ILuaState) int {
+	if ls.IsInteger(1) {
+		if x := ls.ToInteger(1); x < 0 {
+			ls.PushInteger(-x)
+		} else {
+			ls.PushInteger(x)
+		}
+	} else {
+		ls.PushNumber(math.Abs(ls.CheckNumber(1)))
+	}
+	return 1
+}
+
+// pushes f as an integer if it fits, or as a float
+func pushNumInt(ls api.ILuaState, f float64) {
+	if i, ok := number.FloatToInteger(f); ok &&
+		f >= math.MinInt64 && f < -float64(math.MinInt64) {
+		ls.PushInteger(i)
+	} else {
+		ls.PushNumber(f)
+	}
+}
+
+// math.ceil (x)
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.ceil
+func mathCeil(ls api.ILuaState) int {
+	if ls.IsInteger(1) {
+		ls.SetTop(1) // integer is its own ceil
+	} else {
+		pushNumInt(ls, math.Ceil(ls.CheckNumber(1)))
+	}
+	return 1
+}
+
+// math.floor (x)
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.floor
+func mathFloor(ls api.ILuaState) int {
+	if ls.IsInteger(1) {
+		ls.SetTop(1) // integer is its own floor
+	} else {
+		pushNumInt(ls, math.Floor(ls.CheckNumber(1)))
+	}
+	return 1
+}
+
+// math.fmod (x, y)
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.fmod
+func mathFmod(ls api.ILuaState) int {
+	if ls.IsInteger(1) && ls.IsInteger(2) {
+		d := ls.ToInteger(2)
+		if uint64(d)+1 <= 1 { // special cases: -1 or 0
+			if d == 0 {
+				return ls.ArgError(2, "zero")
+			}
+			ls.PushInteger(0) // avoid overflow with 0x80000... / -1
+		} else {
+			ls.PushInteger(ls.ToInteger(1) % d)
+		}
+	} else {
+		ls.PushNumber(math.Mod(ls.CheckNumber(1), ls.CheckNumber(2)))
+	}
+	return 1
+}
+
+// math.modf (x)
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.modf
+func mathModf(ls api.ILuaState) int {
+	if ls.IsInteger(1) {
+		ls.SetTop(1)     // number is its own integer part
+		ls.PushNumber(0) // no fractional part
+	} else {
+		x := ls.CheckNumber(1)
+		ip := math.Trunc(x) // integer part (rounds toward zero)
+		ls.PushNumber(ip)
+		if x == ip { // fractional part (test needed for inf/-inf)
+			ls.PushNumber(0)
+		} else {
+			ls.PushNumber(x - ip)
+		}
+	}
+	return 2
+}
+
+// math.sqrt (x)
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.sqrt
+func mathSqrt(ls api.ILuaState) int {
+	ls.PushNumber(math.Sqrt(ls.CheckNumber(1)))
+	return 1
+}
+
+// math.exp (x)
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.exp
+func mathExp(ls api.ILuaState) int {
+	ls.PushNumber(math.Exp(ls.CheckNumber(1)))
+	return 1
+}
+
+// math.log (x [, base])
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.log
+func mathLog(ls api.ILuaState) int {
+	x := ls.CheckNumber(1)
+	var res float64
+	if ls.IsNoneOrNil(2) {
+		res = math.Log(x)
+	} else {
+		switch base := ls.CheckNumber(2); base {
+		case 2:
+			res = math.Log2(x)
+		case 10:
+			res = math.Log10(x)
+		default:
+			res = math.Log(x) / math.Log(base)
+		}
+	}
+	ls.PushNumber(res)
+	return 1
+}
+
+// math.sin (x)
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.sin
+func mathSin(ls api.ILuaState) int {
+	ls.PushNumber(math.Sin(ls.CheckNumber(1)))
+	return 1
+}
+
+// math.cos (x)
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.cos
+func mathCos(ls api.ILuaState) int {
+	ls.PushNumber(math.Cos(ls.CheckNumber(1)))
+	return 1
+}
+
+// math.tan (x)
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.tan
+func mathTan(ls api.ILuaState) int {
+	ls.PushNumber(math.Tan(ls.CheckNumber(1)))
+	return 1
+}
+
+// math.asin (x)
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.asin
+func mathAsin(ls api.ILuaState) int {
+	ls.PushNumber(math.Asin(ls.CheckNumber(1)))
+	return 1
+}
+
+// math.acos (x)
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.acos
+func mathAcos(ls api.ILuaState) int {
+	ls.PushNumber(math.Acos(ls.CheckNumber(1)))
+	return 1
+}
+
+// math.atan (y [, x])
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.atan
+func mathAtan(ls api.ILuaState) int {
+	y := ls.CheckNumber(1)
+	x := ls.OptNumber(2, 1.0)
+	ls.PushNumber(math.Atan2(y, x))
+	return 1
+}
+
+// math.tointeger (x)
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.tointeger
+func mathToInt(ls api.ILuaState) int {
+	if i, ok := ls.ToIntegerX(1); ok && ls.Type(1) == api.LuaTNumber {
+		ls.PushInteger(i)
+	} else {
+		ls.CheckAny(1)
+		ls.PushNil() // value is not convertible to integer
+	}
+	return 1
+}
+
+// math.type (x)
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.type
+func mathType(ls api.ILuaState) int {
+	if ls.Type(1) == api.LuaTNumber {
+		if ls.IsInteger(1) {
+			ls.PushString("integer")
+		} else {
+			ls.PushString("float")
+		}
+	} else {
+		ls.CheckAny(1)
+		ls.PushNil()
+	}
+	return 1
+}
+
+// math.ult (m, n)
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.ult
+func mathUlt(ls api.ILuaState) int {
+	m := ls.CheckInteger(1)
+	n := ls.CheckInteger(2)
+	ls.PushBoolean(uint64(m) < uint64(n))
+	return 1
+}
+
+// math.max (x, ···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.max
+func mathMax(ls api.ILuaState) int {
+	n := ls.GetTop() // number of arguments
+	imax := 1        // index of current maximum value
+	ls.ArgCheck(n >= 1, 1, "number expected")
+	for i := 1; i <= n; i++ {
+		ls.CheckNumber(i)
+		if ls.Compare(imax, i, api.LuaOpLt) {
+			imax = i
+		}
+	}
+	ls.PushValue(imax)
+	return 1
+}
+
+// math.min (x, ···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.min
+func mathMin(ls api.ILuaState) int {
+	n := ls.GetTop() // number of arguments
+	imin := 1        // index of current minimum value
+	ls.ArgCheck(n >= 1, 1, "number expected")
+	for i := 1; i <= n; i++ {
+		ls.CheckNumber(i)
+		if ls.Compare(i, imin, api.LuaOpLt) {
+			imin = i
+		}
+	}
+	ls.PushValue(imin)
+	return 1
+}
+
+// math.random ([m [, n]])
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.random
+func mathRandom(ls api.ILuaState) int {
+	r := randGen(ls)
+	var low, up int64
+	switch ls.GetTop() { // check number of arguments
+	case 0: // no arguments
+		ls.PushNumber(r.Float64()) // Number between 0 and 1
+		return 1
+	case 1: // only upper limit
+		low = 1
+		up = ls.CheckInteger(1)
+	case 2: // lower and upper limits
+		low = ls.CheckInteger(1)
+		up = ls.CheckInteger(2)
+	default:
+		return ls.Error2("wrong number of arguments")
+	}
+
+	// random integer in the interval [low, up]
+	ls.ArgCheck(low <= up, 1, "interval is empty")
+	ls.ArgCheck(low >= 0 || up <= math.MaxInt64+low, 1,
+		"interval too large")
+	if up-low == math.MaxInt64 {
+		ls.PushInteger(low + r.Int63())
+	} else {
+		ls.PushInteger(low + r.Int63n(up-low+1))
+	}
+	return 1
+}
+
+// math.randomseed (x)
+// http://www.lua.org/manual/5.3/manual.html#pdf-math.randomseed
+func mathRandomSeed(ls api.ILuaState) int {
+	x := ls.CheckNumber(1)
+	randGen(ls).Seed(int64(x))
+	return 0
+}
+
+func randGen(ls api.ILuaState) *rand.Rand {
+	return ls.ToUserData(api.LuaUpvalueIndex(1)).(*rand.Rand)
+}
@@ -0,0 +1,333 @@
+package stdlib
+
+import (
+	"fmt"
+	"io/ioutil"
+	"luago/api"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+var sysLib = api.FuncReg{
+	"clock":     osClock,
+	"date":      osDate,
+	"difftime":  osDiffTime,
+	"execute":   osExecute,
+	"exit":      osExit,
+	"getenv":    osGetEnv,
+	"remove":    osRemove,
+	"rename":    osRename,
+	"setlocale": osSetLocale,
+	"time":      osTime,
+	"tmpname":   osTmpName,
+}
+
+// OpenOSLib opens the os library
+func OpenOSLib(ls api.ILuaState) int {
+	ls.NewLib(sysLib)
+	return 1
+}
+
+var startTime = time.Now()
+
+// os.clock ()
+// http://www.lua.org/manual/5.3/manual.html#pdf-os.clock
+func osClock(ls api.ILuaState) int {
+	ls.PushNumber(time.Since(startTime).Seconds())
+	return 1
+}
+
+// os.difftime (t2, t1)
+// http://www.lua.org/manual/5.3/manual.html#pdf-os.difftime
+func osDiffTime(ls api.ILuaState) int {
+	t2 := ls.CheckInteger(1)
+	t1 := ls.OptInteger(2, 0)
+	ls.PushNumber(float64(t2 - t1))
+	return 1
+}
+
+// os.time ([table])
+// http://www.lua.org/manual/5.3/manual.html#pdf-os.time
+func osTime(ls api.ILuaState) int {
+	if ls.IsNoneOrNil(1) { // called without args?
+		ls.PushInteger(time.Now().Unix()) // get current time
+		return 1
+	}
+
+	ls.CheckType(1, api.LuaTTable)
+	ls.SetTop(1) // make sure table is at the top
+	sec := getField(ls, "sec", 0, 0)
+	min := getField(ls, "min", 0, 0)
+	hour := getField(ls, "hour", 12, 0)
+	day := getField(ls, "day", -1, 0)
+	month := getField(ls, "month", -1, 1)
+	year := getField(ls, "year", -1, 1900)
+	// normalizes the fields like mktime
+	t := time.Date(year, time.Month(month), day, hour, min, sec, 0, time.Local)
+	ls.PushInteger(t.Unix())
+	return 1
+}
+
+// gets the integer field key of the table on the top,
+// d < 0 means the field is required, delta is the offset of C struct tm
+func getField(ls api.ILuaState, key string, d, delta int) int {
+	t := ls.GetField(-1, key)
+	res, isNum := ls.ToIntegerX(-1)
+	if !isNum { // field is not an integer?
+		if t != api.LuaTNil { // some other value?
+			ls.Error2("field '%s' is not an integer", key)
+		} else if d < 0 { // absent field; no default?
+			ls.Error2("field '%s' missing in date table", key)
+		}
+		res = int64(d)
+	} else {
+		if !(res >= 0 && res-int64(delta) <= 1<<31-1 ||
+			res < 0 && int64(-1<<31)+int64(delta) <= res) {
+			ls.Error2("field '%s' is out-of-bound", key)
+		}
+	}
+	ls.Pop(1)
+	return int(res)
+}
+
+// os.date ([format [, time]])
+// http://www.lua.org/manual/5.3/manual.html#pdf-os.date
+func osDate(ls api.ILuaState) int {
+	format := ls.OptString(1, "%c")
+	t := time.Now()
+	if !ls.IsNoneOrNil(2) {
+		t = time.Unix(ls.CheckInteger(2), 0)
+	}
+
+	if strings.HasPrefix(format, "!") { // UTC?
+		format = format[1:] // skip '!'
+		t = t.UTC()
+	} else {
+		t = t.Local()
+	}
+
+	if strings.HasPrefix(format, "*t") {
+		ls.CreateTable(0, 9) // 9 = number of fields
+		setField(ls, "sec", t.Second())
+		setField(ls, "min", t.Minute())
+		setField(ls, "hour", t.Hour())
+		setField(ls, "day", t.Day())
+		setField(ls, "month", int(t.Month()))
+		setField(ls, "year", t.Year())
+		setField(ls, "wday", int(t.Weekday())+1)
+		setField(ls, "yday", t.YearDay())
+		ls.PushBoolean(t.IsDST())
+		ls.SetField(-2, "isdst")
+	} else {
+		ls.PushString(strftime(ls, format, t))
+	}
+	return 1
+}
+
+func setField(ls api.ILuaState, key string, value int) {
+	ls.PushInteger(int64(value))
+	ls.SetField(-2, key)
+}
+
+// formats t like strftime of C in the "C" locale
+func strftime(ls api.ILuaState, format string, t time.Time) string {
+	var b strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			b.WriteByte(format[i])
+			continue
+		}
+		i++
+		if i >= len(format) {
+			ls.ArgError(1, "invalid conversion specifier '%'")
+		}
+		switch c := format[i]; c {
+		case 'a':
+			b.WriteString(t.Format("Mon"))
+		case 'A':
+			b.WriteString(t.Format("Monday"))
+		case 'b', 'h':
+			b.WriteString(t.Format("Jan"))
+		case 'B':
+			b.WriteString(t.Format("January"))
+		case 'c':
+			b.WriteString(t.Format("Mon Jan  2 15:04:05 2006"))
+		case 'C':
+			fmt.Fprintf(&b, "%02d", t.Year()/100)
+		case 'd':
+			fmt.Fprintf(&b, "%02d", t.Day())
+		case 'D':
+			b.WriteString(t.Format("01/02/06"))
+		case 'e':
+			fmt.Fprintf(&b, "%2d", t.Day())
+		case 'F':
+			b.WriteString(t.Format("2006-01-02"))
+		case 'H':
+			fmt.Fprintf(&b, "%02d", t.Hour())
+		case 'I':
+			fmt.Fprintf(&b, "%02d", (t.Hour()+11)%12+1)
+		case 'j':
+			fmt.Fprintf(&b, "%03d", t.YearDay())
+		case 'm':
+			fmt.Fprintf(&b, "%02d", int(t.Month()))
+		case 'M':
+			fmt.Fprintf(&b, "%02d", t.Minute())
+		case 'n':
+			b.WriteByte('\n')
+		case 'p':
+			b.WriteString(t.Format("PM"))
+		case 'r':
+			b.WriteString(t.Format("03:04:05 PM"))
+		case 'R':
+			b.WriteString(t.Format("15:04"))
+		case 's':
+			fmt.Fprintf(&b, "%d", t.Unix())
+		case 'S':
+			fmt.Fprintf(&b, "%02d", t.Second())
+		case 't':
+			b.WriteByte('\t')
+		case 'T', 'X':
+			b.WriteString(t.Format("15:04:05"))
+		case 'u':
+			fmt.Fprintf(&b, "%d", (int(t.Weekday())+6)%7+1)
+		case 'w':
+			fmt.Fprintf(&b, "%d", int(t.Weekday()))
+		case 'x':
+			b.WriteString(t.Format("01/02/06"))
+		case 'y':
+			fmt.Fprintf(&b, "%02d", t.Year()%100)
+		case 'Y':
+			fmt.Fprintf(&b, "%d", t.Year())
+		case 'z':
+			b.WriteString(t.Format("-0700"))
+		case 'Z':
+			b.WriteString(t.Format("MST"))
+		case '%':
+			b.WriteByte('%')
+		default:
+			ls.ArgError(1, fmt.Sprintf("invalid conversion specifier '%%%c'", c))
+		}
+	}
+	return b.String()
+}
+
+// os.getenv (varname)
+// http://www.lua.org/manual/5.3/manual.html#pdf-os.getenv
+func osGetEnv(ls api.ILuaState) int {
+	key := ls.CheckString(1)
+	if env, ok := os.LookupEnv(key); ok {
+		ls.PushString(env)
+	} else {
+		ls.PushNil()
+	}
+	return 1
+}
+
+// os.remove (filename)
+// http://www.lua.org/manual/5.3/manual.html#pdf-os.remove
+func osRemove(ls api.ILuaState) int {
+	filename := ls.CheckString(1)
+	return fileResult(ls, os.Remove(filename), filename)
+}
+
+// os.rename (oldname, newname)
+// http://www.lua.org/manual/5.3/manual.html#pdf-os.rename
+func osRename(ls api.ILuaState) int {
+	oldName := ls.CheckString(1)
+	newName := ls.CheckString(2)
+	return fileResult(ls, os.Rename(oldName, newName), "")
+}
+
+// os.tmpname ()
+// http://www.lua.org/manual/5.3/manual.html#pdf-os.tmpname
+func osTmpName(ls api.ILuaState) int {
+	f, err := ioutil.TempFile("", "lua_")
+	if err != nil {
+		return ls.Error2("unable to generate a unique filename")
+	}
+	f.Close()
+	ls.PushString(f.Name())
+	return 1
+}
+
+// os.setlocale (locale [, category])
+// http://www.lua.org/manual/5.3/manual.html#pdf-os.setlocale
+func osSetLocale(ls api.ILuaState) int {
+	locale := ls.OptString(1, "")
+	ls.CheckOption(2, "all", []string{"all", "collate", "ctype",
+		"monetary", "numeric", "time"})
+	// only the "C" locale is supported
+	if locale == "" || locale == "C" || locale == "POSIX" || ls.IsNoneOrNil(1) {
+		ls.PushString("C")
+	} else {
+		ls.PushNil()
+	}
+	return 1
+}
+
+// os.execute ([command])
+// http://www.lua.org/manual/5.3/manual.html#pdf-os.execute
+func osExecute(ls api.ILuaState) int {
+	if ls.IsNoneOrNil(1) {
+		_, err := exec.LookPath("sh")
+		ls.PushBoolean(err == nil) // true if there is a shell
+		return 1
+	}
+
+	cmd := exec.Command("sh", "-c", ls.CheckString(1))
+	cmd.Stdin = stdin(ls)
+	cmd.Stdout = stdout(ls)
+	cmd.Stderr = stderr(ls)
+	err := cmd.Run()
+	if err == nil {
+		ls.PushBoolean(true)
+		ls.PushString("exit")
+		ls.PushInteger(0)
+		return 3
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		ls.PushNil()
+		ls.PushString("exit")
+		ls.PushInteger(int64(exitErr.ExitCode()))
+		return 3
+	}
+	return fileResult(ls, err, "")
+}
+
+// os.exit ([code [, close]])
+// http://www.lua.org/manual/5.3/manual.html#pdf-os.exit
+func osExit(ls api.ILuaState) int {
+	var status int
+	if ls.IsBoolean(1) {
+		if !ls.ToBoolean(1) {
+			status = 1
+		}
+	} else {
+		status = int(ls.OptInteger(1, 0))
+	}
+	if ls.ToBoolean(2) {
+		ls.Close()
+	}
+	os.Exit(status)
+	return 0
+}
+
+// pushes the results of functions on files, true if err is nil,
+// nil, the message and the error number otherwise
+func fileResult(ls api.ILuaState, err error, filename string) int {
+	if err == nil {
+		ls.PushBoolean(true)
+		return 1
+	}
+	ls.PushNil()
+	msg := pathError(err)
+	if filename != "" {
+		ls.PushString(filename + ": " + msg)
+	} else {
+		ls.PushString(msg)
+	}
+	ls.PushInteger(int64(errno(err)))
+	return 3
+}
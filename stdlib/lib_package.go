@@ -0,0 +1,216 @@
+package stdlib
+
+import (
+	"luago/api"
+	"os"
+	"strings"
+)
+
+const (
+	luaDirSep    = string(os.PathSeparator)
+	luaPathSep   = ";"
+	luaPathMark  = "?"
+	luaExecDir   = "!"
+	luaIgMark    = "-"
+	luaPathVar   = "LUA_PATH"
+	luaPathVar53 = "LUA_PATH_5_3"
+
+	luaRoot        = "/usr/local/"
+	luaLDir        = luaRoot + "share/lua/5.3/"
+	luaCDir        = luaRoot + "lib/lua/5.3/"
+	luaPathDefault = luaLDir + "?.lua;" + luaLDir + "?/init.lua;" +
+		luaCDir + "?.lua;" + luaCDir + "?/init.lua;" +
+		"./?.lua;" + "./?/init.lua"
+)
+
+var pkgFuncs = api.FuncReg{
+	"searchpath": pkgSearchPath,
+}
+
+var llFuncs = api.FuncReg{
+	"require": pkgRequire,
+}
+
+// OpenPackageLib opens the package library, require is set into
+// the global table
+func OpenPackageLib(ls api.ILuaState) int {
+	ls.NewLib(pkgFuncs) // create 'package' table
+	createSearchersTable(ls)
+	setPath(ls, "path", luaPathVar53, luaPathVar, luaPathDefault)
+	ls.PushString("")
+	ls.SetField(-2, "cpath") // Go modules can't be loaded dynamically
+	// store config information
+	ls.PushString(luaDirSep + "\n" + luaPathSep + "\n" +
+		luaPathMark + "\n" + luaExecDir + "\n" + luaIgMark + "\n")
+	ls.SetField(-2, "config")
+	// set field 'loaded'
+	ls.GetSubTable(api.LuaRegistryIndex, "_LOADED")
+	ls.SetField(-2, "loaded")
+	// set field 'preload'
+	ls.GetSubTable(api.LuaRegistryIndex, "_PRELOAD")
+	ls.SetField(-2, "preload")
+	ls.PushGlobalTable()
+	ls.PushValue(-2)        // set 'package' as upvalue for next lib
+	ls.SetFuncs(llFuncs, 1) // open lib into global table
+	ls.Pop(1)               // pop global table
+	return 1                // return 'package' table
+}
+
+func createSearchersTable(ls api.ILuaState) {
+	searchers := []api.GoFunction{
+		preloadSearcher,
+		luaSearcher,
+	}
+	// create 'searchers' table
+	ls.CreateTable(len(searchers), 0)
+	// fill it with predefined searchers
+	for idx, searcher := range searchers {
+		ls.PushValue(-2) // set 'package' as upvalue for all searchers
+		ls.PushGoClosure(searcher, 1)
+		ls.RawSetI(-2, int64(idx+1))
+	}
+	ls.SetField(-2, "searchers")
+}
+
+// sets package[fieldName] to the path in environment variable envName53
+// or envName, dft if there is no such variable,
+// ";;" in the path is replaced by the default path
+func setPath(ls api.ILuaState, fieldName, envName53, envName, dft string) {
+	path, ok := os.LookupEnv(envName53)
+	if !ok {
+		path, ok = os.LookupEnv(envName)
+	}
+	if !ok {
+		path = dft
+	} else {
+		path = strings.Replace(path, luaPathSep+luaPathSep,
+			luaPathSep+dft+luaPathSep, 1)
+	}
+	ls.PushString(path)
+	ls.SetField(-2, fieldName)
+}
+
+func preloadSearcher(ls api.ILuaState) int {
+	name := ls.CheckString(1)
+	ls.GetField(api.LuaRegistryIndex, "_PRELOAD")
+	if ls.GetField(-1, name) == api.LuaTNil { // not found?
+		ls.PushString("\n\tno field package.preload['" + name + "']")
+	}
+	return 1
+}
+
+func luaSearcher(ls api.ILuaState) int {
+	name := ls.CheckString(1)
+	ls.GetField(api.LuaUpvalueIndex(1), "path")
+	path, ok := ls.ToStringX(-1)
+	if !ok {
+		ls.Error2("'package.path' must be a string")
+	}
+
+	filename, errMsg := searchPath(name, path, ".", luaDirSep)
+	if filename == "" {
+		ls.PushString(errMsg)
+		return 1 // module not found in this path
+	}
+	if ls.LoadFile(filename) == api.LuaOk { // module loaded successfully?
+		ls.PushString(filename) // will be 2nd argument to module
+		return 2                // return open function and file name
+	}
+	return ls.Error2("error loading module '%s' from file '%s':\n\t%s",
+		ls.CheckString(1), filename, ls.CheckString(-1))
+}
+
+// package.searchpath (name, path [, sep [, rep]])
+// http://www.lua.org/manual/5.3/manual.html#pdf-package.searchpath
+func pkgSearchPath(ls api.ILuaState) int {
+	name := ls.CheckString(1)
+	path := ls.CheckString(2)
+	sep := ls.OptString(3, ".")
+	rep := ls.OptString(4, luaDirSep)
+	filename, errMsg := searchPath(name, path, sep, rep)
+	if filename == "" {
+		ls.PushNil()
+		ls.PushString(errMsg)
+		return 2 // return nil + error message
+	}
+	ls.PushString(filename)
+	return 1
+}
+
+// returns the first readable file for name in path,
+// the message of tried files if there is no such file
+func searchPath(name, path, sep, dirSep string) (filename, errMsg string) {
+	if sep != "" {
+		name = strings.Replace(name, sep, dirSep, -1)
+	}
+
+	for _, filename := range strings.Split(path, luaPathSep) {
+		if filename == "" {
+			continue
+		}
+		filename = strings.Replace(filename, luaPathMark, name, -1)
+		if f, err := os.Open(filename); err == nil { // does file exist and is readable?
+			f.Close()
+			return filename, "" // return that file name
+		}
+		errMsg += "\n\tno file '" + filename + "'"
+	}
+	return "", errMsg
+}
+
+// require (modname)
+// http://www.lua.org/manual/5.3/manual.html#pdf-require
+func pkgRequire(ls api.ILuaState) int {
+	name := ls.CheckString(1)
+	ls.SetTop(1) // LOADED table will be at index 2
+	ls.GetField(api.LuaRegistryIndex, "_LOADED")
+	ls.GetField(2, name)  // LOADED[name]
+	if ls.ToBoolean(-1) { // is it there?
+		return 1 // package is already loaded
+	}
+	// else must load package
+	ls.Pop(1) // remove 'getfield' result
+	findLoader(ls, name)
+	ls.PushString(name) // pass name as argument to module loader
+	ls.Insert(-2)       // name is 1st argument (before search data)
+	ls.Call(2, 1)       // run loader to load module
+	if !ls.IsNil(-1) {  // non-nil return?
+		ls.SetField(2, name) // LOADED[name] = returned value
+	}
+	if ls.GetField(2, name) == api.LuaTNil { // module set no value?
+		ls.PushBoolean(true) // use true as result
+		ls.PushValue(-1)     // extra copy to be returned
+		ls.SetField(2, name) // LOADED[name] = true
+	}
+	return 1
+}
+
+func findLoader(ls api.ILuaState, name string) {
+	// push 'package.searchers' to index 3 in the stack
+	if ls.GetField(api.LuaUpvalueIndex(1), "searchers") != api.LuaTTable {
+		ls.Error2("'package.searchers' must be a table")
+	}
+
+	// to build error message
+	errMsg := "module '" + name + "' not found:"
+
+	//  iterate over available searchers to find a loader
+	for i := int64(1); ; i++ {
+		if ls.RawGetI(3, i) == api.LuaTNil { // no more searchers?
+			ls.Pop(1)               // remove nil
+			ls.Error2("%s", errMsg) // create error message
+		}
+
+		ls.PushString(name)
+		ls.Call(1, 2)                        // call it
+		if ls.Type(-2) == api.LuaTFunction { // did it find a loader?
+			return // module loader found
+		} else if ls.IsString(-2) { // searcher returned error message?
+			ls.Pop(1)                 // remove extra return
+			errMsg += ls.ToString(-1) // concatenate error message
+			ls.Pop(1)
+		} else {
+			ls.Pop(2) // remove both returns
+		}
+	}
+}
@@ -0,0 +1,356 @@
+package stdlib
+
+import (
+	"luago/api"
+	"math"
+	"strings"
+)
+
+var strLib = api.FuncReg{
+	"byte":    strByte,
+	"char":    strChar,
+	"find":    strFind,
+	"format":  strFormat,
+	"gmatch":  strGmatch,
+	"gsub":    strGsub,
+	"len":     strLen,
+	"lower":   strLower,
+	"match":   strMatch,
+	"rep":     strRep,
+	"reverse": strReverse,
+	"sub":     strSub,
+	"upper":   strUpper,
+}
+
+// OpenStringLib opens the string library and sets it as
+// the __index of the metatable of strings
+func OpenStringLib(ls api.ILuaState) int {
+	ls.NewLib(strLib)
+	createMetatable(ls)
+	return 1
+}
+
+func createMetatable(ls api.ILuaState) {
+	ls.CreateTable(0, 1)       // table to be metatable for strings
+	ls.PushString("dummy")     // dummy string
+	ls.PushValue(-2)           // copy table
+	ls.SetMetaTable(-2)        // set table as metatable for strings
+	ls.Pop(1)                  // pop dummy string
+	ls.PushValue(-2)           // get string library
+	ls.SetField(-2, "__index") // metatable.__index = string
+	ls.Pop(1)                  // pop metatable
+}
+
+// translates a relative string position: negative means back from end
+func posRelat(pos int64, l int) int64 {
+	if pos >= 0 {
+		return pos
+	} else if -pos > int64(l) {
+		return 0
+	}
+	return int64(l) + pos + 1
+}
+
+// string.len (s)
+// http://www.lua.org/manual/5.3/manual.html#pdf-string.len
+func strLen(ls api.ILuaState) int {
+	s := ls.CheckString(1)
+	ls.PushInteger(int64(len(s)))
+	return 1
+}
+
+// string.sub (s, i [, j])
+// http://www.lua.org/manual/5.3/manual.html#pdf-string.sub
+func strSub(ls api.ILuaState) int {
+	s := ls.CheckString(1)
+	l := len(s)
+	i := posRelat(ls.CheckInteger(2), l)
+	j := posRelat(ls.OptInteger(3, -1), l)
+	if i < 1 {
+		i = 1
+	}
+	if j > int64(l) {
+		j = int64(l)
+	}
+	if i <= j {
+		ls.PushString(s[i-1 : j])
+	} else {
+		ls.PushString("")
+	}
+	return 1
+}
+
+// string.reverse (s)
+// http://www.lua.org/manual/5.3/manual.html#pdf-string.reverse
+func strReverse(ls api.ILuaState) int {
+	s := ls.CheckString(1)
+	b := make([]byte, len(s))
+	for i := range s {
+		b[len(s)-1-i] = s[i]
+	}
+	ls.PushString(string(b))
+	return 1
+}
+
+// string.lower (s)
+// http://www.lua.org/manual/5.3/manual.html#pdf-string.lower
+func strLower(ls api.ILuaState) int {
+	s := ls.CheckString(1)
+	b := []byte(s)
+	for i, c := range b {
+		if 'A' <= c && c <= 'Z' {
+			b[i] = c + 'a' - 'A'
+		}
+	}
+	ls.PushString(string(b))
+	return 1
+}
+
+// string.upper (s)
+// http://www.lua.org/manual/5.3/manual.html#pdf-string.upper
+func strUpper(ls api.ILuaState) int {
+	s := ls.CheckString(1)
+	b := []byte(s)
+	for i, c := range b {
+		if 'a' <= c && c <= 'z' {
+			b[i] = c - 'a' + 'A'
+		}
+	}
+	ls.PushString(string(b))
+	return 1
+}
+
+// string.rep (s, n [, sep])
+// http://www.lua.org/manual/5.3/manual.html#pdf-string.rep
+func strRep(ls api.ILuaState) int {
+	s := ls.CheckString(1)
+	n := ls.CheckInteger(2)
+	sep := ls.OptString(3, "")
+
+	if n <= 0 {
+		ls.PushString("")
+	} else if int64(len(s)+len(sep)) > math.MaxInt32/n { // may overflow?
+		return ls.Error2("resulting string too large")
+	} else if n == 1 {
+		ls.PushString(s)
+	} else {
+		ls.PushString(strings.Repeat(s+sep, int(n-1)) + s)
+	}
+	return 1
+}
+
+// string.byte (s [, i [, j]])
+// http://www.lua.org/manual/5.3/manual.html#pdf-string.byte
+func strByte(ls api.ILuaState) int {
+	s := ls.CheckString(1)
+	l := len(s)
+	posi := posRelat(ls.OptInteger(2, 1), l)
+	pose := posRelat(ls.OptInteger(3, posi), l)
+	if posi < 1 {
+		posi = 1
+	}
+	if pose > int64(l) {
+		pose = int64(l)
+	}
+	if posi > pose {
+		return 0 // empty interval; return no values
+	}
+	if pose-posi >= math.MaxInt32 { // arithmetic overflow?
+		return ls.Error2("string slice too long")
+	}
+
+	n := int(pose - posi + 1)
+	ls.CheckStack2(n, "string slice too long")
+	for i := 0; i < n; i++ {
+		ls.PushInteger(int64(s[posi+int64(i)-1]))
+	}
+	return n
+}
+
+// string.char (···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-string.char
+func strChar(ls api.ILuaState) int {
+	n := ls.GetTop() // number of arguments
+	b := make([]byte, n)
+	for i := 1; i <= n; i++ {
+		c := ls.CheckInteger(i)
+		ls.ArgCheck(uint64(c) <= math.MaxUint8, i, "value out of range")
+		b[i-1] = byte(c)
+	}
+	ls.PushString(string(b))
+	return 1
+}
+
+// string.find (s, pattern [, init [, plain]])
+// http://www.lua.org/manual/5.3/manual.html#pdf-string.find
+func strFind(ls api.ILuaState) int {
+	return strFindAux(ls, true)
+}
+
+// string.match (s, pattern [, init])
+// http://www.lua.org/manual/5.3/manual.html#pdf-string.match
+func strMatch(ls api.ILuaState) int {
+	return strFindAux(ls, false)
+}
+
+func strFindAux(ls api.ILuaState, find bool) int {
+	s := ls.CheckString(1)
+	p := ls.CheckString(2)
+	init := posRelat(ls.OptInteger(3, 1), len(s))
+	if init < 1 {
+		init = 1
+	} else if init > int64(len(s))+1 { // start after string's end?
+		ls.PushNil() // cannot find anything
+		return 1
+	}
+
+	// explicit request or no special characters?
+	if find && (ls.ToBoolean(4) || noSpecials(p)) {
+		// do a plain search
+		if idx := strings.Index(s[init-1:], p); idx >= 0 {
+			start := init + int64(idx)
+			ls.PushInteger(start)
+			ls.PushInteger(start + int64(len(p)) - 1)
+			return 2
+		}
+	} else {
+		anchor := strings.HasPrefix(p, "^")
+		if anchor {
+			p = p[1:] // skip anchor character
+		}
+		ms := newMatchState(ls, s, p)
+		for s1 := int(init - 1); ; s1++ {
+			ms.reprep()
+			if e := ms.match(s1, 0); e != -1 {
+				if find {
+					ls.PushInteger(int64(s1 + 1)) // start
+					ls.PushInteger(int64(e))      // end
+					return ms.pushCaptures(-1, -1, false) + 2
+				}
+				return ms.pushCaptures(s1, e, true)
+			}
+			if s1 >= len(s) || anchor {
+				break
+			}
+		}
+	}
+	ls.PushNil() // not found
+	return 1
+}
+
+// string.gmatch (s, pattern)
+// http://www.lua.org/manual/5.3/manual.html#pdf-string.gmatch
+func strGmatch(ls api.ILuaState) int {
+	s := ls.CheckString(1)
+	p := ls.CheckString(2)
+	ms := newMatchState(ls, s, p)
+	src, lastMatch := 0, -1
+
+	gmatchAux := func(ls api.ILuaState) int {
+		ms.ls = ls
+		for ; src <= len(s); src++ {
+			ms.reprep()
+			if e := ms.match(src, 0); e != -1 && e != lastMatch {
+				start := src
+				src, lastMatch = e, e
+				return ms.pushCaptures(start, e, true)
+			}
+		}
+		return 0 // not found
+	}
+	ls.PushGoFunction(gmatchAux)
+	return 1
+}
+
+// string.gsub (s, pattern, repl [, n])
+// http://www.lua.org/manual/5.3/manual.html#pdf-string.gsub
+func strGsub(ls api.ILuaState) int {
+	src := ls.CheckString(1)
+	p := ls.CheckString(2)
+	tr := ls.Type(3) // replacement type
+	maxN := ls.OptInteger(4, int64(len(src))+1)
+	ls.ArgCheck(tr == api.LuaTNumber || tr == api.LuaTString ||
+		tr == api.LuaTFunction || tr == api.LuaTTable, 3,
+		"string/function/table expected")
+
+	anchor := strings.HasPrefix(p, "^")
+	if anchor {
+		p = p[1:] // skip anchor character
+	}
+	ms := newMatchState(ls, src, p)
+	var b strings.Builder
+	s, lastMatch := 0, -1
+	n := int64(0) // replacement count
+	for n < maxN {
+		ms.reprep()
+		if e := ms.match(s, 0); e != -1 && e != lastMatch { // match?
+			n++
+			ms.addValue(&b, s, e, tr) // add replacement to buffer
+			s, lastMatch = e, e
+		} else if s < len(src) { // otherwise, skip one character
+			b.WriteByte(src[s])
+			s++
+		} else {
+			break // end of subject
+		}
+		if anchor {
+			break
+		}
+	}
+	b.WriteString(src[s:])
+	ls.PushString(b.String())
+	ls.PushInteger(n) // number of substitutions
+	return 2
+}
+
+// adds the replacement of the match [s, e) to b
+func (ms *matchState) addValue(b *strings.Builder, s, e int, tr api.LuaType) {
+	ls := ms.ls
+	switch tr {
+	case api.LuaTFunction:
+		ls.PushValue(3)
+		n := ms.pushCaptures(s, e, true)
+		ls.Call(n, 1)
+	case api.LuaTTable:
+		ms.pushOneCapture(0, s, e)
+		ls.GetTable(3)
+	default: // number or string
+		ms.addString(b, s, e)
+		return
+	}
+
+	if !ls.ToBoolean(-1) { // nil or false?
+		ls.Pop(1)
+		b.WriteString(ms.src[s:e]) // keep original text
+		return
+	} else if !ls.IsString(-1) {
+		ls.Error2("invalid replacement value (a %s)", ls.TypeName2(-1))
+	}
+	b.WriteString(ls.ToString(-1)) // add result to accumulator
+	ls.Pop(1)
+}
+
+// adds the replacement string with captures %0-%9 to b
+func (ms *matchState) addString(b *strings.Builder, s, e int) {
+	ls := ms.ls
+	news := ls.ToString(3)
+	for i := 0; i < len(news); i++ {
+		if news[i] != lEsc {
+			b.WriteByte(news[i])
+			continue
+		}
+		i++ // skip ESC
+		if i >= len(news) || !('0' <= news[i] && news[i] <= '9') {
+			if i >= len(news) || news[i] != lEsc {
+				ls.Error2("invalid use of '%c' in replacement string", lEsc)
+			}
+			b.WriteByte(news[i]) // %%
+		} else if news[i] == '0' {
+			b.WriteString(ms.src[s:e])
+		} else {
+			ms.pushOneCapture(int(news[i]-'1'), s, e)
+			b.WriteString(ls.ToStringMeta(-1)) // if number, convert it to string
+			ls.Pop(2)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package stdlib
+
+import (
+	"luago/api"
+	"math"
+	"sort"
+	"strings"
+)
+
+var tabFuncs = api.FuncReg{
+	"concat": tabConcat,
+	"insert": tabInsert,
+	"move":   tabMove,
+	"pack":   tabPack,
+	"remove": tabRemove,
+	"sort":   tabSort,
+	"unpack": tabUnpack,
+}
+
+// OpenTableLib opens the table library
+func OpenTableLib(ls api.ILuaState) int {
+	ls.NewLib(tabFuncs)
+	return 1
+}
+
+// operations needed by a table function
+const (
+	tabR  = 1           // read
+	tabW  = 2           // write
+	tabL  = 4           // length
+	tabRW = tabR | tabW // read/write
+)
+
+// checks that arg is a table or a value with the metamethods needed by what
+func checkTab(ls api.ILuaState, arg, what int) {
+	if ls.Type(arg) == api.LuaTTable {
+		return
+	}
+	n := 1                     // number of elements to pop
+	if ls.GetMetaTable(arg) && // must have metatable
+		(what&tabR == 0 || checkField(ls, "__index", &n)) &&
+		(what&tabW == 0 || checkField(ls, "__newindex", &n)) &&
+		(what&tabL == 0 || checkField(ls, "__len", &n)) {
+		ls.Pop(n) // pop metatable and tested metamethods
+	} else {
+		ls.CheckType(arg, api.LuaTTable) // force an error
+	}
+}
+
+func checkField(ls api.ILuaState, key string, n *int) bool {
+	ls.PushString(key)
+	*n++
+	return ls.RawGet(-*n) != api.LuaTNil
+}
+
+// checks the table at arg, returns its length
+func auxGetN(ls api.ILuaState, arg, what int) int64 {
+	checkTab(ls, arg, what|tabL)
+	return ls.Len2(arg)
+}
+
+// table.concat (list [, sep [, i [, j]]])
+// http://www.lua.org/manual/5.3/manual.html#pdf-table.concat
+func tabConcat(ls api.ILuaState) int {
+	last := auxGetN(ls, 1, tabR)
+	sep := ls.OptString(2, "")
+	i := ls.OptInteger(3, 1)
+	last = ls.OptInteger(4, last)
+
+	var b strings.Builder
+	for ; i <= last; i++ {
+		ls.GetI(1, i)
+		if !ls.IsString(-1) {
+			ls.Error2("invalid value (at index %d) in table for 'concat'", i)
+		}
+		b.WriteString(ls.ToString(-1))
+		ls.Pop(1)
+		if i != last {
+			b.WriteString(sep)
+		}
+		if i == math.MaxInt64 {
+			break
+		}
+	}
+	ls.PushString(b.String())
+	return 1
+}
+
+// table.insert (list, [pos,] value)
+// http://www.lua.org/manual/5.3/manual.html#pdf-table.insert
+func tabInsert(ls api.ILuaState) int {
+	e := auxGetN(ls, 1, tabRW) + 1 // first empty element
+	var pos int64                  // where to insert new element
+	switch ls.GetTop() {
+	case 2: // called with only 2 arguments
+		pos = e // insert new element at the end
+	case 3:
+		pos = ls.CheckInteger(2) // 2nd argument is the position
+		// check whether 'pos' is in [1, e]
+		ls.ArgCheck(uint64(pos)-1 < uint64(e), 2, "position out of bounds")
+		for i := e; i > pos; i-- { // move up elements
+			ls.GetI(1, i-1)
+			ls.SetI(1, i) // t[i] = t[i - 1]
+		}
+	default:
+		return ls.Error2("wrong number of arguments to 'insert'")
+	}
+	ls.SetI(1, pos) // t[pos] = v
+	return 0
+}
+
+// table.remove (list [, pos])
+// http://www.lua.org/manual/5.3/manual.html#pdf-table.remove
+func tabRemove(ls api.ILuaState) int {
+	size := auxGetN(ls, 1, tabRW)
+	pos := ls.OptInteger(2, size)
+	if pos != size { // validate 'pos' if given
+		ls.ArgCheck(uint64(pos)-1 <= uint64(size), 1, "position out of bounds")
+	}
+	ls.GetI(1, pos) // result = t[pos]
+	for ; pos < size; pos++ {
+		ls.GetI(1, pos+1)
+		ls.SetI(1, pos) // t[pos] = t[pos + 1]
+	}
+	ls.PushNil()
+	ls.SetI(1, pos) // t[pos] = nil
+	return 1
+}
+
+// table.move (a1, f, e, t [,a2])
+// http://www.lua.org/manual/5.3/manual.html#pdf-table.move
+func tabMove(ls api.ILuaState) int {
+	f := ls.CheckInteger(2)
+	e := ls.CheckInteger(3)
+	t := ls.CheckInteger(4)
+	tt := 1 // destination table
+	if !ls.IsNoneOrNil(5) {
+		tt = 5
+	}
+	checkTab(ls, 1, tabR)
+	checkTab(ls, tt, tabW)
+	if e >= f { // otherwise, nothing to move
+		ls.ArgCheck(f > 0 || e < math.MaxInt64+f, 3, "too many elements to move")
+		n := e - f // number of elements minus 1 (avoid overflows)
+		ls.ArgCheck(t <= math.MaxInt64-n, 4, "destination wrap around")
+		if t > e || t <= f || (tt != 1 && !ls.Compare(1, tt, api.LuaOpEq)) {
+			for i := int64(0); i <= n; i++ {
+				ls.GetI(1, f+i)
+				ls.SetI(tt, t+i)
+			}
+		} else {
+			for i := n; i >= 0; i-- {
+				ls.GetI(1, f+i)
+				ls.SetI(tt, t+i)
+			}
+		}
+	}
+	ls.PushValue(tt) // return destination table
+	return 1
+}
+
+// table.pack (···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-table.pack
+func tabPack(ls api.ILuaState) int {
+	n := int64(ls.GetTop())   // number of elements to pack
+	ls.CreateTable(int(n), 1) // create result table
+	ls.Insert(1)              // put it at index 1
+	for i := n; i >= 1; i-- { // assign elements
+		ls.SetI(1, i)
+	}
+	ls.PushInteger(n)
+	ls.SetField(1, "n") // t.n = number of elements
+	return 1            // return table
+}
+
+// table.unpack (list [, i [, j]])
+// http://www.lua.org/manual/5.3/manual.html#pdf-table.unpack
+func tabUnpack(ls api.ILuaState) int {
+	i := ls.OptInteger(2, 1)
+	var e int64
+	if ls.IsNoneOrNil(3) {
+		e = ls.Len2(1)
+	} else {
+		e = ls.CheckInteger(3)
+	}
+	if i > e {
+		return 0 // empty range
+	}
+
+	n := uint64(e) - uint64(i) // number of elements minus 1 (avoid overflows)
+	if n >= math.MaxInt32 || !ls.CheckStack(int(n+1)) {
+		return ls.Error2("too many results to unpack")
+	}
+	for ; i < e; i++ { // push arg[i..e - 1] (to avoid overflows)
+		ls.GetI(1, i)
+	}
+	ls.GetI(1, e) // push last element
+	return int(n + 1)
+}
+
+// table.sort (list [, comp])
+// http://www.lua.org/manual/5.3/manual.html#pdf-table.sort
+func tabSort(ls api.ILuaState) int {
+	n := auxGetN(ls, 1, tabRW)
+	if n > 1 { // non-trivial interval?
+		ls.ArgCheck(n < math.MaxInt32, 1, "array too big")
+		if !ls.IsNoneOrNil(2) { // is there a 2nd argument?
+			ls.CheckType(2, api.LuaTFunction) // must be a function
+		}
+		ls.SetTop(2) // make sure there are two arguments
+		ls.CheckStack2(int(n)+3, "array too big")
+		for i := int64(1); i <= n; i++ { // elements are sorted on the stack
+			ls.GetI(1, i)
+		}
+		sort.Sort(sortHelper{ls, int(n)})
+		for i := n; i >= 1; i-- {
+			ls.SetI(1, i)
+		}
+	}
+	return 0
+}
+
+// sortHelper sorts the n elements on the top of the stack
+type sortHelper struct {
+	ls api.ILuaState
+	n  int
+}
+
+func (h sortHelper) Len() int {
+	return h.n
+}
+
+func (h sortHelper) Less(i, j int) bool {
+	ls := h.ls
+	i, j = 3+i, 3+j  // the elements start from index 3
+	if ls.IsNil(2) { // no function?
+		return ls.Compare(i, j, api.LuaOpLt) // a < b
+	}
+	ls.PushValue(2) // push function
+	ls.PushValue(i) // 1st argument
+	ls.PushValue(j) // 2nd argument
+	ls.Call(2, 1)   // call function
+	less := ls.ToBoolean(-1)
+	ls.Pop(1) // pop result
+	return less
+}
+
+func (h sortHelper) Swap(i, j int) {
+	ls := h.ls
+	i, j = 3+i, 3+j
+	ls.PushValue(i)
+	ls.Copy(j, i)
+	ls.Replace(j)
+}
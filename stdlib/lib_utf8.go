@@ -0,0 +1,232 @@
+package stdlib
+
+import (
+	"luago/api"
+	"strings"
+)
+
+const maxUnicode = 0x7FFFFFFF // max code point of extended utf-8 of Lua
+
+// pattern to match exactly one UTF-8 byte sequence
+const utf8Pattern = "[\x00-\x7F\xC2-\xF4][\x80-\xBF]*"
+
+var utf8Lib = api.FuncReg{
+	"char":      utfChar,
+	"codepoint": utfCodePoint,
+	"codes":     utfCodes,
+	"len":       utfLen,
+	"offset":    utfOffset,
+}
+
+// OpenUTF8Lib opens the utf8 library
+func OpenUTF8Lib(ls api.ILuaState) int {
+	ls.NewLib(utf8Lib)
+	ls.PushString(utf8Pattern)
+	ls.SetField(-2, "charpattern")
+	return 1
+}
+
+func isCont(s string, i int) bool {
+	return i < len(s) && s[i]&0xC0 == 0x80
+}
+
+// decodes one UTF-8 sequence at s[i:], returns the code point and
+// the length of sequence, 0 if the sequence is invalid
+func utf8Decode(s string, i int) (code, size int) {
+	limits := [...]int{0xFF, 0x7F, 0x7FF, 0xFFFF, 0x1FFFFF, 0x3FFFFFF}
+	c := int(s[i])
+	if c < 0x80 { // ascii?
+		return c, 1
+	}
+	res := 0
+	count := 0                   // to count number of continuation bytes
+	for ; c&0x40 != 0; c <<= 1 { // still have continuation bytes?
+		count++
+		if i+count >= len(s) {
+			return 0, 0
+		}
+		cc := int(s[i+count]) // read next byte
+		if cc&0xC0 != 0x80 {  // not a continuation byte?
+			return 0, 0 // invalid byte sequence
+		}
+		res = res<<6 | cc&0x3F // add lower 6 bits from cont. byte
+	}
+	res |= (c & 0x7F) << (count * 5) // add first byte
+	if count > 5 || res > maxUnicode || res <= limits[count] {
+		return 0, 0 // invalid byte sequence
+	}
+	return res, count + 1
+}
+
+// encodes x as an UTF-8 sequence of Lua, up to 6 bytes
+func utf8Encode(x int) string {
+	if x < 0x80 { // ascii?
+		return string([]byte{byte(x)})
+	}
+	var buf [8]byte
+	n := 1        // number of bytes put in buffer (backwards)
+	mfb := 0x3f   // maximum that fits in first byte
+	for x > mfb { // need continuation bytes?
+		buf[8-n] = byte(0x80 | x&0x3f) // add continuation byte
+		n++
+		x >>= 6   // remove added bits
+		mfb >>= 1 // now there is one less bit available in first byte
+	}
+	buf[8-n] = byte((^mfb << 1) | x) // add first byte
+	return string(buf[8-n:])
+}
+
+// utf8.len (s [, i [, j]])
+// http://www.lua.org/manual/5.3/manual.html#pdf-utf8.len
+func utfLen(ls api.ILuaState) int {
+	s := ls.CheckString(1)
+	l := len(s)
+	posi := posRelat(ls.OptInteger(2, 1), l)
+	posj := posRelat(ls.OptInteger(3, -1), l)
+	ls.ArgCheck(1 <= posi && posi-1 <= int64(l), 2,
+		"initial position out of string")
+	ls.ArgCheck(posj-1 < int64(l), 3, "final position out of string")
+
+	n := int64(0)
+	for i := int(posi - 1); i < int(posj); {
+		_, size := utf8Decode(s, i)
+		if size == 0 { // conversion error?
+			ls.PushNil()                 // return nil ...
+			ls.PushInteger(int64(i + 1)) // ... and current position
+			return 2
+		}
+		i += size
+		n++
+	}
+	ls.PushInteger(n)
+	return 1
+}
+
+// utf8.codepoint (s [, i [, j]])
+// http://www.lua.org/manual/5.3/manual.html#pdf-utf8.codepoint
+func utfCodePoint(ls api.ILuaState) int {
+	s := ls.CheckString(1)
+	l := len(s)
+	posi := posRelat(ls.OptInteger(2, 1), l)
+	pose := posRelat(ls.OptInteger(3, posi), l)
+	ls.ArgCheck(posi >= 1, 2, "out of range")
+	ls.ArgCheck(pose <= int64(l), 3, "out of range")
+	if posi > pose {
+		return 0 // empty interval; return no values
+	}
+	if pose-posi >= 1<<31-1 { // (int64 -> int) overflow?
+		return ls.Error2("string slice too long")
+	}
+
+	n := int(pose - posi + 1)
+	ls.CheckStack2(n, "string slice too long")
+	n = 0
+	for i := int(posi - 1); i < int(pose); {
+		code, size := utf8Decode(s, i)
+		if size == 0 {
+			return ls.Error2("invalid UTF-8 code")
+		}
+		ls.PushInteger(int64(code))
+		i += size
+		n++
+	}
+	return n
+}
+
+// utf8.char (···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-utf8.char
+func utfChar(ls api.ILuaState) int {
+	n := ls.GetTop() // number of arguments
+	var b strings.Builder
+	for i := 1; i <= n; i++ {
+		code := ls.CheckInteger(i)
+		ls.ArgCheck(uint64(code) <= maxUnicode, i, "value out of range")
+		b.WriteString(utf8Encode(int(code)))
+	}
+	ls.PushString(b.String())
+	return 1
+}
+
+// utf8.offset (s, n [, i])
+// http://www.lua.org/manual/5.3/manual.html#pdf-utf8.offset
+func utfOffset(ls api.ILuaState) int {
+	s := ls.CheckString(1)
+	l := len(s)
+	n := ls.CheckInteger(2)
+	def := int64(1)
+	if n < 0 {
+		def = int64(l) + 1
+	}
+	posi := posRelat(ls.OptInteger(3, def), l)
+	ls.ArgCheck(1 <= posi && posi-1 <= int64(l), 3, "position out of range")
+
+	i := int(posi - 1)
+	if n == 0 {
+		// find beginning of current byte sequence
+		for i > 0 && isCont(s, i) {
+			i--
+		}
+	} else {
+		if isCont(s, i) {
+			return ls.Error2("initial position is a continuation byte")
+		}
+		if n < 0 {
+			for n < 0 && i > 0 { // move back
+				i-- // at least one position back
+				for i > 0 && isCont(s, i) {
+					i--
+				}
+				n++
+			}
+		} else {
+			n-- // do not move for 1st character
+			for n > 0 && i < l {
+				i++ // at least one position forward
+				for isCont(s, i) {
+					i++ // (cannot pass final '\0')
+				}
+				n--
+			}
+		}
+	}
+	if n == 0 { // did it find given character?
+		ls.PushInteger(int64(i + 1))
+	} else { // no such character
+		ls.PushNil()
+	}
+	return 1
+}
+
+// utf8.codes (s)
+// http://www.lua.org/manual/5.3/manual.html#pdf-utf8.codes
+func utfCodes(ls api.ILuaState) int {
+	ls.ArgCheck(!isCont(ls.CheckString(1), 0), 1, "invalid UTF-8 code")
+	ls.PushGoFunction(iterAux)
+	ls.PushValue(1)
+	ls.PushInteger(0)
+	return 3
+}
+
+func iterAux(ls api.ILuaState) int {
+	s := ls.CheckString(1)
+	l := len(s)
+	i := int(ls.ToInteger(2) - 1)
+	if i < 0 { // first iteration?
+		i = 0 // start from here
+	} else if i < l {
+		i++ // skip current byte
+		for isCont(s, i) {
+			i++ // and its continuations
+		}
+	}
+	if i >= l {
+		return 0 // no more codepoints
+	}
+	code, size := utf8Decode(s, i)
+	if size == 0 || isCont(s, i+size) {
+		return ls.Error2("invalid UTF-8 code")
+	}
+	ls.PushInteger(int64(i + 1))
+	ls.PushInteger(int64(code))
+	return 2
+}
@@ -0,0 +1,133 @@
+package stdlib
+
+import "luago/api"
+
+// SafeLibNames is the names of libraries opened by OpenSafeLibs,
+// the libraries which access files, processes or internals are left out
+var SafeLibNames = []string{
+	BaseLibName, CoroutineLibName, StringLibName, TableLibName, MathLibName,
+	OSLibName, UTF8LibName, JSONLibName,
+}
+
+// SafeMaxStringRep is the max length of strings made by string.rep
+// in the libraries opened by OpenSafeLibs
+const SafeMaxStringRep = 1 << 24
+
+// the functions of os library kept by OpenSafeLibs
+var safeOSFuncs = []string{"clock", "date", "difftime", "time"}
+
+// OpenSafeLibs opens the standard libraries safe for untrusted code,
+// see SafeLibNames, and restricts them:
+//
+//	load            loads text chunks only
+//	dofile          removed
+//	loadfile        removed
+//	collectgarbage  supports "count" only
+//	string.rep      fails if the result is longer than SafeMaxStringRep
+//	os              clock, date, difftime and time only
+//
+// the metatables of strings and the ones registered by NewMetatable are
+// protected, see ProtectMetatables
+func OpenSafeLibs(ls api.ILuaState) {
+	for _, name := range SafeLibNames {
+		openLib(ls, name)
+	}
+	addOpened(ls, safeLibsName)
+
+	ls.PushGlobalTable()
+	ls.SetFuncs(api.FuncReg{
+		"load":           safeLoad,
+		"collectgarbage": safeCollectGarbage,
+	}, 0)
+	for _, name := range []string{"dofile", "loadfile"} {
+		ls.PushNil()
+		ls.SetField(-2, name)
+	}
+	ls.Pop(1)
+
+	if ls.GetGlobal(StringLibName) == api.LuaTTable {
+		ls.PushGoFunction(safeStrRep)
+		ls.SetField(-2, "rep")
+	}
+	ls.Pop(1)
+	ProtectMetatables(ls)
+
+	if ls.GetGlobal(OSLibName) == api.LuaTTable {
+		ls.CreateTable(0, len(safeOSFuncs))
+		for _, name := range safeOSFuncs {
+			ls.GetField(-2, name)
+			ls.SetField(-2, name)
+		}
+		ls.SetGlobal(OSLibName)
+	}
+	ls.Pop(1)
+}
+
+// sets __metatable of the metatable on the top to false, so getmetatable
+// returns false and setmetatable fails
+func protectMetatable(ls api.ILuaState) {
+	ls.PushBoolean(false)
+	ls.SetField(-2, "__metatable")
+}
+
+// ProtectMetatables protects the metatable of strings and the metatables
+// registered by NewMetatable, like the ones of files, lanes and json
+// values, they're shared by all chunks of the state; the metatables made
+// later aren't protected by it
+func ProtectMetatables(ls api.ILuaState) {
+	ProtectStringMetatable(ls)
+	ls.PushNil()
+	for ls.Next(api.LuaRegistryIndex) {
+		if ls.Type(-2) == api.LuaTString && ls.Type(-1) == api.LuaTTable {
+			ls.GetField(-1, "__name")
+			if ls.RawEqual(-1, -3) { // registry[tname].__name == tname
+				ls.Pop(1)
+				protectMetatable(ls)
+			} else {
+				ls.Pop(1)
+			}
+		}
+		ls.Pop(1)
+	}
+}
+
+// ProtectStringMetatable sets __metatable of the metatable of strings,
+// so getmetatable("") returns false instead of the metatable shared by
+// all chunks of the state, debug.getmetatable still reaches it
+func ProtectStringMetatable(ls api.ILuaState) {
+	ls.PushString("")
+	if ls.GetMetaTable(-1) {
+		protectMetatable(ls)
+		ls.Pop(1)
+	}
+	ls.Pop(1)
+}
+
+// load (chunk [, chunkname [, mode [, env]]]), binary chunks are refused
+func safeLoad(ls api.ILuaState) int {
+	if ls.GetTop() < 3 {
+		ls.SetTop(3)
+	}
+	ls.PushString("t")
+	ls.Replace(3)
+	return baseLoad(ls)
+}
+
+// collectgarbage ([opt])
+func safeCollectGarbage(ls api.ILuaState) int {
+	if opt := ls.OptString(1, "collect"); opt != "count" {
+		return ls.ArgError(1, "option '"+opt+"' is not allowed")
+	}
+	return baseCollectGarbage(ls)
+}
+
+// string.rep (s, n [, sep]), the result is limited to SafeMaxStringRep
+func safeStrRep(ls api.ILuaState) int {
+	s := ls.CheckString(1)
+	n := ls.CheckInteger(2)
+	sep := ls.OptString(3, "")
+	if n > 0 && int64(len(s)+len(sep)) > (SafeMaxStringRep+int64(len(sep)))/n {
+		return ls.Error2("resulting string too large")
+	}
+	return strRep(ls)
+}
@@ -0,0 +1,160 @@
+package stdlib
+
+import (
+	"io"
+	"luago/api"
+	"os"
+)
+
+// names of the standard libraries
+const (
+	BaseLibName      = "_G"
+	PackageLibName   = "package"
+	CoroutineLibName = "coroutine"
+	StringLibName    = "string"
+	TableLibName     = "table"
+	MathLibName      = "math"
+	IOLibName        = "io"
+	OSLibName        = "os"
+	UTF8LibName      = "utf8"
+	DebugLibName     = "debug"
+	LanesLibName     = "lanes"
+	JSONLibName      = "json"
+)
+
+// Libs is the open functions of standard libraries, name => function
+var Libs = map[string]api.GoFunction{
+	BaseLibName:      OpenBaseLib,
+	PackageLibName:   OpenPackageLib,
+	CoroutineLibName: OpenCoroutineLib,
+	StringLibName:    OpenStringLib,
+	TableLibName:     OpenTableLib,
+	MathLibName:      OpenMathLib,
+	IOLibName:        OpenIOLib,
+	OSLibName:        OpenOSLib,
+	UTF8LibName:      OpenUTF8Lib,
+	DebugLibName:     OpenDebugLib,
+	LanesLibName:     OpenLanesLib,
+	JSONLibName:      OpenJSONLib,
+}
+
+// LibNames is the names of standard libraries in the order they're opened
+var LibNames = []string{
+	BaseLibName, PackageLibName, CoroutineLibName, StringLibName, TableLibName,
+	MathLibName, IOLibName, OSLibName, UTF8LibName, DebugLibName, LanesLibName,
+	JSONLibName,
+}
+
+// OpenLibs opens all standard libraries
+func OpenLibs(ls api.ILuaState) {
+	for _, name := range LibNames {
+		OpenLib(ls, name)
+	}
+}
+
+// OpenLib opens the library name and sets the global name to it,
+// returns false if there is no such library
+func OpenLib(ls api.ILuaState, name string) bool {
+	if !openLib(ls, name) {
+		return false
+	}
+	addOpened(ls, name)
+	return true
+}
+
+func openLib(ls api.ILuaState, name string) bool {
+	openf, ok := Libs[name]
+	if !ok {
+		return false
+	}
+	ls.RequireF(name, openf, true)
+	ls.Pop(1)
+	return true
+}
+
+// registry key of the sequence of libraries opened by OpenLib and
+// OpenSafeLibs, the states of lanes open the same ones
+const openedKey = "_OPENED"
+
+// safeLibsName stands for OpenSafeLibs in the libraries opened
+const safeLibsName = "*safe"
+
+// appends name to the libraries opened
+func addOpened(ls api.ILuaState, name string) {
+	if ls.GetField(api.LuaRegistryIndex, openedKey) != api.LuaTTable {
+		ls.Pop(1)
+		ls.CreateTable(0, 0)
+		ls.PushValue(-1)
+		ls.SetField(api.LuaRegistryIndex, openedKey)
+	}
+	ls.PushString(name)
+	ls.RawSetI(-2, int64(ls.RawLen(-2))+1)
+	ls.Pop(1)
+}
+
+// opens the libraries opened in the state from in ls the same way
+func openLibsOf(ls, from api.ILuaState) {
+	var names []string
+	if from.GetField(api.LuaRegistryIndex, openedKey) == api.LuaTTable {
+		n := int64(from.RawLen(-1))
+		for i := int64(1); i <= n; i++ {
+			from.RawGetI(-1, i)
+			names = append(names, from.ToString(-1))
+			from.Pop(1)
+		}
+	}
+	from.Pop(1)
+	for _, name := range names {
+		if name == safeLibsName {
+			OpenSafeLibs(ls)
+		} else {
+			OpenLib(ls, name)
+		}
+	}
+}
+
+// registry keys of the standard files
+const (
+	stdinKey  = "_STDIN"
+	stdoutKey = "_STDOUT"
+	stderrKey = "_STDERR"
+)
+
+// SetStdio sets the standard files of the state, nil keeps the current one,
+// print writes to stdout, io.stdin, io.stdout and io.stderr are
+// made of them when io library is opened
+func SetStdio(ls api.ILuaState, stdin io.Reader, stdout, stderr io.Writer) {
+	setStdFile(ls, stdinKey, stdin)
+	setStdFile(ls, stdoutKey, stdout)
+	setStdFile(ls, stderrKey, stderr)
+}
+
+func setStdFile(ls api.ILuaState, key string, f interface{}) {
+	if f != nil {
+		ls.NewUserData(f)
+		ls.SetField(api.LuaRegistryIndex, key)
+	}
+}
+
+// returns the standard file with key in registry, def if it's not set
+func stdFile(ls api.ILuaState, key string, def interface{}) interface{} {
+	ls.GetField(api.LuaRegistryIndex, key)
+	f := ls.ToUserData(-1)
+	ls.Pop(1)
+	if f == nil {
+		return def
+	}
+	return f
+}
+
+func stdin(ls api.ILuaState) io.Reader {
+	return stdFile(ls, stdinKey, os.Stdin).(io.Reader)
+}
+
+func stdout(ls api.ILuaState) io.Writer {
+	return stdFile(ls, stdoutKey, os.Stdout).(io.Writer)
+}
+
+func stderr(ls api.ILuaState) io.Writer {
+	return stdFile(ls, stderrKey, os.Stderr).(io.Writer)
+}
@@ -0,0 +1,502 @@
+package stdlib
+
+import (
+	"bytes"
+	"luago/api"
+	"luago/state"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newState(t *testing.T) (api.ILuaState, *bytes.Buffer) {
+	ls := state.NewLuaState()
+	out := &bytes.Buffer{}
+	SetStdio(ls, strings.NewReader("12 0x10 -3.5e1 abc\nsecond line\nlast"), out, out)
+	OpenLibs(ls)
+	return ls, out
+}
+
+func doString(t *testing.T, ls api.ILuaState, src string) {
+	t.Helper()
+	if ls.DoString(src) {
+		t.Fatalf("%s", ls.ToString(-1))
+	}
+}
+
+func TestBaseLib(t *testing.T) {
+	ls, out := newState(t)
+	doString(t, ls, `
+		print(1, 2.0, "x", nil, true)
+		assert(select('#', 1, nil, nil) == 3)
+		assert(select(-1, 1, 2, 3) == 3)
+		assert(tonumber("0x10") == 16 and tonumber("z", 36) == 35)
+		assert(tonumber("10", 2) == 2 and tonumber("8", 8) == nil)
+		assert(tostring(nil) == "nil" and tostring(1.5) == "1.5")
+		assert(math.type(tonumber(" 10 ")) == "integer")
+		local ok, err = pcall(error, {code = 1})
+		assert(not ok and err.code == 1)
+		ok, err = pcall(error, "msg", 0)
+		assert(err == "msg")
+		ok, err = xpcall(function() error("x") end, function(m) return "h:" .. m end)
+		assert(not ok and err:find("^h:.*x$"))
+		local t = setmetatable({}, {__index = function(_, k) return k * 2 end})
+		assert(t[21] == 42 and rawget(t, 21) == nil)
+		local n = 0
+		for i, v in ipairs({1, 2, 3, nil, 5}) do n = n + i end
+		assert(n == 6)
+		local f = load("return ...")
+		assert(f(7) == 7)
+		local env = {y = 3}
+		assert(load("return y", "c", "t", env)() == 3)
+		assert(type(collectgarbage("count")) == "number")
+	`)
+	if got := out.String(); got != "1\t2.0\tx\tnil\ttrue\n" {
+		t.Errorf("print wrote %q", got)
+	}
+}
+
+func TestStringLib(t *testing.T) {
+	ls, _ := newState(t)
+	doString(t, ls, `
+		assert(("abc"):upper() == "ABC" and #("x"):rep(3, ",") == 5)
+		assert(string.sub("hello", 2, -2) == "ell")
+		assert(string.byte("A") == 65 and string.char(72, 105) == "Hi")
+		assert(string.find("hello world", "o w") == 5)
+		assert(string.find("a.b", ".", 1, true) == 2)
+		assert(string.match("key = value", "(%w+)%s*=%s*(%w+)") == "key")
+		local s, n = string.gsub("hello world", "o", "0")
+		assert(s == "hell0 w0rld" and n == 2)
+		assert(string.gsub("abc", "%w", "%0%0") == "aabbcc")
+		assert(string.gsub("$x $y", "%$(%w+)", {x = 1, y = "2"}) == "1 2")
+		local words = {}
+		for w in string.gmatch("one two three", "%a+") do words[#words + 1] = w end
+		assert(#words == 3 and words[3] == "three")
+		assert(string.format("%5.2f|%-3d|%s|%x", 3.14159, 7, "s", 255) == " 3.14|7  |s|ff")
+		assert(string.format("%q", "a\nb\0") == '"a\\\nb\\0"')
+		assert(string.format("%g", 1e20) == "1e+20")
+		assert(string.match("  trim  ", "^%s*(.-)%s*$") == "trim")
+		assert(string.find("THE (quick) fox", "%((%a+)%)") == 5)
+		assert(string.match("[[x]]", "%b[]") == "[[x]]")
+		assert(string.match("THE (quick) fox", "%f[%a]%a+", 5) == "quick")
+		assert(not pcall(string.rep, "x", 1 << 40))
+	`)
+}
+
+func TestTableLib(t *testing.T) {
+	ls, _ := newState(t)
+	doString(t, ls, `
+		local t = {5, 2, 8, 1}
+		table.sort(t)
+		assert(table.concat(t, ",") == "1,2,5,8")
+		table.sort(t, function(a, b) return a > b end)
+		assert(table.concat(t, ",") == "8,5,2,1")
+		table.insert(t, 1, 0)
+		table.insert(t, 9)
+		assert(table.concat(t, ",") == "0,8,5,2,1,9")
+		assert(table.remove(t) == 9 and table.remove(t, 1) == 0)
+		local p = table.pack(1, nil, 3)
+		assert(p.n == 3 and p[3] == 3)
+		assert(select('#', table.unpack({1, 2, 3})) == 3)
+		assert(table.concat(table.move({1, 2, 3}, 1, 3, 2), ",") == "1,1,2,3")
+	`)
+}
+
+func TestMathLib(t *testing.T) {
+	ls, _ := newState(t)
+	doString(t, ls, `
+		assert(math.floor(3.7) == 3 and math.type(math.floor(3.7)) == "integer")
+		assert(math.ceil(-3.7) == -3 and math.abs(-4) == 4)
+		assert(math.max(1, 5, 3) == 5 and math.min(2, -1) == -1)
+		assert(math.tointeger(3.0) == 3 and math.tointeger(3.5) == nil)
+		assert(math.fmod(7, 3) == 1 and math.fmod(-7, 3) == -1)
+		assert(math.ult(1, -1) and math.huge > math.maxinteger)
+		math.randomseed(42)
+		local a = math.random(1, 10)
+		math.randomseed(42)
+		assert(math.random(1, 10) == a and a >= 1 and a <= 10)
+		local f = math.random()
+		assert(f >= 0 and f < 1)
+	`)
+}
+
+func TestIOLib(t *testing.T) {
+	ls, out := newState(t)
+	doString(t, ls, `
+		local a, b, c = io.read("n", "n", "n")
+		assert(a == 12 and math.type(a) == "integer")
+		assert(b == 16 and c == -35.0)
+		assert(io.read("l") == " abc")
+		assert(io.read("L") == "second line\n")
+		assert(io.read("a") == "last" and io.read("l") == nil)
+		io.write("x", 1, "\n")
+		io.stdout:write("y\n")
+		assert(io.type(io.stdout) == "file" and io.type(1) == nil)
+		local ok, msg = io.stdout:close()
+		assert(not ok and msg == "cannot close standard file")
+
+		local name = os.tmpname()
+		local f = assert(io.open(name, "w"))
+		f:write("line1\n", "line2\n")
+		f:close()
+		assert(io.type(f) == "closed file")
+		local lines = {}
+		for l in io.lines(name) do lines[#lines + 1] = l end
+		assert(#lines == 2 and lines[2] == "line2")
+		f = io.open(name)
+		assert(f:read(3) == "lin" and f:seek() == 3)
+		f:seek("set", 1)
+		assert(f:read("l") == "ine1")
+		f:close()
+		assert(os.remove(name))
+		local nf, err = io.open(name)
+		assert(nf == nil and err:find("no such file"))
+	`)
+	if got := out.String(); got != "x1\ny\n" {
+		t.Errorf("io wrote %q", got)
+	}
+}
+
+func TestOSLib(t *testing.T) {
+	ls, _ := newState(t)
+	doString(t, ls, `
+		local t = os.time({year = 2020, month = 1, day = 2, hour = 3})
+		local d = os.date("*t", t)
+		assert(d.year == 2020 and d.month == 1 and d.day == 2 and d.hour == 3)
+		assert(os.date("%Y-%m-%d %H", t) == "2020-01-02 03")
+		assert(os.date("!%H:%M:%S", 3661) == "01:01:01")
+		assert(type(os.clock()) == "number" and os.getenv("NO_SUCH_VAR_") == nil)
+		assert(os.time({year = 2020, month = 1, day = 32}) == os.time({year = 2020, month = 2, day = 1}))
+	`)
+}
+
+func TestUTF8Lib(t *testing.T) {
+	ls, _ := newState(t)
+	doString(t, ls, `
+		local s = utf8.char(72, 228, 8364, 0x10348)
+		assert(s == "H\u{E4}\u{20AC}\u{10348}" and utf8.len(s) == 4)
+		assert(select(2, utf8.codepoint(s, 1, -1)) == 228)
+		assert(utf8.offset(s, 3) == 4 and utf8.offset(s, -1) == 7)
+		local n = 0
+		for p, c in utf8.codes(s) do n = n + 1 end
+		assert(n == 4)
+		assert(utf8.len("\xff") == nil)
+		local cs = {}
+		for c in s:gmatch(utf8.charpattern) do cs[#cs + 1] = c end
+		assert(#cs == 4)
+	`)
+}
+
+func TestDebugLib(t *testing.T) {
+	ls, _ := newState(t)
+	doString(t, ls, `
+		local x = 1
+		local function f() return x end
+		assert(debug.getupvalue(f, 1) == "x")
+		debug.setupvalue(f, 1, 5)
+		assert(f() == 5 and x == 5)
+		assert(debug.traceback("m"):find("^m\nstack traceback:"))
+		assert(debug.getmetatable("").__index == string)
+		assert(debug.getregistry()._LOADED.string == string)
+	`)
+}
+
+func TestRequire(t *testing.T) {
+	ls, _ := newState(t)
+	doString(t, ls, `
+		package.preload.mod = function(name) return {name = name} end
+		local m = require("mod")
+		assert(m.name == "mod" and require("mod") == m)
+		assert(package.loaded.mod == m)
+		local ok, err = pcall(require, "no.such.mod")
+		assert(not ok and err:find("module 'no.such.mod' not found"))
+		assert(package.searchpath("x", "./?.none") == nil)
+	`)
+}
+
+func TestOpenLib(t *testing.T) {
+	ls := state.NewLuaState()
+	if !OpenLib(ls, BaseLibName) || !OpenLib(ls, MathLibName) || OpenLib(ls, "nope") {
+		t.Fatal("OpenLib")
+	}
+	doString(t, ls, `assert(string == nil and math.pi > 3 and type(print) == "function")`)
+}
+
+func TestSafeLibs(t *testing.T) {
+	ls := state.NewLuaState()
+	SetStdio(ls, nil, &bytes.Buffer{}, nil)
+	OpenSafeLibs(ls)
+	doString(t, ls, `
+		assert(io == nil and debug == nil and package == nil and require == nil)
+		assert(dofile == nil and loadfile == nil)
+		assert(os.execute == nil and os.remove == nil and os.getenv == nil)
+		assert(math.type(os.time()) == "integer" and type(os.clock()) == "number")
+		assert(load("return 1")() == 1)
+		local f, err = load("\27Lua")
+		assert(f == nil and err:find("attempt to load a binary chunk"))
+		f, err = load("\27Lua", "b", "b")
+		assert(f == nil and err:find("attempt to load a binary chunk"))
+		assert(type(collectgarbage("count")) == "number")
+		local ok, err = pcall(collectgarbage)
+		assert(not ok and err:find("option 'collect' is not allowed"))
+		ok, err = pcall(collectgarbage, "stop")
+		assert(not ok and err:find("option 'stop' is not allowed"))
+		assert(("ab"):rep(3, ",") == "ab,ab,ab")
+		ok, err = pcall(string.rep, "x", 1 << 30)
+		assert(not ok and err:find("resulting string too large"))
+		assert(getmetatable("") == false)
+	`)
+}
+
+func TestLanesLib(t *testing.T) {
+	ls, out := newState(t)
+	doString(t, ls, `
+		local function sum(t, n)
+			local s = 0
+			for i = 1, n do s = s + t[i] end
+			return s, {n = n}
+		end
+		local h = lanes.spawn(sum, {1, 2, 3}, 3)
+		local ok, s, info = h:join()
+		assert(ok and s == 6 and info.n == 3 and h:status() == "done")
+
+		h = lanes.spawn(function() error({code = 7}) end)
+		local ok, err = h:join(5)
+		assert(not ok and err.code == 7 and h:status() == "error")
+
+		local x = 1
+		ok, err = pcall(lanes.spawn, function() return x end)
+		assert(not ok and err:find("function has upvalue 'x'"))
+		ok, err = pcall(lanes.spawn, sum, {print})
+		assert(not ok and err:find("cannot pass function value"))
+		local cyc = {} cyc.self = cyc
+		ok, err = pcall(lanes.spawn, sum, cyc)
+		assert(not ok and err:find("cannot pass cyclic table"))
+
+		-- channels pass values between lanes
+		local jobs, results = lanes.channel(4, "number"), lanes.channel()
+		local workers = {}
+		for i = 1, 2 do
+			workers[i] = lanes.spawn(function(jobs, results)
+				while true do
+					local ok, n = jobs:receive()
+					if not ok then return end
+					results:send(n * n)
+				end
+			end, jobs, results)
+		end
+		for i = 1, 4 do jobs:send(i) end
+		jobs:close()
+		local total = 0
+		for i = 1, 4 do
+			local ok, v = results:receive(5)
+			assert(ok, v)
+			total = total + v
+		end
+		assert(total == 30)
+		for _, w in ipairs(workers) do assert(w:join(5)) end
+		assert(select(2, jobs:receive()) == "closed")
+		assert(select(2, jobs:send(1)) == "closed")
+		ok, err = pcall(jobs.send, jobs, "x")
+		assert(not ok and err:find("number expected, got string"))
+
+		local a, b = lanes.channel(1), lanes.channel(1)
+		b:send("hi")
+		local ch, ok, v = lanes.select({a, b}, 1)
+		assert(ch == b and ok and v == "hi")
+		ch, ok, v = lanes.select({a, b}, 0.01)
+		assert(ch == nil and not ok and v == "timeout")
+		assert(select(2, a:receive(0)) == "timeout")
+
+		-- cancel stops busy loops and waiting
+		local busy = lanes.spawn(function() while true do end end)
+		local waiting = lanes.spawn(function(c) c:receive() end, a)
+		assert(busy:join(0.01) == nil and busy:status() == "running")
+		busy:cancel()
+		waiting:cancel()
+		ok, err = busy:join(5)
+		assert(not ok and err:find("interrupted") and busy:status() == "cancelled")
+		assert(not waiting:join(5) and waiting:status() == "cancelled")
+
+		lanes.spawn(function() print("from lane") end):join()
+	`)
+	if got := out.String(); got != "from lane\n" {
+		t.Errorf("print wrote %q", got)
+	}
+}
+
+func TestLanesLibProfile(t *testing.T) {
+	ls := state.NewLuaState()
+	SetStdio(ls, nil, &bytes.Buffer{}, nil)
+	OpenSafeLibs(ls)
+	OpenLib(ls, LanesLibName)
+	doString(t, ls, `
+		local ok, sandboxed = lanes.spawn(function()
+			return io == nil and debug == nil and require == nil and
+				os.execute == nil and getmetatable("") == false and
+				lanes.spawn(function() return io == nil end):join()
+		end):join(5)
+		assert(ok and sandboxed)
+	`)
+
+	ls = state.NewLuaState()
+	OpenLib(ls, BaseLibName)
+	OpenLib(ls, LanesLibName)
+	doString(t, ls, `
+		local ok, names = lanes.spawn(function()
+			return tostring(string) .. " " .. type(lanes)
+		end):join(5)
+		assert(ok and names == "nil table", names)
+	`)
+}
+
+func TestCoroutineLib(t *testing.T) {
+	ls, _ := newState(t)
+	doString(t, ls, `
+		local co = coroutine.create(function(a, b)
+			assert(coroutine.isyieldable())
+			local c = coroutine.yield(a + b)
+			local d, e = coroutine.yield(c * 2)
+			return d + e
+		end)
+		assert(coroutine.status(co) == "suspended")
+		local ok, v = coroutine.resume(co, 1, 2)
+		assert(ok and v == 3 and coroutine.status(co) == "suspended")
+		ok, v = coroutine.resume(co, 10)
+		assert(ok and v == 20)
+		ok, v = coroutine.resume(co, 3, 4)
+		assert(ok and v == 7 and coroutine.status(co) == "dead")
+		ok, v = coroutine.resume(co)
+		assert(not ok and v == "cannot resume dead coroutine")
+		assert(not coroutine.isyieldable())
+		local main, ismain = coroutine.running()
+		assert(type(main) == "thread" and ismain)
+
+		-- yield across pcall and metamethods, errors end coroutines
+		co = coroutine.create(function()
+			local ok, err = pcall(function()
+				coroutine.yield(1)
+				error("oops")
+			end)
+			assert(not ok and err:find("oops"))
+			local t = setmetatable({}, {__index = function(_, k) return coroutine.yield(k) end})
+			return t.x
+		end)
+		assert(select(2, coroutine.resume(co)) == 1)
+		assert(select(2, coroutine.resume(co)) == "x")
+		assert(select(2, coroutine.resume(co, 5)) == 5)
+
+		co = coroutine.create(function() error({code = 1}) end)
+		ok, v = coroutine.resume(co)
+		assert(not ok and v.code == 1 and coroutine.status(co) == "dead")
+
+		-- status seen by nested coroutines
+		local outer
+		outer = coroutine.create(function()
+			local inner = coroutine.create(function()
+				return coroutine.status(outer), coroutine.running()
+			end)
+			return coroutine.resume(inner)
+		end)
+		local _, _, st, th = coroutine.resume(outer)
+		assert(st == "normal" and type(th) == "thread")
+
+		-- generators
+		local function range(n)
+			return coroutine.wrap(function()
+				for i = 1, n do coroutine.yield(i) end
+			end)
+		end
+		local sum = 0
+		for i in range(4) do sum = sum + i end
+		assert(sum == 10)
+		local gen = coroutine.wrap(function() error("bad") end)
+		ok, v = pcall(gen)
+		assert(not ok and v:find("bad"))
+		ok, v = pcall(coroutine.yield, 1)
+		assert(not ok and v:find("attempt to yield from outside a coroutine"))
+	`)
+
+	// the goroutines of abandoned coroutines end when they're collected
+	before := runtime.NumGoroutine()
+	doString(t, ls, `
+		collectgarbage("stop")
+		for i = 1, 100 do
+			local gen = coroutine.wrap(function() while true do coroutine.yield(i) end end)
+			gen()
+		end
+	`)
+	if n := runtime.NumGoroutine(); n < before+100 {
+		t.Fatalf("goroutines = %d, before %d", n, before)
+	}
+	doString(t, ls, `collectgarbage("restart") collectgarbage()`)
+	for i := 0; i < 100 && runtime.NumGoroutine() > before; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("goroutines = %d, before %d", n, before)
+	}
+}
+
+func TestJSONLib(t *testing.T) {
+	ls, _ := newState(t)
+	doString(t, ls, `
+		assert(json.encode({1, 2.5, "a\n\"b\"", true, json.null}) == '[1,2.5,"a\\n\\"b\\"",true,null]')
+		assert(json.encode({a = {x = 1}, b = {}}, {sortkeys = true}) == '{"a":{"x":1},"b":{}}')
+		assert(json.encode({[1] = 1, [3] = 3}) == "[1,null,3]")
+		assert(json.encode({[1] = 1, [10] = 10}, {sortkeys = true}) == '{"1":1,"10":10}')
+		assert(json.encode(json.array()) == "[]" and json.encode(json.object({})) == "{}")
+		assert(json.encode({b = 1, a = {1, 2}}, {pretty = true, sortkeys = true}) ==
+			'{\n  "a": [\n    1,\n    2\n  ],\n  "b": 1\n}')
+		assert(json.encode({1}, {pretty = true, indent = "\t"}) == "[\n\t1\n]")
+
+		local cyc = {} cyc[1] = cyc
+		local ok, err = pcall(json.encode, cyc)
+		assert(not ok and err:find("cannot encode cyclic table"))
+		local shared = {1}
+		assert(json.encode({shared, shared}) == "[[1],[1]]")
+		ok, err = pcall(json.encode, {print})
+		assert(not ok and err:find("cannot encode function value"))
+		ok, err = pcall(json.encode, {[true] = 1})
+		assert(not ok and err:find("cannot encode table key of type boolean"))
+		ok, err = pcall(json.encode, {["1"] = 1, [1] = 2})
+		assert(not ok and err:find("cannot encode duplicate key '1'"))
+		ok, err = pcall(json.encode, {a = 1, ["1.5"] = 1, [1.5] = 2})
+		assert(not ok and err:find("cannot encode duplicate key '1.5'"))
+		ok, err = pcall(json.encode, 0/0)
+		assert(not ok and err:find("cannot encode NaN"))
+		assert(json.encode({1/0, 0/0}, {nonfinite = "null"}) == "[null,null]")
+		assert(json.encode({-1/0}, {nonfinite = "string"}) == '["-Infinity"]')
+
+		local v = json.decode(' {"a": [1, 2.5, -3e2, null], "s": "\\u00e9\\ud83d\\ude00\\t", "t": true} ')
+		assert(v.a[1] == 1 and math.type(v.a[1]) == "float" and v.a[3] == -300)
+		assert(v.a[4] == json.null and tostring(json.null) == "null")
+		assert(v.s == "é😀\t" and v.t == true)
+		v = json.decode("[1, 1e2, 1.5, 9007199254740993]", {integers = true})
+		assert(math.type(v[1]) == "integer" and math.type(v[2]) == "integer")
+		assert(math.type(v[3]) == "float" and v[4] == 9007199254740993)
+		v = json.decode("[null]", {null = false})
+		assert(v[1] == false)
+
+		-- empty arrays and objects survive a round trip
+		assert(json.encode(json.decode('{"a":[],"o":{}}'), {sortkeys = true}) == '{"a":[],"o":{}}')
+
+		local r, msg = json.decode('{"a": 1,\n "b" 2}')
+		assert(r == nil and msg == "':' expected at line 2, column 6")
+		r, msg = json.decode("[1, 2")
+		assert(r == nil and msg:find("',' or ']' expected"))
+		r, msg = json.decode('"abc')
+		assert(r == nil and msg:find("unfinished string at line 1, column 1"))
+		r, msg = json.decode("01")
+		assert(r == nil and msg:find("invalid number"))
+		r, msg = json.decode("[1] x")
+		assert(r == nil and msg:find("unexpected character after value"))
+		r, msg = json.decode(string.rep("[", 2000))
+		assert(r == nil and msg:find("nesting too deep"))
+
+		assert(getmetatable(json.array()) == false and getmetatable(json.object()) == false)
+		assert(getmetatable(json.null) == false)
+		assert(not pcall(setmetatable, json.object(), {}))
+	`)
+}
@@ -0,0 +1,272 @@
+package stdlib
+
+import (
+	"fmt"
+	"luago/api"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const formatFlags = "-+ #0"
+
+// string.format (formatstring, ···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-string.format
+func strFormat(ls api.ILuaState) int {
+	fmtStr := ls.CheckString(1)
+	top := ls.GetTop()
+	arg := 1
+	var b strings.Builder
+
+	for i := 0; i < len(fmtStr); i++ {
+		if fmtStr[i] != '%' {
+			b.WriteByte(fmtStr[i])
+			continue
+		}
+		i++
+		if i < len(fmtStr) && fmtStr[i] == '%' {
+			b.WriteByte('%') // %%
+			continue
+		}
+
+		// format item
+		arg++
+		if arg > top { // too many format specifiers?
+			ls.ArgError(arg, "no value")
+		}
+		spec, conv := scanFormat(ls, fmtStr[i:])
+		i += len(spec)
+		formatItem(ls, &b, spec, conv, arg)
+	}
+	ls.PushString(b.String())
+	return 1
+}
+
+// scans the flags, width and precision of the specifier at the beginning
+// of strfrmt, returns them and the conversion character
+func scanFormat(ls api.ILuaState, strfrmt string) (spec string, conv byte) {
+	p := 0
+	for p < len(strfrmt) && strings.IndexByte(formatFlags, strfrmt[p]) >= 0 {
+		p++ // skip flags
+	}
+	if p > len(formatFlags) {
+		ls.Error2("invalid format (repeated flags)")
+	}
+	digits := func() {
+		for n := 0; n < 2 && p < len(strfrmt) && isDigit(strfrmt[p]); n++ {
+			p++ // skip width or precision, 2 digits at most
+		}
+	}
+	digits()
+	if p < len(strfrmt) && strfrmt[p] == '.' {
+		p++
+		digits()
+	}
+	if p < len(strfrmt) && isDigit(strfrmt[p]) {
+		ls.Error2("invalid format (width or precision too long)")
+	}
+	if p >= len(strfrmt) {
+		ls.Error2("invalid option '%%' to 'format'")
+	}
+	return strfrmt[:p], strfrmt[p]
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func formatItem(ls api.ILuaState, b *strings.Builder, spec string, conv byte, arg int) {
+	switch conv {
+	case 'c':
+		b.WriteString(padString(spec, string([]byte{byte(ls.CheckInteger(arg))})))
+	case 'd', 'i':
+		n := checkFormatInteger(ls, arg)
+		b.WriteString(fmt.Sprintf("%"+spec+"d", n))
+	case 'u':
+		n := checkFormatInteger(ls, arg)
+		b.WriteString(fmt.Sprintf("%"+spec+"d", uint64(n)))
+	case 'o', 'x', 'X':
+		n := checkFormatInteger(ls, arg)
+		b.WriteString(fmt.Sprintf("%"+spec+string(conv), uint64(n)))
+	case 'a', 'A':
+		b.WriteString(formatHexFloat(spec, conv, ls.CheckNumber(arg)))
+	case 'e', 'E', 'f', 'F', 'g', 'G':
+		n := ls.CheckNumber(arg)
+		if math.IsInf(n, 0) || math.IsNaN(n) {
+			b.WriteString(formatSpecialFloat(spec, conv, n))
+			break
+		}
+		if conv == 'F' {
+			conv = 'f'
+		}
+		if !strings.Contains(spec, ".") { // precision of C is 6 by default
+			spec += ".6"
+		}
+		b.WriteString(fmt.Sprintf("%"+spec+string(conv), n))
+	case 'q':
+		addQuoted(ls, b, arg)
+	case 's':
+		s := ls.ToStringMeta(arg)
+		ls.Pop(1)
+		b.WriteString(padString(spec, s))
+	default: // also treat cases 'pnLlh'
+		ls.Error2("invalid option '%%%c' to 'format'", conv)
+	}
+}
+
+// formats s with the width and precision in spec, they are counted
+// in bytes like C
+func padString(spec string, s string) string {
+	leftAlign := strings.Contains(spec, "-")
+	spec = strings.TrimLeft(spec, formatFlags)
+	width, prec := spec, ""
+	if i := strings.IndexByte(spec, '.'); i >= 0 {
+		width, prec = spec[:i], spec[i+1:]
+		if p, _ := strconv.Atoi(prec); p < len(s) {
+			s = s[:p]
+		}
+	}
+	w, _ := strconv.Atoi(width)
+	if pad := w - len(s); pad > 0 {
+		if leftAlign {
+			return s + strings.Repeat(" ", pad)
+		}
+		return strings.Repeat(" ", pad) + s
+	}
+	return s
+}
+
+func checkFormatInteger(ls api.ILuaState, arg int) int64 {
+	n, ok := ls.ToIntegerX(arg)
+	if !ok {
+		if _, isNum := ls.ToNumberX(arg); isNum {
+			ls.ArgError(arg, "number has no integer representation")
+		}
+		ls.CheckInteger(arg) // raises the error of type
+	}
+	return n
+}
+
+// formats inf and nan like C, the flag '0' is ignored
+func formatSpecialFloat(spec string, conv byte, n float64) string {
+	var s string
+	switch {
+	case math.IsNaN(n):
+		s = "nan"
+	case n > 0:
+		s = "inf"
+		if strings.Contains(spec, "+") {
+			s = "+inf"
+		} else if strings.Contains(spec, " ") {
+			s = " inf"
+		}
+	default:
+		s = "-inf"
+	}
+	if 'A' <= conv && conv <= 'Z' {
+		s = strings.ToUpper(s)
+	}
+	return padString(widthOf(spec), s)
+}
+
+// returns the width in spec with the flag '-', other flags and
+// the precision are dropped
+func widthOf(spec string) string {
+	width := strings.TrimLeft(spec, formatFlags)
+	flags := spec[:len(spec)-len(width)]
+	if i := strings.IndexByte(width, '.'); i >= 0 {
+		width = width[:i]
+	}
+	if strings.Contains(flags, "-") {
+		return "-" + width
+	}
+	return width
+}
+
+// formats float in hexadecimal like "%a" of C
+func formatHexFloat(spec string, conv byte, n float64) string {
+	if math.IsInf(n, 0) || math.IsNaN(n) {
+		return formatSpecialFloat(spec, conv, n)
+	}
+	prec := -1
+	if i := strings.IndexByte(spec, '.'); i >= 0 {
+		prec, _ = strconv.Atoi(spec[i+1:])
+		spec = spec[:i]
+	}
+	s := strconv.FormatFloat(n, 'x', prec, 64)
+	// C writes the exponent with digits as few as possible, "p+01" => "p+1"
+	if i := strings.IndexByte(s, 'p'); i >= 0 && s[i+2] == '0' && i+3 < len(s) {
+		s = s[:i+2] + s[i+3:]
+	}
+	if n >= 0 && strings.Contains(spec, "+") {
+		s = "+" + s
+	} else if n >= 0 && strings.Contains(spec, " ") {
+		s = " " + s
+	}
+	if conv == 'A' {
+		s = strings.ToUpper(s)
+	}
+	return padString(widthOf(spec), s)
+}
+
+// adds the value at arg to b in a form that can be read back by Lua
+func addQuoted(ls api.ILuaState, b *strings.Builder, arg int) {
+	switch ls.Type(arg) {
+	case api.LuaTString:
+		s := ls.ToString(arg)
+		b.WriteByte('"')
+		for i := 0; i < len(s); i++ {
+			c := s[i]
+			switch {
+			case c == '"' || c == '\\' || c == '\n':
+				b.WriteByte('\\')
+				b.WriteByte(c)
+			case c == '\r':
+				b.WriteString("\\r")
+			case c == 0:
+				if i+1 < len(s) && isDigit(s[i+1]) {
+					b.WriteString("\\000")
+				} else {
+					b.WriteString("\\0")
+				}
+			case c < 32 || c == 127:
+				if i+1 < len(s) && isDigit(s[i+1]) {
+					fmt.Fprintf(b, "\\%03d", c)
+				} else {
+					fmt.Fprintf(b, "\\%d", c)
+				}
+			default:
+				b.WriteByte(c)
+			}
+		}
+		b.WriteByte('"')
+	case api.LuaTNumber:
+		if n, ok := ls.ToIntegerX(arg); ok && ls.IsInteger(arg) {
+			if n == math.MinInt64 { // corner case
+				b.WriteString("0x8000000000000000")
+			} else {
+				b.WriteString(strconv.FormatInt(n, 10))
+			}
+			break
+		}
+		n := ls.ToNumber(arg)
+		switch {
+		case math.IsInf(n, 1):
+			b.WriteString("1e9999")
+		case math.IsInf(n, -1):
+			b.WriteString("-1e9999")
+		case math.IsNaN(n):
+			b.WriteString("(0/0)")
+		case n == math.Floor(n):
+			// integral floats are written in hex to keep the type
+			b.WriteString(strconv.FormatFloat(n, 'x', -1, 64))
+		default:
+			b.WriteString(strconv.FormatFloat(n, 'g', 17, 64))
+		}
+	case api.LuaTNil, api.LuaTBoolean:
+		b.WriteString(ls.ToStringMeta(arg))
+		ls.Pop(1)
+	default:
+		ls.ArgError(arg, "value has no literal form")
+	}
+}
@@ -0,0 +1,397 @@
+package stdlib
+
+import (
+	"luago/api"
+	"strings"
+)
+
+// Lua patterns, like the matcher of lstrlib.c
+
+const (
+	luaMaxCaptures = 32
+	maxMatchDepth  = 200 // max recursion depth of matching
+	specials       = "^$*+?.([%-"
+
+	capUnfinished = -1
+	capPosition   = -2
+	lEsc          = '%'
+)
+
+type capture struct {
+	init int // start of capture in src
+	len  int // length or capUnfinished, capPosition
+}
+
+type matchState struct {
+	ls         api.ILuaState
+	src        string
+	pat        string
+	level      int // total number of captures (finished or unfinished)
+	matchDepth int // control for recursive depth
+	capture    [luaMaxCaptures]capture
+}
+
+func newMatchState(ls api.ILuaState, src, pat string) *matchState {
+	return &matchState{ls: ls, src: src, pat: pat}
+}
+
+func (ms *matchState) reprep() {
+	ms.level = 0
+	ms.matchDepth = maxMatchDepth
+}
+
+func (ms *matchState) checkCapture(c byte) int {
+	l := int(c) - '1'
+	if l < 0 || l >= ms.level || ms.capture[l].len == capUnfinished {
+		ms.ls.Error2("invalid capture index %%%d", l+1)
+	}
+	return l
+}
+
+func (ms *matchState) captureToClose() int {
+	level := ms.level - 1
+	for ; level >= 0; level-- {
+		if ms.capture[level].len == capUnfinished {
+			return level
+		}
+	}
+	ms.ls.Error2("invalid pattern capture")
+	return 0
+}
+
+// returns the index after the class at p
+func (ms *matchState) classEnd(p int) int {
+	pat := ms.pat
+	c := pat[p]
+	p++
+	if c == lEsc {
+		if p >= len(pat) {
+			ms.ls.Error2("malformed pattern (ends with '%%')")
+		}
+		return p + 1
+	}
+	if c == '[' {
+		if p < len(pat) && pat[p] == '^' {
+			p++
+		}
+		for { // look for a ']'
+			if p >= len(pat) {
+				ms.ls.Error2("malformed pattern (missing ']')")
+			}
+			c := pat[p]
+			p++
+			if c == lEsc && p < len(pat) {
+				p++ // skip escapes (e.g. '%]')
+			}
+			if p < len(pat) && pat[p] == ']' {
+				return p + 1
+			}
+		}
+	}
+	return p
+}
+
+func singleMatchClass(c, cl byte) bool {
+	var res bool
+	switch cl | 0x20 { // tolower
+	case 'a':
+		res = isAlpha(c)
+	case 'c':
+		res = c < 32 || c == 127
+	case 'd':
+		res = '0' <= c && c <= '9'
+	case 'g':
+		res = 32 < c && c < 127
+	case 'l':
+		res = 'a' <= c && c <= 'z'
+	case 'p':
+		res = isPunct(c)
+	case 's':
+		res = c == ' ' || ('\t' <= c && c <= '\r')
+	case 'u':
+		res = 'A' <= c && c <= 'Z'
+	case 'w':
+		res = isAlpha(c) || ('0' <= c && c <= '9')
+	case 'x':
+		res = ('0' <= c && c <= '9') || ('a' <= c|0x20 && c|0x20 <= 'f')
+	default:
+		return cl == c
+	}
+	if 'A' <= cl && cl <= 'Z' {
+		return !res
+	}
+	return res
+}
+
+func isAlpha(c byte) bool {
+	return 'a' <= c|0x20 && c|0x20 <= 'z'
+}
+
+func isPunct(c byte) bool {
+	return 32 < c && c < 127 && !isAlpha(c) && !('0' <= c && c <= '9')
+}
+
+// matches c with the set [...] between p and ec, pat[ec] is ']'
+func (ms *matchState) matchBracketClass(c byte, p, ec int) bool {
+	pat := ms.pat
+	sig := true
+	if pat[p+1] == '^' {
+		sig = false
+		p++ // skip the '^'
+	}
+	for p++; p < ec; p++ {
+		if pat[p] == lEsc {
+			p++
+			if singleMatchClass(c, pat[p]) {
+				return sig
+			}
+		} else if p+2 < ec && pat[p+1] == '-' {
+			if pat[p] <= c && c <= pat[p+2] {
+				return sig
+			}
+			p += 2
+		} else if pat[p] == c {
+			return sig
+		}
+	}
+	return !sig
+}
+
+func (ms *matchState) singleMatch(s, p, ep int) bool {
+	if s >= len(ms.src) {
+		return false
+	}
+	c := ms.src[s]
+	switch ms.pat[p] {
+	case '.':
+		return true // matches any char
+	case lEsc:
+		return singleMatchClass(c, ms.pat[p+1])
+	case '[':
+		return ms.matchBracketClass(c, p, ep-1)
+	default:
+		return ms.pat[p] == c
+	}
+}
+
+func (ms *matchState) matchBalance(s, p int) int {
+	if p+1 >= len(ms.pat) {
+		ms.ls.Error2("malformed pattern (missing arguments to '%%b')")
+	}
+	if s >= len(ms.src) || ms.src[s] != ms.pat[p] {
+		return -1
+	}
+	b, e := ms.pat[p], ms.pat[p+1]
+	cont := 1
+	for s++; s < len(ms.src); s++ {
+		if ms.src[s] == e {
+			if cont--; cont == 0 {
+				return s + 1
+			}
+		} else if ms.src[s] == b {
+			cont++
+		}
+	}
+	return -1 // string ends out of balance
+}
+
+func (ms *matchState) maxExpand(s, p, ep int) int {
+	i := 0 // counts maximum expand for item
+	for ms.singleMatch(s+i, p, ep) {
+		i++
+	}
+	// keeps trying to match with the maximum repetitions
+	for i >= 0 {
+		if res := ms.match(s+i, ep+1); res != -1 {
+			return res
+		}
+		i-- // else didn't match; reduce 1 repetition to try again
+	}
+	return -1
+}
+
+func (ms *matchState) minExpand(s, p, ep int) int {
+	for {
+		if res := ms.match(s, ep+1); res != -1 {
+			return res
+		} else if ms.singleMatch(s, p, ep) {
+			s++ // try with one more repetition
+		} else {
+			return -1
+		}
+	}
+}
+
+func (ms *matchState) startCapture(s, p, what int) int {
+	if ms.level >= luaMaxCaptures {
+		ms.ls.Error2("too many captures")
+	}
+	ms.capture[ms.level] = capture{s, what}
+	ms.level++
+	res := ms.match(s, p)
+	if res == -1 { // match failed?
+		ms.level-- // undo capture
+	}
+	return res
+}
+
+func (ms *matchState) endCapture(s, p int) int {
+	l := ms.captureToClose()
+	ms.capture[l].len = s - ms.capture[l].init // close capture
+	res := ms.match(s, p)
+	if res == -1 { // match failed?
+		ms.capture[l].len = capUnfinished // undo capture
+	}
+	return res
+}
+
+func (ms *matchState) matchCapture(s int, l byte) int {
+	idx := ms.checkCapture(l)
+	c := ms.capture[idx]
+	str := ms.src[c.init : c.init+c.len]
+	if strings.HasPrefix(ms.src[s:], str) {
+		return s + len(str)
+	}
+	return -1
+}
+
+// match returns the end of the match of pat[p:] at src[s:], -1 if it fails
+func (ms *matchState) match(s, p int) int {
+	ms.matchDepth--
+	if ms.matchDepth == 0 {
+		ms.ls.Error2("pattern too complex")
+	}
+	defer func() { ms.matchDepth++ }()
+
+	for p < len(ms.pat) { // end of pattern?
+		switch ms.pat[p] {
+		case '(': // start capture
+			if p+1 < len(ms.pat) && ms.pat[p+1] == ')' { // position capture?
+				return ms.startCapture(s, p+2, capPosition)
+			}
+			return ms.startCapture(s, p+1, capUnfinished)
+		case ')': // end capture
+			return ms.endCapture(s, p+1)
+		case '$':
+			if p+1 == len(ms.pat) { // is the '$' the last char in pattern?
+				if s != len(ms.src) { // check end of string
+					return -1
+				}
+				return s
+			}
+		case lEsc: // escaped sequences not in the format class[*+?-]?
+			if p+1 < len(ms.pat) {
+				switch ms.pat[p+1] {
+				case 'b': // balanced string?
+					s = ms.matchBalance(s, p+2)
+					if s != -1 {
+						p += 4
+						continue // return match(ms, s, p + 4);
+					} // else fail (s == -1)
+					return -1
+				case 'f': // frontier?
+					p += 2
+					if p >= len(ms.pat) || ms.pat[p] != '[' {
+						ms.ls.Error2("missing '[' after '%%f' in pattern")
+					}
+					ep := ms.classEnd(p) // points to what is next
+					var prev byte
+					if s > 0 {
+						prev = ms.src[s-1]
+					}
+					var cur byte
+					if s < len(ms.src) {
+						cur = ms.src[s]
+					}
+					if !ms.matchBracketClass(prev, p, ep-1) &&
+						ms.matchBracketClass(cur, p, ep-1) {
+						p = ep
+						continue // return match(ms, s, ep);
+					}
+					return -1 // match failed
+				case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9': // capture results (%0-%9)?
+					s = ms.matchCapture(s, ms.pat[p+1])
+					if s != -1 {
+						p += 2
+						continue // return match(ms, s, p + 2)
+					}
+					return -1
+				}
+			}
+		}
+
+		// default
+		ep := ms.classEnd(p) // points to optional suffix
+		// does not match at least once?
+		if !ms.singleMatch(s, p, ep) {
+			if ep < len(ms.pat) && (ms.pat[ep] == '*' || ms.pat[ep] == '?' || ms.pat[ep] == '-') { // accept empty?
+				p = ep + 1
+				continue // return match(ms, s, ep + 1);
+			}
+			return -1 // '+' or no suffix
+		}
+		// matched once
+		if ep >= len(ms.pat) {
+			s++
+			p = ep
+			continue
+		}
+		switch ms.pat[ep] { // handle optional suffix
+		case '?': // optional
+			if res := ms.match(s+1, ep+1); res != -1 {
+				return res
+			}
+			p = ep + 1
+			continue // else return match(ms, s, ep + 1);
+		case '+': // 1 or more repetitions
+			return ms.maxExpand(s+1, p, ep) // 1 match already done
+		case '*': // 0 or more repetitions
+			return ms.maxExpand(s, p, ep)
+		case '-': // 0 or more repetitions (minimum)
+			return ms.minExpand(s, p, ep)
+		default: // no suffix
+			s++
+			p = ep
+		}
+	}
+	return s
+}
+
+// pushes the i-th capture, the whole match [s, e) if there are no captures
+func (ms *matchState) pushOneCapture(i, s, e int) {
+	if i >= ms.level {
+		if i != 0 {
+			ms.ls.Error2("invalid capture index %%%d", i+1)
+		}
+		ms.ls.PushString(ms.src[s:e]) // add whole match
+		return
+	}
+	c := ms.capture[i]
+	switch c.len {
+	case capUnfinished:
+		ms.ls.Error2("unfinished capture")
+	case capPosition:
+		ms.ls.PushInteger(int64(c.init + 1))
+	default:
+		ms.ls.PushString(ms.src[c.init : c.init+c.len])
+	}
+}
+
+// pushes the captures, or the whole match if wholeIfNone and
+// there are no captures, returns the count of pushed values
+func (ms *matchState) pushCaptures(s, e int, wholeIfNone bool) int {
+	nLevels := ms.level
+	if nLevels == 0 && wholeIfNone {
+		nLevels = 1
+	}
+	ms.ls.CheckStack2(nLevels, "too many captures")
+	for i := 0; i < nLevels; i++ {
+		ms.pushOneCapture(i, s, e)
+	}
+	return nLevels // number of strings pushed
+}
+
+// returns true if the pattern has no special characters
+func noSpecials(pat string) bool {
+	return !strings.ContainsAny(pat, specials)
+}
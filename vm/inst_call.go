@@ -23,8 +23,16 @@ func call(inst Instruction, vm api.ILuaVM) {
 	a, b, c := inst.ABC()
 	a++
 	nArgs := pushFuncAndArgs(a, b, vm)
-	vm.Call(nArgs, c-1)
-	popResults(a, c, vm)
+	if vm.PrepareCall(nArgs, c-1) { // Go function
+		popResults(a, c, vm)
+	}
+}
+
+// FinishCall completes the CALL instruction after the called
+// Lua function has returned to the dispatch loop
+func (inst Instruction) FinishCall(vm api.ILuaVM) {
+	a, _, c := inst.ABC()
+	popResults(a+1, c, vm)
 }
 
 func pushFuncAndArgs(a, b int, vm api.ILuaVM) int {
@@ -106,10 +114,10 @@ func tailcall(inst Instruction, vm api.ILuaVM) {
 	// TAILCALL 0 4 0
 	a, b, _ := inst.ABC()
 	a++
-	c := 0
 	nArgs := pushFuncAndArgs(a, b, vm)
-	vm.Call(nArgs, c-1)
-	popResults(a, c, vm)
+	if vm.PrepareTailCall(nArgs) { // Go function
+		popResults(a, 0, vm)
+	}
 }
 
 // self A B C | R(A+1) := R(B); R(A) := R(B)[RK(C)]
@@ -72,8 +72,8 @@ const (
 	OpRETURN          // Return from function call
 	OpFORLOOP         // Iterate a numeric for loop
 	OpFORPREP         // Initialization for a numeric for loop
+	OpTFORCALL        // Call the iterator of a generic for loop
 	OpTFORLOOP        // Iterate a generic for loop
-	OpTFORCALL        // Initialization for a generic for loop
 	OpSETLIST         // Set a range of array elements for a table
 	OpCLOSURE         // Create a closure of a function prototype
 	OpVARARG          // Assign vararg function arguments to registers
@@ -169,3 +169,8 @@ func (i Instruction) BMode() byte {
 func (i Instruction) CMode() byte {
 	return opcodes[i.Opcode()].argCMode
 }
+
+// SetsA returns if the instruction sets register A
+func (i Instruction) SetsA() bool {
+	return opcodes[i.Opcode()].setAFlag == 1
+}